@@ -0,0 +1,313 @@
+package knownhosts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// PermissionError is returned by functions which modify known_hosts files on
+// disk, when the file or its parent directory cannot be created or written
+// due to filesystem permissions. This includes attempts to write to a
+// read-only filesystem. Errors caused by invalid input (for example a hostname
+// containing spaces) are never a PermissionError.
+type PermissionError struct {
+	Path string
+	Err  error
+}
+
+// Error satisfies the error interface.
+func (pe *PermissionError) Error() string {
+	return "knownhosts: unable to write " + pe.Path + ": " + pe.Err.Error()
+}
+
+// Unwrap returns the underlying filesystem error.
+func (pe *PermissionError) Unwrap() error {
+	return pe.Err
+}
+
+// AppendKnownHost appends a known_hosts line to the file at path for the
+// supplied hostname, remote, and key, using the same formatting rules and
+// options as WriteKnownHost. If the file does not exist yet, it is created with
+// mode 0600, and its parent directory is created with mode 0700 if needed. If
+// the existing file does not end in a newline, one is added first so that the
+// new entry cannot be merged into the file's previous last line. The file is
+// synced to disk before returning.
+//
+// Appends are idempotent: any address which already has an entry for key in
+// the file, either as a plaintext or hashed pattern, is omitted from the new
+// line, and if all addresses are already present, nothing is appended. This
+// check is performed while holding the file lock, so it remains accurate even
+// when multiple callers attempt to append the same entry concurrently.
+//
+// An exclusive advisory lock is held on the file while appending, so that
+// concurrent appends from multiple goroutines or processes cannot interleave.
+// If the lock cannot be obtained within DefaultLockTimeout (or the duration
+// supplied via WithLockTimeout), ErrLockTimeout is returned.
+//
+// If the file or directory cannot be written due to permissions, the returned
+// error will be a *PermissionError.
+func AppendKnownHost(path string, hostname string, remote net.Addr, key ssh.PublicKey, opts ...WriteOption) error {
+	return AppendKnownHosts(path, hostname, remote, []ssh.PublicKey{key}, opts...)
+}
+
+// AppendKnownHosts appends known_hosts lines to the file at path for the
+// supplied hostname and remote, with one line per key, formatted in the same
+// manner as WriteKnownHosts. All lines are appended in a single write while
+// holding the file lock. File creation, newline handling, locking, and error
+// behavior are otherwise the same as in AppendKnownHost.
+func AppendKnownHosts(path string, hostname string, remote net.Addr, keys []ssh.PublicKey, opts ...WriteOption) error {
+	// Determine the addresses before touching the filesystem, so that bad input
+	// never results in a created-but-empty file
+	wo := newWriteOptions(opts)
+	addresses, err := knownHostAddresses(hostname, remote, wo)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := validateKey(key); err != nil {
+			return err
+		}
+	}
+	if err := validateComment(wo.comment); err != nil {
+		return err
+	}
+	if len(addresses) > 1 && wo.resolver != nil && remoteIsResolved(wo.resolver, withDefaultPort(hostname, wo.defaultPort), remote) {
+		if wo.mergeRemote {
+			wo.mergeAddrs = true
+		} else {
+			addresses = addresses[:1]
+		}
+	}
+	if wo.mergeAddrs && !wo.hashed && !wo.autoStyle {
+		if err := mergeAddresses(path, addresses, keys, wo); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return appendToFile(path, wo, func(existing []byte) ([]byte, error) {
+		var buf bytes.Buffer
+		for _, key := range dedupeKeys(keys) {
+			if missing := missingAddresses(existing, addresses, key); len(missing) > 0 {
+				lineOpts := wo
+				if wo.autoStyle {
+					lineOpts.hashed = autoHashed(existing, wo.emptyStyle)
+				}
+				if wo.mergeAddrs && hasHashedLineForKey(existing, key) {
+					lineOpts.hashed = true
+				}
+				lines, err := knownHostLines(missing, key, lineOpts)
+				if err != nil {
+					return nil, err
+				}
+				buf.WriteString(lines + "\n")
+			}
+		}
+		return buf.Bytes(), nil
+	})
+}
+
+// resolveTimeout is the maximum duration of lookups by WithResolvedRemote.
+const resolveTimeout = 5 * time.Second
+
+// remoteIsResolved returns true if remote has the same port as hostname, and
+// its IP is among the addresses of hostname returned by resolver. False is
+// returned if hostname is an IP address, or if the lookup fails.
+func remoteIsResolved(resolver HostResolver, hostname string, remote net.Addr) bool {
+	normalized, err := NormalizeStrict(hostname)
+	if err != nil {
+		return false
+	}
+	host, port, err := net.SplitHostPort(normalized)
+	if err != nil {
+		host, port = normalized, "22"
+	}
+	remoteHost, remotePort, err := net.SplitHostPort(remote.String())
+	if err != nil || remotePort != port || net.ParseIP(host) != nil {
+		return false
+	}
+	remoteIP := net.ParseIP(stripZone(remoteHost))
+	if remoteIP == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := resolver.LookupHost(ctx, host)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(stripZone(addr)); ip != nil && ip.Equal(remoteIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeAddresses rewrites the known_hosts file at path, adding any of the
+// supplied addresses which are missing for each key to the first existing
+// plaintext line for that key. Keys without an existing plaintext line are
+// skipped. The file is left unchanged if no lines were modified.
+func mergeAddresses(path string, addresses []string, keys []ssh.PublicKey, wo writeOptions) error {
+	return rewriteFile(path, wo, func(in io.Reader, out io.Writer) error {
+		contents, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		lines := strings.SplitAfter(string(contents), "\n")
+		var changed bool
+		for _, key := range keys {
+			missing := missingAddresses([]byte(strings.Join(lines, "")), addresses, key)
+			if len(missing) == 0 {
+				continue
+			}
+			marshaled := key.Marshal()
+			for n, line := range lines {
+				content, eol := splitLineEnding(line)
+				leading, patterns, rest := splitFirstField(content)
+				if patterns == "" || patterns[0] == '#' || patterns[0] == '@' || strings.Contains(patterns, "|") || !keyFieldsMatch(rest, marshaled) {
+					continue
+				}
+				lines[n] = leading + patterns + "," + strings.Join(missing, ",") + rest + eol
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return errUnchanged
+		}
+		_, err = io.WriteString(out, strings.Join(lines, ""))
+		return err
+	})
+}
+
+// hasHashedLineForKey returns true if contents has a line with a hashed
+// pattern for key.
+func hasHashedLineForKey(contents []byte, key ssh.PublicKey) bool {
+	marshaled := key.Marshal()
+	for _, line := range strings.Split(string(contents), "\n") {
+		_, patterns, rest := splitFirstField(strings.TrimRight(line, "\r"))
+		if strings.HasPrefix(patterns, hashMagic) && keyFieldsMatch(rest, marshaled) {
+			return true
+		}
+	}
+	return false
+}
+
+// AppendKnownHostCA appends a @cert-authority line to the file at path,
+// formatted in the same manner as WriteKnownHostCA. File creation, newline
+// handling, locking, and error behavior are the same as in AppendKnownHost.
+func AppendKnownHostCA(path string, hostPatterns []string, caKey ssh.PublicKey, opts ...WriteOption) error {
+	line, err := caLine(hostPatterns, caKey)
+	if err != nil {
+		return err
+	}
+	return appendToFile(path, newWriteOptions(opts), func([]byte) ([]byte, error) {
+		return []byte(line + "\n"), nil
+	})
+}
+
+// appendToFile appends data to the file at path, creating the file and its
+// parent directory if necessary. The data to append is obtained by calling
+// generate with the file's existing contents, after the file has been locked;
+// this permits the caller to avoid appending entries which already exist, even
+// if another process appended them concurrently. If generate returns no data
+// or an error, the file is left unchanged. A newline is inserted before data if the
+// existing file is non-empty and does not already end in a newline. If the
+// WithPlan option was used, the append is recorded in the ChangeSet instead.
+func appendToFile(path string, wo writeOptions, generate func(existing []byte) ([]byte, error)) (err error) {
+	if wo.plan != nil {
+		return wo.plan.append(path, wo, generate)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return wrapFileError(path, err)
+	}
+	f, err := openLocked(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, wo.lockTimeout)
+	if err != nil {
+		return wrapFileError(path, err)
+	}
+	defer func() {
+		if closeErr := closeLocked(f); err == nil && closeErr != nil {
+			err = wrapFileError(path, closeErr)
+		}
+	}()
+
+	existing, err := io.ReadAll(f)
+	if err != nil {
+		return wrapFileError(path, err)
+	}
+	data, err := generate(existing)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	if len(existing) > 0 && existing[len(existing)-1] != '\n' {
+		data = append([]byte{'\n'}, data...)
+	}
+	if _, err := f.Write(data); err != nil {
+		return wrapFileError(path, err)
+	}
+	if err := f.Sync(); err != nil {
+		return wrapFileError(path, err)
+	}
+	return nil
+}
+
+// missingAddresses returns the subset of addresses which do not already have
+// a known_hosts entry for key in contents. An address is considered present if
+// some line for key has a pattern that equals the address after normalization,
+// or a hashed pattern that matches the address. Wildcard and negated patterns
+// are not considered, nor are lines with markers.
+func missingAddresses(contents []byte, addresses []string, key ssh.PublicKey) []string {
+	marshaled := key.Marshal()
+	present := make(map[string]bool, len(addresses))
+	for _, line := range strings.Split(string(contents), "\n") {
+		_, patterns, rest := splitFirstField(strings.TrimRight(line, "\r"))
+		if patterns == "" || patterns[0] == '#' || patterns[0] == '@' || !keyFieldsMatch(rest, marshaled) {
+			continue
+		}
+		for _, pattern := range strings.Split(patterns, ",") {
+			for _, a := range addresses {
+				if patternMatchesAddress(pattern, a) {
+					present[a] = true
+				}
+			}
+		}
+	}
+	var missing []string
+	for _, a := range addresses {
+		if !present[a] {
+			missing = append(missing, a)
+		}
+	}
+	return missing
+}
+
+// patternMatchesAddress returns true if pattern is a hashed pattern matching
+// address, or a plaintext pattern which is identical to address after both are
+// normalized.
+func patternMatchesAddress(pattern, address string) bool {
+	if strings.HasPrefix(pattern, hashMagic) {
+		match, err := MatchHashedPattern(pattern, Normalize(address))
+		return match && err == nil
+	}
+	return Normalize(pattern) == Normalize(address)
+}
+
+// wrapFileError converts permission-related filesystem errors into a
+// *PermissionError, leaving other errors as-is.
+func wrapFileError(path string, err error) error {
+	if err == nil {
+		return nil
+	} else if errors.Is(err, fs.ErrPermission) || isReadOnlyFS(err) {
+		return &PermissionError{Path: path, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,399 @@
+package knownhosts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAppendKnownHost(t *testing.T) {
+	dir := t.TempDir()
+	khPath := filepath.Join(dir, "newdir", "known_hosts")
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	pubKey := generatePubKeyEd25519(t)
+
+	// Missing directory and file should both be created
+	if err := AppendKnownHost(khPath, "newhost.example.test:22", noAddr, pubKey); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	if runtime.GOOS != "windows" {
+		if fi, err := os.Stat(filepath.Dir(khPath)); err != nil {
+			t.Fatalf("Unexpected error from Stat: %v", err)
+		} else if perm := fi.Mode().Perm(); perm&0077 != 0 {
+			t.Errorf("Expected created directory to have no group/other permissions, instead found %v", perm)
+		}
+		if fi, err := os.Stat(khPath); err != nil {
+			t.Fatalf("Unexpected error from Stat: %v", err)
+		} else if perm := fi.Mode().Perm(); perm&0077 != 0 {
+			t.Errorf("Expected created file to have no group/other permissions, instead found %v", perm)
+		}
+	}
+
+	// Strip the trailing newline, and then append again: the new entry must not
+	// be glued onto the previous line
+	contents, err := os.ReadFile(khPath)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %v", khPath, err)
+	}
+	if err := os.WriteFile(khPath, contents[:len(contents)-1], 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	otherKey := generatePubKeyECDSA(t)
+	if err := AppendKnownHost(khPath, "otherhost.example.test:22", noAddr, otherKey); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	if err := kh("newhost.example.test:22", noAddr, pubKey); err != nil {
+		t.Errorf("Unexpected error from callback for first appended host: %v", err)
+	}
+	if err := kh("otherhost.example.test:22", noAddr, otherKey); err != nil {
+		t.Errorf("Unexpected error from callback for second appended host: %v", err)
+	}
+
+	// Bad input should return an error which is not a PermissionError, and
+	// should not create the file
+	badPath := filepath.Join(dir, "bad_known_hosts")
+	var permErr *PermissionError
+	if err := AppendKnownHost(badPath, "bad host", noAddr, pubKey); err == nil || errors.As(err, &permErr) {
+		t.Errorf("Expected non-permission error from AppendKnownHost with bad hostname, instead found %v", err)
+	}
+	if _, err := os.Stat(badPath); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to not exist, but Stat returned %v", badPath, err)
+	}
+}
+
+func TestAppendKnownHostPermissionError(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("Test requires non-root user on a platform with Unix permissions")
+	}
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0500); err != nil {
+		t.Fatalf("Unable to chmod %s: %v", dir, err)
+	}
+	defer os.Chmod(dir, 0700)
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	khPath := filepath.Join(dir, "known_hosts")
+	var permErr *PermissionError
+	if err := AppendKnownHost(khPath, "host.example.test", noAddr, generatePubKeyEd25519(t)); !errors.As(err, &permErr) {
+		t.Errorf("Expected PermissionError from AppendKnownHost, instead found %v", err)
+	}
+}
+
+func TestAppendKnownHosts(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	keys := []ssh.PublicKey{generatePubKeyEd25519(t), generatePubKeyECDSA(t), generatePubKeyRSA(t)}
+	if err := AppendKnownHosts(khPath, "multi.example.test:2222", noAddr, keys); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHosts: %v", err)
+	}
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	if algos := kh.HostKeyAlgorithms("multi.example.test:2222"); len(algos) != 5 {
+		t.Errorf("Expected 5 algorithms for host after AppendKnownHosts, instead found %v", algos)
+	}
+	for _, key := range keys {
+		if err := kh("multi.example.test:2222", noAddr, key); err != nil {
+			t.Errorf("Unexpected error from callback for %s key: %v", key.Type(), err)
+		}
+	}
+}
+
+func TestAppendKnownHostIdempotent(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	pubKey := generatePubKeyEd25519(t)
+	otherKey := generatePubKeyEd25519(t)
+	hashed, _ := HashHostname("hashed.example.test")
+	contents := Line([]string{"a.example.test", "b.example.test:2222"}, pubKey) + "\n" +
+		hashed + " " + keyString(pubKey) + " some comment\n" +
+		"@revoked revoked.example.test " + keyString(pubKey) + "\n"
+	if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	remote, _ := net.ResolveTCPAddr("tcp", "10.0.0.5:22")
+
+	for _, host := range []string{"a.example.test", "a.example.test:22", "[b.example.test]:2222", "hashed.example.test:22"} {
+		if err := AppendKnownHost(khPath, host, noAddr, pubKey); err != nil {
+			t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+		}
+	}
+	if after, _ := os.ReadFile(khPath); string(after) != contents {
+		t.Fatalf("Expected AppendKnownHost of existing entries to leave file unchanged, but contents are now %q", after)
+	}
+
+	// Only the missing address should be appended for a partially-known host; a
+	// different key or a host only present in a marker line should be appended
+	if err := AppendKnownHost(khPath, "a.example.test", remote, pubKey); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	if err := AppendKnownHost(khPath, "a.example.test", noAddr, otherKey); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	if err := AppendKnownHost(khPath, "revoked.example.test", noAddr, pubKey); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	want := contents + Line([]string{"10.0.0.5"}, pubKey) + "\n" +
+		Line([]string{"a.example.test"}, otherKey) + "\n" +
+		Line([]string{"revoked.example.test"}, pubKey) + "\n"
+	if after, _ := os.ReadFile(khPath); string(after) != want {
+		t.Errorf("Unexpected contents after AppendKnownHost:\n%s\nExpected:\n%s", after, want)
+	}
+}
+
+func TestAppendKnownHostMergeAddresses(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	remote, _ := net.ResolveTCPAddr("tcp", "10.0.0.5:22")
+	plainKey, hashedKey, newKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t), generatePubKeyECDSA(t)
+
+	// Nonexistent file: should just append normally
+	if err := AppendKnownHost(khPath, "host1.example.test", noAddr, plainKey, WithMergeAddresses()); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	hashed, _ := HashHostname("hashed.example.test")
+	contents := "# header\n" + Line([]string{"host1.example.test"}, plainKey) + " keep comment\n" + hashed + " " + keyString(hashedKey) + "\n"
+	if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+
+	if err := AppendKnownHost(khPath, "host2.example.test", remote, plainKey, WithMergeAddresses()); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	if err := AppendKnownHost(khPath, "other.example.test", noAddr, hashedKey, WithMergeAddresses()); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	if err := AppendKnownHost(khPath, "new.example.test", noAddr, newKey, WithMergeAddresses()); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	after, err := os.ReadFile(khPath)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %v", khPath, err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(after), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Expected 5 lines, instead found %d:\n%s", len(lines), after)
+	}
+	if want := Line([]string{"host1.example.test", "host2.example.test", "10.0.0.5"}, plainKey) + " keep comment"; lines[1] != want {
+		t.Errorf("Expected merged line %q, instead found %q", want, lines[1])
+	}
+	if !strings.HasPrefix(lines[3], "|1|") || !strings.HasSuffix(lines[3], keyString(hashedKey)) {
+		t.Errorf("Expected new hashed line for key only present in hashed lines, instead found %q", lines[3])
+	}
+	if want := Line([]string{"new.example.test"}, newKey); lines[4] != want {
+		t.Errorf("Expected new line %q, instead found %q", want, lines[4])
+	}
+
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	for host, key := range map[string]ssh.PublicKey{"host2.example.test:22": plainKey, "10.0.0.5:22": plainKey, "other.example.test:22": hashedKey} {
+		if err := kh(host, noAddr, key); err != nil {
+			t.Errorf("Unexpected error from callback for %s: %v", host, err)
+		}
+	}
+}
+
+func TestAppendKnownHostComment(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	pubKey, otherKey := generatePubKeyEd25519(t), generatePubKeyECDSA(t)
+	const comment = "added-by=deployer@ci 2024-05-01"
+
+	for _, bad := range []string{"two\nlines", "carriage\rreturn", " leading", "trailing ", "nul\x00"} {
+		if err := AppendKnownHost(khPath, "host.example.test", noAddr, pubKey, WithComment(bad)); err == nil {
+			t.Errorf("Expected error from AppendKnownHost with comment %q, but error was nil", bad)
+		}
+	}
+	if _, err := os.Stat(khPath); !os.IsNotExist(err) {
+		t.Fatalf("Expected %s to not exist after invalid comments, but Stat returned %v", khPath, err)
+	}
+
+	if err := AppendKnownHost(khPath, "host.example.test", noAddr, pubKey, WithComment(comment)); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	if err := AppendKnownHost(khPath, "other.example.test", noAddr, otherKey, WithComment(comment), WithHashedHostnames()); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	if err := AppendKnownHost(khPath, "gone.example.test", noAddr, otherKey); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+
+	// Comments must survive rewrites of other lines, as well as hashing
+	if _, err := RemoveHost(khPath, "gone.example.test"); err != nil {
+		t.Fatalf("Unexpected error from RemoveHost: %v", err)
+	}
+	if _, err := HashFile(khPath); err != nil {
+		t.Fatalf("Unexpected error from HashFile: %v", err)
+	}
+	if _, err := Prune(khPath, func(Entry) bool { return true }); err != nil {
+		t.Fatalf("Unexpected error from Prune: %v", err)
+	}
+	entries, err := readEntries(khPath, nil, newReadOptions(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error from readEntries: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, instead found %d", len(entries))
+	}
+	for _, e := range entries {
+		if !e.Hashed() || e.Comment != comment {
+			t.Errorf("Expected hashed entry with comment %q, instead found %+v", comment, e)
+		}
+	}
+}
+
+// fakeHostResolver is a HostResolver which returns canned addresses, counting
+// lookups. Hosts without addresses fail to resolve.
+type fakeHostResolver struct {
+	addrs   map[string][]string
+	lookups int
+}
+
+func (r *fakeHostResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.lookups++
+	if addrs, ok := r.addrs[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestAppendKnownHostResolvedRemote(t *testing.T) {
+	dir := t.TempDir()
+	key := generatePubKeyEd25519(t)
+	resolver := &fakeHostResolver{addrs: map[string][]string{
+		"db1.example.test": {"10.0.0.4", "10.0.0.5", "fe80::1"},
+	}}
+	addr := func(s string) net.Addr {
+		a, err := net.ResolveTCPAddr("tcp", s)
+		if err != nil {
+			t.Fatalf("Unable to resolve %s: %v", s, err)
+		}
+		return a
+	}
+	lines := func(path string) []string {
+		t.Helper()
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Unable to read %s: %v", path, err)
+		}
+		return strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	}
+
+	// Redundant remotes are omitted, including on a later dial via a different
+	// address; remotes which are not among the resolved addresses, or whose
+	// hostname cannot be resolved, are still recorded
+	khPath := filepath.Join(dir, "known_hosts")
+	appends := []struct {
+		hostname string
+		remote   string
+	}{
+		{"db1.example.test", "10.0.0.5:22"},
+		{"user@DB1.example.test.", "10.0.0.4:22"},
+		{"db1.example.test", "[fe80::1%eth0]:22"},
+		{"db1.example.test", "10.0.0.9:22"},
+		{"unknown.example.test", "10.0.0.6:22"},
+	}
+	for _, a := range appends {
+		if err := AppendKnownHost(khPath, a.hostname, addr(a.remote), key, WithResolvedRemote(resolver, false)); err != nil {
+			t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+		}
+	}
+	expected := []string{
+		Line([]string{"db1.example.test"}, key),
+		Line([]string{"10.0.0.9"}, key),
+		Line([]string{"unknown.example.test", "10.0.0.6"}, key),
+	}
+	if actual := lines(khPath); strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected file contents: expected\n%s\nfound\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+	if resolver.lookups != len(appends) {
+		t.Errorf("Expected %d lookups, instead found %d", len(appends), resolver.lookups)
+	}
+
+	// Hostnames which are IPs, and remotes on a different port, are never
+	// looked up
+	resolver.lookups = 0
+	if err := AppendKnownHost(khPath, "10.0.0.5", addr("10.0.0.4:22"), key, WithResolvedRemote(resolver, false)); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	if err := AppendKnownHost(khPath, "db1.example.test:2222", addr("10.0.0.4:22"), key, WithResolvedRemote(resolver, false)); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	if resolver.lookups != 0 {
+		t.Errorf("Expected no lookups, instead found %d", resolver.lookups)
+	}
+
+	// With merge, a redundant remote is added to the hostname's existing line
+	khPath = filepath.Join(dir, "known_hosts_merge")
+	for _, remote := range []string{"10.0.0.5:22", "10.0.0.4:22"} {
+		if err := AppendKnownHost(khPath, "db1.example.test", addr(remote), key, WithResolvedRemote(resolver, true)); err != nil {
+			t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+		}
+	}
+	if actual, want := lines(khPath), Line([]string{"db1.example.test", "10.0.0.5", "10.0.0.4"}, key); len(actual) != 1 || actual[0] != want {
+		t.Errorf("Expected merged line %q, instead found %q", want, actual)
+	}
+}
+
+func TestAppendKnownHostHostnameOnly(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	key := generatePubKeyEd25519(t)
+	resolver := &fakeHostResolver{addrs: map[string][]string{"vip.example.test": {"192.0.2.10"}}}
+	for _, ip := range []string{"192.0.2.10:22", "192.0.2.11:22", "[2001:db8::5]:22"} {
+		remote, err := net.ResolveTCPAddr("tcp", ip)
+		if err != nil {
+			t.Fatalf("Unable to resolve %s: %v", ip, err)
+		}
+		if err := AppendKnownHost(khPath, "vip.example.test:22", remote, key, WithHostnameOnly(), WithResolvedRemote(resolver, false)); err != nil {
+			t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+		}
+	}
+	contents, err := os.ReadFile(khPath)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %v", khPath, err)
+	}
+	if expected := "vip.example.test " + keyString(key) + "\n"; string(contents) != expected {
+		t.Errorf("Unexpected contents: expected %q, found %q", expected, string(contents))
+	}
+	if resolver.lookups > 0 {
+		t.Errorf("Expected no lookups with WithHostnameOnly, found %d", resolver.lookups)
+	}
+
+	// The same option applies to WriteKnownHost and to keys accepted by a policy
+	remote := &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 22}
+	var buf bytes.Buffer
+	if err := WriteKnownHost(&buf, "vip.example.test", remote, key, WithHostnameOnly()); err != nil {
+		t.Fatalf("Unexpected error from WriteKnownHost: %v", err)
+	} else if expected := "vip.example.test " + keyString(key) + "\n"; buf.String() != expected {
+		t.Errorf("Unexpected output from WriteKnownHost: expected %q, found %q", expected, buf.String())
+	}
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	cb := kh.WithPolicy(Policy{Mode: PolicyAcceptNew, AppendPath: khPath, WriteOptions: []WriteOption{WithHostnameOnly()}})
+	if err := cb("other.example.test:22", remote, key); err != nil {
+		t.Fatalf("Unexpected error from callback: %v", err)
+	}
+	entries, err := ReadEntries(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadEntries: %v", err)
+	}
+	if len(entries) != 2 || strings.Join(entries[1].Patterns, ",") != "other.example.test" {
+		t.Errorf("Expected appended entry for other.example.test only, instead found %+v", entries)
+	}
+}
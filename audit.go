@@ -0,0 +1,178 @@
+package knownhosts
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AuditConfig configures the behavior of Audit.
+type AuditConfig struct {
+	// Network is passed to ScanAll. If empty, "tcp" is used.
+	Network string
+
+	// Concurrency is the maximum number of hosts scanned at a time. If zero or
+	// negative, 4 is used. Each host may itself be scanned using multiple
+	// connections, as configured by Scan.Concurrency.
+	Concurrency int
+
+	// Scan, if non-nil, is passed to ScanAll for each host.
+	Scan *ScanConfig
+}
+
+// KeyChange describes a host key type for which the key presented by a server
+// differs from the key in known_hosts.
+type KeyChange struct {
+	Stored    ssh.PublicKey
+	Presented ssh.PublicKey
+}
+
+// HostAudit describes the result of auditing a single host.
+type HostAudit struct {
+	Host string // host:port which was scanned
+
+	Matching []ssh.PublicKey // keys which are both stored and presented
+	Changed  []KeyChange     // key types with different stored and presented keys
+	Missing  []ssh.PublicKey // stored keys of types which were not presented
+	New      []ssh.PublicKey // presented keys of types which are not stored
+
+	// Err is non-nil if scanning the host failed. If no keys were obtained,
+	// the other fields are empty. Otherwise, the keys which were obtained are
+	// compared, in which case Missing may include keys whose scan failed.
+	Err error
+}
+
+// Drifted returns true if the host's presented keys did not exactly match its
+// stored keys, or if it could not be scanned.
+func (ha HostAudit) Drifted() bool {
+	return ha.Err != nil || len(ha.Changed) > 0 || len(ha.Missing) > 0 || len(ha.New) > 0
+}
+
+// AuditReport summarizes the result of Audit.
+type AuditReport struct {
+	Hosts []HostAudit // in the same order as the hosts passed to Audit
+}
+
+// Drifted returns the audit results of hosts for which HostAudit.Drifted
+// returns true.
+func (ar AuditReport) Drifted() []HostAudit {
+	var drifted []HostAudit
+	for _, ha := range ar.Hosts {
+		if ha.Drifted() {
+			drifted = append(drifted, ha)
+		}
+	}
+	return drifted
+}
+
+// String returns a human-readable summary of the report, with one line per
+// host followed by an indented line per key which is not matching. Keys are
+// identified by their type and SHA256 fingerprint.
+func (ar AuditReport) String() string {
+	var b strings.Builder
+	for _, ha := range ar.Hosts {
+		switch {
+		case ha.Err != nil && len(ha.Matching)+len(ha.Changed)+len(ha.New) == 0:
+			fmt.Fprintf(&b, "%s: ERROR %v\n", ha.Host, ha.Err)
+			continue
+		case ha.Err != nil:
+			fmt.Fprintf(&b, "%s: PARTIAL %d matching (%v)\n", ha.Host, len(ha.Matching), ha.Err)
+		case ha.Drifted():
+			fmt.Fprintf(&b, "%s: DRIFT %d matching\n", ha.Host, len(ha.Matching))
+		default:
+			fmt.Fprintf(&b, "%s: OK %d matching\n", ha.Host, len(ha.Matching))
+		}
+		for _, change := range ha.Changed {
+			fmt.Fprintf(&b, "  changed %s %s -> %s\n", change.Stored.Type(), Fingerprint(change.Stored), Fingerprint(change.Presented))
+		}
+		for _, key := range ha.Missing {
+			fmt.Fprintf(&b, "  missing %s %s\n", key.Type(), Fingerprint(key))
+		}
+		for _, key := range ha.New {
+			fmt.Fprintf(&b, "  new %s %s\n", key.Type(), Fingerprint(key))
+		}
+	}
+	return b.String()
+}
+
+// Audit scans each of hosts using ScanAll, and compares the presented keys to
+// the keys returned by hkcb.HostKeys, per key type. Hosts lacking a port are
+// scanned on port 22. At most cfg.Concurrency hosts are scanned at a time.
+//
+// Failures to scan individual hosts are reported in HostAudit.Err, rather than
+// aborting the audit. The returned error is only non-nil if ctx is cancelled
+// or expires, in which case the report is still populated, with hosts which
+// were not scanned having Err set to the context's error.
+//
+// Since hkcb.HostKeys only returns the first key of each type for a host, at
+// most one stored key per type is compared, consistent with how the key would
+// be chosen when connecting.
+func Audit(ctx context.Context, hkcb HostKeyCallback, hosts []string, cfg AuditConfig) (AuditReport, error) {
+	network := cfg.Network
+	if network == "" {
+		network = "tcp"
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	report := AuditReport{Hosts: make([]HostAudit, len(hosts))}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for n, host := range hosts {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+		}
+		report.Hosts[n].Host = host
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			report.Hosts[n].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(ha *HostAudit) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			presented, err := ScanAll(ctx, network, ha.Host, cfg.Scan)
+			ha.Err = err
+			if len(presented) > 0 {
+				ha.compare(hkcb.HostKeys(ha.Host), presented)
+			}
+		}(&report.Hosts[n])
+	}
+	wg.Wait()
+	return report, ctx.Err()
+}
+
+// compare populates the key fields of ha by comparing stored and presented
+// keys of each type.
+func (ha *HostAudit) compare(stored, presented []ssh.PublicKey) {
+	presentedByType := make(map[string]ssh.PublicKey, len(presented))
+	for _, key := range presented {
+		presentedByType[key.Type()] = key
+	}
+	storedTypes := make(map[string]bool, len(stored))
+	for _, key := range stored {
+		storedTypes[key.Type()] = true
+		if p, ok := presentedByType[key.Type()]; !ok {
+			ha.Missing = append(ha.Missing, key)
+		} else if KeysEqual(p, key) {
+			ha.Matching = append(ha.Matching, key)
+		} else {
+			ha.Changed = append(ha.Changed, KeyChange{Stored: key, Presented: p})
+		}
+	}
+	for _, key := range presented {
+		if !storedTypes[key.Type()] {
+			ha.New = append(ha.New, key)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package knownhosts_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeema/knownhosts"
+	"github.com/skeema/knownhosts/knownhoststest"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAudit(t *testing.T) {
+	edSigner, ecSigner := knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519), knownhoststest.GenerateSigner(t, ssh.KeyAlgoECDSA256)
+	otherEdKey := knownhoststest.GenerateKey(t, ssh.KeyAlgoED25519)
+	okAddr := knownhoststest.StartServer(t, edSigner).Addr
+	driftAddr := knownhoststest.StartServer(t, edSigner, ecSigner).Addr
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+
+	otherECKey := knownhoststest.GenerateKey(t, ssh.KeyAlgoECDSA256)
+	cb, err := knownhosts.NewFromEntries([]knownhosts.Entry{
+		{Patterns: []string{knownhosts.Normalize(okAddr)}, Key: edSigner.PublicKey()},
+		{Patterns: []string{knownhosts.Normalize(driftAddr)}, Key: otherEdKey},
+		{Patterns: []string{knownhosts.Normalize(closedAddr)}, Key: edSigner.PublicKey()},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromEntries: %v", err)
+	}
+	missingCB, err := knownhosts.NewFromEntries([]knownhosts.Entry{
+		{Patterns: []string{knownhosts.Normalize(okAddr)}, Key: edSigner.PublicKey()},
+		{Patterns: []string{knownhosts.Normalize(okAddr)}, Key: otherECKey},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromEntries: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	scanCfg := &knownhosts.ScanConfig{HostKeyAlgorithms: []string{ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256}}
+
+	report, err := knownhosts.Audit(ctx, cb, []string{okAddr, driftAddr, closedAddr}, knownhosts.AuditConfig{Concurrency: 2, Scan: scanCfg})
+	if err != nil {
+		t.Fatalf("Unexpected error from Audit: %v", err)
+	}
+	if len(report.Hosts) != 3 {
+		t.Fatalf("Expected 3 hosts in report, instead found %d", len(report.Hosts))
+	}
+	if ok := report.Hosts[0]; ok.Drifted() || len(ok.Matching) != 1 {
+		t.Errorf("Unexpected result for matching host: %+v", ok)
+	}
+	drift := report.Hosts[1]
+	if len(drift.Changed) != 1 || len(drift.New) != 1 || len(drift.Matching) != 0 || len(drift.Missing) != 0 || drift.Err != nil {
+		t.Errorf("Unexpected result for drifted host: %+v", drift)
+	} else if string(drift.Changed[0].Presented.Marshal()) != string(edSigner.PublicKey().Marshal()) || drift.New[0].Type() != ssh.KeyAlgoECDSA256 {
+		t.Errorf("Unexpected keys for drifted host: %+v", drift)
+	}
+	if closed := report.Hosts[2]; closed.Err == nil || !closed.Drifted() {
+		t.Errorf("Expected error for closed host, instead found %+v", closed)
+	}
+	if drifted := report.Drifted(); len(drifted) != 2 {
+		t.Errorf("Expected 2 drifted hosts, instead found %d", len(drifted))
+	}
+	summary := report.String()
+	for _, expected := range []string{
+		okAddr + ": OK 1 matching",
+		driftAddr + ": DRIFT 0 matching",
+		"changed ssh-ed25519 " + ssh.FingerprintSHA256(otherEdKey) + " -> " + ssh.FingerprintSHA256(edSigner.PublicKey()),
+		"new ecdsa-sha2-nistp256 " + ssh.FingerprintSHA256(ecSigner.PublicKey()),
+		closedAddr + ": ERROR ",
+	} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("Expected summary to contain %q, instead found:\n%s", expected, summary)
+		}
+	}
+
+	// Stored key of a type the server does not present, and host without port
+	host, _, _ := net.SplitHostPort(okAddr)
+	report, err = knownhosts.Audit(ctx, missingCB, []string{okAddr, host}, knownhosts.AuditConfig{Scan: scanCfg})
+	if err != nil {
+		t.Fatalf("Unexpected error from Audit: %v", err)
+	}
+	if ha := report.Hosts[0]; len(ha.Missing) != 1 || string(ha.Missing[0].Marshal()) != string(otherECKey.Marshal()) || len(ha.Matching) != 1 {
+		t.Errorf("Unexpected result for host with missing key: %+v", ha)
+	}
+	if ha := report.Hosts[1]; ha.Host != net.JoinHostPort(host, "22") {
+		t.Errorf("Expected host without port to use port 22, instead found %q", ha.Host)
+	}
+
+	// Cancelled context
+	cancel()
+	report, err = knownhosts.Audit(ctx, cb, []string{okAddr}, knownhosts.AuditConfig{})
+	if err != context.Canceled {
+		t.Errorf("Expected Audit to return context.Canceled, instead found %v", err)
+	} else if report.Hosts[0].Err == nil {
+		t.Error("Expected host to have an error after cancellation, but it did not")
+	}
+}
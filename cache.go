@@ -0,0 +1,288 @@
+package knownhosts
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheMagic and cacheVersion begin every cache file written by SaveCache. The
+// version must be incremented whenever the encoding of the payload changes.
+const (
+	cacheMagic   = "knownhosts-cache"
+	cacheVersion = 2
+)
+
+// cacheHeaderSize is the length of the magic string, version, and SHA256
+// digest of the payload which precede it in a cache file.
+const cacheHeaderSize = len(cacheMagic) + 4 + sha256.Size
+
+// The payload of a cache file, following the header, consists of unsigned
+// varints: the number of files, followed by each file's path length, path,
+// size, modification time in nanoseconds since the Unix epoch, and SHA256
+// digest; the number of resident lines, followed by the file number, line
+// number, and offset of each; and the number of indexed lines, followed by the
+// normalized address hash, file number, line number, and offset of each.
+
+// cacheWriter accumulates a cache file payload.
+type cacheWriter struct {
+	bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (w *cacheWriter) uint(v uint64) {
+	w.Write(w.scratch[:binary.PutUvarint(w.scratch[:], v)])
+}
+
+func (w *cacheWriter) ref(ref lowMemoryRef) {
+	w.uint(uint64(ref.file))
+	w.uint(uint64(ref.line))
+	w.uint(uint64(ref.offset))
+}
+
+// cacheReader decodes a cache file payload. Once a read fails, err is set and
+// all further reads return zero values.
+type cacheReader struct {
+	data []byte
+	err  error
+}
+
+// errBadCache is recorded by cacheReader for malformed payloads. It is never
+// returned by exported functions, which fall back to parsing instead.
+var errBadCache = errors.New("knownhosts: malformed cache")
+
+func (r *cacheReader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errBadCache
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *cacheReader) bytes(n uint64) []byte {
+	if r.err == nil && n > uint64(len(r.data)) {
+		r.err = errBadCache
+	}
+	if r.err != nil {
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// ref reads a lowMemoryRef, verifying that it lies within one of files.
+func (r *cacheReader) ref(files []lowMemoryFile) lowMemoryRef {
+	fileNum, line, offset := r.uint(), r.uint(), r.uint()
+	if r.err == nil && (fileNum >= uint64(len(files)) || line == 0 || line > math.MaxInt32 || offset >= uint64(files[fileNum].size)) {
+		r.err = errBadCache
+	}
+	if r.err != nil {
+		return lowMemoryRef{}
+	}
+	return lowMemoryRef{file: int32(fileNum), line: int32(line), offset: int64(offset)}
+}
+
+// SaveCache parses the given known_hosts files in the same manner as
+// NewLowMemory, and writes the resulting index to a cache file at path, so
+// that later processes may use LoadCache to avoid parsing the files again. The
+// cache file is replaced atomically, and is created with 0600 permissions.
+//
+// The cache records each file's path, size, modification time, and SHA256
+// digest, along with the file offsets of its lines; it contains no keys or host
+// patterns of its own. Nonetheless, it determines which lines are consulted,
+// so it should be protected in the same way as the known_hosts files.
+func SaveCache(path string, files ...string) error {
+	db := &lowMemoryDB{index: make(map[uint64][]lowMemoryRef)}
+	for n, file := range files {
+		if err := db.load(int32(n), file, newReadOptions(nil)); err != nil {
+			return err
+		}
+	}
+	var payload cacheWriter
+	payload.uint(uint64(len(db.files)))
+	for _, file := range db.files {
+		payload.uint(uint64(len(file.path)))
+		payload.WriteString(file.path)
+		payload.uint(uint64(file.size))
+		payload.uint(uint64(file.modTime.UnixNano()))
+		payload.Write(file.digest[:])
+	}
+	payload.uint(uint64(len(db.residentRefs)))
+	for _, ref := range db.residentRefs {
+		payload.ref(ref)
+	}
+	var indexed int
+	for _, refs := range db.index {
+		indexed += len(refs)
+	}
+	payload.uint(uint64(indexed))
+	for hash, refs := range db.index {
+		for _, ref := range refs {
+			payload.uint(hash)
+			payload.ref(ref)
+		}
+	}
+
+	var header [cacheHeaderSize]byte
+	copy(header[:], cacheMagic)
+	binary.BigEndian.PutUint32(header[len(cacheMagic):], cacheVersion)
+	digest := sha256.Sum256(payload.Bytes())
+	copy(header[len(cacheMagic)+4:], digest[:])
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(append(header[:], payload.Bytes()...))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadCache returns a host key callback for the given known_hosts files, which
+// behaves identically to one returned by NewLowMemory. If path contains a cache
+// written by SaveCache for the same files, in the same order, and none of the
+// files have changed since then, the callback is created from the cache without
+// parsing the files. The returned bool indicates whether the cache was used.
+//
+// Otherwise, including if the cache file is missing, corrupt, or was written by
+// an incompatible version of this package, the files are parsed by
+// NewLowMemory instead; call SaveCache to refresh the cache in this case. The
+// cache is never trusted over the files: a file is considered changed if its
+// size, modification time, or SHA256 digest differs from the cache, and lines
+// located via the cache are always read from the files themselves. Validating
+// the digests requires reading every file in full, but this is much faster
+// than parsing them. Lookups behave as with NewLowMemory, including failing
+// with an error wrapping ErrStale if a file changes afterwards.
+func LoadCache(path string, files ...string) (HostKeyCallback, bool, error) {
+	if db := loadCache(path, files); db != nil {
+		return lenientLookup(db.check), true, nil
+	}
+	cb, err := NewLowMemory(files...)
+	return cb, false, err
+}
+
+// loadCache returns the index stored in the cache file at path, or nil if the
+// cache cannot be used for files for any reason.
+func loadCache(path string, files []string) *lowMemoryDB {
+	contents, err := os.ReadFile(path)
+	if err != nil || len(contents) < cacheHeaderSize || string(contents[:len(cacheMagic)]) != cacheMagic {
+		return nil
+	} else if binary.BigEndian.Uint32(contents[len(cacheMagic):]) != cacheVersion {
+		return nil
+	}
+	payload := contents[cacheHeaderSize:]
+	if digest := sha256.Sum256(payload); !bytes.Equal(digest[:], contents[len(cacheMagic)+4:cacheHeaderSize]) {
+		return nil
+	}
+	r := &cacheReader{data: payload}
+	if r.uint() != uint64(len(files)) {
+		return nil
+	}
+	db := &lowMemoryDB{files: make([]lowMemoryFile, len(files))}
+	for n := range db.files {
+		file := &db.files[n]
+		file.path = string(r.bytes(r.uint()))
+		file.size = int64(r.uint())
+		file.modTime = time.Unix(0, int64(r.uint()))
+		copy(file.digest[:], r.bytes(sha256.Size))
+		if r.err != nil || file.path != files[n] || !fileMatchesCache(*file) {
+			return nil
+		}
+	}
+	// Each ref occupies at least 3 bytes, so larger counts are malformed
+	if count := r.uint(); count <= uint64(len(r.data)/3) {
+		db.residentRefs = make([]lowMemoryRef, count)
+		for n := range db.residentRefs {
+			db.residentRefs[n] = r.ref(db.files)
+		}
+	} else {
+		return nil
+	}
+	if count := r.uint(); count <= uint64(len(r.data)/4) {
+		db.index = make(map[uint64][]lowMemoryRef, count)
+		for n := uint64(0); n < count && r.err == nil; n++ {
+			hash := r.uint()
+			db.index[hash] = append(db.index[hash], r.ref(db.files))
+		}
+	} else {
+		return nil
+	}
+	if r.err != nil || len(r.data) > 0 {
+		return nil
+	}
+	if db.resident = readResident(db); db.resident == nil && len(db.residentRefs) > 0 {
+		return nil
+	}
+	return db
+}
+
+// fileMatchesCache returns true if file is unchanged since it was cached.
+func fileMatchesCache(file lowMemoryFile) bool {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.Size() != file.size || !fi.ModTime().Equal(file.modTime) {
+		return false
+	}
+	h := sha256.New()
+	if n, err := io.Copy(h, f); err != nil || n != file.size {
+		return false
+	}
+	return bytes.Equal(h.Sum(nil), file.digest[:])
+}
+
+// readResident reads and parses the lines located by db.residentRefs, returning
+// nil if any of them is not a line which NewLowMemory would keep in memory.
+func readResident(db *lowMemoryDB) []Entry {
+	entries := make([]Entry, 0, len(db.residentRefs))
+	handles := make(map[int32]*os.File)
+	defer func() {
+		for _, f := range handles {
+			f.Close()
+		}
+	}()
+	for _, ref := range db.residentRefs {
+		file := db.files[ref.file]
+		f := handles[ref.file]
+		if f == nil {
+			var err error
+			if f, err = os.Open(file.path); err != nil {
+				return nil
+			}
+			handles[ref.file] = f
+		}
+		line, err := bufio.NewReader(io.NewSectionReader(f, ref.offset, file.size-ref.offset)).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil
+		}
+		e, ok, err := parseEntry(line)
+		if err != nil || !ok || !isResident(e) {
+			return nil
+		}
+		e.Filename, e.Line = file.path, int(ref.line)
+		entries = append(entries, e)
+	}
+	return entries
+}
@@ -0,0 +1,175 @@
+package knownhosts
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	keys := []ssh.PublicKey{generatePubKeyEd25519(t), generatePubKeyECDSA(t), generatePubKeyEd25519(t)}
+	revokedKey, caKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t)
+	hashed, err := HashHostname("hashed.example.test")
+	if err != nil {
+		t.Fatalf("Unexpected error from HashHostname: %v", err)
+	}
+	caLine, _ := LineWithOptions([]string{"*.ca.example.test"}, caKey, LineOptions{Marker: MarkerCertAuthority})
+	revokedLine, _ := LineWithOptions([]string{"*"}, revokedKey, LineOptions{Marker: MarkerRevoked})
+	first := strings.Join([]string{
+		"# comment",
+		Line([]string{"plain.example.test"}, keys[0]),
+		Line([]string{"plain.example.test", "[plain.example.test]:2222"}, keys[1]),
+		Line([]string{"*.wild.example.test"}, keys[2]),
+		Line([]string{hashed}, keys[0]),
+		caLine,
+		revokedLine,
+	}, "\n") + "\n"
+	second := Line([]string{"other.example.test"}, keys[2]) + "\r\n"
+	paths := []string{filepath.Join(dir, "first"), filepath.Join(dir, "second")}
+	for n, contents := range []string{first, second} {
+		if err := os.WriteFile(paths[n], []byte(contents), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %v", paths[n], err)
+		}
+	}
+	cachePath := filepath.Join(dir, "cache")
+	inMemory, err := New(paths...)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	remote, _ := net.ResolveTCPAddr("tcp", "10.0.0.5:22")
+	hosts := []string{
+		"plain.example.test:22", "plain.example.test:2222", "a.wild.example.test:22", "hashed.example.test:22",
+		"host.ca.example.test:22", "other.example.test:22", "unknown.example.test:22",
+	}
+	assertLoad := func(expectFromCache bool) {
+		t.Helper()
+		cb, fromCache, err := LoadCache(cachePath, paths...)
+		if err != nil {
+			t.Fatalf("Unexpected error from LoadCache: %v", err)
+		} else if fromCache != expectFromCache {
+			t.Fatalf("Expected LoadCache to return fromCache=%t, instead found %t", expectFromCache, fromCache)
+		}
+		for _, hostname := range hosts {
+			for _, key := range append([]ssh.PublicKey{revokedKey, generatePubKeyEd25519(t)}, keys...) {
+				expected := describeCallbackResult(inMemory(hostname, remote, key))
+				if actual := describeCallbackResult(cb(hostname, remote, key)); actual != expected {
+					t.Errorf("Mismatched results for %q with %s key: expected %s, found %s", hostname, key.Type(), expected, actual)
+				}
+			}
+		}
+	}
+
+	// No cache yet, so files are parsed
+	assertLoad(false)
+	if err := SaveCache(cachePath, paths...); err != nil {
+		t.Fatalf("Unexpected error from SaveCache: %v", err)
+	}
+	if fi, err := os.Stat(cachePath); err != nil {
+		t.Fatalf("Unable to stat cache: %v", err)
+	} else if perm := fi.Mode().Perm(); perm != 0600 && os.PathSeparator == '/' {
+		t.Errorf("Expected cache to have permissions 0600, instead found %o", perm)
+	}
+	assertLoad(true)
+
+	// A different set of files does not use the cache
+	if _, fromCache, err := LoadCache(cachePath, paths[0]); err != nil || fromCache {
+		t.Errorf("Unexpected result from LoadCache with different files: %t, %v", fromCache, err)
+	}
+	if _, fromCache, err := LoadCache(cachePath, paths[1], paths[0]); err != nil || fromCache {
+		t.Errorf("Unexpected result from LoadCache with reordered files: %t, %v", fromCache, err)
+	}
+
+	// Corrupt or incompatible caches are ignored
+	cacheContents, err := os.ReadFile(cachePath)
+	if err != nil {
+		t.Fatalf("Unable to read cache: %v", err)
+	}
+	corruptions := map[string][]byte{
+		"truncated": cacheContents[:len(cacheContents)/2],
+		"empty":     nil,
+		"version":   append(append(append([]byte(nil), cacheContents[:len(cacheMagic)]...), 0, 0, 0, 99), cacheContents[len(cacheMagic)+4:]...),
+		"payload":   append(append([]byte(nil), cacheContents[:len(cacheContents)-1]...), cacheContents[len(cacheContents)-1]^0xff),
+		"garbage":   bytes.Repeat([]byte("x"), 1000),
+	}
+	for name, contents := range corruptions {
+		if err := os.WriteFile(cachePath, contents, 0600); err != nil {
+			t.Fatalf("Unable to write cache: %v", err)
+		}
+		t.Run(name, func(t *testing.T) { assertLoad(false) })
+	}
+
+	// A file modified without changing its size or modification time is
+	// detected via its digest
+	if err := SaveCache(cachePath, paths...); err != nil {
+		t.Fatalf("Unexpected error from SaveCache: %v", err)
+	}
+	fi, err := os.Stat(paths[1])
+	if err != nil {
+		t.Fatalf("Unable to stat %s: %v", paths[1], err)
+	}
+	second = Line([]string{"other.example.test"}, keys[0]) + "\r\n"
+	if err := os.WriteFile(paths[1], []byte(second), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", paths[1], err)
+	}
+	if err := os.Chtimes(paths[1], fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatalf("Unable to change times: %v", err)
+	}
+	if inMemory, err = New(paths...); err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	assertLoad(false)
+
+	// Errors from parsing the files are returned
+	if _, _, err := LoadCache(cachePath, filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected error from LoadCache for missing file, but it succeeded")
+	}
+	if err := SaveCache(cachePath, filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected error from SaveCache for missing file, but it succeeded")
+	}
+}
+
+// BenchmarkLoadCache compares the time taken to create a callback for a
+// 200000-line file by parsing it, and by loading a cache written by SaveCache.
+func BenchmarkLoadCache(b *testing.B) {
+	dir := b.TempDir()
+	path, cachePath := filepath.Join(dir, "known_hosts"), filepath.Join(dir, "cache")
+	keys := []ssh.PublicKey{generatePubKeyEd25519(b), generatePubKeyECDSA(b), generatePubKeyRSA(b)}
+	var buf bytes.Buffer
+	for line := 0; line < 200000; line++ {
+		buf.WriteString(Line([]string{fmt.Sprintf("host%d.example.test", line)}, keys[line%len(keys)]) + "\n")
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		b.Fatalf("Unable to write %s: %v", path, err)
+	}
+	if err := SaveCache(cachePath, path); err != nil {
+		b.Fatalf("Unexpected error from SaveCache: %v", err)
+	}
+	b.Run("New", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			if _, err := New(path); err != nil {
+				b.Fatalf("Unexpected error: %v", err)
+			}
+		}
+	})
+	b.Run("NewLowMemory", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			if _, err := NewLowMemory(path); err != nil {
+				b.Fatalf("Unexpected error: %v", err)
+			}
+		}
+	})
+	b.Run("LoadCache", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			if _, fromCache, err := LoadCache(cachePath, path); err != nil || !fromCache {
+				b.Fatalf("Unexpected result from LoadCache: %t, %v", fromCache, err)
+			}
+		}
+	})
+}
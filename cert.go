@@ -0,0 +1,207 @@
+package knownhosts
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// CertAuthorityFor returns the @cert-authority entry responsible for trusting
+// cert when it is presented by hostWithPort, along with true, or a zero Entry
+// and false if no entry in entries would trust it. The entry's Filename and
+// Line identify the trusting line, and its key is the CA key, so for example
+// Fingerprint(entry.Key) returns the CA's fingerprint.
+//
+// This is useful for logging why a connection was trusted, after a callback
+// from New or NewFromEntries has accepted a host certificate. Supply the same
+// entries the callback was built from, such as those returned by ReadEntries
+// for the same files. The result is deterministic: if multiple lines list the
+// same CA for hosts matching hostWithPort, the first one in entries is
+// returned. Lines are matched in exactly the same manner as by the callback,
+// so hostWithPort must include a port, and host patterns are evaluated using
+// MatchesLine.
+//
+// CertAuthorityFor only identifies the CA line. It does not verify the
+// certificate's signature, principals, or validity period, and does not check
+// whether the CA key is @revoked; those checks are performed by the callback.
+func CertAuthorityFor(entries []Entry, hostWithPort string, cert *ssh.Certificate) (Entry, bool) {
+	if cert == nil {
+		return Entry{}, false
+	}
+	for _, e := range entries {
+		if e.Marker == MarkerCertAuthority && trustsAuthority(e.Patterns, e.Key, cert.SignatureKey, hostWithPort) {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// CertExpiryWarning configures HostKeyCallback.WithCertExpiryWarning.
+type CertExpiryWarning struct {
+	// Window is how far in advance of a host certificate's ValidBefore time to
+	// warn. A certificate expiring exactly Window from now triggers a warning.
+	Window time.Duration
+
+	// Warn is called for each accepted host certificate which expires within
+	// Window, with the time remaining until it expires. It must be safe for
+	// concurrent use if the callback is.
+	Warn func(hostname string, remote net.Addr, cert *ssh.Certificate, remaining time.Duration)
+
+	// Now returns the current time used to compute the time remaining,
+	// defaulting to time.Now if nil. Whether a certificate is accepted is
+	// decided by the wrapped callback, using its own clock; supply the same
+	// function to WithCertClock when creating that callback, so that both
+	// agree on the current time.
+	Now func() time.Time
+}
+
+// WithCertClock sets the function used as the current time when checking the
+// ValidAfter and ValidBefore times of host certificates, in callbacks created
+// by functions such as NewWithOptions, NewLowMemoryWithOptions, and
+// NewFromReader. This is useful for testing expiry behavior, or for replaying
+// recorded sessions deterministically. If now is nil, or this option is not
+// supplied, time.Now is used. It has no effect on ReadEntriesWithOptions.
+func WithCertClock(now func() time.Time) ReadOption {
+	return func(ro *readOptions) {
+		ro.now = now
+	}
+}
+
+// WithCertExpiryWarning returns a host key callback which behaves like hkcb,
+// but additionally calls w.Warn when it accepts a host certificate that will
+// expire within w.Window. The certificate is still accepted. Certificates
+// which have already expired continue to be rejected by hkcb, and
+// certificates valid forever never trigger a warning.
+//
+// This provides advance notice of host certificates which have not been
+// reissued in time, before connections begin to fail.
+func (hkcb HostKeyCallback) WithCertExpiryWarning(w CertExpiryWarning) HostKeyCallback {
+	if w.Warn == nil {
+		return hkcb
+	}
+	now := w.Now
+	if now == nil {
+		now = time.Now
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := hkcb(hostname, remote, key)
+		cert, ok := key.(*ssh.Certificate)
+		if err != nil || !ok || cert.ValidBefore == ssh.CertTimeInfinity || cert.ValidBefore > math.MaxInt64 {
+			return err
+		}
+		if remaining := time.Unix(int64(cert.ValidBefore), 0).Sub(now()); remaining <= w.Window {
+			w.Warn(hostname, remote, cert, remaining)
+		}
+		return nil
+	}
+}
+
+// CertAuthorityKey wraps the key of a @cert-authority line. It is returned by
+// the HostKeys method of callbacks with certificate detection, as provided by
+// HostKeyCallback.WithCertDetection, in place of the bare key. The
+// HostKeyAlgorithms method of such callbacks reports certificate algorithms
+// for these keys, such as "ssh-ed25519-cert-v01@openssh.com", since the host
+// must present a certificate signed by the CA rather than the CA key itself.
+type CertAuthorityKey struct {
+	ssh.PublicKey
+}
+
+// certAlgos maps key types to the corresponding certificate algorithms.
+var certAlgos = map[string]string{
+	ssh.KeyAlgoRSA:        ssh.CertAlgoRSAv01,
+	ssh.KeyAlgoDSA:        ssh.CertAlgoDSAv01,
+	ssh.KeyAlgoECDSA256:   ssh.CertAlgoECDSA256v01,
+	ssh.KeyAlgoECDSA384:   ssh.CertAlgoECDSA384v01,
+	ssh.KeyAlgoECDSA521:   ssh.CertAlgoECDSA521v01,
+	ssh.KeyAlgoSKECDSA256: ssh.CertAlgoSKECDSA256v01,
+	ssh.KeyAlgoED25519:    ssh.CertAlgoED25519v01,
+	ssh.KeyAlgoSKED25519:  ssh.CertAlgoSKED25519v01,
+}
+
+// certLine identifies a line of a known_hosts file.
+type certLine struct {
+	filename string
+	line     int
+}
+
+// WithCertDetection returns a host key callback which behaves like hkcb, but
+// whose HostKeys and HostKeyAlgorithms methods identify keys from
+// @cert-authority lines. HostKeys returns such keys as a *CertAuthorityKey, and
+// HostKeyAlgorithms returns certificate algorithms for them, so that
+// ssh.ClientConfig.HostKeyAlgorithms may be populated for hosts known via a
+// CA. Without this, HostKeyAlgorithms reports the CA key's own type, which the
+// host does not present, so it must not be used for such hosts.
+//
+// The files must be the same paths from which hkcb was created using New, in
+// order to identify the @cert-authority lines by their filename and line
+// number. Only lines beginning with the @cert-authority marker are examined,
+// so this is much cheaper than fully parsing the files. The values returned by
+// New and by this method are both plain callbacks, usable anywhere an
+// ssh.HostKeyCallback is expected; host key verification itself is unchanged.
+//
+// Note that golang.org/x/crypto/ssh/knownhosts only exposes the first key of
+// each type for a host, so if a host is matched by a @cert-authority line and
+// also has a plain key of the same type, only whichever comes first is
+// reported.
+func (hkcb HostKeyCallback) WithCertDetection(files ...string) (HostKeyCallback, error) {
+	caLines := make(map[certLine]bool)
+	for _, path := range files {
+		if err := scanCertAuthorityLines(path, caLines); err != nil {
+			return nil, err
+		}
+	}
+	return hkcb.withCertLines(caLines), nil
+}
+
+// withCertLines returns a callback which behaves like hkcb, but which wraps
+// the keys of caLines in a *CertAuthorityKey for lookups by HostKeys.
+func (hkcb HostKeyCallback) withCertLines(caLines map[certLine]bool) HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := hkcb(hostname, remote, key)
+		if _, placeholder := key.(*fakePublicKey); !placeholder || len(caLines) == 0 {
+			return err
+		}
+		var keyErr *xknownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			for n, kk := range keyErr.Want {
+				if caLines[certLine{filepath.Clean(kk.Filename), kk.Line}] {
+					keyErr.Want[n].Key = &CertAuthorityKey{kk.Key}
+				}
+			}
+		}
+		return err
+	}
+}
+
+// scanCertAuthorityLines adds the line numbers of the @cert-authority lines in
+// the file at path to caLines.
+func scanCertAuthorityLines(path string, caLines map[certLine]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	path = filepath.Clean(path)
+	for lineNum := 1; ; lineNum++ {
+		line, err := r.ReadString('\n')
+		if strings.HasPrefix(strings.TrimLeft(line, " \t"), string(MarkerCertAuthority)+" ") ||
+			strings.HasPrefix(strings.TrimLeft(line, " \t"), string(MarkerCertAuthority)+"\t") {
+			caLines[certLine{path, lineNum}] = true
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
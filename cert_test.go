@@ -0,0 +1,323 @@
+package knownhosts
+
+import (
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCertAuthorityFor(t *testing.T) {
+	prodCA, testCA := generateSignerEd25519(t), generateSignerEd25519(t)
+	hostKey := generatePubKeyEd25519(t)
+	prodLine, _ := LineWithOptions([]string{"*.prod.example.com"}, prodCA.PublicKey(), LineOptions{Marker: MarkerCertAuthority})
+	testLine, _ := LineWithOptions([]string{"*.test.example.com"}, testCA.PublicKey(), LineOptions{Marker: MarkerCertAuthority})
+	wideLine, _ := LineWithOptions([]string{"*.example.com"}, prodCA.PublicKey(), LineOptions{Marker: MarkerCertAuthority})
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	contents := strings.Join([]string{"# CAs", testLine, prodLine, wideLine}, "\n") + "\n"
+	if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	entries, err := ReadEntries(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadEntries: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+
+	// Both the prod and wide lines trust prodCA for this host; the first wins
+	cases := map[string]int{
+		"web.prod.example.com:22": 3,
+		"web.test.example.com:22": 2,
+		"other.example.com:22":    4,
+	}
+	for host, expectLine := range cases {
+		ca := prodCA
+		if expectLine == 2 {
+			ca = testCA
+		}
+		cert := generateHostCert(t, ca, hostKey, strings.TrimSuffix(host, ":22"))
+		if err := kh(host, noAddr, cert); err != nil {
+			t.Errorf("Unexpected error from callback for %s: %v", host, err)
+		}
+		e, ok := CertAuthorityFor(entries, host, cert)
+		if !ok || e.Filename != khPath || e.Line != expectLine || Fingerprint(e.Key) != Fingerprint(ca.PublicKey()) {
+			t.Errorf("Unexpected result from CertAuthorityFor(%s): %+v, %t", host, e, ok)
+		}
+	}
+
+	// No CA line trusts testCA for prod hosts
+	cert := generateHostCert(t, testCA, hostKey, "web.prod.example.com")
+	if e, ok := CertAuthorityFor(entries, "web.prod.example.com:22", cert); ok {
+		t.Errorf("Expected no CA for out-of-scope certificate, instead found %+v", e)
+	}
+
+	// The callback rejects a hostname without a port, so no CA line trusts it
+	cert = generateHostCert(t, prodCA, hostKey, "web.prod.example.com")
+	if err := kh("web.prod.example.com", noAddr, cert); err == nil {
+		t.Error("Expected callback to reject hostname without port, but it did not")
+	}
+	if e, ok := CertAuthorityFor(entries, "web.prod.example.com", cert); ok {
+		t.Errorf("Expected no CA for hostname without port, instead found %+v", e)
+	}
+	if e, ok := CertAuthorityFor(entries, "WEB.prod.example.com.:22", cert); !ok || e.Line != 3 {
+		t.Errorf("Expected CA line 3 for non-normalized hostname, instead found %+v, %t", e, ok)
+	}
+	if _, ok := CertAuthorityFor(entries, "web.prod.example.com", nil); ok {
+		t.Error("Expected no CA for nil certificate")
+	}
+}
+
+func TestWithCertExpiryWarning(t *testing.T) {
+	caSigner := generateSignerEd25519(t)
+	kh, err := NewFromEntries([]Entry{{Marker: MarkerCertAuthority, Patterns: []string{"*.example.test"}, Key: caSigner.PublicKey()}})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromEntries: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+
+	// The underlying callback checks validity using the real time, so the
+	// certificate expires an hour from now; the fake clock is set relative to
+	// that expiry to control the warning
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	cert := &ssh.Certificate{
+		Key:             generatePubKeyEd25519(t),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"host.example.test"},
+		ValidBefore:     uint64(expiry.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatalf("Unable to sign host certificate: %v", err)
+	}
+	const window = 48 * time.Hour
+	var fakeNow time.Time
+	var warnings []time.Duration
+	cb := kh.WithCertExpiryWarning(CertExpiryWarning{
+		Window: window,
+		Warn: func(hostname string, remote net.Addr, c *ssh.Certificate, remaining time.Duration) {
+			warnings = append(warnings, remaining)
+		},
+		Now: func() time.Time { return fakeNow },
+	})
+	cases := []struct {
+		now        time.Time
+		expectWarn bool
+	}{
+		{expiry.Add(-window - time.Second), false},
+		{expiry.Add(-window), true},
+		{expiry.Add(-window + time.Second), true},
+		{expiry.Add(-time.Minute), true},
+	}
+	for _, tc := range cases {
+		fakeNow, warnings = tc.now, nil
+		if err := cb("host.example.test:22", noAddr, cert); err != nil {
+			t.Errorf("Unexpected error from callback at %s: %v", tc.now, err)
+		}
+		if tc.expectWarn && (len(warnings) != 1 || warnings[0] != expiry.Sub(tc.now)) {
+			t.Errorf("Expected one warning with %s remaining at %s, instead found %v", expiry.Sub(tc.now), tc.now, warnings)
+		} else if !tc.expectWarn && len(warnings) > 0 {
+			t.Errorf("Expected no warning at %s, instead found %v", tc.now, warnings)
+		}
+	}
+
+	// Expired certificates, and other errors, are still rejected without a
+	// warning
+	cert.ValidBefore = uint64(time.Now().Add(-time.Minute).Unix())
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatalf("Unable to sign host certificate: %v", err)
+	}
+	fakeNow, warnings = expiry, nil
+	if err := cb("host.example.test:22", noAddr, cert); err == nil || len(warnings) > 0 {
+		t.Errorf("Expected expired certificate to be rejected without warning, instead found err=%v, warnings=%v", err, warnings)
+	}
+
+	// Plain host keys and certificates valid forever never warn
+	cert = generateHostCert(t, caSigner, generatePubKeyEd25519(t), "host.example.test")
+	if err := cb("host.example.test:22", noAddr, cert); err != nil || len(warnings) > 0 {
+		t.Errorf("Unexpected result for certificate valid forever: err=%v, warnings=%v", err, warnings)
+	}
+}
+
+func TestWithCertClock(t *testing.T) {
+	caSigner := generateSignerEd25519(t)
+	caLine, _ := LineWithOptions([]string{"*.example.test"}, caSigner.PublicKey(), LineOptions{Marker: MarkerCertAuthority})
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(khPath, []byte(caLine+"\n"), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+
+	// The certificate was only valid for a day long ago, so only the fake clock
+	// can make it valid
+	validAfter := time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC)
+	validBefore := validAfter.Add(24 * time.Hour)
+	cert := &ssh.Certificate{
+		Key:             generatePubKeyEd25519(t),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"host.example.test"},
+		ValidAfter:      uint64(validAfter.Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatalf("Unable to sign host certificate: %v", err)
+	}
+
+	var fakeNow time.Time
+	clock := func() time.Time { return fakeNow }
+	fromFile, err := NewWithOptions([]string{khPath}, WithCertClock(clock))
+	if err != nil {
+		t.Fatalf("Unexpected error from NewWithOptions: %v", err)
+	}
+	lowMemory, err := NewLowMemoryWithOptions([]string{khPath}, WithCertClock(clock))
+	if err != nil {
+		t.Fatalf("Unexpected error from NewLowMemoryWithOptions: %v", err)
+	}
+	fromBytes, err := NewFromBytes([]byte(caLine+"\n"), "ca", WithCertClock(clock))
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromBytes: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+
+	const window = 2 * time.Hour
+	cases := []struct {
+		name       string
+		now        time.Time
+		expectOK   bool
+		expectWarn bool
+	}{
+		{"not yet valid", validAfter.Add(-time.Second), false, false},
+		{"valid", validAfter.Add(time.Hour), true, false},
+		{"expiring within window", validBefore.Add(-time.Hour), true, true},
+		{"expired", validBefore.Add(time.Second), false, false},
+	}
+	for name, kh := range map[string]HostKeyCallback{"NewWithOptions": fromFile, "NewLowMemoryWithOptions": lowMemory, "NewFromBytes": fromBytes} {
+		var warnings []time.Duration
+		cb := kh.WithCertExpiryWarning(CertExpiryWarning{
+			Window: window,
+			Warn: func(hostname string, remote net.Addr, c *ssh.Certificate, remaining time.Duration) {
+				warnings = append(warnings, remaining)
+			},
+			Now: clock,
+		})
+		for _, tc := range cases {
+			fakeNow, warnings = tc.now, nil
+			err := cb("host.example.test:22", noAddr, cert)
+			if tc.expectOK && err != nil {
+				t.Errorf("%s: Unexpected error from callback for %s certificate: %v", name, tc.name, err)
+			} else if !tc.expectOK && err == nil {
+				t.Errorf("%s: Expected error from callback for %s certificate, but it was accepted", name, tc.name)
+			}
+			if tc.expectWarn && (len(warnings) != 1 || warnings[0] != validBefore.Sub(tc.now)) {
+				t.Errorf("%s: Expected one warning for %s certificate, instead found %v", name, tc.name, warnings)
+			} else if !tc.expectWarn && len(warnings) > 0 {
+				t.Errorf("%s: Expected no warning for %s certificate, instead found %v", name, tc.name, warnings)
+			}
+		}
+	}
+
+	// Without the option, the real time is used, so the certificate has expired
+	fakeNow = validAfter.Add(time.Hour)
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	if err := kh("host.example.test:22", noAddr, cert); err == nil {
+		t.Error("Expected error from callback without WithCertClock, but certificate was accepted")
+	}
+}
+
+func TestWithCertDetection(t *testing.T) {
+	edCA, rsaCA := generateSignerEd25519(t), generatePubKeyRSA(t)
+	plainKey := generatePubKeyECDSA(t)
+	edLine, _ := LineWithOptions([]string{"*.ed.example.test"}, edCA.PublicKey(), LineOptions{Marker: MarkerCertAuthority})
+	rsaLine, _ := LineWithOptions([]string{"*.rsa.example.test"}, rsaCA, LineOptions{Marker: MarkerCertAuthority})
+	plainLine := Line([]string{"plain.example.test", "mixed.ed.example.test"}, plainKey)
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	contents := strings.Join([]string{"# CAs", edLine, "  " + rsaLine, plainLine}, "\n") + "\n"
+	if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	detecting, err := kh.WithCertDetection(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from WithCertDetection: %v", err)
+	}
+
+	cases := []struct {
+		host         string
+		expectPlain  []string
+		expectDetect []string
+	}{
+		{"web.ed.example.test:22", []string{ssh.KeyAlgoED25519}, []string{ssh.CertAlgoED25519v01}},
+		{"web.rsa.example.test:22", []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}, []string{ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01}},
+		{"plain.example.test:22", []string{ssh.KeyAlgoECDSA256}, []string{ssh.KeyAlgoECDSA256}},
+		{"mixed.ed.example.test:22", []string{ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256}, []string{ssh.CertAlgoED25519v01, ssh.KeyAlgoECDSA256}},
+		{"unknown.example.test:22", nil, nil},
+	}
+	for _, tc := range cases {
+		if algos := kh.HostKeyAlgorithms(tc.host); !sameStrings(algos, tc.expectPlain) {
+			t.Errorf("Unexpected result from HostKeyAlgorithms(%s) without cert detection: %v", tc.host, algos)
+		}
+		if algos := detecting.HostKeyAlgorithms(tc.host); !sameStrings(algos, tc.expectDetect) {
+			t.Errorf("Unexpected result from HostKeyAlgorithms(%s) with cert detection: %v", tc.host, algos)
+		}
+	}
+
+	keys := detecting.HostKeys("web.ed.example.test:22")
+	if len(keys) != 1 {
+		t.Fatalf("Unexpected result from HostKeys: %v", keys)
+	}
+	if ca, ok := keys[0].(*CertAuthorityKey); !ok || !KeysEqual(ca.PublicKey, edCA.PublicKey()) {
+		t.Errorf("Expected HostKeys to return *CertAuthorityKey wrapping CA key, instead found %T", keys[0])
+	}
+	if keys := kh.HostKeys("web.ed.example.test:22"); len(keys) != 1 || !KeysEqual(keys[0], edCA.PublicKey()) {
+		t.Errorf("Unexpected result from HostKeys without cert detection: %v", keys)
+	}
+
+	// Verification is unaffected
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	cert := generateHostCert(t, edCA, generatePubKeyEd25519(t), "web.ed.example.test")
+	if err := detecting("web.ed.example.test:22", noAddr, cert); err != nil {
+		t.Errorf("Unexpected error from callback with cert detection: %v", err)
+	}
+
+	// FileDB shares the same implementation
+	db, err := NewFileDB(FileDBOptions{CertDetection: true}, khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFileDB: %v", err)
+	}
+	if algos := db.HostKeyAlgorithms("web.ed.example.test:22"); !sameStrings(algos, []string{ssh.CertAlgoED25519v01}) {
+		t.Errorf("Unexpected result from FileDB.HostKeyAlgorithms with cert detection: %v", algos)
+	}
+
+	if _, err := kh.WithCertDetection(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected error from WithCertDetection for missing file, but it succeeded")
+	}
+}
+
+// sameStrings returns true if a and b contain the same strings, in any order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int, len(a))
+	for _, s := range a {
+		counts[s]++
+	}
+	for _, s := range b {
+		if counts[s]--; counts[s] < 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+)
+
+// severity ranks the findings of the audit command.
+type severity int
+
+const (
+	severityInfo severity = iota
+	severityWarning
+	severityError
+)
+
+var severityNames = []string{"info", "warning", "error"}
+
+func (s severity) String() string {
+	return severityNames[s]
+}
+
+// MarshalJSON represents the severity by name.
+func (s severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON parses a severity name.
+func (s *severity) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	parsed, err := parseSeverity(name)
+	*s = parsed
+	return err
+}
+
+// parseSeverity returns the severity with the supplied name.
+func parseSeverity(name string) (severity, error) {
+	for n, sn := range severityNames {
+		if name == sn {
+			return severity(n), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity %q", name)
+}
+
+// finding is a problem or notable feature of a line of a known_hosts file.
+type finding struct {
+	Line     int      `json:"line"`
+	Severity severity `json:"severity"`
+	Kind     string   `json:"kind"` // malformed, duplicate, conflict, weak-key, revoked-key, unknown-marker, cert-authority, or revoked
+	Message  string   `json:"message"`
+}
+
+// fileStats summarizes the contents of a known_hosts file.
+type fileStats struct {
+	Style           string         `json:"style"` // empty, plain, hashed, or mixed
+	Entries         int            `json:"entries"`
+	Plaintext       int            `json:"plaintext"`
+	Hashed          int            `json:"hashed"`
+	CertAuthorities int            `json:"certAuthorities"`
+	Revoked         int            `json:"revoked"`
+	Malformed       int            `json:"malformed"`
+	KeyTypes        map[string]int `json:"keyTypes"`
+}
+
+// fixResult describes the remediations applied by the audit command's -fix
+// flag.
+type fixResult struct {
+	RemovedLines []int  `json:"removedLines"`
+	Backup       string `json:"backup,omitempty"`
+}
+
+// fileReport is the result of auditing a known_hosts file.
+type fileReport struct {
+	File     string     `json:"file"`
+	Stats    fileStats  `json:"stats"`
+	Findings []finding  `json:"findings"`
+	Fixed    *fixResult `json:"fixed,omitempty"`
+
+	duplicateLines []int // lines which are exact duplicates of earlier lines
+}
+
+// worst returns the highest severity among the report's findings, and false
+// if there are no findings.
+func (fr fileReport) worst() (severity, bool) {
+	var worst severity
+	for _, f := range fr.Findings {
+		if f.Severity > worst {
+			worst = f.Severity
+		}
+	}
+	return worst, len(fr.Findings) > 0
+}
+
+// runAudit implements the audit command.
+func runAudit(args []string, stdout, stderr io.Writer) int {
+	fs, file, jsonOutput := newFileFlagSet("audit", "[flags]",
+		"Reports the health of a known_hosts file: malformed lines, duplicate entries,\nconflicting keys for the same host, weak key types, keys which are both trusted\nand revoked, and a summary of its entries. Exits with status 1 if any finding\nis at least as severe as -fail-on.", stderr)
+	fix := fs.Bool("fix", false, "remove exact duplicate lines, saving the original file with \".old\" appended")
+	failOn := fs.String("fail-on", "error", "minimum `severity` which causes a non-zero exit: info, warning, error, or none")
+	if code, ok := parseFlags(fs, args, 0); !ok {
+		return code
+	}
+	threshold, err := parseSeverity(*failOn)
+	if *failOn == "none" {
+		threshold, err = severityError+1, nil
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "knownhosts: invalid -fail-on: %v\n", err)
+		return exitUsage
+	}
+	path, err := resolveFile(*file)
+	if err != nil {
+		fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+		return exitFailure
+	}
+	report, err := auditFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+		return exitFailure
+	}
+	if *fix && len(report.duplicateLines) > 0 {
+		fixed, err := removeDuplicateLines(path, report.duplicateLines)
+		if err != nil {
+			fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+			return exitFailure
+		}
+		if report, err = auditFile(path); err != nil {
+			fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+			return exitFailure
+		}
+		report.Fixed = &fixed
+	}
+
+	if *jsonOutput {
+		if err := writeJSON(stdout, report); err != nil {
+			fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+			return exitFailure
+		}
+	} else {
+		printFileReport(stdout, report)
+	}
+	if worst, ok := report.worst(); ok && worst >= threshold {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// printFileReport writes a human-readable form of report to w.
+func printFileReport(w io.Writer, report fileReport) {
+	st := report.Stats
+	fmt.Fprintf(w, "%s: %d entries (%d plaintext, %d hashed; %s style), %d malformed line(s)\n", report.File, st.Entries, st.Plaintext, st.Hashed, st.Style, st.Malformed)
+	fmt.Fprintf(w, "  markers: %d @cert-authority, %d @revoked\n", st.CertAuthorities, st.Revoked)
+	keyTypes := make([]string, 0, len(st.KeyTypes))
+	for keyType := range st.KeyTypes {
+		keyTypes = append(keyTypes, keyType)
+	}
+	sort.Strings(keyTypes)
+	for n, keyType := range keyTypes {
+		keyTypes[n] = fmt.Sprintf("%s %d", keyType, st.KeyTypes[keyType])
+	}
+	fmt.Fprintf(w, "  key types: %s\n", strings.Join(keyTypes, ", "))
+	if report.Fixed != nil {
+		fmt.Fprintf(w, "  fixed: removed %d duplicate line(s); original contents saved to %s\n", len(report.Fixed.RemovedLines), report.Fixed.Backup)
+	}
+	for _, f := range report.Findings {
+		fmt.Fprintf(w, "%s:%d: %s: %s: %s\n", report.File, f.Line, f.Severity, f.Kind, f.Message)
+	}
+}
+
+// auditFile reads and analyzes the known_hosts file at path. Unlike
+// knownhosts.ReadEntries, malformed lines are reported as findings rather
+// than errors.
+func auditFile(path string) (fileReport, error) {
+	report := fileReport{File: path, Findings: []finding{}}
+	report.Stats.KeyTypes = make(map[string]int)
+	f, err := os.Open(path)
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+
+	var entries []knownhosts.Entry
+	r := bufio.NewReader(f)
+	for lineNum := 1; ; lineNum++ {
+		line, readErr := r.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return report, readErr
+		} else if line == "" && readErr == io.EOF {
+			break
+		}
+		e, err := knownhosts.ParseLine([]byte(line))
+		var pe *knownhosts.ParseError
+		if errors.As(err, &pe) {
+			report.Stats.Malformed++
+			report.add(lineNum, severityError, "malformed", fmt.Sprintf("column %d: invalid %s: %v", pe.Column, pe.Field, pe.Err))
+		} else if err != nil {
+			return report, err
+		} else if e.Key != nil {
+			e.Filename, e.Line = path, lineNum
+			entries = append(entries, e)
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	report.analyze(entries)
+	style, err := knownhosts.DetectStyle(path)
+	if err != nil {
+		return report, err
+	}
+	report.Stats.Style = style.Style.String()
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Line < report.Findings[j].Line
+	})
+	return report, nil
+}
+
+// add appends a finding to the report.
+func (fr *fileReport) add(line int, sev severity, kind, message string) {
+	fr.Findings = append(fr.Findings, finding{Line: line, Severity: sev, Kind: kind, Message: message})
+}
+
+// analyze populates the report's stats and findings for entries.
+func (fr *fileReport) analyze(entries []knownhosts.Entry) {
+	revokedLines := make(map[string]int) // marshaled key -> line
+	for _, e := range entries {
+		if e.Marker == knownhosts.MarkerRevoked {
+			revokedLines[string(e.Key.Marshal())] = e.Line
+		}
+	}
+
+	seenLines := make(map[string]int) // marker, patterns, and key -> first line
+	type hostKey struct{ host, keyType string }
+	hostKeys := make(map[hostKey]knownhosts.Entry) // first entry per host and key type
+	for _, e := range entries {
+		fr.Stats.Entries++
+		fr.Stats.KeyTypes[e.Key.Type()]++
+		if e.Hashed() {
+			fr.Stats.Hashed++
+		} else {
+			fr.Stats.Plaintext++
+		}
+		fingerprint := e.Key.Type() + " " + e.FingerprintSHA256()
+		patterns := strings.Join(e.Patterns, ",")
+
+		lineKey := string(e.Marker) + " " + patterns + " " + string(e.Key.Marshal())
+		if first, ok := seenLines[lineKey]; ok {
+			fr.add(e.Line, severityWarning, "duplicate", fmt.Sprintf("identical to line %d", first))
+			fr.duplicateLines = append(fr.duplicateLines, e.Line)
+			continue
+		}
+		seenLines[lineKey] = e.Line
+
+		if sev, msg := weakKey(e.Key); msg != "" {
+			fr.add(e.Line, sev, "weak-key", msg)
+		}
+		switch e.Marker {
+		case knownhosts.MarkerNone:
+		case knownhosts.MarkerCertAuthority:
+			fr.Stats.CertAuthorities++
+			fr.add(e.Line, severityInfo, "cert-authority", fmt.Sprintf("trusts CA %s for host certificates matching %s", fingerprint, patterns))
+			continue
+		case knownhosts.MarkerRevoked:
+			fr.Stats.Revoked++
+			fr.add(e.Line, severityInfo, "revoked", fmt.Sprintf("revokes %s", fingerprint))
+			continue
+		default:
+			fr.add(e.Line, severityWarning, "unknown-marker", fmt.Sprintf("unknown marker %s, so this line is ignored", e.Marker))
+			continue
+		}
+		if line, ok := revokedLines[string(e.Key.Marshal())]; ok {
+			fr.add(e.Line, severityError, "revoked-key", fmt.Sprintf("key is revoked on line %d, so this entry is never trusted", line))
+		}
+		for _, pattern := range e.Patterns {
+			if strings.ContainsAny(pattern, "*?!") {
+				continue
+			}
+			host := pattern
+			if !strings.HasPrefix(pattern, "|") {
+				host = knownhosts.Normalize(pattern)
+			}
+			hk := hostKey{host, e.Key.Type()}
+			if first, ok := hostKeys[hk]; !ok {
+				hostKeys[hk] = e
+			} else if string(first.Key.Marshal()) != string(e.Key.Marshal()) {
+				fr.add(e.Line, severityError, "conflict", fmt.Sprintf("%s already has a different %s key on line %d, which takes precedence", pattern, e.Key.Type(), first.Line))
+			}
+		}
+	}
+}
+
+// weakKey returns a severity and description if key uses a weak algorithm or
+// size, or an empty message otherwise.
+func weakKey(key ssh.PublicKey) (severity, string) {
+	switch key.Type() {
+	case ssh.KeyAlgoDSA:
+		return severityError, "DSA keys are insecure, and unsupported by OpenSSH 7.0 and later"
+	case ssh.KeyAlgoRSA:
+		cpk, ok := key.(ssh.CryptoPublicKey)
+		if !ok {
+			break
+		}
+		if rsaKey, ok := cpk.CryptoPublicKey().(*rsa.PublicKey); ok {
+			if bits := rsaKey.N.BitLen(); bits < 1024 {
+				return severityError, fmt.Sprintf("%d-bit RSA key is insecure", bits)
+			} else if bits < 2048 {
+				return severityWarning, fmt.Sprintf("%d-bit RSA key is weak; at least 2048 bits is recommended", bits)
+			}
+		}
+	}
+	return severityInfo, ""
+}
+
+// removeDuplicateLines removes the supplied lines from the known_hosts file at
+// path, keeping a backup of the original.
+func removeDuplicateLines(path string, lines []int) (fixResult, error) {
+	remove := make(map[int]bool, len(lines))
+	for _, line := range lines {
+		remove[line] = true
+	}
+	removed, err := knownhosts.Prune(path, func(e knownhosts.Entry) bool {
+		return !remove[e.Line]
+	}, knownhosts.WithBackup())
+	if err != nil {
+		return fixResult{}, err
+	}
+	result := fixResult{RemovedLines: make([]int, len(removed)), Backup: path + ".old"}
+	for n, e := range removed {
+		result.RemovedLines[n] = e.Line
+	}
+	return result, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// auditFixturePath is a known_hosts file with one example of each problem
+// reported by the audit command.
+const auditFixturePath = "testdata/audit_known_hosts"
+
+func TestRunAudit(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"audit", "-f", auditFixturePath, "-json"}, &stdout, &stderr); code != exitFailure {
+		t.Errorf("Expected exit code %d due to errors, instead found %d; stderr %q", exitFailure, code, stderr.String())
+	}
+	var report fileReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("Unable to unmarshal audit output: %v\n%s", err, stdout.String())
+	}
+	if st := report.Stats; st.Entries != 9 || st.Hashed != 1 || st.Malformed != 1 || st.CertAuthorities != 1 || st.Revoked != 1 || st.KeyTypes["ssh-rsa"] != 2 {
+		t.Errorf("Unexpected stats: %+v", st)
+	}
+	kinds := make(map[string][]int)
+	for _, f := range report.Findings {
+		kinds[f.Kind] = append(kinds[f.Kind], f.Line)
+	}
+	expected := map[string][]int{
+		"malformed":      {10},
+		"duplicate":      {4},
+		"conflict":       {7},
+		"weak-key":       {7, 9},
+		"revoked-key":    {3, 8},
+		"cert-authority": {11},
+		"revoked":        {12},
+	}
+	for kind, lines := range expected {
+		if got := kinds[kind]; len(got) != len(lines) || (len(got) > 0 && got[0] != lines[0]) || (len(got) > 1 && got[1] != lines[1]) {
+			t.Errorf("Expected %s findings on lines %v, instead found %v", kind, lines, got)
+		}
+	}
+
+	// Severity thresholds
+	for failOn, expectCode := range map[string]int{"none": exitOK, "info": exitFailure, "warning": exitFailure} {
+		if code := run([]string{"audit", "-f", auditFixturePath, "-fail-on", failOn}, &stdout, &stderr); code != expectCode {
+			t.Errorf("Expected exit code %d with -fail-on %s, instead found %d", expectCode, failOn, code)
+		}
+	}
+	if code := run([]string{"audit", "-f", fixturePath, "-fail-on", "error"}, &stdout, &stderr); code != exitOK {
+		t.Errorf("Expected exit code %d auditing %s, instead found %d", exitOK, fixturePath, code)
+	}
+
+	// Usage and file errors
+	if code := run([]string{"audit", "-f", auditFixturePath, "-fail-on", "bogus"}, &stdout, &stderr); code != exitUsage {
+		t.Errorf("Expected exit code %d with invalid -fail-on, instead found %d", exitUsage, code)
+	}
+	if code := run([]string{"audit", "-f", auditFixturePath, "extra"}, &stdout, &stderr); code != exitUsage {
+		t.Errorf("Expected exit code %d with extra argument, instead found %d", exitUsage, code)
+	}
+	if code := run([]string{"audit", "-f", filepath.Join(t.TempDir(), "nonexistent")}, &stdout, &stderr); code != exitFailure {
+		t.Errorf("Expected exit code %d with nonexistent file, instead found %d", exitFailure, code)
+	}
+}
+
+func TestRunAuditFix(t *testing.T) {
+	orig, err := os.ReadFile(auditFixturePath)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %v", auditFixturePath, err)
+	}
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, orig, 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", path, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"audit", "-f", path, "-fix", "-fail-on", "none"}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("Expected exit code %d, instead found %d; stderr %q", exitOK, code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "removed 1 duplicate line(s)") || strings.Contains(stdout.String(), "duplicate:") {
+		t.Errorf("Unexpected output from audit -fix:\n%s", stdout.String())
+	}
+	if backup, err := os.ReadFile(path + ".old"); err != nil || !bytes.Equal(backup, orig) {
+		t.Errorf("Expected backup to match original contents; err=%v", err)
+	}
+	fixed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %v", path, err)
+	}
+	origLines := strings.Split(string(orig), "\n")
+	fixedLines := strings.Split(string(fixed), "\n")
+	if len(fixedLines) != len(origLines)-1 || fixedLines[3] != origLines[4] {
+		t.Errorf("Expected only line 4 to be removed, instead found:\n%s", fixed)
+	}
+
+	// Nothing left to fix, so no backup is made and the report is unchanged
+	os.Remove(path + ".old")
+	stdout.Reset()
+	if code := run([]string{"audit", "-f", path, "-fix", "-json", "-fail-on", "none"}, &stdout, &stderr); code != exitOK || strings.Contains(stdout.String(), "\"fixed\"") {
+		t.Errorf("Unexpected result from second audit -fix: exit %d, stdout %s", code, stdout.String())
+	}
+	if _, err := os.Stat(path + ".old"); err == nil {
+		t.Error("Expected no backup when there is nothing to fix")
+	}
+}
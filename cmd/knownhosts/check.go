@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Statuses reported by the check command
+const (
+	statusOK      = "OK"
+	statusUnknown = "UNKNOWN"
+	statusChanged = "CHANGED"
+	statusRevoked = "REVOKED"
+	statusError   = "ERROR"
+)
+
+// checkOptions configures checkHosts.
+type checkOptions struct {
+	Parallel int           // maximum hosts checked at once
+	Timeout  time.Duration // per host
+	AllKeys  bool          // check every key type each host offers
+}
+
+// keyInfo describes a host key in check results.
+type keyInfo struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+func newKeyInfo(key ssh.PublicKey) keyInfo {
+	return keyInfo{Type: key.Type(), Fingerprint: knownhosts.Fingerprint(key)}
+}
+
+// checkResult is the outcome of checking one host.
+type checkResult struct {
+	Host      string    `json:"host"`
+	Status    string    `json:"status"`
+	Presented []keyInfo `json:"presented,omitempty"` // keys presented by the host
+	Known     []keyInfo `json:"known,omitempty"`     // conflicting keys in known_hosts, if CHANGED or REVOKED
+	Error     string    `json:"error,omitempty"`
+}
+
+// detail returns a description of the result for table output.
+func (cr checkResult) detail() string {
+	describe := func(keys []keyInfo) string {
+		strs := make([]string, len(keys))
+		for n, k := range keys {
+			strs[n] = k.Type + " " + k.Fingerprint
+		}
+		return strings.Join(strs, ", ")
+	}
+	switch {
+	case cr.Error != "":
+		return cr.Error
+	case len(cr.Known) > 0:
+		return "presented " + describe(cr.Presented) + "; known_hosts has " + describe(cr.Known)
+	}
+	return describe(cr.Presented)
+}
+
+// runCheck implements the check command.
+func runCheck(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	file := fs.String("f", "", "known_hosts file `path` (default OpenSSH's default files)")
+	hostsFile := fs.String("hosts", "", "read hosts from `path`, one per line, in addition to arguments")
+	parallel := fs.Int("parallel", 16, "maximum number of hosts to check at once")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for each host")
+	deadline := fs.Duration("deadline", 5*time.Minute, "timeout for the entire run")
+	allKeys := fs.Bool("all-keys", false, "check every key type each host offers, not just the negotiated one")
+	jsonOutput := fs.Bool("json", false, "print machine-readable JSON output")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: knownhosts check [flags] [host[:port]...]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Verifies the host key of each host against known_hosts, without")
+		fmt.Fprintln(stderr, "authenticating, and prints a status for each: OK, UNKNOWN, CHANGED, REVOKED,")
+		fmt.Fprintln(stderr, "or ERROR. Exits with status 1 if any host is CHANGED or REVOKED.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	hosts := fs.Args()
+	if *hostsFile != "" {
+		fileHosts, err := readHostsFile(*hostsFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+			return exitFailure
+		}
+		hosts = append(hosts, fileHosts...)
+	}
+	if len(hosts) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	cb, _, err := loadCallback(*file)
+	if err != nil {
+		fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+		return exitFailure
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *deadline)
+	defer cancel()
+	results := checkHosts(ctx, cb, hosts, checkOptions{Parallel: *parallel, Timeout: *timeout, AllKeys: *allKeys})
+	if *jsonOutput {
+		if err := writeJSON(stdout, results); err != nil {
+			fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+			return exitFailure
+		}
+	} else {
+		tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "HOST\tSTATUS\tDETAIL")
+		for _, cr := range results {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", cr.Host, cr.Status, cr.detail())
+		}
+		tw.Flush()
+	}
+	for _, cr := range results {
+		if cr.Status == statusChanged || cr.Status == statusRevoked {
+			return exitFailure
+		}
+	}
+	return exitOK
+}
+
+// readHostsFile returns the hosts listed in the file at path, one per line.
+// Blank lines and lines beginning with # are ignored.
+func readHostsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var hosts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && line[0] != '#' {
+			hosts = append(hosts, line)
+		}
+	}
+	return hosts, scanner.Err()
+}
+
+// checkHosts checks each of hosts against cb, with at most opts.Parallel
+// checks in progress at once. Results are returned in the same order as hosts.
+// Hosts which were not checked before ctx expired have status ERROR.
+func checkHosts(ctx context.Context, cb knownhosts.HostKeyCallback, hosts []string, opts checkOptions) []checkResult {
+	check := checkHost
+	if opts.AllKeys {
+		check = auditHost
+	}
+	parallel := opts.Parallel
+	if parallel <= 0 {
+		parallel = 1
+	}
+	results := make([]checkResult, len(hosts))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for n, host := range hosts {
+		results[n].Host = host
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[n].Status, results[n].Error = statusError, ctx.Err().Error()
+			continue
+		}
+		wg.Add(1)
+		go func(cr *checkResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			hostCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+			defer cancel()
+			*cr = check(hostCtx, cb, cr.Host)
+		}(&results[n])
+	}
+	wg.Wait()
+	return results
+}
+
+// checkHost obtains the host key which host presents to a client configured
+// with cb's HostKeyAlgorithms, and verifies it using cb.
+func checkHost(ctx context.Context, cb knownhosts.HostKeyCallback, host string) checkResult {
+	cr := checkResult{Host: host}
+	addr := host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "22")
+	}
+	key, err := knownhosts.Scan(ctx, "tcp", addr, &knownhosts.ScanConfig{HostKeyAlgorithms: cb.HostKeyAlgorithms(addr)})
+	if err != nil {
+		cr.Status, cr.Error = statusError, err.Error()
+		return cr
+	}
+	cr.Presented = []keyInfo{newKeyInfo(key)}
+
+	// Only the hostname is checked when one is supplied, so the remote address
+	// does not matter
+	err = cb(addr, &net.TCPAddr{IP: net.IPv4zero}, key)
+	var keyErr *xknownhosts.KeyError
+	var revokedErr *xknownhosts.RevokedError
+	switch {
+	case err == nil:
+		cr.Status = statusOK
+	case knownhosts.IsHostUnknown(err):
+		cr.Status = statusUnknown
+	case errors.As(err, &keyErr):
+		cr.Status = statusChanged
+		for _, kk := range keyErr.Want {
+			cr.Known = append(cr.Known, newKeyInfo(kk.Key))
+		}
+	case errors.As(err, &revokedErr):
+		cr.Status = statusRevoked
+		cr.Known = []keyInfo{newKeyInfo(revokedErr.Revoked.Key)}
+	default:
+		cr.Status, cr.Error = statusError, err.Error()
+	}
+	return cr
+}
+
+// auditHost checks every key type offered by host, using knownhosts.Audit.
+// The host is CHANGED if any key type differs from the stored key of that
+// type, and UNKNOWN if it has no stored keys at all.
+func auditHost(ctx context.Context, cb knownhosts.HostKeyCallback, host string) checkResult {
+	cr := checkResult{Host: host}
+	report, _ := knownhosts.Audit(ctx, cb, []string{host}, knownhosts.AuditConfig{})
+	ha := report.Hosts[0]
+	for _, key := range ha.Matching {
+		cr.Presented = append(cr.Presented, newKeyInfo(key))
+	}
+	for _, change := range ha.Changed {
+		cr.Presented = append(cr.Presented, newKeyInfo(change.Presented))
+		cr.Known = append(cr.Known, newKeyInfo(change.Stored))
+	}
+	for _, key := range ha.New {
+		cr.Presented = append(cr.Presented, newKeyInfo(key))
+	}
+	switch {
+	case len(ha.Changed) > 0:
+		cr.Status = statusChanged
+	case len(cr.Presented) == 0 && ha.Err != nil:
+		cr.Status, cr.Error = statusError, ha.Err.Error()
+	case len(ha.Matching) == 0 && len(ha.Missing) == 0:
+		cr.Status = statusUnknown
+	default:
+		cr.Status = statusOK
+	}
+	return cr
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skeema/knownhosts"
+	"github.com/skeema/knownhosts/knownhoststest"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRunCheck(t *testing.T) {
+	edSigner := knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519)
+	ecSigner := knownhoststest.GenerateSigner(t, ssh.KeyAlgoECDSA256)
+	okServer := knownhoststest.StartServer(t, edSigner, ecSigner)
+	changedServer := knownhoststest.StartServer(t, knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519))
+	unknownServer := knownhoststest.StartServer(t, edSigner)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error from Listen: %v", err)
+	}
+	deadAddr := ln.Addr().String()
+	ln.Close()
+
+	// okServer is only known by its ed25519 key, which HostKeyAlgorithms
+	// ensures is negotiated
+	khPath := knownhoststest.WriteTempKnownHosts(t,
+		knownhosts.Entry{Patterns: []string{knownhosts.Normalize(okServer.Addr)}, Key: edSigner.PublicKey()},
+		knownhosts.Entry{Patterns: []string{knownhosts.Normalize(changedServer.Addr)}, Key: edSigner.PublicKey()},
+	)
+	hostsPath := filepath.Join(t.TempDir(), "hosts.txt")
+	hostsContents := "# inventory\n" + okServer.Addr + "\n\n  " + changedServer.Addr + "  \n"
+	if err := os.WriteFile(hostsPath, []byte(hostsContents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", hostsPath, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"check", "-f", khPath, "-hosts", hostsPath, "-json", "-timeout", "5s", unknownServer.Addr, deadAddr}, &stdout, &stderr)
+	if code != exitFailure {
+		t.Errorf("Expected exit code %d due to changed key, instead found %d; stderr %q", exitFailure, code, stderr.String())
+	}
+	var results []checkResult
+	if err := json.Unmarshal(stdout.Bytes(), &results); err != nil {
+		t.Fatalf("Unable to unmarshal check output: %v\n%s", err, stdout.String())
+	}
+	expected := []struct{ host, status string }{
+		{unknownServer.Addr, statusUnknown},
+		{deadAddr, statusError},
+		{okServer.Addr, statusOK},
+		{changedServer.Addr, statusChanged},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, instead found %d: %+v", len(expected), len(results), results)
+	}
+	for n, exp := range expected {
+		if results[n].Host != exp.host || results[n].Status != exp.status {
+			t.Errorf("Expected result %d to be %s %s, instead found %+v", n, exp.host, exp.status, results[n])
+		}
+	}
+	if changed := results[3]; len(changed.Presented) != 1 || len(changed.Known) != 1 || changed.Known[0].Fingerprint != ssh.FingerprintSHA256(edSigner.PublicKey()) {
+		t.Errorf("Expected CHANGED result to include both fingerprints, instead found %+v", changed)
+	}
+	if results[1].Error == "" {
+		t.Error("Expected ERROR result to include error message")
+	}
+
+	// Table output; unknown hosts and errors alone do not fail the run
+	stdout.Reset()
+	if code := run([]string{"check", "-f", khPath, okServer.Addr, unknownServer.Addr, deadAddr}, &stdout, &stderr); code != exitOK {
+		t.Errorf("Expected exit code %d without changed keys, instead found %d", exitOK, code)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "HOST ") || !strings.Contains(lines[1], " OK ") || !strings.Contains(lines[1], ssh.FingerprintSHA256(edSigner.PublicKey())) {
+		t.Errorf("Unexpected table output:\n%s", stdout.String())
+	}
+
+	// Usage errors
+	for _, args := range [][]string{
+		{"check"},
+		{"check", "-parallel", "bogus", okServer.Addr},
+	} {
+		if code := run(args, &stdout, &stderr); code != exitUsage {
+			t.Errorf("Expected exit code %d for args %q, instead found %d", exitUsage, args, code)
+		}
+	}
+	if code := run([]string{"check", "-hosts", filepath.Join(t.TempDir(), "nonexistent")}, &stdout, &stderr); code != exitFailure {
+		t.Errorf("Expected exit code %d for nonexistent hosts file, instead found %d", exitFailure, code)
+	}
+}
+
+func TestCheckHostsAllKeys(t *testing.T) {
+	edSigner := knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519)
+	ecSigner := knownhoststest.GenerateSigner(t, ssh.KeyAlgoECDSA256)
+	server := knownhoststest.StartServer(t, edSigner, ecSigner)
+	host := knownhosts.Normalize(server.Addr)
+	kh, err := knownhosts.New(knownhoststest.WriteTempKnownHosts(t, knownhosts.Entry{Patterns: []string{host}, Key: edSigner.PublicKey()}))
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	opts := checkOptions{Parallel: 2, Timeout: 5 * time.Second, AllKeys: true}
+	results := checkHosts(context.Background(), kh, []string{server.Addr}, opts)
+	if len(results) != 1 || results[0].Status != statusOK || len(results[0].Presented) != 2 {
+		t.Errorf("Unexpected results with all keys: %+v", results)
+	}
+
+	// A changed ECDSA key is only noticed when checking all keys
+	kh, err = knownhosts.New(knownhoststest.WriteTempKnownHosts(t,
+		knownhosts.Entry{Patterns: []string{host}, Key: edSigner.PublicKey()},
+		knownhosts.Entry{Patterns: []string{host}, Key: knownhoststest.GenerateKey(t, ssh.KeyAlgoECDSA256)},
+	))
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	if results := checkHosts(context.Background(), kh, []string{server.Addr}, opts); results[0].Status != statusChanged || len(results[0].Known) != 1 {
+		t.Errorf("Unexpected results with all keys and changed ECDSA key: %+v", results)
+	}
+	opts.AllKeys = false
+	if results := checkHosts(context.Background(), kh, []string{server.Addr}, opts); results[0].Status != statusOK {
+		t.Errorf("Unexpected results with negotiated key only: %+v", results)
+	}
+
+	// An expired overall deadline reports every host as an error
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, cr := range checkHosts(ctx, kh, []string{server.Addr, server.Addr}, opts) {
+		if cr.Status != statusError {
+			t.Errorf("Expected ERROR after deadline, instead found %+v", cr)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/skeema/knownhosts"
+	"github.com/skeema/knownhosts/internal/auth"
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+)
+
+// connectOptions configures connectHost.
+type connectOptions struct {
+	Callback   knownhosts.HostKeyCallback
+	AppendPath string // file to which keys accepted by Mode are appended
+	Mode       knownhosts.PolicyMode
+	User       string
+	Auth       []ssh.AuthMethod
+	Timeout    time.Duration
+}
+
+// runConnect implements the connect command.
+func runConnect(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("connect", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	file := fs.String("f", "", "known_hosts file `path` (default OpenSSH's default files)")
+	userName := fs.String("user", defaultUser(), "user `name` to authenticate as")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for each connection")
+	mode := fs.String("mode", "strict", "handling of unknown hosts: strict or accept-new")
+	identity := fs.String("i", "", "private key `file` to authenticate with, in addition to any SSH agent")
+	visual := fs.Bool("visual", false, "also print each host key as randomart, like ssh's VisualHostKey option")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: knownhosts connect [flags] host[:port]...")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Connects to each host, verifying its host key against known_hosts, and prints")
+		fmt.Fprintln(stderr, "the fingerprint of each verified host key. Authentication uses the first")
+		fmt.Fprintln(stderr, "available SSH agent: Pageant on Windows, then SSH_AUTH_SOCK, then the Windows")
+		fmt.Fprintln(stderr, "OpenSSH agent service. Keys from -i are offered after the agent's keys.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	opts := connectOptions{User: *userName, Timeout: *timeout}
+	switch *mode {
+	case "strict":
+		opts.Mode = knownhosts.PolicyStrict
+	case "accept-new":
+		opts.Mode = knownhosts.PolicyAcceptNew
+	default:
+		fmt.Fprintf(stderr, "knownhosts: invalid -mode %q; must be strict or accept-new\n", *mode)
+		return exitUsage
+	}
+	var err error
+	if opts.Callback, opts.AppendPath, err = loadCallback(*file); err != nil {
+		fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+		return exitFailure
+	}
+	creds, err := auth.New(auth.Options{IdentityFile: *identity, Passphrase: promptPassphrase(stderr)})
+	if err != nil {
+		fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+		return exitFailure
+	}
+	defer creds.Close()
+	opts.Auth = creds.Methods()
+
+	status := exitOK
+	for _, host := range fs.Args() {
+		key, err := connectHost(context.Background(), host, opts)
+		w := stdout
+		if err != nil {
+			w = stderr
+			fmt.Fprintf(stderr, "%s: %s\n", host, describeConnectError(err, key))
+			status = exitFailure
+		} else {
+			fmt.Fprintf(stdout, "%s: OK %s %s\n", host, key.Type(), knownhosts.Fingerprint(key))
+		}
+		if *visual && key != nil {
+			fmt.Fprintln(w, knownhosts.Randomart(key))
+		}
+	}
+	return status
+}
+
+// loadCallback returns a host key callback for the known_hosts file at path,
+// or for whichever of knownhosts.DefaultFiles exist if path is empty, along
+// with the path to which newly accepted keys should be appended.
+func loadCallback(path string) (knownhosts.HostKeyCallback, string, error) {
+	if path == "" {
+		defaults := knownhosts.DefaultFiles()
+		if len(defaults) == 0 {
+			return nil, "", errors.New("unable to determine default known_hosts location; use -f")
+		}
+		cb, err := knownhosts.NewDefault()
+		return cb, defaults[0], err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		// The file will be created if a key is accepted
+		cb, err := knownhosts.New()
+		return cb, path, err
+	}
+	cb, err := knownhosts.New(path)
+	return cb, path, err
+}
+
+// connectHost connects to host using knownhosts.Dial, and returns the host key
+// presented by the server. The key is also returned alongside an error if the
+// server presented it before the connection failed.
+func connectHost(ctx context.Context, host string, opts connectOptions) (key ssh.PublicKey, err error) {
+	recording := func(hostname string, remote net.Addr, k ssh.PublicKey) error {
+		key = k
+		return opts.Callback(hostname, remote, k)
+	}
+	client, err := knownhosts.Dial(ctx, "tcp", host, &knownhosts.DialConfig{
+		Callback: knownhosts.HostKeyCallback(recording),
+		Policy:   knownhosts.Policy{Mode: opts.Mode, AppendPath: opts.AppendPath},
+		User:     opts.User,
+		Auth:     opts.Auth,
+		Timeout:  opts.Timeout,
+	})
+	if err != nil {
+		return key, err
+	}
+	return key, client.Close()
+}
+
+// describeConnectError returns a human-readable description of an error from
+// connectHost, where key is the host key presented by the server, if any.
+func describeConnectError(err error, key ssh.PublicKey) string {
+	var keyErr *xknownhosts.KeyError
+	var revokedErr *xknownhosts.RevokedError
+	if knownhosts.IsHostUnknown(err) && key != nil {
+		return fmt.Sprintf("UNKNOWN host key %s %s", key.Type(), knownhosts.Fingerprint(key))
+	} else if errors.As(err, &keyErr) && key != nil {
+		var known []string
+		for _, kk := range keyErr.Want {
+			known = append(known, fmt.Sprintf("%s %s (%s:%d)", kk.Key.Type(), knownhosts.Fingerprint(kk.Key), kk.Filename, kk.Line))
+		}
+		return fmt.Sprintf("CHANGED host key %s %s; known_hosts has %s", key.Type(), knownhosts.Fingerprint(key), strings.Join(known, ", "))
+	} else if errors.As(err, &revokedErr) {
+		return fmt.Sprintf("REVOKED host key %s (%s:%d)", knownhosts.Fingerprint(revokedErr.Revoked.Key), revokedErr.Revoked.Filename, revokedErr.Revoked.Line)
+	} else if key != nil {
+		return fmt.Sprintf("host key %s %s was verified, but the connection failed: %v", key.Type(), knownhosts.Fingerprint(key), err)
+	}
+	return fmt.Sprintf("ERROR %v", err)
+}
+
+// defaultUser returns the name of the current user, without any Windows
+// domain prefix.
+func defaultUser() string {
+	if u, err := user.Current(); err == nil {
+		name := u.Username
+		if backslash := strings.LastIndexByte(name, '\\'); backslash >= 0 {
+			name = name[backslash+1:]
+		}
+		return name
+	}
+	return os.Getenv("USER")
+}
+
+// promptPassphrase returns a function which prompts for a passphrase on the
+// terminal, writing the prompt to w. It returns nil if standard input is not a
+// terminal.
+func promptPassphrase(w io.Writer) func(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil
+	}
+	return func(prompt string) ([]byte, error) {
+		fmt.Fprint(w, prompt)
+		defer fmt.Fprintln(w)
+		return term.ReadPassword(fd)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skeema/knownhosts"
+	"github.com/skeema/knownhosts/knownhoststest"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRunConnect(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	signer := knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519)
+	server := knownhoststest.StartServer(t, signer)
+	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+	path := filepath.Join(t.TempDir(), "known_hosts")
+
+	runArgs := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := run(args, &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	// Unknown host in strict mode, with nonexistent file
+	if code, _, stderr := runArgs("connect", "-f", path, server.Addr); code != exitFailure || !strings.Contains(stderr, "UNKNOWN host key ssh-ed25519 "+fingerprint) {
+		t.Errorf("Unexpected result for unknown host: exit %d, stderr %q", code, stderr)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("Expected strict mode to leave known_hosts file uncreated")
+	}
+
+	// Accept-new appends the key
+	if code, stdout, stderr := runArgs("connect", "-f", path, "-mode", "accept-new", server.Addr); code != exitOK || !strings.Contains(stdout, server.Addr+": OK ssh-ed25519 "+fingerprint) {
+		t.Errorf("Unexpected result for accept-new: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if code, stdout, stderr := runArgs("connect", "-f", path, "-timeout", "5s", server.Addr); code != exitOK || !strings.Contains(stdout, fingerprint) {
+		t.Errorf("Unexpected result for known host: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if code, stdout, stderr := runArgs("connect", "-f", path, "-visual", server.Addr); code != exitOK || !strings.Contains(stdout, knownhosts.Randomart(signer.PublicKey())) {
+		t.Errorf("Unexpected result for known host with -visual: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+
+	// Changed key for a host known under another key
+	other := knownhoststest.StartServer(t, knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519))
+	changedPath := knownhoststest.WriteTempKnownHosts(t, knownhosts.Entry{Patterns: []string{knownhosts.Normalize(other.Addr)}, Key: signer.PublicKey()})
+	if code, _, stderr := runArgs("connect", "-f", changedPath, "-mode", "accept-new", other.Addr); code != exitFailure || !strings.Contains(stderr, "CHANGED host key") || !strings.Contains(stderr, fingerprint) {
+		t.Errorf("Unexpected result for changed key: exit %d, stderr %q", code, stderr)
+	}
+	if code, _, stderr := runArgs("connect", "-f", changedPath, "-visual", other.Addr); code != exitFailure || !strings.Contains(stderr, "CHANGED host key") || !strings.Contains(stderr, "+----[SHA256]-----+") {
+		t.Errorf("Unexpected result for changed key with -visual: exit %d, stderr %q", code, stderr)
+	}
+
+	// Multiple hosts: any failure means a non-zero exit
+	if code, stdout, _ := runArgs("connect", "-f", path, server.Addr, other.Addr); code != exitFailure || !strings.Contains(stdout, server.Addr+": OK") {
+		t.Errorf("Unexpected result for multiple hosts: exit %d, stdout %q", code, stdout)
+	}
+
+	// Unusable identity file
+	if code, _, stderr := runArgs("connect", "-f", path, "-i", filepath.Join(t.TempDir(), "nonexistent"), server.Addr); code != exitFailure || stderr == "" {
+		t.Errorf("Unexpected result for nonexistent identity file: exit %d, stderr %q", code, stderr)
+	}
+
+	// Usage errors
+	for _, args := range [][]string{
+		{},
+		{"bogus"},
+		{"connect"},
+		{"connect", "-mode", "bogus", server.Addr},
+		{"connect", "-bogus", server.Addr},
+	} {
+		if code, _, _ := runArgs(args...); code != exitUsage {
+			t.Errorf("Expected exit code %d for args %q, instead found %d", exitUsage, args, code)
+		}
+	}
+	if code, stdout, _ := runArgs("help"); code != exitOK || !strings.Contains(stdout, "connect") {
+		t.Errorf("Unexpected result for help: exit %d, stdout %q", code, stdout)
+	}
+}
@@ -0,0 +1,82 @@
+// Command knownhosts is a command-line tool for working with OpenSSH
+// known_hosts files, built on github.com/skeema/knownhosts.
+//
+// Usage:
+//
+//	knownhosts <command> [flags] [arguments]
+//
+// Run "knownhosts help" for a list of commands, or "knownhosts <command> -h"
+// for the flags of a command.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Exit codes
+const (
+	exitOK      = 0 // success
+	exitFailure = 1 // one or more hosts or operations failed
+	exitUsage   = 2 // invalid command, flags, or arguments
+)
+
+// command is a subcommand of the knownhosts tool.
+type command struct {
+	summary string
+	run     func(args []string, stdout, stderr io.Writer) int
+}
+
+// commands maps subcommand names to their implementations.
+var commands = map[string]command{
+	"audit":   {"report problems in a known_hosts file, such as duplicates and weak keys", runAudit},
+	"check":   {"verify the host keys of many hosts concurrently, without authenticating", runCheck},
+	"connect": {"connect to hosts, verifying their host keys", runConnect},
+	"find":    {"print the known_hosts entries for a host, like ssh-keygen -F", runFind},
+	"hash":    {"hash the hostnames in a known_hosts file, like ssh-keygen -H", runHash},
+	"remove":  {"remove the known_hosts entries for a host, like ssh-keygen -R", runRemove},
+	"scan":    {"print the host keys offered by hosts, like ssh-keyscan", runScan},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run dispatches to the subcommand named by args[0], returning an exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return exitUsage
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage(stdout)
+		return exitOK
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "knownhosts: unknown command %q\n\n", args[0])
+		usage(stderr)
+		return exitUsage
+	}
+	return cmd.run(args[1:], stdout, stderr)
+}
+
+// usage prints a list of commands to w.
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: knownhosts <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "knownhosts <command> -h" for the flags of a command.`)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/skeema/knownhosts"
+)
+
+// fileFlagUsage is the usage text of the -f flag of commands which operate on
+// a single known_hosts file.
+const fileFlagUsage = "known_hosts file `path` (default ~/.ssh/known_hosts)"
+
+// newFileFlagSet returns a flag set for a command which operates on a single
+// known_hosts file, with the -f and -json flags registered. The usage text
+// lists synopsis and description before the flags.
+func newFileFlagSet(name, synopsis, description string, stderr io.Writer) (fs *flag.FlagSet, file *string, jsonOutput *bool) {
+	fs = flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	file = fs.String("f", "", fileFlagUsage)
+	jsonOutput = fs.Bool("json", false, "print machine-readable JSON output")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: knownhosts "+name+" "+synopsis)
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, description)
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+	return fs, file, jsonOutput
+}
+
+// parseFlags parses args using fs, returning an exit code and false if the
+// command should not proceed. Exactly nargs positional arguments are required.
+func parseFlags(fs *flag.FlagSet, args []string, nargs int) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	if fs.NArg() != nargs {
+		fs.Usage()
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// resolveFile returns path, or the user's default known_hosts file if path is
+// empty.
+func resolveFile(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	defaults := knownhosts.DefaultFiles()
+	if len(defaults) == 0 {
+		return "", errors.New("unable to determine default known_hosts location; use -f")
+	}
+	return defaults[0], nil
+}
+
+// writeJSON writes v to w as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// findEntries returns the entries of the known_hosts file at path which match
+// host, as determined by Entry.MatchesHost. If includeMarkers is false, lines
+// with a marker are omitted.
+func findEntries(path, host string, includeMarkers bool) ([]knownhosts.Entry, error) {
+	entries, err := knownhosts.ReadEntries(path)
+	if err != nil {
+		return nil, err
+	}
+	matches := []knownhosts.Entry{}
+	for _, e := range entries {
+		if (includeMarkers || e.Marker == knownhosts.MarkerNone) && e.MatchesHost(host) {
+			matches = append(matches, e)
+		}
+	}
+	return matches, nil
+}
+
+// runFind implements the find command.
+func runFind(args []string, stdout, stderr io.Writer) int {
+	fs, file, jsonOutput := newFileFlagSet("find", "[flags] host[:port]",
+		"Prints the entries matching host, including hashed entries, prefixed by their\nfile and line number, like ssh-keygen -F. Exits with status 1 if none match.", stderr)
+	if code, ok := parseFlags(fs, args, 1); !ok {
+		return code
+	}
+	path, err := resolveFile(*file)
+	if err != nil {
+		fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+		return exitFailure
+	}
+	matches, err := findEntries(path, fs.Arg(0), true)
+	if err != nil {
+		fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+		return exitFailure
+	}
+	if *jsonOutput {
+		if err := writeJSON(stdout, matches); err != nil {
+			fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+			return exitFailure
+		}
+	} else {
+		for _, e := range matches {
+			fmt.Fprintf(stdout, "%s:%d: %s\n", e.Filename, e.Line, e.Format())
+		}
+	}
+	if len(matches) == 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// removeResult is the JSON output of the remove command.
+type removeResult struct {
+	File     string             `json:"file"`
+	Backup   string             `json:"backup,omitempty"`
+	DryRun   bool               `json:"dryRun"`
+	Removed  []knownhosts.Entry `json:"removed"`  // lines removed entirely
+	Modified []knownhosts.Entry `json:"modified"` // lines with only some patterns removed
+}
+
+// runRemove implements the remove command.
+func runRemove(args []string, stdout, stderr io.Writer) int {
+	fs, file, jsonOutput := newFileFlagSet("remove", "[flags] host[:port]",
+		"Removes all entries for host, including hashed entries, like ssh-keygen -R.\nLines listing other hosts too are modified to remove just the matching host.\nLines with a marker, such as @cert-authority, are never removed. The original\nfile is saved with \".old\" appended.", stderr)
+	dryRun := fs.Bool("dry-run", false, "show what would be removed, without modifying the file")
+	if code, ok := parseFlags(fs, args, 1); !ok {
+		return code
+	}
+	path, err := resolveFile(*file)
+	if err != nil {
+		fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+		return exitFailure
+	}
+	host := fs.Arg(0)
+	matches, err := findEntries(path, host, false)
+	if err != nil {
+		fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+		return exitFailure
+	}
+	result := removeResult{File: path, DryRun: *dryRun, Removed: []knownhosts.Entry{}, Modified: []knownhosts.Entry{}}
+	partial := make(map[int]bool, len(matches)) // keyed by line number
+	for _, e := range matches {
+		for _, pattern := range e.Patterns {
+			if !(knownhosts.Entry{Patterns: []string{pattern}}).MatchesHost(host) {
+				partial[e.Line] = true
+			}
+		}
+		if partial[e.Line] {
+			result.Modified = append(result.Modified, e)
+		} else {
+			result.Removed = append(result.Removed, e)
+		}
+	}
+	if !*dryRun && len(matches) > 0 {
+		if _, err := knownhosts.RemoveHost(path, host, knownhosts.WithBackup()); err != nil {
+			fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+			return exitFailure
+		}
+		result.Backup = path + ".old"
+	}
+
+	if *jsonOutput {
+		if err := writeJSON(stdout, result); err != nil {
+			fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+	removeVerb, modifyVerb := "removed", "modified"
+	if *dryRun {
+		removeVerb, modifyVerb = "would remove", "would modify"
+	}
+	for _, e := range matches {
+		verb := removeVerb
+		if partial[e.Line] {
+			verb = modifyVerb
+		}
+		fmt.Fprintf(stdout, "%s:%d: %s: %s\n", e.Filename, e.Line, verb, e.Format())
+	}
+	if len(matches) == 0 {
+		fmt.Fprintf(stderr, "%s: host %s not found\n", path, host)
+	} else if result.Backup != "" {
+		fmt.Fprintf(stderr, "%s: original contents saved to %s\n", path, result.Backup)
+	}
+	return exitOK
+}
+
+// hashResult is the JSON output of the hash command.
+type hashResult struct {
+	File          string `json:"file"`
+	Backup        string `json:"backup"`
+	UnhashedLines []int  `json:"unhashedLines"` // lines with wildcard or negated patterns
+}
+
+// runHash implements the hash command.
+func runHash(args []string, stdout, stderr io.Writer) int {
+	fs, file, jsonOutput := newFileFlagSet("hash", "[flags]",
+		"Replaces plaintext host patterns with hashed ones, like ssh-keygen -H. Lines\nwith wildcard or negated patterns cannot be hashed, and are reported. The\noriginal file is saved with \".old\" appended.", stderr)
+	if code, ok := parseFlags(fs, args, 0); !ok {
+		return code
+	}
+	path, err := resolveFile(*file)
+	if err != nil {
+		fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+		return exitFailure
+	}
+	unhashed, err := knownhosts.HashFile(path, knownhosts.WithBackup())
+	if err != nil {
+		fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+		return exitFailure
+	}
+	result := hashResult{File: path, Backup: path + ".old", UnhashedLines: unhashed}
+	if result.UnhashedLines == nil {
+		result.UnhashedLines = []int{}
+	}
+	if *jsonOutput {
+		if err := writeJSON(stdout, result); err != nil {
+			fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+	for _, line := range unhashed {
+		fmt.Fprintf(stderr, "%s:%d: not hashed: line has wildcard or negated patterns\n", path, line)
+	}
+	fmt.Fprintf(stdout, "%s: hashed; original contents saved to %s\n", path, result.Backup)
+	return exitOK
+}
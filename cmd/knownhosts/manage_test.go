@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skeema/knownhosts"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// fixturePath is a known_hosts file used by golden output tests. Commands run
+// against it must not modify it. The path uses a forward slash on all platforms,
+// so that it appears identically in golden output.
+const fixturePath = "testdata/known_hosts"
+
+// copyFixture copies the fixture known_hosts file to a temporary directory,
+// returning the new path.
+func copyFixture(t *testing.T) string {
+	t.Helper()
+	data, err := os.ReadFile(fixturePath)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %v", fixturePath, err)
+	}
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", path, err)
+	}
+	return path
+}
+
+func TestGoldenOutput(t *testing.T) {
+	cases := map[string][]string{
+		"find":                {"find", "-f", fixturePath, "target.example.test"},
+		"find_json":           {"find", "-f", fixturePath, "-json", "target.example.test"},
+		"remove_dry_run":      {"remove", "-f", fixturePath, "-dry-run", "target.example.test"},
+		"remove_dry_run_json": {"remove", "-f", fixturePath, "-dry-run", "-json", "target.example.test"},
+		"audit":               {"audit", "-f", auditFixturePath, "-fail-on", "none"},
+		"audit_json":          {"audit", "-f", auditFixturePath, "-fail-on", "none", "-json"},
+	}
+	for name, args := range cases {
+		var stdout, stderr bytes.Buffer
+		if code := run(args, &stdout, &stderr); code != exitOK {
+			t.Errorf("%s: expected exit code %d, instead found %d; stderr %q", name, exitOK, code, stderr.String())
+			continue
+		}
+		goldenPath := filepath.Join("testdata", name+".golden")
+		if *update {
+			if err := os.WriteFile(goldenPath, stdout.Bytes(), 0644); err != nil {
+				t.Fatalf("Unable to write %s: %v", goldenPath, err)
+			}
+			continue
+		}
+		golden, err := os.ReadFile(goldenPath)
+		if err != nil {
+			t.Fatalf("Unable to read %s: %v", goldenPath, err)
+		}
+		if stdout.String() != string(golden) {
+			t.Errorf("%s: output differs from %s:\n%s", name, goldenPath, stdout.String())
+		}
+	}
+}
+
+func TestRunFind(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"find", "-f", fixturePath, "[target.example.test]:2222"}, &stdout, &stderr); code != exitOK || strings.Count(stdout.String(), "\n") != 1 || !strings.HasPrefix(stdout.String(), fixturePath+":5: ") {
+		t.Errorf("Unexpected result from find with port: exit %d, stdout %q", code, stdout.String())
+	}
+	stdout.Reset()
+	if code := run([]string{"find", "-f", fixturePath, "-json", "missing.example.test"}, &stdout, &stderr); code != exitFailure || strings.TrimSpace(stdout.String()) != "[]" {
+		t.Errorf("Unexpected result from find for missing host: exit %d, stdout %q", code, stdout.String())
+	}
+	if code := run([]string{"find", "-f", filepath.Join(t.TempDir(), "nonexistent"), "host"}, &stdout, &stderr); code != exitFailure {
+		t.Errorf("Expected exit code %d from find with nonexistent file, instead found %d", exitFailure, code)
+	}
+	if code := run([]string{"find", "-f", fixturePath}, &stdout, &stderr); code != exitUsage {
+		t.Errorf("Expected exit code %d from find without host, instead found %d", exitUsage, code)
+	}
+}
+
+func TestRunRemove(t *testing.T) {
+	path := copyFixture(t)
+	before, err := knownhosts.ReadEntries(path)
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadEntries: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"remove", "-f", path, "-json", "target.example.test"}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("Unexpected exit code %d from remove; stderr %q", code, stderr.String())
+	}
+	var result removeResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("Unable to unmarshal remove output: %v", err)
+	}
+	if result.DryRun || result.Backup != path+".old" || len(result.Removed) != 2 || len(result.Modified) != 1 {
+		t.Errorf("Unexpected result from remove: %+v", result)
+	}
+
+	// The result should reflect what RemoveHost actually did
+	after, err := knownhosts.ReadEntries(path)
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadEntries: %v", err)
+	}
+	if len(after) != len(before)-len(result.Removed) {
+		t.Errorf("Expected %d entries after remove, instead found %d", len(before)-len(result.Removed), len(after))
+	}
+	for _, e := range after {
+		if e.Marker == "" && e.MatchesHost("target.example.test") {
+			t.Errorf("Entry still matches removed host: %s", e.Format())
+		}
+	}
+	if _, err := os.Stat(path + ".old"); err != nil {
+		t.Errorf("Expected backup file to exist: %v", err)
+	}
+
+	// Removing again finds nothing
+	stdout.Reset()
+	stderr.Reset()
+	if code := run([]string{"remove", "-f", path, "target.example.test"}, &stdout, &stderr); code != exitOK || stdout.Len() != 0 || !strings.Contains(stderr.String(), "not found") {
+		t.Errorf("Unexpected result from remove of missing host: exit %d, stdout %q, stderr %q", code, stdout.String(), stderr.String())
+	}
+}
+
+func TestRunHash(t *testing.T) {
+	// Prepend a line which cannot be hashed to the fixture
+	fixture, err := os.ReadFile(fixturePath)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %v", fixturePath, err)
+	}
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	contents := "plain.example.test,!bad.example.test ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIDJqkTKB7K1fyiOrxI1Uw6VSlzUaID+9YxPu+MpNYaT7\n" + string(fixture)
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", path, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"hash", "-f", path, "-json"}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("Unexpected exit code %d from hash; stderr %q", code, stderr.String())
+	}
+	var result hashResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("Unable to unmarshal hash output: %v", err)
+	}
+	if result.Backup != path+".old" || len(result.UnhashedLines) != 1 || result.UnhashedLines[0] != 1 {
+		t.Errorf("Unexpected result from hash: %+v", result)
+	}
+	entries, err := knownhosts.ReadEntries(path)
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadEntries: %v", err)
+	}
+	for _, e := range entries[1:] {
+		if e.Marker == "" && !e.Hashed() {
+			t.Errorf("Expected entry to be hashed: %s", e.Format())
+		}
+	}
+
+	// Found by find, despite being hashed
+	stdout.Reset()
+	if code := run([]string{"find", "-f", path, "other.example.test"}, &stdout, &stderr); code != exitOK || strings.Count(stdout.String(), "\n") != 2 {
+		t.Errorf("Unexpected result from find after hash: exit %d, stdout %q", code, stdout.String())
+	}
+	if code := run([]string{"hash", "-f", path, "extra"}, &stdout, &stderr); code != exitUsage {
+		t.Errorf("Expected exit code %d from hash with extra argument, instead found %d", exitUsage, code)
+	}
+}
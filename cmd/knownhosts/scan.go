@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+)
+
+// keyTypeAlgorithms maps the short key type names accepted by ssh-keyscan -t
+// to the host key algorithms which obtain keys of that type.
+var keyTypeAlgorithms = map[string][]string{
+	"ed25519": {ssh.KeyAlgoED25519},
+	"ecdsa":   {ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521},
+	"rsa":     {ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA},
+}
+
+// runScan implements the scan command.
+func runScan(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout for scanning each host")
+	types := fs.String("t", "", "comma-separated key `types` to request: ed25519, ecdsa, rsa, or host key\nalgorithm names (default all)")
+	hashed := fs.Bool("H", false, "hash hostnames in output")
+	appendPath := fs.String("append", "", "append new keys to the known_hosts file at `path`, instead of\nwriting them to stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: knownhosts scan [flags] host[:port]...")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Obtains every host key offered by each host, like ssh-keyscan, and prints them")
+		fmt.Fprintln(stderr, "as known_hosts lines. The keys are not verified in any way.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	algorithms, err := parseKeyTypes(*types)
+	if err != nil {
+		fmt.Fprintf(stderr, "knownhosts: %v\n", err)
+		return exitUsage
+	}
+	var opts []knownhosts.WriteOption
+	if *hashed {
+		opts = append(opts, knownhosts.WithHashedHostnames())
+	}
+
+	status := exitOK
+	for _, host := range fs.Args() {
+		keys, err := scanHost(context.Background(), host, algorithms, *timeout)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", host, err)
+			status = exitFailure
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		if *appendPath != "" {
+			entries := make([]knownhosts.Entry, len(keys))
+			for n, key := range keys {
+				entries[n] = knownhosts.Entry{Patterns: []string{knownhosts.Normalize(host)}, Key: key}
+			}
+			added, err := knownhosts.AppendEntries(*appendPath, entries, opts...)
+			if err != nil {
+				fmt.Fprintf(stderr, "%s: %v\n", host, err)
+				status = exitFailure
+			} else {
+				fmt.Fprintf(stderr, "%s: %d key(s) found, %d line(s) added to %s\n", host, len(keys), added, *appendPath)
+			}
+			continue
+		}
+		for _, key := range keys {
+			if err := knownhosts.WriteKnownHostAddresses(stdout, []string{host}, key, opts...); err != nil {
+				fmt.Fprintf(stderr, "%s: %v\n", host, err)
+				status = exitFailure
+				break
+			}
+		}
+	}
+	return status
+}
+
+// parseKeyTypes converts a comma-separated list of key types, as accepted by
+// the scan command's -t flag, into host key algorithms. An empty list returns
+// nil, so that ScanAll's default algorithms are used.
+func parseKeyTypes(types string) ([]string, error) {
+	if types == "" {
+		return nil, nil
+	}
+	var algorithms []string
+	for _, t := range strings.Split(types, ",") {
+		t = strings.TrimSpace(t)
+		if algos, ok := keyTypeAlgorithms[t]; ok {
+			algorithms = append(algorithms, algos...)
+		} else if strings.Contains(t, "-") {
+			algorithms = append(algorithms, t)
+		} else {
+			return nil, fmt.Errorf("unknown key type %q", t)
+		}
+	}
+	return algorithms, nil
+}
+
+// scanHost obtains the host keys of host, which may omit the port to use port
+// 22. Any keys found are returned even if some scans failed.
+func scanHost(ctx context.Context, host string, algorithms []string, timeout time.Duration) ([]ssh.PublicKey, error) {
+	addr := host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "22")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return knownhosts.ScanAll(ctx, "tcp", addr, &knownhosts.ScanConfig{HostKeyAlgorithms: algorithms})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skeema/knownhosts"
+	"github.com/skeema/knownhosts/knownhoststest"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRunScan(t *testing.T) {
+	edSigner := knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519)
+	ecSigner := knownhoststest.GenerateSigner(t, ssh.KeyAlgoECDSA256)
+	server := knownhoststest.StartServer(t, edSigner, ecSigner)
+
+	// Obtain an address with nothing listening on it
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error from Listen: %v", err)
+	}
+	deadAddr := ln.Addr().String()
+	ln.Close()
+
+	runArgs := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := run(args, &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	// Output can be loaded as a known_hosts file which trusts both keys
+	code, stdout, stderr := runArgs("scan", server.Addr)
+	if code != exitOK || strings.Count(stdout, "\n") != 2 {
+		t.Fatalf("Unexpected result from scan: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, []byte(stdout), 0600); err != nil {
+		t.Fatalf("Unexpected error from WriteFile: %v", err)
+	}
+	kh, err := knownhosts.New(path)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	for _, signer := range []ssh.Signer{edSigner, ecSigner} {
+		if err := kh(server.Addr, knownhoststest.FakeRemote(server.Addr), signer.PublicKey()); err != nil {
+			t.Errorf("Unexpected error from callback for scanned %s key: %v", signer.PublicKey().Type(), err)
+		}
+	}
+
+	// Key types and hashing
+	if code, stdout, _ := runArgs("scan", "-t", "ed25519", "-H", server.Addr); code != exitOK || strings.Count(stdout, "\n") != 1 || !strings.HasPrefix(stdout, "|1|") || !strings.Contains(stdout, ssh.KeyAlgoED25519) {
+		t.Errorf("Unexpected result from scan -t ed25519 -H: exit %d, stdout %q", code, stdout)
+	}
+	if code, stdout, _ := runArgs("scan", "-t", ssh.KeyAlgoECDSA256, server.Addr); code != exitOK || strings.Count(stdout, "\n") != 1 || !strings.Contains(stdout, ssh.KeyAlgoECDSA256) {
+		t.Errorf("Unexpected result from scan -t %s: exit %d, stdout %q", ssh.KeyAlgoECDSA256, code, stdout)
+	}
+
+	// A failing host is reported without aborting the others
+	if code, stdout, stderr := runArgs("scan", "-timeout", "2s", deadAddr, server.Addr); code != exitFailure || strings.Count(stdout, "\n") != 2 || !strings.HasPrefix(stderr, deadAddr+": ") {
+		t.Errorf("Unexpected result from scan with unreachable host: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+
+	// Appending is idempotent
+	appendPath := filepath.Join(t.TempDir(), "known_hosts")
+	for _, expectAdded := range []string{"2 line(s) added", "0 line(s) added"} {
+		if code, stdout, stderr := runArgs("scan", "-append", appendPath, server.Addr); code != exitOK || stdout != "" || !strings.Contains(stderr, expectAdded) {
+			t.Errorf("Unexpected result from scan -append: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+		}
+	}
+	if entries, err := knownhosts.ReadEntries(appendPath); err != nil || len(entries) != 2 {
+		t.Errorf("Unexpected result from ReadEntries after scan -append: %v, %v", entries, err)
+	}
+
+	// Usage errors
+	for _, args := range [][]string{
+		{"scan"},
+		{"scan", "-t", "dsa", server.Addr},
+	} {
+		if code, _, _ := runArgs(args...); code != exitUsage {
+			t.Errorf("Expected exit code %d for args %q, instead found %d", exitUsage, args, code)
+		}
+	}
+}
+
+func TestParseKeyTypes(t *testing.T) {
+	if algos, err := parseKeyTypes(""); err != nil || algos != nil {
+		t.Errorf("Unexpected result from parseKeyTypes with empty list: %v, %v", algos, err)
+	}
+	algos, err := parseKeyTypes("ed25519, rsa-sha2-256,ecdsa")
+	if err != nil {
+		t.Fatalf("Unexpected error from parseKeyTypes: %v", err)
+	}
+	expected := []string{ssh.KeyAlgoED25519, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521}
+	if strings.Join(algos, ",") != strings.Join(expected, ",") {
+		t.Errorf("Unexpected result from parseKeyTypes: %v", algos)
+	}
+	if _, err := parseKeyTypes("ed25519,bogus"); err == nil {
+		t.Error("Expected error from parseKeyTypes with unknown type, but error was nil")
+	}
+}
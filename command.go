@@ -0,0 +1,216 @@
+package knownhosts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// CommandSource obtains known_hosts entries on demand from an external
+// command, similar to OpenSSH's KnownHostsCommand option. The command's
+// standard output is parsed as known_hosts lines, which are used for
+// verification without being written anywhere.
+//
+// The zero value is not usable; at least Argv must be set. A CommandSource
+// must not be copied after first use.
+type CommandSource struct {
+	// Argv is the command and its arguments. Within each argument, the tokens
+	// %h and %p are replaced with the host and port being looked up, %H is
+	// replaced with the host in known_hosts form as returned by Normalize, and
+	// %% is replaced with a literal percent sign.
+	Argv []string
+
+	// Timeout limits the run time of each command invocation. If zero, there is
+	// no limit.
+	Timeout time.Duration
+
+	// TTL controls how long the entries obtained for each host are cached. If
+	// zero, entries are cached for the lifetime of the CommandSource. If
+	// negative, entries are never cached, and the command is run for every
+	// lookup. Failed invocations are never cached.
+	TTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]commandResult
+}
+
+type commandResult struct {
+	entries []Entry
+	expires time.Time // zero if no expiration
+}
+
+// CommandError is returned when a CommandSource's command cannot be run,
+// exits with a non-zero status, times out, or produces output which cannot be
+// parsed as known_hosts lines.
+type CommandError struct {
+	Argv     []string // command and arguments, after token substitution
+	Stderr   string   // standard error output of the command, if any
+	TimedOut bool     // true if the command was killed due to Timeout
+	Err      error
+}
+
+// Error satisfies the error interface.
+func (ce *CommandError) Error() string {
+	msg := "knownhosts: command " + strings.Join(ce.Argv, " ") + " failed: " + ce.Err.Error()
+	if ce.TimedOut {
+		msg = "knownhosts: command " + strings.Join(ce.Argv, " ") + " timed out"
+	}
+	if stderr := strings.TrimSpace(ce.Stderr); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (ce *CommandError) Unwrap() error {
+	return ce.Err
+}
+
+// Entries returns the entries output by the command for hostWithPort, which
+// should be in host:port form. Results are cached according to cs.TTL. If
+// the command fails, the returned error will be a *CommandError.
+func (cs *CommandSource) Entries(hostWithPort string) ([]Entry, error) {
+	key := lookupHostname(hostWithPort)
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if result, ok := cs.cache[key]; ok && cs.TTL >= 0 && (result.expires.IsZero() || time.Now().Before(result.expires)) {
+		return result.entries, nil
+	}
+	entries, err := cs.run(key)
+	if err != nil {
+		return nil, err
+	}
+	if cs.TTL >= 0 {
+		if cs.cache == nil {
+			cs.cache = make(map[string]commandResult)
+		}
+		result := commandResult{entries: entries}
+		if cs.TTL > 0 {
+			result.expires = time.Now().Add(cs.TTL)
+		}
+		cs.cache[key] = result
+	}
+	return entries, nil
+}
+
+// run executes the command for hostWithPort and parses its output.
+func (cs *CommandSource) run(hostWithPort string) ([]Entry, error) {
+	host, port, err := net.SplitHostPort(hostWithPort)
+	if err != nil {
+		host, port = hostWithPort, "22"
+	}
+	if len(cs.Argv) == 0 {
+		return nil, &CommandError{Err: errors.New("no command specified")}
+	}
+	argv := make([]string, len(cs.Argv))
+	for n, arg := range cs.Argv {
+		if argv[n], err = expandTokens(arg, host, port, Normalize(hostWithPort)); err != nil {
+			return nil, &CommandError{Argv: cs.Argv, Err: err}
+		}
+	}
+
+	ctx := context.Background()
+	if cs.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cs.Timeout)
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, &CommandError{Argv: argv, Stderr: stderr.String(), TimedOut: ctx.Err() != nil, Err: err}
+	}
+
+	var entries []Entry
+	scanner := bufio.NewScanner(&stdout)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		entry, err := ParseLine(scanner.Bytes())
+		if err != nil {
+			return nil, &CommandError{Argv: argv, Err: fmt.Errorf("line %d: %w", lineNum, err)}
+		} else if entry.Key != nil {
+			entry.Filename, entry.Line = argv[0], lineNum
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &CommandError{Argv: argv, Err: err}
+	}
+	return entries, nil
+}
+
+// expandTokens replaces the tokens supported by CommandSource in arg.
+func expandTokens(arg, host, port, address string) (string, error) {
+	var b strings.Builder
+	for n := 0; n < len(arg); n++ {
+		if arg[n] != '%' {
+			b.WriteByte(arg[n])
+			continue
+		} else if n == len(arg)-1 {
+			return "", errors.New("invalid trailing % in argument " + arg)
+		}
+		n++
+		switch arg[n] {
+		case 'h':
+			b.WriteString(host)
+		case 'p':
+			b.WriteString(port)
+		case 'H':
+			b.WriteString(address)
+		case '%':
+			b.WriteByte('%')
+		default:
+			return "", fmt.Errorf("unknown token %%%c in argument %s", arg[n], arg)
+		}
+	}
+	return b.String(), nil
+}
+
+// HostKeyCallback returns a host key callback which verifies host keys against
+// the entries output by the command, in the same manner as a callback returned
+// by NewFromEntries. If the command fails, the callback returns the
+// *CommandError, so that verification fails closed rather than treating the
+// host as unknown. The callback may be combined with others using Chain.
+func (cs *CommandSource) HostKeyCallback() HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		entries, err := cs.Entries(hostname)
+		if err != nil {
+			return err
+		}
+		cb, err := NewFromEntries(entries)
+		if err != nil {
+			return err
+		}
+		return cb(hostname, remote, key)
+	}
+}
+
+// Chain returns a host key callback which tries each of callbacks in order,
+// until one of them knows the host: the result of the first callback whose
+// error does not satisfy IsHostUnknown is returned. If no callback knows the
+// host, the error from the last callback is returned, or an unknown host error
+// if callbacks is empty. This permits using sources such as a CommandSource
+// as either a fallback for, or in preference to, known_hosts files.
+func Chain(callbacks ...HostKeyCallback) HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		var err error = &xknownhosts.KeyError{}
+		for _, cb := range callbacks {
+			if err = cb(hostname, remote, key); !IsHostUnknown(err) {
+				return err
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,146 @@
+package knownhosts
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCommandSourceHelper is not a real test: it is invoked as a subprocess by
+// TestCommandSource, acting as a KnownHostsCommand. It records each invocation
+// in the file named by KNOWNHOSTS_TEST_LOG, and outputs the line in
+// KNOWNHOSTS_TEST_LINE for host "good.example.test".
+func TestCommandSourceHelper(t *testing.T) {
+	logPath := os.Getenv("KNOWNHOSTS_TEST_LOG")
+	if logPath == "" {
+		return
+	}
+	args := os.Args
+	for n, arg := range args {
+		if arg == "--" {
+			args = args[n+1:]
+			break
+		}
+	}
+	f, _ := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	fmt.Fprintln(f, strings.Join(args, " "))
+	f.Close()
+	switch args[0] {
+	case "good.example.test":
+		fmt.Println("# comment")
+		fmt.Println(os.Getenv("KNOWNHOSTS_TEST_LINE"))
+	case "garbage.example.test":
+		fmt.Println("this is not a known_hosts line")
+	case "fail.example.test":
+		fmt.Fprintln(os.Stderr, "lookup failed")
+		os.Exit(1)
+	case "slow.example.test":
+		time.Sleep(10 * time.Second)
+	}
+	os.Exit(0)
+}
+
+func TestCommandSource(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "log")
+	key, otherKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t)
+	t.Setenv("KNOWNHOSTS_TEST_LOG", logPath)
+	t.Setenv("KNOWNHOSTS_TEST_LINE", Line([]string{"good.example.test"}, key))
+	cs := &CommandSource{
+		Argv:    []string{os.Args[0], "-test.run=^TestCommandSourceHelper$", "--", "%h", "%p", "%H", "100%%"},
+		Timeout: 2 * time.Second,
+	}
+	invocations := func() []string {
+		contents, _ := os.ReadFile(logPath)
+		return strings.Split(strings.TrimSpace(string(contents)), "\n")
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	cb := cs.HostKeyCallback()
+
+	if err := cb("good.example.test:22", noAddr, key); err != nil {
+		t.Errorf("Unexpected error from callback: %v", err)
+	}
+	if err := cb("GOOD.example.test:22", noAddr, otherKey); !IsHostKeyChanged(err) {
+		t.Errorf("Expected host key changed error, instead found %v", err)
+	}
+	if keys := cb.HostKeys("good.example.test:22"); len(keys) != 1 {
+		t.Errorf("Expected HostKeys to return 1 key, instead found %d", len(keys))
+	}
+	if err := cb("other.example.test:2222", noAddr, key); !IsHostUnknown(err) {
+		t.Errorf("Expected unknown host error, instead found %v", err)
+	}
+	if inv := invocations(); len(inv) != 2 || inv[0] != "good.example.test 22 good.example.test 100%" || inv[1] != "other.example.test 2222 [other.example.test]:2222 100%" {
+		t.Errorf("Unexpected invocations: %q", inv)
+	}
+
+	// Failures are typed errors, fail closed, and are not cached
+	var cmdErr *CommandError
+	for _, host := range []string{"fail.example.test:22", "garbage.example.test:22", "slow.example.test:22"} {
+		err := cb(host, noAddr, key)
+		if !errors.As(err, &cmdErr) || IsHostUnknown(err) {
+			t.Errorf("Expected *CommandError for %s, instead found %v", host, err)
+		}
+	}
+	if err := cb("fail.example.test:22", noAddr, key); !errors.As(err, &cmdErr) || !strings.Contains(err.Error(), "lookup failed") {
+		t.Errorf("Unexpected error: %v", err)
+	} else if cmdErr.TimedOut {
+		t.Error("Expected TimedOut to be false")
+	}
+	if err := cb("slow.example.test:22", noAddr, key); !errors.As(err, &cmdErr) || !cmdErr.TimedOut {
+		t.Errorf("Expected timeout error, instead found %v", err)
+	}
+	if inv := invocations(); len(inv) != 7 {
+		t.Errorf("Expected 7 invocations, instead found %d", len(inv))
+	}
+
+	// With a negative TTL, results are never cached
+	cs.TTL = -1
+	for n := 0; n < 2; n++ {
+		if err := cb("good.example.test:22", noAddr, key); err != nil {
+			t.Errorf("Unexpected error from callback: %v", err)
+		}
+	}
+	if inv := invocations(); len(inv) != 9 {
+		t.Errorf("Expected 9 invocations, instead found %d", len(inv))
+	}
+
+	badTokens := &CommandSource{Argv: []string{os.Args[0], "%x"}}
+	if _, err := badTokens.Entries("good.example.test:22"); !errors.As(err, &cmdErr) {
+		t.Errorf("Expected *CommandError for unknown token, instead found %v", err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	khPath := getTestKnownHosts(t)
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	key := generatePubKeyEd25519(t)
+	extra, err := NewFromEntries([]Entry{{Patterns: []string{"extra.example.test", "[multi.example.test]:2233"}, Key: key}})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromEntries: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+
+	cb := Chain(kh, extra)
+	if err := cb("extra.example.test:22", noAddr, key); err != nil {
+		t.Errorf("Expected fallback callback to accept key, instead found %v", err)
+	}
+	if err := cb("multi.example.test:2233", noAddr, key); !IsHostKeyChanged(err) {
+		t.Errorf("Expected first callback's result to take precedence, instead found %v", err)
+	}
+	if err := Chain(extra, kh)("multi.example.test:2233", noAddr, key); err != nil {
+		t.Errorf("Expected first callback's result to take precedence, instead found %v", err)
+	}
+	if err := cb("unknown.example.test:22", noAddr, key); !IsHostUnknown(err) {
+		t.Errorf("Expected unknown host error, instead found %v", err)
+	}
+	if err := Chain()("unknown.example.test:22", noAddr, key); !IsHostUnknown(err) {
+		t.Errorf("Expected unknown host error from empty chain, instead found %v", err)
+	}
+}
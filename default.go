@@ -0,0 +1,75 @@
+package knownhosts
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// DefaultFiles returns the paths of the known_hosts files which OpenSSH reads
+// by default on the current platform, in the order OpenSSH reads them: the
+// user's files (UserKnownHostsFile) followed by the system-wide files
+// (GlobalKnownHostsFile). Paths are returned regardless of whether the files
+// exist. The first path is the conventional location for appending newly
+// accepted keys.
+//
+// On Windows, these are known_hosts and known_hosts2 in %USERPROFILE%\.ssh,
+// followed by ssh_known_hosts and ssh_known_hosts2 in %ProgramData%\ssh, as
+// used by OpenSSH for Windows. On other platforms, these are known_hosts and
+// known_hosts2 in ~/.ssh, followed by ssh_known_hosts and ssh_known_hosts2 in
+// /etc/ssh. If the user's home directory or the system-wide directory cannot
+// be determined, the corresponding paths are omitted. Duplicate paths, such as
+// the same file reached via different casing on Windows, are only returned
+// once.
+func DefaultFiles() []string {
+	var files []string
+	if home, err := os.UserHomeDir(); err == nil && home != "" {
+		dir := filepath.Join(home, ".ssh")
+		files = append(files, filepath.Join(dir, "known_hosts"), filepath.Join(dir, "known_hosts2"))
+	}
+	if dir := systemConfigDir(); dir != "" {
+		files = append(files, filepath.Join(dir, "ssh_known_hosts"), filepath.Join(dir, "ssh_known_hosts2"))
+	}
+	return dedupeFiles(files)
+}
+
+// NewDefault creates a host key callback from whichever of the paths returned
+// by DefaultFiles exist. If none of them exist, the returned callback treats
+// every host as unknown. An error is returned if an existing file cannot be
+// read or contains a malformed line.
+func NewDefault() (HostKeyCallback, error) {
+	var files []string
+	for _, path := range DefaultFiles() {
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return New(files...)
+}
+
+// dedupeFiles returns paths with duplicates removed, keeping the first
+// occurrence of each. Paths are considered duplicates if they are equal after
+// cleaning (case-insensitively on Windows), or if they refer to the same
+// existing file.
+func dedupeFiles(paths []string) []string {
+	var result []string
+	var infos []os.FileInfo
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		fi, _ := os.Stat(path)
+		dupe := false
+		for n := range result {
+			if samePath(path, result[n]) || (fi != nil && infos[n] != nil && os.SameFile(fi, infos[n])) {
+				dupe = true
+				break
+			}
+		}
+		if !dupe {
+			result = append(result, path)
+			infos = append(infos, fi)
+		}
+	}
+	return result
+}
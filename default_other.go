@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package knownhosts
+
+// systemConfigDir returns the directory containing OpenSSH's system-wide
+// configuration.
+func systemConfigDir() string {
+	return "/etc/ssh"
+}
+
+// samePath returns true if the cleaned paths a and b are equal.
+func samePath(a, b string) bool {
+	return a == b
+}
@@ -0,0 +1,67 @@
+package knownhosts
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDefaultFiles(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("Test uses HOME environment variable")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	expected := []string{
+		filepath.Join(home, ".ssh", "known_hosts"),
+		filepath.Join(home, ".ssh", "known_hosts2"),
+		"/etc/ssh/ssh_known_hosts",
+		"/etc/ssh/ssh_known_hosts2",
+	}
+	files := DefaultFiles()
+	if len(files) != len(expected) {
+		t.Fatalf("Expected DefaultFiles to return %v, instead found %v", expected, files)
+	}
+	for n := range files {
+		if files[n] != expected[n] {
+			t.Errorf("Expected DefaultFiles()[%d] to be %q, instead found %q", n, expected[n], files[n])
+		}
+	}
+
+	// NewDefault should load only the files which exist
+	key := generatePubKeyEd25519(t)
+	if err := os.Mkdir(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatalf("Unable to create directory: %v", err)
+	}
+	if err := os.WriteFile(expected[1], []byte(Line([]string{"default.example.test"}, key)+"\n"), 0600); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	cb, err := NewDefault()
+	if err != nil {
+		t.Fatalf("Unexpected error from NewDefault: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	if err := cb("default.example.test:22", noAddr, key); err != nil {
+		t.Errorf("Unexpected error from callback: %v", err)
+	}
+}
+
+func TestDedupeFiles(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	link := filepath.Join(dir, "linked_known_hosts")
+	if err := os.Link(file, link); err != nil {
+		t.Skipf("Unable to create hard link: %v", err)
+	}
+	other := filepath.Join(dir, "other")
+	input := []string{file, filepath.Join(dir, ".", "known_hosts"), other, link, other + string(filepath.Separator)}
+	files := dedupeFiles(input)
+	if len(files) != 2 || files[0] != file || files[1] != other {
+		t.Errorf("Unexpected result from dedupeFiles(%v): %v", input, files)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build windows
+// +build windows
+
+package knownhosts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// systemConfigDir returns the directory containing OpenSSH for Windows'
+// system-wide configuration, %ProgramData%\ssh. If the ProgramData environment
+// variable is not set, the ProgramData known folder is used instead. An empty
+// string is returned if neither is available.
+func systemConfigDir() string {
+	programData := os.Getenv("ProgramData")
+	if programData == "" {
+		programData, _ = windows.KnownFolderPath(windows.FOLDERID_ProgramData, 0)
+	}
+	if programData == "" {
+		return ""
+	}
+	return filepath.Join(programData, "ssh")
+}
+
+// samePath returns true if the cleaned paths a and b are equal, ignoring case,
+// since Windows file systems are case-insensitive.
+func samePath(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
@@ -0,0 +1,62 @@
+//go:build windows
+// +build windows
+
+package knownhosts
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaultFilesWindows(t *testing.T) {
+	profile, programData := t.TempDir(), t.TempDir()
+	t.Setenv("USERPROFILE", profile)
+	t.Setenv("ProgramData", programData)
+	expected := []string{
+		filepath.Join(profile, ".ssh", "known_hosts"),
+		filepath.Join(profile, ".ssh", "known_hosts2"),
+		filepath.Join(programData, "ssh", "ssh_known_hosts"),
+		filepath.Join(programData, "ssh", "ssh_known_hosts2"),
+	}
+	files := DefaultFiles()
+	if len(files) != len(expected) {
+		t.Fatalf("Expected DefaultFiles to return %v, instead found %v", expected, files)
+	}
+	for n := range files {
+		if files[n] != expected[n] {
+			t.Errorf("Expected DefaultFiles()[%d] to be %q, instead found %q", n, expected[n], files[n])
+		}
+	}
+
+	// Paths differing only by case and separators are the same file
+	if files := dedupeFiles([]string{`C:\Foo\known_hosts`, `c:\foo\KNOWN_HOSTS`, `C:/Foo/known_hosts`}); len(files) != 1 {
+		t.Errorf("Expected paths to be deduplicated, instead found %v", files)
+	}
+
+	// Without ProgramData, the known folder is used, or system-wide files are
+	// omitted
+	t.Setenv("ProgramData", "")
+	files = DefaultFiles()
+	if len(files) != 2 && len(files) != 4 {
+		t.Fatalf("Expected DefaultFiles to return 2 or 4 paths, instead found %v", files)
+	}
+	for _, file := range files {
+		if !filepath.IsAbs(file) {
+			t.Errorf("Expected DefaultFiles to return absolute paths, instead found %q", file)
+		}
+	}
+	if len(files) == 4 && !strings.HasSuffix(files[2], `\ssh\ssh_known_hosts`) {
+		t.Errorf("Unexpected system-wide path %q", files[2])
+	}
+	if _, err := NewDefault(); err != nil {
+		t.Errorf("Unexpected error from NewDefault: %v", err)
+	}
+
+	// Without USERPROFILE, user files are omitted
+	t.Setenv("USERPROFILE", "")
+	t.Setenv("ProgramData", programData)
+	if files := DefaultFiles(); len(files) != 2 || files[0] != expected[2] {
+		t.Errorf("Expected DefaultFiles to return only system-wide paths, instead found %v", files)
+	}
+}
@@ -0,0 +1,130 @@
+package knownhosts
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DialConfig configures the behavior of Dial.
+type DialConfig struct {
+	// Callback is used to verify host keys, and may be a HostKeyCallback or any
+	// other KeyDB. If nil, a callback is created by calling New with Files.
+	Callback KeyDB
+
+	// Files lists the known_hosts files to use if Callback is nil.
+	Files []string
+
+	// Policy determines how unknown hosts are handled. If Policy.AppendPath is
+	// empty, keys accepted by the policy are appended to the first of Files,
+	// if any.
+	Policy Policy
+
+	// User and Auth are used to authenticate to the server.
+	User string
+	Auth []ssh.AuthMethod
+
+	// Timeout limits the duration of the connection and handshake, in addition
+	// to any deadline of the context passed to Dial. If zero, there is no
+	// additional limit.
+	Timeout time.Duration
+
+	// Dialer is used to connect to the server. If nil, a zero net.Dialer is
+	// used.
+	Dialer *net.Dialer
+}
+
+// Dial connects to the SSH server at addr, verifying its host key using the
+// known_hosts settings in cfg. This wires together ClientConfigFor,
+// PolicyCallback, and ssh.NewClientConn: the address is given a default port of 22 if it lacks
+// one, HostKeyAlgorithms are populated for the specific host, and keys
+// accepted by cfg.Policy are appended under lock via AppendKnownHost.
+//
+// Unlike ssh.Dial, any deadline or cancellation of ctx applies to both the
+// connection and the SSH handshake. If the host key is rejected, the error
+// returned is the error from the host key callback, so that it may be examined
+// with IsHostUnknown and IsHostKeyChanged.
+func Dial(ctx context.Context, network, addr string, cfg *DialConfig) (*ssh.Client, error) {
+	if cfg == nil {
+		cfg = &DialConfig{}
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "22")
+	}
+	db := cfg.Callback
+	if isNilKeyDB(db) {
+		cb, err := New(cfg.Files...)
+		if err != nil {
+			return nil, err
+		}
+		db = cb
+	}
+	policy := cfg.Policy
+	if policy.AppendPath == "" && len(cfg.Files) > 0 {
+		policy.AppendPath = cfg.Files[0]
+	}
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	config := ClientConfigFor(db, addr, &ssh.ClientConfig{User: cfg.User, Auth: cfg.Auth})
+	config.HostKeyCallback = PolicyCallback(db, policy).HostKeyCallback()
+	// ssh.NewClientConn does not wrap the host key callback's error, so retain
+	// it in order to return it as-is
+	var hostKeyErr error
+	verify := config.HostKeyCallback
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		hostKeyErr = verify(hostname, remote, key)
+		return hostKeyErr
+	}
+
+	dialer := cfg.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Close the connection if ctx is cancelled mid-handshake, to unblock it
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	close(done)
+	if err != nil {
+		conn.Close()
+		if hostKeyErr != nil {
+			return nil, hostKeyErr
+		} else if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		} else if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			// The connection deadline may fire slightly before the context
+			// records its expiry
+			return nil, context.DeadlineExceeded
+		}
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		// The context was cancelled just as the handshake completed, so the
+		// connection may have been closed
+		c.Close()
+		return nil, ctx.Err()
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
@@ -0,0 +1,97 @@
+package knownhosts_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skeema/knownhosts"
+	"github.com/skeema/knownhosts/knownhoststest"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDial(t *testing.T) {
+	signer := knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519)
+	addr := knownhoststest.StartServer(t, signer).Addr
+	otherAddr := knownhoststest.StartServer(t, knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519)).Addr
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", path, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Strict policy rejects unknown host, with a classifiable error
+	if _, err := knownhosts.Dial(ctx, "tcp", addr, &knownhosts.DialConfig{Files: []string{path}}); !knownhosts.IsHostUnknown(err) {
+		t.Errorf("Expected Dial to return an error satisfying IsHostUnknown, instead found %v", err)
+	}
+
+	// Accept-new policy connects and appends the key to the first file
+	cfg := &knownhosts.DialConfig{Files: []string{path}, Policy: knownhosts.Policy{Mode: knownhosts.PolicyAcceptNew}}
+	client, err := knownhosts.Dial(ctx, "tcp", addr, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error from Dial: %v", err)
+	}
+	client.Close()
+	kh, err := knownhosts.New(path)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	if keys := kh.HostKeys(addr); len(keys) != 1 || string(keys[0].Marshal()) != string(signer.PublicKey().Marshal()) {
+		t.Errorf("Expected accepted key to be appended to %s, instead found keys %v", path, keys)
+	}
+
+	// Now the host is known, so the strict policy permits it
+	client, err = knownhosts.Dial(ctx, "tcp", addr, &knownhosts.DialConfig{Files: []string{path}})
+	if err != nil {
+		t.Fatalf("Unexpected error from Dial: %v", err)
+	}
+	client.Close()
+
+	// A different key for a known host is rejected, even with accept-new
+	_, otherPort, _ := net.SplitHostPort(otherAddr)
+	cb, err := knownhosts.NewFromEntries([]knownhosts.Entry{{
+		Patterns: []string{knownhosts.Normalize(net.JoinHostPort("127.0.0.1", otherPort))},
+		Key:      signer.PublicKey(),
+	}})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromEntries: %v", err)
+	}
+	cfg = &knownhosts.DialConfig{Callback: cb, Policy: knownhosts.Policy{Mode: knownhosts.PolicyAcceptNew}}
+	if _, err := knownhosts.Dial(ctx, "tcp", otherAddr, cfg); !knownhosts.IsHostKeyChanged(err) {
+		t.Errorf("Expected Dial to return an error satisfying IsHostKeyChanged, instead found %v", err)
+	}
+}
+
+func TestDialCancel(t *testing.T) {
+	// Server which accepts connections but never speaks SSH
+	addr := startTestServer(t, func(conn net.Conn) {
+		buf := make([]byte, 1024)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				return
+			}
+		}
+	})
+	cfg := &knownhosts.DialConfig{Callback: knownhosts.HostKeyCallback(ssh.InsecureIgnoreHostKey()), Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	if _, err := knownhosts.Dial(context.Background(), "tcp", addr, cfg); err != context.DeadlineExceeded {
+		t.Errorf("Expected Dial to return context.DeadlineExceeded, instead found %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Dial took too long to time out: %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	cfg.Timeout = 0
+	if _, err := knownhosts.Dial(ctx, "tcp", addr, cfg); err != context.Canceled {
+		t.Errorf("Expected Dial to return context.Canceled, instead found %v", err)
+	}
+}
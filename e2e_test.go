@@ -0,0 +1,110 @@
+package knownhosts_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/skeema/knownhosts"
+	"github.com/skeema/knownhosts/knownhoststest"
+	"golang.org/x/crypto/ssh"
+)
+
+// capturingCallback wraps cb, recording the error it last returned, since
+// golang.org/x/crypto/ssh does not wrap host key callback errors.
+func capturingCallback(cb knownhosts.HostKeyCallback, lastErr *error) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		*lastErr = cb(hostname, remote, key)
+		return *lastErr
+	}
+}
+
+// These tests exercise callbacks in genuine SSH handshakes, using servers from
+// the knownhoststest package.
+func TestEndToEnd(t *testing.T) {
+	edSigner := knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519)
+	ecSigner := knownhoststest.GenerateSigner(t, ssh.KeyAlgoECDSA256)
+	server := knownhoststest.StartServer(t, edSigner, ecSigner)
+	serverHost := knownhosts.Normalize(server.Addr)
+
+	// Only the server's ed25519 key is known. Without HostKeyAlgorithms,
+	// golang.org/x/crypto/ssh prefers ECDSA, which is reported as a changed key.
+	kh, err := knownhosts.New(knownhoststest.WriteTempKnownHosts(t, knownhosts.Entry{Patterns: []string{serverHost}, Key: edSigner.PublicKey()}))
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	var cbErr error
+	if err := server.Handshake("", &ssh.ClientConfig{HostKeyCallback: capturingCallback(kh, &cbErr)}); err == nil || !knownhosts.IsHostKeyChanged(cbErr) {
+		t.Errorf("Expected handshake without HostKeyAlgorithms to fail with changed key, instead found %v (callback error %v)", err, cbErr)
+	} else if knownhosts.IsHostKeyChanged(err) {
+		t.Error("Expected golang.org/x/crypto/ssh to discard the callback error type; if this changed, callers no longer need to capture it")
+	}
+	if err := server.Handshake("", kh.ClientConfig(server.Addr, nil)); err != nil {
+		t.Errorf("Unexpected error from handshake with HostKeyAlgorithms: %v", err)
+	}
+
+	// Changed key
+	other := knownhoststest.StartServer(t, knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519))
+	if err := other.Handshake(server.Addr, &ssh.ClientConfig{HostKeyCallback: capturingCallback(kh, &cbErr)}); err == nil || !knownhosts.IsHostKeyChanged(cbErr) {
+		t.Errorf("Expected changed key, instead found %v (callback error %v)", err, cbErr)
+	}
+
+	// Unknown host
+	if err := other.Handshake("", &ssh.ClientConfig{HostKeyCallback: capturingCallback(kh, &cbErr)}); err == nil || !knownhosts.IsHostUnknown(cbErr) {
+		t.Errorf("Expected unknown host, instead found %v (callback error %v)", err, cbErr)
+	}
+
+	// Dial preserves the callback error type
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := knownhosts.Dial(ctx, "tcp", other.Addr, &knownhosts.DialConfig{Callback: kh}); !knownhosts.IsHostUnknown(err) {
+		t.Errorf("Expected Dial to return unknown host error, instead found %v", err)
+	}
+}
+
+func TestEndToEndCertificates(t *testing.T) {
+	f := knownhoststest.NewFixtures(t)
+	khPath := knownhoststest.WriteTempKnownHosts(t, f.Entries()...)
+	kh, err := knownhosts.New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	server := knownhoststest.StartServer(t, f.HostCertSigner(t, "server.ca.example.test"))
+
+	// HostKeyAlgorithms reports the key type of @cert-authority lines rather
+	// than certificate algorithms, so it cannot be used for hosts which are
+	// only known via a CA; the defaults of golang.org/x/crypto/ssh are used
+	// instead.
+	if algos := kh.HostKeyAlgorithms("server.ca.example.test:22"); len(algos) != 1 || algos[0] != ssh.KeyAlgoED25519 {
+		t.Errorf("Unexpected result from HostKeyAlgorithms for host known via CA: %v", algos)
+	}
+	config := &ssh.ClientConfig{HostKeyCallback: kh.HostKeyCallback()}
+	if err := server.Handshake("server.ca.example.test:22", config); err != nil {
+		t.Errorf("Unexpected error from handshake with trusted host certificate: %v", err)
+	}
+
+	// With certificate detection, the certificate algorithm is reported instead,
+	// and may be used to populate ssh.ClientConfig.HostKeyAlgorithms
+	detecting, err := kh.WithCertDetection(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from WithCertDetection: %v", err)
+	}
+	if algos := detecting.HostKeyAlgorithms("server.ca.example.test:22"); len(algos) != 1 || algos[0] != ssh.CertAlgoED25519v01 {
+		t.Errorf("Unexpected result from HostKeyAlgorithms with cert detection: %v", algos)
+	}
+	if err := server.Handshake("server.ca.example.test:22", detecting.ClientConfig("server.ca.example.test:22", nil)); err != nil {
+		t.Errorf("Unexpected error from handshake using cert detection: %v", err)
+	}
+
+	// Certificate for a principal other than the host being dialed
+	if err := server.Handshake("other.ca.example.test:22", config); err == nil {
+		t.Error("Expected handshake to fail for certificate with wrong principal, but it succeeded")
+	}
+
+	// Revoked key
+	revoked := knownhoststest.StartServer(t, f.RevokedSigner)
+	if err := revoked.Handshake(f.RevokedHost+":22", kh.ClientConfig(f.RevokedHost+":22", nil)); err == nil {
+		t.Error("Expected handshake to fail for revoked key, but it succeeded")
+	}
+}
@@ -0,0 +1,549 @@
+package knownhosts
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Entry represents a single known_hosts line which specifies a host key.
+//
+// An entry read from a file may have a Marker other than the known constants,
+// if the line began with an unrecognized marker such as one introduced by a
+// future version of OpenSSH. Such markers are preserved so that the line can be
+// written back unchanged, but as in OpenSSH, those entries are ignored for
+// trust decisions: NewFromEntries skips them.
+type Entry struct {
+	Marker   Marker   // MarkerCertAuthority, MarkerRevoked, or MarkerNone; see above
+	Patterns []string // host patterns, which may be hashed, wildcards, or negated
+	Key      ssh.PublicKey
+	Comment  string // optional text following the key, if any
+	Filename string // source file, if the entry was read from a file
+	Line     int    // 1-based line number in Filename, if read from a file
+	Raw      string // verbatim line text without its line ending, if parsed
+
+	// keyBase64 retains the key's encoding exactly as it appeared in the source
+	// line, if the entry was parsed.
+	keyBase64 string
+}
+
+// Hashed returns true if any of the entry's host patterns are hashed.
+func (e Entry) Hashed() bool {
+	for _, pattern := range e.Patterns {
+		if strings.HasPrefix(pattern, hashMagic) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesHost returns true if any of the entry's host patterns is a hashed
+// pattern matching host, or a plaintext pattern identical to host after both
+// are normalized using Normalize. Wildcard and negated patterns are compared
+// literally rather than evaluated, and the entry's marker is not considered.
+// This is the same matching used by RemoveHost, so for a host without
+// wildcards or a leading "!" it may be used to determine which entries
+// RemoveHost would affect. RemoveHost never removes wildcard or negated
+// patterns.
+func (e Entry) MatchesHost(host string) bool {
+	address := Normalize(host)
+	for _, pattern := range e.Patterns {
+		if patternMatchesAddress(pattern, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodedKey returns the base64 encoding of the entry's key, using the
+// original encoding from the source line if available.
+func (e Entry) encodedKey() string {
+	if e.keyBase64 != "" {
+		return e.keyBase64
+	}
+	return base64.StdEncoding.EncodeToString(e.Key.Marshal())
+}
+
+// Format returns the entry formatted as a known_hosts line, without a trailing
+// newline. Patterns are output as-is, without normalization, and the key uses
+// its original base64 encoding if the entry was parsed. For any valid
+// known_hosts line, formatting the result of ParseLine yields a line which is
+// semantically identical to the original, differing at most in whitespace.
+func (e Entry) Format() string {
+	fields := []string{strings.Join(e.Patterns, ","), e.Key.Type(), e.encodedKey()}
+	if e.Marker != MarkerNone {
+		fields = append([]string{string(e.Marker)}, fields...)
+	}
+	if e.Comment != "" {
+		fields = append(fields, e.Comment)
+	}
+	return strings.Join(fields, " ")
+}
+
+// entryJSON is the JSON representation of an Entry.
+type entryJSON struct {
+	Patterns          []string `json:"patterns"`
+	Hashed            bool     `json:"hashed"`
+	Marker            Marker   `json:"marker"`
+	KeyType           string   `json:"keyType"`
+	KeyBase64         string   `json:"keyBase64"`
+	SHA256Fingerprint string   `json:"sha256Fingerprint"`
+	Comment           string   `json:"comment"`
+	Filename          string   `json:"filename"`
+	Line              int      `json:"line"`
+}
+
+// MarshalJSON returns a JSON object representing the entry. The key is
+// included both in its original base64 encoding and as a SHA256 fingerprint.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	if e.Key == nil {
+		return nil, errors.New("knownhosts: cannot marshal entry with nil key")
+	}
+	return json.Marshal(entryJSON{
+		Patterns:          e.Patterns,
+		Hashed:            e.Hashed(),
+		Marker:            e.Marker,
+		KeyType:           e.Key.Type(),
+		KeyBase64:         e.encodedKey(),
+		SHA256Fingerprint: e.FingerprintSHA256(),
+		Comment:           e.Comment,
+		Filename:          e.Filename,
+		Line:              e.Line,
+	})
+}
+
+// UnmarshalJSON populates the entry from a JSON object in the format produced
+// by MarshalJSON. The hashed and sha256Fingerprint fields are ignored, since
+// they are derived from other fields.
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	var ej entryJSON
+	if err := json.Unmarshal(data, &ej); err != nil {
+		return err
+	}
+	if len(ej.Patterns) == 0 {
+		return errors.New("knownhosts: JSON entry has no patterns")
+	}
+	key, err := parseKeyFields(ej.KeyType, ej.KeyBase64)
+	if err != nil {
+		return fmt.Errorf("knownhosts: %w", err)
+	}
+	*e = Entry{
+		Marker:    ej.Marker,
+		Patterns:  ej.Patterns,
+		Key:       key,
+		Comment:   ej.Comment,
+		Filename:  ej.Filename,
+		Line:      ej.Line,
+		keyBase64: ej.KeyBase64,
+	}
+	return nil
+}
+
+// ExportJSON reads the known_hosts files at the supplied paths, and returns a
+// JSON array containing an object for each entry, in the format described by
+// Entry.MarshalJSON. Comment lines and blank lines are not included. An error
+// is returned if any file cannot be read or contains a malformed line.
+func ExportJSON(files ...string) ([]byte, error) {
+	entries, err := ReadEntries(files...)
+	if err != nil {
+		return nil, err
+	} else if entries == nil {
+		entries = []Entry{}
+	}
+	return json.MarshalIndent(entries, "", "  ")
+}
+
+// ImportJSON parses a JSON array in the format produced by ExportJSON, and
+// writes the corresponding known_hosts lines to w. Each line is generated from
+// the JSON object's patterns, marker, keyType, keyBase64, and comment fields.
+//
+// Every entry is validated before anything is written, in the same manner as
+// LineWithOptions: an *InvalidHostnameError is returned for any pattern which
+// would corrupt the line, and an error for an unknown marker or a comment
+// containing newlines or other control characters. Hashed patterns must be
+// well-formed, and are written as-is.
+func ImportJSON(w io.Writer, data []byte) error {
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if _, err := ParseMarker(string(e.Marker)); err != nil {
+			return err
+		} else if err := validateKey(e.Key); err != nil {
+			return err
+		} else if err := validateComment(e.Comment); err != nil {
+			return err
+		}
+		for _, pattern := range e.Patterns {
+			if err := validateEntryPattern(pattern); err != nil {
+				return err
+			}
+		}
+	}
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		bw.WriteString(e.Format() + "\n")
+	}
+	return bw.Flush()
+}
+
+// NewFromEntries creates a host key callback from in-memory entries, rather
+// than from known_hosts files. The returned callback supports all of the same
+// functionality as one returned by New, including @cert-authority and @revoked
+// markers. Entries with any other marker are ignored. An error is returned if
+// any entry has a nil key, no patterns, or an empty pattern, or if its patterns
+// would be rejected by New, such as a malformed hashed pattern.
+//
+// Lookups are performed entirely in memory, so no files are read or written.
+// Host patterns are matched using MatchesLine, which follows OpenSSH's rules.
+// These differ from those of New in a few edge cases: matching is
+// case-insensitive, and wildcards apply to the entire "[host]:port" form of a
+// host on a nonstandard port, so for example "*" matches hosts on any port.
+//
+// The KnownKey values reported in errors from the callback use each entry's
+// Filename and Line fields. For entries with no Filename, the synthetic
+// filename "entries" is used, along with the entry's 1-based position in
+// entries if its Line is zero.
+func NewFromEntries(entries []Entry) (HostKeyCallback, error) {
+	return newFromEntries(entries, nil)
+}
+
+// newFromEntries implements NewFromEntries, validating host certificates using
+// now as the current time, or time.Now if nil.
+func newFromEntries(entries []Entry, now func() time.Time) (HostKeyCallback, error) {
+	db := &memoryDB{revoked: make(map[string]*xknownhosts.KnownKey), now: now}
+	for n, e := range entries {
+		if e.Key == nil {
+			return nil, fmt.Errorf("knownhosts: entry %d has nil key", n+1)
+		} else if len(e.Patterns) == 0 {
+			return nil, fmt.Errorf("knownhosts: entry %d has no patterns", n+1)
+		}
+		for _, pattern := range e.Patterns {
+			if pattern == "" || strings.ContainsAny(pattern, " \t\r\n,") {
+				return nil, fmt.Errorf("knownhosts: entry %d has invalid pattern %q", n+1, pattern)
+			}
+		}
+		if !e.Marker.known() {
+			continue
+		}
+		kk := xknownhosts.KnownKey{Key: e.Key, Filename: e.Filename, Line: e.Line}
+		if kk.Filename == "" {
+			kk.Filename = "entries"
+		}
+		if kk.Line == 0 {
+			kk.Line = n + 1
+		}
+		if err := db.add(e, kk); err != nil {
+			return nil, fmt.Errorf("knownhosts: entry %d has invalid patterns: %w", n+1, err)
+		}
+	}
+	return lenientLookup(db.callback()), nil
+}
+
+// ReadEntries reads the known_hosts files at the supplied paths, and returns
+// all of their entries in order, with each entry's Filename and Line fields
+// populated. Comment lines and blank lines are skipped. An error is returned if
+// any file cannot be read, or a *ParseError if any file contains a malformed
+// line.
+//
+// When multiple files are supplied, they are read and parsed concurrently, with
+// at most GOMAXPROCS files in progress at a time. The result is the same as
+// reading them sequentially: entries are returned in the order the files were
+// supplied. If any file fails, reading of the remaining files is abandoned,
+// and the error of the first failing file in the supplied order is returned.
+//
+// The default limits described by DefaultMaxFileSize, DefaultMaxLineLength,
+// and DefaultMaxEntries apply, and a *LimitError is returned if any file
+// exceeds them. Use ReadEntriesWithOptions to configure the limits.
+func ReadEntries(files ...string) ([]Entry, error) {
+	return ReadEntriesWithOptions(files)
+}
+
+// ReadEntriesWithOptions behaves like ReadEntries, but with the limits
+// configured by opts, such as WithMaxFileSize. Reading stops as soon as a limit
+// is exceeded, so memory use is bounded even for adversarial input.
+func ReadEntriesWithOptions(files []string, opts ...ReadOption) ([]Entry, error) {
+	ro := newReadOptions(opts)
+	if len(files) == 1 {
+		return readEntries(files[0], nil, ro)
+	}
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(files) {
+		workers = len(files)
+	}
+	results := make([][]Entry, len(files))
+	errs := make([]error, len(files))
+	done := make(chan struct{})
+	var closeDone sync.Once
+	indexes := make(chan int, len(files))
+	for n := range files {
+		indexes <- n
+	}
+	close(indexes)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range indexes {
+				select {
+				case <-done:
+					errs[n] = errReadAbandoned
+					continue
+				default:
+				}
+				if results[n], errs[n] = readEntries(files[n], done, ro); errs[n] != nil {
+					closeDone.Do(func() { close(done) })
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var entries []Entry
+	for n := range files {
+		if errs[n] != nil && !errors.Is(errs[n], errReadAbandoned) {
+			return nil, errs[n]
+		}
+		entries = append(entries, results[n]...)
+	}
+	return entries, nil
+}
+
+// errReadAbandoned is used internally by ReadEntries to stop reading files
+// once another file has failed.
+var errReadAbandoned = errors.New("knownhosts: read abandoned")
+
+// readEntries returns the entries in the known_hosts file at path, skipping
+// comments and blank lines. If done is non-nil and becomes closed, reading
+// stops early with errReadAbandoned.
+func readEntries(path string, done <-chan struct{}, ro readOptions) ([]Entry, error) {
+	f, r, err := openLimited(path, ro)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if done == nil {
+		return parseEntries(r, path, nil, ro)
+	}
+	return parseEntries(&abandonableReader{r: r, done: done}, path, nil, ro)
+}
+
+// abandonableReader wraps r, failing reads with errReadAbandoned once done is
+// closed.
+type abandonableReader struct {
+	r    io.Reader
+	done <-chan struct{}
+}
+
+func (ar *abandonableReader) Read(p []byte) (int, error) {
+	select {
+	case <-ar.done:
+		return 0, errReadAbandoned
+	default:
+		return ar.r.Read(p)
+	}
+}
+
+// parseEntries returns the entries in known_hosts data read from r, skipping
+// comments and blank lines. The supplied name is used for the Filename of
+// entries and errors. If skip is non-nil, malformed lines are passed to it and
+// skipped, rather than causing an error. The line length and entry limits of ro
+// are enforced; the file size limit must be enforced by r, as done by
+// openLimited.
+func parseEntries(r io.Reader, path string, skip func(*ParseError), ro readOptions) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, ro.scanBufferSize())
+	lineNum := 1
+	for ; scanner.Scan(); lineNum++ {
+		if ro.lineTooLong(len(scanner.Bytes())) {
+			return nil, &LimitError{Limit: LimitLineLength, Max: int64(ro.maxLineLength), Filename: path, Line: lineNum}
+		}
+		e, ok, err := parseEntry(scanner.Text())
+		if err != nil {
+			var pe *ParseError
+			if errors.As(err, &pe) {
+				pe.Filename, pe.Line = path, lineNum
+				if skip != nil {
+					skip(pe)
+					continue
+				}
+			}
+			// If the line was cut short by a read error, such as exceeding the
+			// file size limit, report the read error instead
+			if !scanner.Scan() && scanner.Err() != nil {
+				return nil, limitScanError(scanner.Err(), path, lineNum, ro)
+			}
+			return nil, err
+		} else if ok {
+			if ro.maxEntries > 0 && len(entries) >= ro.maxEntries {
+				return nil, &LimitError{Limit: LimitEntries, Max: int64(ro.maxEntries), Filename: path, Line: lineNum}
+			}
+			e.Filename, e.Line = path, lineNum
+			entries = append(entries, e)
+		}
+	}
+	if err := limitScanError(scanner.Err(), path, lineNum, ro); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ParseError describes a known_hosts line which could not be parsed.
+type ParseError struct {
+	Filename string // source file, if the line was read from a file
+	Line     int    // 1-based line number in Filename, if read from a file
+	Column   int    // 1-based byte offset of the problematic field within the line
+	Field    string // "marker", "patterns", "keytype", or "key"
+	Err      error
+}
+
+// Error satisfies the error interface.
+func (pe *ParseError) Error() string {
+	var location string
+	if pe.Filename != "" {
+		location = fmt.Sprintf("%s:%d: ", pe.Filename, pe.Line)
+	}
+	return fmt.Sprintf("knownhosts: %scolumn %d: invalid %s: %v", location, pe.Column, pe.Field, pe.Err)
+}
+
+// Unwrap returns the underlying error.
+func (pe *ParseError) Unwrap() error {
+	return pe.Err
+}
+
+// ParseLine parses a single known_hosts line, which may optionally include a
+// trailing line ending. The line may contain a @cert-authority or @revoked
+// marker, and any combination of plaintext, hashed, wildcard, or negated host
+// patterns. Unrecognized markers are preserved in the entry's Marker field, as
+// described by Entry. Any text following the key is returned as the entry's Comment, and
+// the entire line, excluding any line ending, is returned as its Raw field. The
+// returned entry's Filename and Line fields are not populated.
+//
+// If line is blank or a comment, ParseLine returns a zero Entry, with a nil Key,
+// and a nil error. If line is malformed, the returned error will be a
+// *ParseError indicating the problematic field.
+func ParseLine(line []byte) (Entry, error) {
+	e, _, err := parseEntry(string(line))
+	return e, err
+}
+
+// parseEntry implements ParseLine. If the line is blank or a comment, the
+// returned bool is false.
+func parseEntry(line string) (Entry, bool, error) {
+	content, _ := splitLineEnding(line)
+	_, first, rest := splitFirstField(content)
+	if first == "" || first[0] == '#' {
+		return Entry{}, false, nil
+	}
+	// column returns the 1-based position of the field which precedes rest
+	column := func(field, rest string) int {
+		return len(content) - len(rest) - len(field) + 1
+	}
+	fail := func(fieldName, field, rest string, err error) (Entry, bool, error) {
+		return Entry{}, false, &ParseError{Column: column(field, rest), Field: fieldName, Err: err}
+	}
+
+	var e Entry
+	if first[0] == '@' {
+		e.Marker = Marker(first)
+		_, first, rest = splitFirstField(rest)
+		if first == "" {
+			return fail("patterns", first, rest, errors.New("missing host patterns"))
+		}
+	}
+	for _, pattern := range strings.Split(first, ",") {
+		if err := validatePattern(pattern); err != nil {
+			return fail("patterns", first, rest, err)
+		}
+	}
+	e.Patterns = strings.Split(first, ",")
+
+	_, keyType, afterType := splitFirstField(rest)
+	if keyType == "" {
+		return fail("keytype", keyType, afterType, errors.New("missing key type"))
+	}
+	_, keyBase64, comment := splitFirstField(afterType)
+	if keyBase64 == "" {
+		return fail("key", keyBase64, comment, errors.New("missing key"))
+	}
+	key, err := parseKeyFields(keyType, keyBase64)
+	if err != nil {
+		return fail("key", keyBase64, comment, err)
+	}
+	e.Key = key
+	e.keyBase64 = keyBase64
+	e.Comment = strings.TrimSpace(comment)
+	e.Raw = content
+	return e, true, nil
+}
+
+// validatePattern returns an error if pattern is empty, or is a malformed
+// hashed pattern. Hashed patterns may not contain whitespace or commas, which
+// base64 decoding would otherwise ignore or reject inconsistently.
+func validatePattern(pattern string) error {
+	if pattern == "" {
+		return errors.New("empty host pattern")
+	} else if !strings.HasPrefix(pattern, "|") {
+		return nil
+	} else if strings.IndexFunc(pattern, func(r rune) bool { return r <= ' ' || r == ',' || r >= 0x7f }) >= 0 {
+		return fmt.Errorf("hashed pattern %q contains invalid characters", pattern)
+	}
+	parts := strings.Split(strings.TrimPrefix(pattern, hashMagic), "|")
+	if !strings.HasPrefix(pattern, hashMagic) || len(parts) != 2 {
+		return fmt.Errorf("unsupported hashed pattern %q", pattern)
+	}
+	for _, part := range parts {
+		if _, err := base64.StdEncoding.DecodeString(part); err != nil {
+			return fmt.Errorf("hashed pattern %q has invalid encoding: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// validateEntryPattern returns an *InvalidHostnameError if pattern, as found
+// in Entry.Patterns, cannot be written to a known_hosts line verbatim. Hashed
+// patterns must be well-formed as per validatePattern. Other patterns must be
+// accepted by validateWritePattern, and must not contain characters which
+// would corrupt the line even before normalization.
+func validateEntryPattern(pattern string) error {
+	if strings.HasPrefix(pattern, "|") {
+		if err := validatePattern(pattern); err != nil {
+			return &InvalidHostnameError{Address: pattern, Reason: "is not a valid hashed pattern"}
+		}
+		return nil
+	}
+	if err := validateWritePattern(pattern); err != nil {
+		return err
+	}
+	return validateAddress(strings.TrimPrefix(pattern, "!"))
+}
+
+// parseKeyFields parses a public key from its type and base64 encoding, as
+// they appear in a known_hosts line.
+func parseKeyFields(keyType, keyBase64 string) (ssh.PublicKey, error) {
+	keyBytes, err := base64.StdEncoding.DecodeString(keyBase64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key encoding: %w", err)
+	}
+	key, err := ssh.ParsePublicKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	if key.Type() != keyType {
+		return nil, fmt.Errorf("key type %s does not match encoded key type %s", keyType, key.Type())
+	}
+	return key, nil
+}
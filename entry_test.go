@@ -0,0 +1,337 @@
+package knownhosts
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestExportImportJSON(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	keys := []ssh.PublicKey{generatePubKeyEd25519(t), generatePubKeyECDSA(t)}
+	hashed, _ := HashHostname("hashed.example.test")
+	lines := []string{
+		Line([]string{"a.example.test", "[a.example.test]:2222"}, keys[0]) + " added-by=ci",
+		hashed + " " + keyString(keys[1]),
+		"@cert-authority *.example.test,!bad.example.test " + keyString(keys[0]),
+	}
+	contents := "# comment\n\n" + lines[0] + "\n" + lines[1] + "\n" + lines[2] + "\n"
+	if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+
+	data, err := ExportJSON(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from ExportJSON: %v", err)
+	}
+	var exported []map[string]interface{}
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatalf("Unable to unmarshal exported JSON: %v", err)
+	}
+	if len(exported) != 3 {
+		t.Fatalf("Expected 3 exported entries, instead found %d", len(exported))
+	}
+	first := exported[0]
+	if first["keyType"] != "ssh-ed25519" || first["sha256Fingerprint"] != ssh.FingerprintSHA256(keys[0]) ||
+		first["comment"] != "added-by=ci" || first["filename"] != khPath || first["line"] != float64(3) ||
+		first["hashed"] != false || first["marker"] != "" {
+		t.Errorf("Unexpected first exported entry: %v", first)
+	}
+	if patterns, ok := first["patterns"].([]interface{}); !ok || len(patterns) != 2 || patterns[1] != "[a.example.test]:2222" {
+		t.Errorf("Unexpected patterns in first exported entry: %v", first["patterns"])
+	}
+	if exported[1]["hashed"] != true || exported[2]["marker"] != "@cert-authority" {
+		t.Errorf("Unexpected hashed or marker fields in exported entries: %v", exported)
+	}
+
+	// Importing the exported JSON should regenerate the original lines
+	var buf bytes.Buffer
+	if err := ImportJSON(&buf, data); err != nil {
+		t.Fatalf("Unexpected error from ImportJSON: %v", err)
+	}
+	if want := lines[0] + "\n" + lines[1] + "\n" + lines[2] + "\n"; buf.String() != want {
+		t.Errorf("Unexpected output from ImportJSON:\n%s\nExpected:\n%s", buf.String(), want)
+	}
+
+	// Invalid input should error
+	if err := ImportJSON(&buf, []byte(`[{"patterns":["a"],"keyType":"ssh-ed25519","keyBase64":"bogus"}]`)); err == nil {
+		t.Error("Expected error from ImportJSON with invalid key, but error was nil")
+	}
+	if err := ImportJSON(&buf, []byte(`[{"keyType":"ssh-ed25519","keyBase64":"`+base64.StdEncoding.EncodeToString(keys[0].Marshal())+`"}]`)); err == nil {
+		t.Error("Expected error from ImportJSON with no patterns, but error was nil")
+	}
+
+	// Entries which would corrupt or inject lines should error without writing
+	// anything
+	encodedKey := `"keyType":"ssh-ed25519","keyBase64":"` + base64.StdEncoding.EncodeToString(keys[0].Marshal()) + `"`
+	injected := "\\n* " + keyString(keys[1])
+	for _, entry := range []string{
+		`{"patterns":["ok.example.test"],` + encodedKey + `,"comment":"x` + injected + `"}`,
+		`{"patterns":["a.example.test` + injected + `"],` + encodedKey + `}`,
+		`{"patterns":["a b"],` + encodedKey + `}`,
+		`{"patterns":["a,b"],` + encodedKey + `}`,
+		`{"patterns":["|1|YWJj|` + injected + `"],` + encodedKey + `}`,
+		`{"patterns":["ok.example.test"],"marker":"@bogus",` + encodedKey + `}`,
+	} {
+		buf.Reset()
+		data := []byte(`[{"patterns":["first.example.test"],` + encodedKey + `},` + entry + `]`)
+		if err := ImportJSON(&buf, data); err == nil {
+			t.Errorf("Expected error from ImportJSON with entry %s, but error was nil", entry)
+		} else if buf.Len() > 0 {
+			t.Errorf("Expected ImportJSON to write nothing for invalid input, instead wrote %q", buf.String())
+		}
+	}
+	var ihe *InvalidHostnameError
+	buf.Reset()
+	if err := ImportJSON(&buf, []byte(`[{"patterns":["a b"],`+encodedKey+`}]`)); !errors.As(err, &ihe) {
+		t.Errorf("Expected *InvalidHostnameError from ImportJSON, instead found %v", err)
+	}
+	if err := os.WriteFile(khPath, []byte("host ssh-ed25519\n"), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	if _, err := ExportJSON(khPath); err == nil {
+		t.Error("Expected error from ExportJSON with malformed line, but error was nil")
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	key := generatePubKeyEd25519(t)
+	keyStr := keyString(key)
+	hashed, _ := HashHostname("hashed.example.test")
+	valid := []string{
+		"host.example.test " + keyStr,
+		"host.example.test,10.0.0.5,[host.example.test]:2222 " + keyStr + " trailing comment\n",
+		"  \tindented.example.test\t" + keyStr + "  spaced   comment  \r\n",
+		hashed + " " + keyStr,
+		"*.example.test,!bad.example.test,host?.example.test " + keyStr,
+		"@cert-authority *.example.test " + keyStr + " my CA",
+		"@revoked revoked.example.test " + keyStr,
+		"@future-marker host.example.test " + keyStr,
+	}
+	for _, line := range valid {
+		e, err := ParseLine([]byte(line))
+		if err != nil {
+			t.Errorf("Unexpected error from ParseLine(%q): %v", line, err)
+			continue
+		}
+		formatted := e.Format()
+		if strings.Join(strings.Fields(formatted), " ") != strings.Join(strings.Fields(line), " ") {
+			t.Errorf("Format(ParseLine(%q)) returned %q, which differs by more than whitespace", line, formatted)
+		}
+		again, err := ParseLine([]byte(formatted))
+		if err != nil {
+			t.Errorf("Unexpected error re-parsing %q: %v", formatted, err)
+		} else if again.Marker != e.Marker || strings.Join(again.Patterns, ",") != strings.Join(e.Patterns, ",") || again.Comment != e.Comment || keyString(again.Key) != keyString(e.Key) {
+			t.Errorf("Round trip of %q produced different entry: %+v vs %+v", line, again, e)
+		}
+	}
+
+	if e, _ := ParseLine([]byte("@future-marker host.example.test " + keyStr)); e.Marker != Marker("@future-marker") || e.Marker.known() {
+		t.Errorf("Expected unknown marker to be preserved, instead found %q", e.Marker)
+	}
+
+	for _, line := range []string{"", "   ", "# comment", "  # indented comment\n"} {
+		if e, err := ParseLine([]byte(line)); err != nil || e.Key != nil {
+			t.Errorf("Expected zero Entry and nil error from ParseLine(%q), instead found %+v, %v", line, e, err)
+		}
+	}
+
+	invalid := []struct {
+		line   string
+		column int
+		field  string
+	}{
+		{"@revoked", 9, "patterns"},
+		{"a,,b " + keyStr, 1, "patterns"},
+		{"  |1|notbase64!|AAAA " + keyStr, 3, "patterns"},
+		{"|2|AAAA|AAAA " + keyStr, 1, "patterns"},
+		{"host", 5, "keytype"},
+		{"host ssh-ed25519", 17, "key"},
+		{"host ssh-ed25519 !!!!", 18, "key"},
+		{"host ssh-rsa " + strings.Fields(keyStr)[1], 14, "key"},
+	}
+	for _, c := range invalid {
+		_, err := ParseLine([]byte(c.line))
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("Expected ParseError from ParseLine(%q), instead found %v", c.line, err)
+		} else if pe.Column != c.column || pe.Field != c.field {
+			t.Errorf("Expected ParseError for column %d field %s from ParseLine(%q), instead found column %d field %s", c.column, c.field, c.line, pe.Column, pe.Field)
+		}
+	}
+
+	// Errors from reading a file should include the location
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(khPath, []byte("# comment\nhost ssh-ed25519\n"), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	_, err := ExportJSON(khPath)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Filename != khPath || pe.Line != 2 {
+		t.Errorf("Expected ParseError with file location from ExportJSON, instead found %v", err)
+	}
+}
+
+func TestEntryMatchesHost(t *testing.T) {
+	hashed, _ := HashHostname("[hashed.example.test]:2222")
+	e := Entry{Patterns: []string{"Plain.example.test", "[10.0.0.5]:2222", hashed, "*.wild.example.test"}}
+	cases := map[string]bool{
+		"plain.example.test":         true,
+		"plain.example.test:22":      true,
+		"user@plain.example.test.":   true,
+		"plain.example.test:2222":    false,
+		"10.0.0.5:2222":              true,
+		"10.0.0.5":                   false,
+		"[hashed.example.test]:2222": true,
+		"hashed.example.test:2222":   true,
+		"hashed.example.test":        false,
+		"host.wild.example.test":     false,
+		"*.wild.example.test":        true,
+		"other.example.test":         false,
+	}
+	for host, expected := range cases {
+		if actual := e.MatchesHost(host); actual != expected {
+			t.Errorf("Expected MatchesHost(%q) to return %t, instead found %t", host, expected, actual)
+		}
+	}
+}
+
+func TestNewFromEntries(t *testing.T) {
+	keys := []ssh.PublicKey{generatePubKeyEd25519(t), generatePubKeyECDSA(t), generatePubKeyEd25519(t)}
+	caSigner := generateSignerEd25519(t)
+	hashed, _ := HashHostname("hashed.example.test")
+	entries := []Entry{
+		{Patterns: []string{"a.example.test", "[a.example.test]:2222"}, Key: keys[0], Filename: "inventory", Line: 42},
+		{Patterns: []string{"a.example.test"}, Key: keys[1]},
+		{Patterns: []string{hashed}, Key: keys[0]},
+		{Marker: "@future-marker", Patterns: []string{"b.example.test"}, Key: keys[1]},
+		{Marker: MarkerRevoked, Patterns: []string{"*"}, Key: keys[2]},
+		{Marker: MarkerCertAuthority, Patterns: []string{"*.ca.example.test"}, Key: caSigner.PublicKey()},
+	}
+	kh, err := NewFromEntries(entries)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromEntries: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	if algos := kh.HostKeyAlgorithms("a.example.test:22"); len(algos) != 2 {
+		t.Errorf("Expected 2 host key algorithms, instead found %v", algos)
+	}
+	for host, key := range map[string]ssh.PublicKey{"a.example.test:2222": keys[0], "hashed.example.test:22": keys[0]} {
+		if err := kh(host, noAddr, key); err != nil {
+			t.Errorf("Unexpected error from callback for %s: %v", host, err)
+		}
+	}
+	var revokedErr *xknownhosts.RevokedError
+	if err := kh("b.example.test:22", noAddr, keys[2]); !errors.As(err, &revokedErr) {
+		t.Errorf("Expected RevokedError from callback for revoked key, instead found %v", err)
+	} else if revokedErr.Revoked.Filename != "entries" || revokedErr.Revoked.Line != 5 {
+		t.Errorf("Expected location entries:5 for revoked key, instead found %s:%d", revokedErr.Revoked.Filename, revokedErr.Revoked.Line)
+	}
+	if err := kh("b.example.test:22", noAddr, keys[1]); !IsHostUnknown(err) {
+		t.Errorf("Expected entry with unknown marker to be ignored, instead found %v", err)
+	}
+	cert := generateHostCert(t, caSigner, keys[1], "host.ca.example.test")
+	if err := kh("host.ca.example.test:22", noAddr, cert); err != nil {
+		t.Errorf("Unexpected error from callback for host cert: %v", err)
+	}
+
+	// KnownKey locations should refer to the entries, not a temp file
+	err = kh("a.example.test:22", noAddr, generatePubKeyEd25519(t))
+	var keyErr *xknownhosts.KeyError
+	if !errors.As(err, &keyErr) || len(keyErr.Want) != 2 {
+		t.Fatalf("Expected KeyError with 2 known keys, instead found %v", err)
+	}
+	for _, kk := range keyErr.Want {
+		if kk.Key.Type() == keys[0].Type() && (kk.Filename != "inventory" || kk.Line != 42) {
+			t.Errorf("Expected location inventory:42 for first entry, instead found %s:%d", kk.Filename, kk.Line)
+		} else if kk.Key.Type() == keys[1].Type() && (kk.Filename != "entries" || kk.Line != 2) {
+			t.Errorf("Expected location entries:2 for second entry, instead found %s:%d", kk.Filename, kk.Line)
+		}
+	}
+
+	for _, bad := range []Entry{{Patterns: []string{"host"}}, {Key: keys[0]}, {Patterns: []string{""}, Key: keys[0]}, {Patterns: []string{"a b"}, Key: keys[0]}} {
+		if _, err := NewFromEntries([]Entry{bad}); err == nil {
+			t.Errorf("Expected error from NewFromEntries with invalid entry %+v, but error was nil", bad)
+		}
+	}
+}
+
+func TestReadEntries(t *testing.T) {
+	dir := t.TempDir()
+	key := generatePubKeyEd25519(t)
+	var files []string
+	for n := 0; n < 6; n++ {
+		path := filepath.Join(dir, fmt.Sprintf("known_hosts%d", n))
+		var buf bytes.Buffer
+		for line := 1; line <= 100; line++ {
+			buf.WriteString(Line([]string{fmt.Sprintf("host%d-%d.example.test", n, line)}, key) + "\n")
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %v", path, err)
+		}
+		files = append(files, path)
+	}
+
+	entries, err := ReadEntries(files...)
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadEntries: %v", err)
+	} else if len(entries) != 600 {
+		t.Fatalf("Expected 600 entries, instead found %d", len(entries))
+	}
+	for n, e := range entries {
+		file, line := n/100, n%100+1
+		if e.Filename != files[file] || e.Line != line || e.Patterns[0] != fmt.Sprintf("host%d-%d.example.test", file, line) {
+			t.Fatalf("Unexpected entry %d: %s:%d %v", n, e.Filename, e.Line, e.Patterns)
+		}
+	}
+
+	// Malformed line in one file
+	bad := filepath.Join(dir, "bad")
+	if err := os.WriteFile(bad, []byte("good.example.test ssh-ed25519 AAAA\n"), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", bad, err)
+	}
+	var pe *ParseError
+	if _, err := ReadEntries(append(append([]string{}, files[:3]...), append([]string{bad}, files[3:]...)...)...); !errors.As(err, &pe) || pe.Filename != bad || pe.Line != 1 {
+		t.Errorf("Expected ParseError for %s:1, instead found %v", bad, err)
+	}
+
+	// First failing file in the supplied order is reported
+	missing := filepath.Join(dir, "missing")
+	if _, err := ReadEntries(files[0], missing, bad); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected not-exist error, instead found %v", err)
+	}
+}
+
+// BenchmarkReadEntries reads four files of 50000 lines each.
+func BenchmarkReadEntries(b *testing.B) {
+	dir := b.TempDir()
+	key := generatePubKeyEd25519(b)
+	var files []string
+	for n := 0; n < 4; n++ {
+		path := filepath.Join(dir, fmt.Sprintf("known_hosts%d", n))
+		var buf bytes.Buffer
+		for line := 0; line < 50000; line++ {
+			buf.WriteString(Line([]string{fmt.Sprintf("host%d-%d.example.test", n, line)}, key) + "\n")
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+			b.Fatalf("Unable to write %s: %v", path, err)
+		}
+		files = append(files, path)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := ReadEntries(files...); err != nil {
+			b.Fatalf("Unexpected error from ReadEntries: %v", err)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+package knownhosts
+
+// Exported for use by tests in package knownhosts_test, which cannot access
+// unexported identifiers.
+var DefaultScanAlgorithms = defaultScanAlgorithms
@@ -0,0 +1,226 @@
+package knownhosts
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// FetchOptions configures the behavior of FetchAndLoad.
+type FetchOptions struct {
+	// Client is used for HTTP requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// SHA256, if non-empty, is the expected hex-encoded SHA-256 checksum of the
+	// known_hosts file.
+	SHA256 string
+
+	// SigningKey, if non-nil, is the public key which must have signed the
+	// known_hosts file. The signature is fetched from SignatureURL.
+	SigningKey ssh.PublicKey
+
+	// SignatureURL is the location of a detached signature of the known_hosts
+	// file, made by the private key corresponding to SigningKey. The signature
+	// must be an ssh.Signature in SSH wire format, as produced by marshaling
+	// the result of ssh.Signer.Sign. If empty, the URL of the file with ".sig"
+	// appended is used. This field is ignored if SigningKey is nil.
+	SignatureURL string
+
+	// CachePath, if non-empty, is a file path in which the last successfully
+	// verified copy of the known_hosts file is kept. It is used to send
+	// conditional requests, and as a fallback if fetching fails. HTTP caching
+	// metadata and the file's signature, if any, are stored alongside it, at
+	// CachePath with ".meta" appended.
+	CachePath string
+}
+
+// fetchMeta holds HTTP caching metadata for a cached known_hosts file.
+type fetchMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Signature    []byte `json:"signature,omitempty"`
+}
+
+// FetchAndLoad downloads a known_hosts file from url, verifies its integrity,
+// and returns a host key callback for its entries, in the same manner as
+// NewFromBytes. All markers, including @cert-authority and @revoked, are
+// supported.
+//
+// If opts.SHA256 or opts.SigningKey are set, the file is verified before
+// parsing, and an error is returned if verification fails. If opts.CachePath
+// is set, verified files are cached there, and requests are made conditional
+// using the ETag and Last-Modified headers of the cached copy. If the fetch
+// fails for any reason, including a verification failure, the cached copy is
+// used instead, after being verified again with the current options, using
+// the signature which was fetched along with it.
+func FetchAndLoad(ctx context.Context, url string, opts FetchOptions) (HostKeyCallback, error) {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.SigningKey != nil && opts.SignatureURL == "" {
+		opts.SignatureURL = url + ".sig"
+	}
+
+	var cached []byte
+	var meta fetchMeta
+	if opts.CachePath != "" {
+		if data, err := os.ReadFile(opts.CachePath); err == nil {
+			cached = data
+			if metaJSON, err := os.ReadFile(opts.CachePath + ".meta"); err == nil {
+				json.Unmarshal(metaJSON, &meta)
+			}
+		}
+	}
+
+	data, newMeta, fetchErr := fetchVerified(ctx, url, opts, cached, meta)
+	if fetchErr == nil && data == nil {
+		// Not modified since the cached copy; verify it again, in case the
+		// options have changed
+		data = cached
+		fetchErr = verifyFetched(data, meta.Signature, opts)
+	} else if fetchErr == nil && opts.CachePath != "" {
+		if err := writeFetchCache(opts.CachePath, data, newMeta); err != nil {
+			return nil, err
+		}
+	}
+	if fetchErr != nil {
+		if cached == nil {
+			return nil, fetchErr
+		} else if err := verifyFetched(cached, meta.Signature, opts); err != nil {
+			return nil, fmt.Errorf("%w (cached copy also failed verification: %v)", fetchErr, err)
+		}
+		data = cached
+	}
+
+	return NewFromBytes(data, url)
+}
+
+// fetchVerified downloads and verifies the file at url. If the server
+// indicates that the cached copy described by meta is current, it returns nil
+// data and a nil error.
+func fetchVerified(ctx context.Context, url string, opts FetchOptions, cached []byte, meta fetchMeta) ([]byte, fetchMeta, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, meta, err
+	}
+	if cached != nil {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+	resp, err := opts.Client.Do(req)
+	if err != nil {
+		return nil, meta, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return nil, meta, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, meta, fmt.Errorf("knownhosts: unable to fetch %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, meta, err
+	}
+	newMeta := fetchMeta{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	if opts.SigningKey != nil {
+		if newMeta.Signature, err = fetchSignature(ctx, opts); err != nil {
+			return nil, meta, err
+		}
+	}
+	if err := verifyFetched(data, newMeta.Signature, opts); err != nil {
+		return nil, meta, err
+	}
+	return data, newMeta, nil
+}
+
+// verifyFetched checks data against the checksum in opts, and verifies the
+// signature in sigData using the signing key in opts, if any.
+func verifyFetched(data, sigData []byte, opts FetchOptions) error {
+	if opts.SHA256 != "" {
+		expected, err := hex.DecodeString(opts.SHA256)
+		if err != nil {
+			return fmt.Errorf("knownhosts: invalid SHA256 option: %w", err)
+		}
+		sum := sha256.Sum256(data)
+		if subtle.ConstantTimeCompare(sum[:], expected) != 1 {
+			return errors.New("knownhosts: checksum mismatch for fetched known_hosts file")
+		}
+	}
+	if opts.SigningKey != nil {
+		var sig ssh.Signature
+		if err := ssh.Unmarshal(sigData, &sig); err != nil {
+			return fmt.Errorf("knownhosts: malformed signature from %s: %w", opts.SignatureURL, err)
+		}
+		if err := opts.SigningKey.Verify(data, &sig); err != nil {
+			return fmt.Errorf("knownhosts: invalid signature for fetched known_hosts file: %w", err)
+		}
+	}
+	return nil
+}
+
+// fetchSignature downloads the detached signature from opts.SignatureURL.
+func fetchSignature(ctx context.Context, opts FetchOptions) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, opts.SignatureURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("knownhosts: unable to fetch %s: %s", opts.SignatureURL, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// writeFetchCache atomically replaces the cached known_hosts file at path
+// with data, and records meta alongside it.
+func writeFetchCache(path string, data []byte, meta fetchMeta) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return wrapFileError(path, err)
+	}
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	for _, file := range []struct {
+		path string
+		data []byte
+	}{
+		{path, data},
+		{path + ".meta", metaJSON},
+	} {
+		tmp, err := os.CreateTemp(filepath.Dir(file.path), ".knownhosts-*")
+		if err != nil {
+			return wrapFileError(file.path, err)
+		}
+		_, err = tmp.Write(file.data)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = renameFile(tmp.Name(), file.path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return wrapFileError(file.path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package knownhosts
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fetchTestServer serves a known_hosts file and its signature, honoring
+// If-None-Match, and counts requests for the file.
+type fetchTestServer struct {
+	mu       sync.Mutex
+	contents []byte
+	sig      []byte
+	etag     string
+	fail     bool
+	requests int
+	notMod   int
+}
+
+func (fs *fetchTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.fail {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	switch r.URL.Path {
+	case "/known_hosts":
+		fs.requests++
+		if fs.etag != "" && r.Header.Get("If-None-Match") == fs.etag {
+			fs.notMod++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", fs.etag)
+		w.Write(fs.contents)
+	case "/known_hosts.sig":
+		w.Write(fs.sig)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (fs *fetchTestServer) set(contents []byte, signer ssh.Signer, etag string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.contents, fs.etag = contents, etag
+	if signer != nil {
+		sig, _ := signer.Sign(rand.Reader, contents)
+		fs.sig = ssh.Marshal(sig)
+	}
+}
+
+func TestFetchAndLoad(t *testing.T) {
+	key, otherKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t)
+	signer, evilSigner := generateSignerEd25519(t), generateSignerEd25519(t)
+	contents := []byte(Line([]string{"fleet.example.test"}, key) + "\n")
+	fs := &fetchTestServer{}
+	fs.set(contents, signer, `"v1"`)
+	server := httptest.NewServer(fs)
+	defer server.Close()
+	url := server.URL + "/known_hosts"
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	ctx := context.Background()
+	sum := sha256.Sum256(contents)
+
+	// Checksum verification
+	cb, err := FetchAndLoad(ctx, url, FetchOptions{Client: server.Client(), SHA256: hex.EncodeToString(sum[:])})
+	if err != nil {
+		t.Fatalf("Unexpected error from FetchAndLoad: %v", err)
+	} else if err := cb("fleet.example.test:22", noAddr, key); err != nil {
+		t.Errorf("Unexpected error from callback: %v", err)
+	}
+	if _, err := FetchAndLoad(ctx, url, FetchOptions{SHA256: hex.EncodeToString(make([]byte, 32))}); err == nil {
+		t.Error("Expected FetchAndLoad to return an error for a checksum mismatch, but it did not")
+	}
+
+	// Signature verification, with caching
+	cachePath := filepath.Join(t.TempDir(), "cache", "known_hosts")
+	opts := FetchOptions{SigningKey: signer.PublicKey(), CachePath: cachePath}
+	if _, err := FetchAndLoad(ctx, url, FetchOptions{SigningKey: evilSigner.PublicKey()}); err == nil {
+		t.Error("Expected FetchAndLoad to return an error for a signature from the wrong key, but it did not")
+	}
+	if _, err := FetchAndLoad(ctx, url, opts); err != nil {
+		t.Fatalf("Unexpected error from FetchAndLoad: %v", err)
+	}
+
+	// Conditional request should use the cached copy
+	if cb, err = FetchAndLoad(ctx, url, opts); err != nil {
+		t.Fatalf("Unexpected error from FetchAndLoad: %v", err)
+	} else if err := cb("fleet.example.test:22", noAddr, key); err != nil {
+		t.Errorf("Unexpected error from callback: %v", err)
+	}
+	if fs.notMod != 1 {
+		t.Errorf("Expected 1 not-modified response, instead found %d", fs.notMod)
+	}
+
+	// Tampered content signed by the wrong key: fall back to cached copy
+	fs.set([]byte(Line([]string{"fleet.example.test"}, otherKey)+"\n"), evilSigner, `"v2"`)
+	if cb, err = FetchAndLoad(ctx, url, opts); err != nil {
+		t.Fatalf("Unexpected error from FetchAndLoad: %v", err)
+	} else if err := cb("fleet.example.test:22", noAddr, key); err != nil {
+		t.Errorf("Expected cached key to be used, instead found %v", err)
+	}
+
+	// Server failure: fall back to cached copy
+	fs.fail = true
+	if cb, err = FetchAndLoad(ctx, url, opts); err != nil {
+		t.Fatalf("Unexpected error from FetchAndLoad: %v", err)
+	} else if err := cb("fleet.example.test:22", noAddr, key); err != nil {
+		t.Errorf("Expected cached key to be used, instead found %v", err)
+	}
+
+	// Cached copy must still pass the current verification options
+	opts.SHA256 = hex.EncodeToString(make([]byte, 32))
+	if _, err := FetchAndLoad(ctx, url, opts); err == nil {
+		t.Error("Expected FetchAndLoad to return an error when the cached copy fails verification, but it did not")
+	}
+
+	// Legitimately updated content replaces the cache
+	fs.fail = false
+	newContents := []byte(Line([]string{"fleet.example.test"}, otherKey) + "\n")
+	fs.set(newContents, signer, `"v3"`)
+	opts.SHA256 = ""
+	if cb, err = FetchAndLoad(ctx, url, opts); err != nil {
+		t.Fatalf("Unexpected error from FetchAndLoad: %v", err)
+	} else if err := cb("fleet.example.test:22", noAddr, otherKey); err != nil {
+		t.Errorf("Expected new key to be used, instead found %v", err)
+	}
+	fs.fail = true
+	if cb, err = FetchAndLoad(ctx, url, opts); err != nil {
+		t.Fatalf("Unexpected error from FetchAndLoad: %v", err)
+	} else if err := cb("fleet.example.test:22", noAddr, otherKey); err != nil {
+		t.Errorf("Expected updated cache to be used, instead found %v", err)
+	}
+
+	// No cache and failing server
+	if _, err := FetchAndLoad(ctx, url, FetchOptions{}); err == nil {
+		t.Error("Expected FetchAndLoad to return an error, but it did not")
+	}
+}
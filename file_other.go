@@ -0,0 +1,34 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package knownhosts
+
+import "os"
+
+// File locking is not implemented on this platform, so modifications of
+// known_hosts file paths are not protected against concurrent writers.
+const lockSupported = false
+
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
+
+func isReadOnlyFS(err error) bool {
+	return false
+}
+
+func openFile(path string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(path, flag, perm)
+}
+
+func preserveOwner(f *os.File, fi os.FileInfo) {}
+
+func renameFile(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func syncDir(dir string) {}
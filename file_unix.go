@@ -0,0 +1,64 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package knownhosts
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const lockSupported = true
+
+// tryLockFile attempts to obtain an exclusive flock on f without blocking. It
+// returns false with a nil error if the lock is currently held elsewhere.
+func tryLockFile(f *os.File) (bool, error) {
+	for {
+		err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			return true, nil
+		} else if errors.Is(err, unix.EWOULDBLOCK) {
+			return false, nil
+		} else if !errors.Is(err, unix.EINTR) {
+			return false, &os.PathError{Op: "flock", Path: f.Name(), Err: err}
+		}
+	}
+}
+
+func unlockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
+
+// isReadOnlyFS returns true if err indicates a write to a read-only filesystem.
+func isReadOnlyFS(err error) bool {
+	return errors.Is(err, unix.EROFS)
+}
+
+func openFile(path string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(path, flag, perm)
+}
+
+// preserveOwner attempts to give f the same owner and group as described by
+// fi. Errors are ignored, since only root may give away ownership of a file.
+func preserveOwner(f *os.File, fi os.FileInfo) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		f.Chown(int(st.Uid), int(st.Gid))
+	}
+}
+
+func renameFile(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// syncDir flushes the directory entry changes for dir to disk, so that a
+// completed rename survives a crash. This is best-effort, since some
+// filesystems do not permit syncing directories.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
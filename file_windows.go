@@ -0,0 +1,93 @@
+//go:build windows
+// +build windows
+
+package knownhosts
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+const lockSupported = true
+
+// tryLockFile attempts to obtain an exclusive LockFileEx lock on f without
+// blocking. It returns false with a nil error if the lock is currently held
+// elsewhere.
+func tryLockFile(f *os.File) (bool, error) {
+	ol := new(windows.Overlapped)
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol)
+	if err == nil {
+		return true, nil
+	} else if errors.Is(err, windows.ERROR_LOCK_VIOLATION) || errors.Is(err, windows.ERROR_IO_PENDING) {
+		return false, nil
+	}
+	return false, &os.PathError{Op: "LockFileEx", Path: f.Name(), Err: err}
+}
+
+func unlockFile(f *os.File) error {
+	ol := new(windows.Overlapped)
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+}
+
+// isReadOnlyFS returns true if err indicates a write to read-only media.
+func isReadOnlyFS(err error) bool {
+	return errors.Is(err, windows.ERROR_WRITE_PROTECT)
+}
+
+// openFile behaves like os.OpenFile, but also permits the file to be renamed
+// or deleted while it is open. This is necessary for rewrites, which rename a
+// new file over the original while the original is still open and locked. Only
+// the flags used by this package are supported.
+func openFile(path string, flag int, perm os.FileMode) (*os.File, error) {
+	pathp, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	access := uint32(windows.GENERIC_READ)
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		access |= windows.GENERIC_WRITE
+	}
+	if flag&os.O_APPEND != 0 {
+		access &^= windows.GENERIC_WRITE
+		access |= windows.FILE_APPEND_DATA
+	}
+	createMode := uint32(windows.OPEN_EXISTING)
+	if flag&os.O_CREATE != 0 {
+		createMode = windows.OPEN_ALWAYS
+	}
+	attrs := uint32(windows.FILE_ATTRIBUTE_NORMAL)
+	if perm&0200 == 0 {
+		attrs = windows.FILE_ATTRIBUTE_READONLY
+	}
+	share := uint32(windows.FILE_SHARE_READ | windows.FILE_SHARE_WRITE | windows.FILE_SHARE_DELETE)
+	h, err := windows.CreateFile(pathp, access, share, nil, createMode, attrs, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
+
+func preserveOwner(f *os.File, fi os.FileInfo) {}
+
+// renameRetryTimeout controls how long renameFile keeps retrying when the
+// destination is temporarily opened without delete sharing by another process,
+// such as an antivirus scanner or search indexer.
+const renameRetryTimeout = 2 * time.Second
+
+func renameFile(oldpath, newpath string) error {
+	deadline := time.Now().Add(renameRetryTimeout)
+	for {
+		err := os.Rename(oldpath, newpath)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		} else if !errors.Is(err, windows.ERROR_SHARING_VIOLATION) && !errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+			return err
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func syncDir(dir string) {}
@@ -0,0 +1,314 @@
+package knownhosts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// StaleBehavior determines how a FileDB handles known_hosts files which have
+// changed since they were loaded.
+type StaleBehavior int
+
+// Constants representing possible values of FileDBOptions.Behavior.
+const (
+	StaleKeep   StaleBehavior = iota // keep verifying against the loaded data
+	StaleReload                      // reload the files automatically
+	StaleError                       // fail lookups with an error wrapping ErrStale until Reload is called
+)
+
+// FileDBOptions configures the behavior of a FileDB.
+type FileDBOptions struct {
+	Behavior StaleBehavior
+
+	// OnStale, if non-nil, is called with the paths of the changed files each
+	// time a lookup finds that files have changed, before Behavior is applied.
+	// This is typically used for logging.
+	OnStale func(changed []string)
+
+	// CheckInterval is the minimum time between checks for changed files made
+	// by lookups, so that files are not examined on every connection. If zero,
+	// one second is used. If negative, files are checked on every lookup.
+	CheckInterval time.Duration
+
+	// HashContents causes each file's SHA256 digest to be compared as well as
+	// its size and modification time, in order to detect changes which preserve
+	// both. This requires reading every file in full on each check.
+	HashContents bool
+
+	// Glob causes the files passed to NewFileDB to be treated as patterns, which
+	// are expanded using Glob. The patterns are expanded again by each check
+	// and by Reload, so that files added or removed since the last load, such
+	// as fragments in a known_hosts.d directory, count as changed files. If the
+	// patterns match no files, NewFileDB and Reload return an error, unless
+	// IgnoreMissing is also set.
+	Glob bool
+
+	// IgnoreMissing causes files which do not exist to be skipped, rather than
+	// causing NewFileDB and Reload to return an error. A file which is created
+	// later counts as changed.
+	IgnoreMissing bool
+
+	// CertDetection causes the files to be loaded with certificate authority
+	// detection, as per HostKeyCallback.WithCertDetection, so that HostKeys and
+	// HostKeyAlgorithms account for @cert-authority lines.
+	CertDetection bool
+
+	// ReadOptions configures the limits which each file must be within, as
+	// described by NewWithOptions. If empty, the default limits apply.
+	ReadOptions []ReadOption
+}
+
+// fileState records the state of a known_hosts file at the time it was loaded.
+type fileState struct {
+	path    string
+	exists  bool
+	size    int64
+	modTime time.Time
+	digest  [sha256.Size]byte
+}
+
+// FileDB is a KeyDB backed by known_hosts files, which detects when the files
+// change after being loaded. This is intended for long-lived processes, which
+// would otherwise keep verifying host keys against stale data. Lookups are
+// performed by a callback created by New, and check for changed files at most
+// once per FileDBOptions.CheckInterval, handling them according to
+// FileDBOptions.Behavior.
+//
+// A FileDB is safe for concurrent use.
+type FileDB struct {
+	patterns []string // files or glob patterns passed to NewFileDB
+	opts     FileDBOptions
+
+	mu     sync.RWMutex
+	cb     HostKeyCallback
+	states []fileState // one per expanded file, in the order loaded
+
+	checkMu   sync.Mutex
+	lastCheck time.Time
+	lastErr   error // result of the last check, returned until the next one
+}
+
+var _ KeyDB = (*FileDB)(nil)
+
+// NewFileDB loads the given known_hosts files, in the same manner as New, and
+// records their state so that later changes can be detected. If opts.Glob is
+// set, files are glob patterns, which are expanded as described by Glob.
+func NewFileDB(opts FileDBOptions, files ...string) (*FileDB, error) {
+	db := &FileDB{patterns: files, opts: opts}
+	if err := db.reload(); err != nil {
+		return nil, err
+	}
+	db.lastCheck = time.Now()
+	return db, nil
+}
+
+// Reload loads the files again, replacing the data used for lookups. If
+// loading fails, an error is returned and the previously loaded data remains
+// in use.
+func (db *FileDB) Reload() error {
+	db.checkMu.Lock()
+	defer db.checkMu.Unlock()
+	if err := db.reload(); err != nil {
+		return err
+	}
+	db.lastCheck, db.lastErr = time.Now(), nil
+	return nil
+}
+
+// reload implements Reload, without locking checkMu. The files' states are
+// recorded before they are loaded, so that any change made while loading is
+// detected by the next check.
+func (db *FileDB) reload() error {
+	files, err := db.expand()
+	if err != nil {
+		return err
+	}
+	states := make([]fileState, len(files))
+	var existing []string
+	for n, path := range files {
+		if states[n], err = db.state(path); err != nil {
+			return err
+		}
+		if states[n].exists || !db.opts.IgnoreMissing {
+			existing = append(existing, path)
+		}
+	}
+	cb, err := NewWithOptions(existing, db.opts.ReadOptions...)
+	if err == nil && db.opts.CertDetection {
+		cb, err = cb.WithCertDetection(existing...)
+	}
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	db.cb, db.states = cb, states
+	db.mu.Unlock()
+	return nil
+}
+
+// expand returns the paths of the files to load, expanding the patterns if
+// the Glob option is set.
+func (db *FileDB) expand() ([]string, error) {
+	if !db.opts.Glob {
+		return db.patterns, nil
+	}
+	files, err := Glob(db.patterns...)
+	if err == nil && len(files) == 0 && !db.opts.IgnoreMissing {
+		err = fmt.Errorf("knownhosts: no files match %s", strings.Join(db.patterns, ", "))
+	}
+	return files, err
+}
+
+// Files returns the paths of the files which are currently loaded. If the
+// Glob option is set, these are the files which the patterns matched as of the
+// last load. If the IgnoreMissing option is set, files which did not exist as
+// of the last load are omitted.
+func (db *FileDB) Files() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	files := make([]string, 0, len(db.states))
+	for _, st := range db.states {
+		if st.exists || !db.opts.IgnoreMissing {
+			files = append(files, st.path)
+		}
+	}
+	return files
+}
+
+// state returns the current state of the file at path.
+func (db *FileDB) state(path string) (fileState, error) {
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileState{path: path}, nil
+	} else if err != nil {
+		return fileState{}, err
+	}
+	st := fileState{path: path, exists: true, size: fi.Size(), modTime: fi.ModTime()}
+	if db.opts.HashContents {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return fileState{}, err
+		}
+		st.digest = sha256.Sum256(contents)
+	}
+	return st, nil
+}
+
+// Stale examines the files immediately, regardless of CheckInterval, and
+// reports whether any have changed since they were last loaded, along with
+// the paths of the changed files. A file which has been removed counts as
+// changed, as does a newly matching file if the Glob option is set. An error is
+// returned if a file cannot be examined.
+func (db *FileDB) Stale() (bool, []string, error) {
+	db.mu.RLock()
+	states := db.states
+	db.mu.RUnlock()
+	var changed []string
+	loaded := make(map[string]bool, len(states))
+	for _, prev := range states {
+		loaded[prev.path] = true
+		current, err := db.state(prev.path)
+		if err != nil {
+			return false, nil, err
+		}
+		if current.exists != prev.exists || current.size != prev.size || !current.modTime.Equal(prev.modTime) || !bytes.Equal(current.digest[:], prev.digest[:]) {
+			changed = append(changed, prev.path)
+		}
+	}
+	if db.opts.Glob {
+		files, err := Glob(db.patterns...)
+		if err != nil {
+			return false, nil, err
+		}
+		for _, path := range files {
+			if !loaded[path] {
+				changed = append(changed, path)
+			}
+		}
+	}
+	return len(changed) > 0, changed, nil
+}
+
+// check determines whether the files have changed, if CheckInterval has
+// elapsed since the last check, and handles any change according to the
+// configured behavior. The returned error, if any, should fail the lookup.
+func (db *FileDB) check() error {
+	db.checkMu.Lock()
+	defer db.checkMu.Unlock()
+	interval := db.opts.CheckInterval
+	if interval == 0 {
+		interval = time.Second
+	}
+	if interval > 0 && time.Since(db.lastCheck) < interval {
+		return db.lastErr
+	}
+	db.lastCheck = time.Now()
+	stale, changed, err := db.Stale()
+	if err != nil {
+		if db.opts.Behavior != StaleError {
+			err = nil
+		}
+		db.lastErr = err
+		return err
+	} else if !stale {
+		db.lastErr = nil
+		return nil
+	}
+	if db.opts.OnStale != nil {
+		db.opts.OnStale(changed)
+	}
+	switch db.opts.Behavior {
+	case StaleReload:
+		if err := db.reload(); err != nil {
+			db.lastErr = fmt.Errorf("knownhosts: unable to reload changed known_hosts files: %w", err)
+		} else {
+			db.lastErr = nil
+		}
+	case StaleError:
+		db.lastErr = fmt.Errorf("%w: %s", ErrStale, strings.Join(changed, ", "))
+	default:
+		db.lastErr = nil
+	}
+	return db.lastErr
+}
+
+// callback verifies a host key using the currently loaded data, after checking
+// for changed files.
+func (db *FileDB) callback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if err := db.check(); err != nil {
+		return err
+	}
+	db.mu.RLock()
+	cb := db.cb
+	db.mu.RUnlock()
+	return cb(hostname, remote, key)
+}
+
+// HostKeyCallback returns a callback for use in ssh.ClientConfig, which checks
+// for changed files before each lookup as described by FileDB.
+func (db *FileDB) HostKeyCallback() ssh.HostKeyCallback {
+	return db.callback
+}
+
+// HostKeys behaves like HostKeyCallback.HostKeys, using the currently loaded
+// data. If lookups are failing due to StaleError, nil is returned.
+func (db *FileDB) HostKeys(hostWithPort string) []ssh.PublicKey {
+	return HostKeyCallback(db.callback).HostKeys(hostWithPort)
+}
+
+// HostKeyAlgorithms behaves like HostKeyCallback.HostKeyAlgorithms, using the
+// currently loaded data. If lookups are failing due to StaleError, nil is
+// returned.
+func (db *FileDB) HostKeyAlgorithms(hostWithPort string) []string {
+	return HostKeyCallback(db.callback).HostKeyAlgorithms(hostWithPort)
+}
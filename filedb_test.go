@@ -0,0 +1,185 @@
+package knownhosts
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestFileDB(t *testing.T) {
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	key, newKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t)
+	original := Line([]string{"old.example.test"}, key) + "\n"
+	updated := original + Line([]string{"new.example.test"}, newKey) + "\n"
+
+	// setup writes the original file, and returns a FileDB for it along with
+	// a function which modifies the file
+	setup := func(opts FileDBOptions) (*FileDB, string, func(contents string)) {
+		t.Helper()
+		khPath := filepath.Join(t.TempDir(), "known_hosts")
+		if err := os.WriteFile(khPath, []byte(original), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %v", khPath, err)
+		}
+		db, err := NewFileDB(opts, khPath)
+		if err != nil {
+			t.Fatalf("Unexpected error from NewFileDB: %v", err)
+		}
+		if stale, changed, err := db.Stale(); stale || changed != nil || err != nil {
+			t.Errorf("Unexpected result from Stale on unchanged file: %t, %v, %v", stale, changed, err)
+		}
+		return db, khPath, func(contents string) {
+			if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+				t.Fatalf("Unable to write %s: %v", khPath, err)
+			}
+		}
+	}
+
+	// StaleKeep only notifies
+	var notified [][]string
+	db, khPath, modify := setup(FileDBOptions{CheckInterval: -1, OnStale: func(changed []string) {
+		notified = append(notified, changed)
+	}})
+	modify(updated)
+	if stale, changed, err := db.Stale(); !stale || !reflect.DeepEqual(changed, []string{khPath}) || err != nil {
+		t.Errorf("Unexpected result from Stale on changed file: %t, %v, %v", stale, changed, err)
+	}
+	if err := db.HostKeyCallback()("new.example.test:22", noAddr, newKey); !IsHostUnknown(err) {
+		t.Errorf("Expected StaleKeep to keep using loaded data, instead found %v", err)
+	}
+	if len(notified) != 1 || !reflect.DeepEqual(notified[0], []string{khPath}) {
+		t.Errorf("Expected OnStale to be called once with %s, instead found %v", khPath, notified)
+	}
+	if err := db.Reload(); err != nil {
+		t.Fatalf("Unexpected error from Reload: %v", err)
+	}
+	if err := db.HostKeyCallback()("new.example.test:22", noAddr, newKey); err != nil {
+		t.Errorf("Unexpected error after Reload: %v", err)
+	}
+
+	// StaleReload picks up changes, and fails closed if the reload fails
+	db, _, modify = setup(FileDBOptions{Behavior: StaleReload, CheckInterval: -1})
+	modify(updated)
+	if err := db.HostKeyCallback()("new.example.test:22", noAddr, newKey); err != nil {
+		t.Errorf("Expected StaleReload to pick up new key, instead found %v", err)
+	}
+	if algos := db.HostKeyAlgorithms("new.example.test:22"); !reflect.DeepEqual(algos, []string{ssh.KeyAlgoED25519}) {
+		t.Errorf("Unexpected result from HostKeyAlgorithms: %v", algos)
+	}
+	modify(updated + "malformed.example.test ssh-ed25519\n")
+	if err := db.HostKeyCallback()("new.example.test:22", noAddr, newKey); err == nil || errors.Is(err, ErrStale) {
+		t.Errorf("Expected failed reload to return a non-ErrStale error, instead found %v", err)
+	}
+
+	// StaleError fails lookups until Reload
+	db, _, modify = setup(FileDBOptions{Behavior: StaleError, CheckInterval: -1})
+	modify(updated)
+	if err := db.HostKeyCallback()("old.example.test:22", noAddr, key); !errors.Is(err, ErrStale) {
+		t.Errorf("Expected StaleError to return ErrStale, instead found %v", err)
+	}
+	if keys := db.HostKeys("old.example.test:22"); keys != nil {
+		t.Errorf("Expected HostKeys to return nil while stale, instead found %v", keys)
+	}
+	if err := db.Reload(); err != nil {
+		t.Fatalf("Unexpected error from Reload: %v", err)
+	}
+	if err := db.HostKeyCallback()("new.example.test:22", noAddr, newKey); err != nil {
+		t.Errorf("Unexpected error after Reload: %v", err)
+	}
+
+	// Checks are rate-limited, but Stale always examines the files
+	db, _, modify = setup(FileDBOptions{Behavior: StaleError, CheckInterval: time.Hour})
+	modify(updated)
+	if stale, _, _ := db.Stale(); !stale {
+		t.Error("Expected Stale to report changed file regardless of CheckInterval")
+	}
+	if err := db.HostKeyCallback()("old.example.test:22", noAddr, key); err != nil {
+		t.Errorf("Expected lookup within CheckInterval to skip checking, instead found %v", err)
+	}
+
+	// Removed files are stale
+	db, khPath, _ = setup(FileDBOptions{})
+	os.Remove(khPath)
+	if stale, _, err := db.Stale(); !stale || err != nil {
+		t.Errorf("Expected removed file to be stale, instead found %t, %v", stale, err)
+	}
+}
+
+func TestFileDBHashContents(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	key, otherKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t)
+	original := Line([]string{"host.example.test"}, key) + "\n"
+	if err := os.WriteFile(khPath, []byte(original), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(khPath, modTime, modTime); err != nil {
+		t.Fatalf("Unable to set times of %s: %v", khPath, err)
+	}
+	plainDB, err := NewFileDB(FileDBOptions{}, khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFileDB: %v", err)
+	}
+	hashDB, err := NewFileDB(FileDBOptions{HashContents: true}, khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFileDB: %v", err)
+	}
+
+	// Same size and modification time, but different key
+	if err := os.WriteFile(khPath, []byte(Line([]string{"host.example.test"}, otherKey)+"\n"), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	} else if err := os.Chtimes(khPath, modTime, modTime); err != nil {
+		t.Fatalf("Unable to set times of %s: %v", khPath, err)
+	}
+	if stale, _, _ := plainDB.Stale(); stale {
+		t.Error("Expected change preserving size and modification time to go undetected without HashContents")
+	}
+	if stale, _, _ := hashDB.Stale(); !stale {
+		t.Error("Expected change to be detected with HashContents")
+	}
+}
+
+func TestFileDBConcurrent(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	key := generatePubKeyEd25519(t)
+	line := Line([]string{"host.example.test"}, key) + "\n"
+	if err := os.WriteFile(khPath, []byte(line), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	db, err := NewFileDB(FileDBOptions{Behavior: StaleReload, CheckInterval: -1}, khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFileDB: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if err := db.HostKeyCallback()("host.example.test:22", noAddr, key); err != nil {
+					t.Errorf("Unexpected error from callback: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		// Replace the file atomically, so that reloads never see partial
+		// contents
+		line += Line([]string{"host.example.test"}, generatePubKeyECDSA(t)) + "\n"
+		tmpPath := khPath + ".tmp"
+		if err := os.WriteFile(tmpPath, []byte(line), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %v", tmpPath, err)
+		} else if err := os.Rename(tmpPath, khPath); err != nil {
+			t.Fatalf("Unable to rename %s: %v", tmpPath, err)
+		}
+	}
+	wg.Wait()
+}
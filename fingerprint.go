@@ -0,0 +1,63 @@
+package knownhosts
+
+import (
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Fingerprint returns the SHA256 fingerprint of key, in the same format as
+// ssh.FingerprintSHA256 and OpenSSH: "SHA256:" followed by the unpadded base64
+// hash.
+//
+// If key is an *ssh.Certificate, the fingerprint is of the certified key
+// rather than of the certificate as a whole or of the CA's signature key. This
+// matches ssh-keygen -l, so a host certificate has the same fingerprint as the
+// plain host key it certifies. Use Fingerprint(cert.SignatureKey) to obtain
+// the fingerprint of the CA which signed it.
+func Fingerprint(key ssh.PublicKey) string {
+	return ssh.FingerprintSHA256(plainKey(key))
+}
+
+// FingerprintMD5 returns the legacy MD5 fingerprint of key as lowercase
+// colon-separated hex, identical to the output of ssh-keygen -l -E md5 without
+// its "MD5:" prefix. Certificates are handled the same way as in Fingerprint.
+//
+// MD5 fingerprints are provided only for display, and for comparison with
+// older systems which show nothing else. MD5 is not collision resistant, so an
+// MD5 fingerprint match must never be the basis of a trust decision.
+func FingerprintMD5(key ssh.PublicKey) string {
+	return ssh.FingerprintLegacyMD5(plainKey(key))
+}
+
+// FingerprintSHA256 returns the SHA256 fingerprint of the entry's key, as
+// described by Fingerprint.
+func (e Entry) FingerprintSHA256() string {
+	return Fingerprint(e.Key)
+}
+
+// FindByFingerprint returns the entries whose key has the supplied
+// fingerprint. The fingerprint may be in the SHA256 format returned by
+// Fingerprint, with or without base64 padding, or in the MD5 format returned
+// by FingerprintMD5, optionally with an "MD5:" prefix. MD5 comparisons are
+// case-insensitive. See FingerprintMD5 regarding the limitations of MD5.
+func FindByFingerprint(entries []Entry, fingerprint string) []Entry {
+	fingerprint = strings.TrimSpace(fingerprint)
+	fingerprintFunc := Fingerprint
+	if strings.HasPrefix(fingerprint, "SHA256:") {
+		fingerprint = strings.TrimRight(fingerprint, "=")
+	} else {
+		fingerprintFunc = FingerprintMD5
+		fingerprint = strings.ToLower(fingerprint)
+		if len(fingerprint) >= 4 && fingerprint[:4] == "md5:" {
+			fingerprint = fingerprint[4:]
+		}
+	}
+	var matches []Entry
+	for _, e := range entries {
+		if e.Key != nil && fingerprintFunc(e.Key) == fingerprint {
+			matches = append(matches, e)
+		}
+	}
+	return matches
+}
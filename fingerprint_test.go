@@ -0,0 +1,69 @@
+package knownhosts
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestFingerprint(t *testing.T) {
+	// Expected output obtained from ssh-keygen -l
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIDJqkTKB7K1fyiOrxI1Uw6VSlzUaID+9YxPu+MpNYaT7"))
+	if err != nil {
+		t.Fatalf("Unexpected error from ParseAuthorizedKey: %v", err)
+	}
+	const expected = "SHA256:Pq6e/QsQguZkYlDTkOn7eidqaPCVyaIArntqCplBpDI"
+	if actual := Fingerprint(key); actual != expected {
+		t.Errorf("Expected fingerprint %s, instead found %s", expected, actual)
+	}
+	const expectedMD5 = "4c:19:23:d0:80:a1:84:c8:f9:80:a5:e2:35:d0:91:b5"
+	if actual := FingerprintMD5(key); actual != expectedMD5 {
+		t.Errorf("Expected MD5 fingerprint %s, instead found %s", expectedMD5, actual)
+	}
+	if actual := (Entry{Patterns: []string{"host.example.test"}, Key: key}).FingerprintSHA256(); actual != expected {
+		t.Errorf("Expected entry fingerprint %s, instead found %s", expected, actual)
+	}
+
+	// Certificates have the fingerprint of the certified key, like ssh-keygen
+	hostKey := generatePubKeyECDSA(t)
+	caSigner := generateSignerEd25519(t)
+	cert := generateHostCert(t, caSigner, hostKey, "host.example.test")
+	if actual := Fingerprint(cert); actual != ssh.FingerprintSHA256(hostKey) {
+		t.Errorf("Expected certificate fingerprint to match certified key %s, instead found %s", ssh.FingerprintSHA256(hostKey), actual)
+	}
+	if actual := FingerprintMD5(cert); actual != ssh.FingerprintLegacyMD5(hostKey) {
+		t.Errorf("Expected certificate MD5 fingerprint to match certified key %s, instead found %s", ssh.FingerprintLegacyMD5(hostKey), actual)
+	}
+	if actual := Fingerprint(cert.SignatureKey); actual != ssh.FingerprintSHA256(caSigner.PublicKey()) {
+		t.Errorf("Expected signature key fingerprint %s, instead found %s", ssh.FingerprintSHA256(caSigner.PublicKey()), actual)
+	}
+}
+
+func TestFindByFingerprint(t *testing.T) {
+	edKey, ecKey := generatePubKeyEd25519(t), generatePubKeyECDSA(t)
+	entries := []Entry{
+		{Patterns: []string{"a.example.test"}, Key: edKey, Line: 1},
+		{Patterns: []string{"b.example.test"}, Key: ecKey, Line: 2},
+		{Patterns: []string{"c.example.test"}, Key: edKey, Line: 3},
+	}
+	md5 := FingerprintMD5(edKey)
+	for _, fingerprint := range []string{
+		Fingerprint(edKey),
+		Fingerprint(edKey) + "=",
+		" " + Fingerprint(edKey) + "\n",
+		md5,
+		"MD5:" + md5,
+		strings.ToUpper(md5),
+	} {
+		matches := FindByFingerprint(entries, fingerprint)
+		if len(matches) != 2 || matches[0].Line != 1 || matches[1].Line != 3 {
+			t.Errorf("Unexpected matches for fingerprint %q: %+v", fingerprint, matches)
+		}
+	}
+	for _, fingerprint := range []string{"", "SHA256:bogus", "MD5:00:11", strings.ToLower(Fingerprint(edKey))} {
+		if matches := FindByFingerprint(entries, fingerprint); len(matches) != 0 {
+			t.Errorf("Expected no matches for fingerprint %q, instead found %+v", fingerprint, matches)
+		}
+	}
+}
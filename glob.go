@@ -0,0 +1,50 @@
+package knownhosts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Glob expands each of patterns using filepath.Glob, and returns the paths of
+// the matching regular files. The matches of each pattern are sorted lexically,
+// so that the order in which files are loaded, and therefore the precedence of
+// their entries, is deterministic. Patterns are expanded in the order supplied,
+// and paths matched by more than one pattern are only returned once. A pattern
+// without any glob metacharacters matches only the file it names, if it exists.
+// An error is returned if a pattern is malformed.
+//
+// This is useful for loading known_hosts fragments from a drop-in directory,
+// for example Glob("/etc/ssh/known_hosts.d/*.conf").
+func Glob(patterns ...string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("knownhosts: invalid glob pattern %q: %w", pattern, err)
+		}
+		sort.Strings(matches)
+		for _, path := range matches {
+			if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+				files = append(files, path)
+			}
+		}
+	}
+	return dedupeFiles(files), nil
+}
+
+// NewGlob creates a host key callback from the known_hosts files matching
+// patterns, as returned by Glob, in the same manner as New. An error is
+// returned if the patterns match no files. Use a FileDB with the Glob option
+// to detect files added or removed after loading.
+func NewGlob(patterns ...string) (HostKeyCallback, error) {
+	files, err := Glob(patterns...)
+	if err != nil {
+		return nil, err
+	} else if len(files) == 0 {
+		return nil, fmt.Errorf("knownhosts: no files match %s", strings.Join(patterns, ", "))
+	}
+	return New(files...)
+}
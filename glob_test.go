@@ -0,0 +1,146 @@
+package knownhosts
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFragments writes each of the supplied files in dir, and returns dir.
+func writeFragments(t *testing.T, dir string, files map[string]string) string {
+	t.Helper()
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("Unable to create directory for %s: %v", path, err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %v", path, err)
+		}
+	}
+	return dir
+}
+
+func TestGlob(t *testing.T) {
+	dir := writeFragments(t, t.TempDir(), map[string]string{
+		"known_hosts.d/team-c.conf":        "",
+		"known_hosts.d/team-a.conf":        "",
+		"known_hosts.d/team-b.conf":        "",
+		"known_hosts.d/other.txt":          "",
+		"known_hosts.d/team-dir.conf/file": "",
+		"known_hosts":                      "",
+	})
+	fragments := filepath.Join(dir, "known_hosts.d", "team-*.conf")
+	files, err := Glob(filepath.Join(dir, "known_hosts"), fragments, filepath.Join(dir, "known_hosts.d", "team-a.conf"), filepath.Join(dir, "nonexistent"))
+	if err != nil {
+		t.Fatalf("Unexpected error from Glob: %v", err)
+	}
+	expected := []string{
+		filepath.Join(dir, "known_hosts"),
+		filepath.Join(dir, "known_hosts.d", "team-a.conf"),
+		filepath.Join(dir, "known_hosts.d", "team-b.conf"),
+		filepath.Join(dir, "known_hosts.d", "team-c.conf"),
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Unexpected result from Glob: expected %v, found %v", expected, files)
+	}
+	if _, err := Glob(filepath.Join(dir, "[")); err == nil {
+		t.Error("Expected error from Glob with malformed pattern")
+	}
+}
+
+func TestNewGlob(t *testing.T) {
+	keyA, keyB := generatePubKeyEd25519(t), generatePubKeyECDSA(t)
+	dir := writeFragments(t, t.TempDir(), map[string]string{
+		"team-a.conf": Line([]string{"a.example.test"}, keyA) + "\n",
+		"team-b.conf": Line([]string{"b.example.test"}, keyB) + "\n",
+	})
+	kh, err := NewGlob(filepath.Join(dir, "team-*.conf"))
+	if err != nil {
+		t.Fatalf("Unexpected error from NewGlob: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	if err := kh("a.example.test:22", noAddr, keyA); err != nil {
+		t.Errorf("Unexpected error from callback: %v", err)
+	}
+	if err := kh("b.example.test:22", noAddr, keyB); err != nil {
+		t.Errorf("Unexpected error from callback: %v", err)
+	}
+	if _, err := NewGlob(filepath.Join(dir, "*.missing")); err == nil {
+		t.Error("Expected error from NewGlob with no matches")
+	}
+}
+
+func TestFileDBGlob(t *testing.T) {
+	keyA, keyB := generatePubKeyEd25519(t), generatePubKeyECDSA(t)
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	dir := t.TempDir()
+	pattern := filepath.Join(dir, "team-*.conf")
+	if _, err := NewFileDB(FileDBOptions{Glob: true}, pattern); err == nil {
+		t.Error("Expected error from NewFileDB with no matches")
+	}
+	db, err := NewFileDB(FileDBOptions{Glob: true, IgnoreMissing: true, Behavior: StaleReload, CheckInterval: -1}, pattern)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFileDB with IgnoreMissing: %v", err)
+	}
+	if files := db.Files(); len(files) != 0 {
+		t.Errorf("Expected no files, instead found %v", files)
+	}
+
+	// Added fragments are detected and loaded
+	writeFragments(t, dir, map[string]string{
+		"team-b.conf": Line([]string{"b.example.test"}, keyB) + "\n",
+		"team-a.conf": Line([]string{"a.example.test"}, keyA) + "\n",
+	})
+	pathA, pathB := filepath.Join(dir, "team-a.conf"), filepath.Join(dir, "team-b.conf")
+	if stale, changed, err := db.Stale(); !stale || !reflect.DeepEqual(changed, []string{pathA, pathB}) || err != nil {
+		t.Errorf("Unexpected result from Stale after adding fragments: %t, %v, %v", stale, changed, err)
+	}
+	if err := db.HostKeyCallback()("a.example.test:22", noAddr, keyA); err != nil {
+		t.Errorf("Unexpected error from callback after adding fragments: %v", err)
+	}
+	if files := db.Files(); !reflect.DeepEqual(files, []string{pathA, pathB}) {
+		t.Errorf("Unexpected result from Files: %v", files)
+	}
+
+	// Removed fragments are detected and unloaded
+	if err := os.Remove(pathA); err != nil {
+		t.Fatalf("Unable to remove %s: %v", pathA, err)
+	}
+	if err := db.HostKeyCallback()("a.example.test:22", noAddr, keyA); !IsHostUnknown(err) {
+		t.Errorf("Expected unknown host after removing fragment, instead found %v", err)
+	}
+	if err := db.HostKeyCallback()("b.example.test:22", noAddr, keyB); err != nil {
+		t.Errorf("Unexpected error from callback after removing fragment: %v", err)
+	}
+	if files := db.Files(); !reflect.DeepEqual(files, []string{pathB}) {
+		t.Errorf("Unexpected result from Files after removal: %v", files)
+	}
+}
+
+func TestFileDBIgnoreMissing(t *testing.T) {
+	key := generatePubKeyEd25519(t)
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	if _, err := NewFileDB(FileDBOptions{}, khPath); err == nil {
+		t.Error("Expected error from NewFileDB with missing file")
+	}
+	db, err := NewFileDB(FileDBOptions{IgnoreMissing: true, Behavior: StaleReload, CheckInterval: -1}, khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFileDB with IgnoreMissing: %v", err)
+	}
+	if err := db.HostKeyCallback()("host.example.test:22", noAddr, key); !IsHostUnknown(err) {
+		t.Errorf("Expected unknown host, instead found %v", err)
+	}
+	if err := os.WriteFile(khPath, []byte(Line([]string{"host.example.test"}, key)+"\n"), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	if err := db.HostKeyCallback()("host.example.test:22", noAddr, key); err != nil {
+		t.Errorf("Unexpected error after file creation: %v", err)
+	}
+	if files := db.Files(); !reflect.DeepEqual(files, []string{khPath}) {
+		t.Errorf("Unexpected result from Files: %v", files)
+	}
+}
@@ -0,0 +1,263 @@
+package knownhosts
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// hashMagic is the prefix used by OpenSSH for hashed known_hosts patterns.
+const hashMagic = "|1|"
+
+// HashPattern returns a hashed known_hosts pattern for hostname, in the format
+// OpenSSH writes when its HashKnownHosts option is enabled: "|1|" followed by
+// the base64-encoded random 20-byte salt, a "|" separator, and the
+// base64-encoded HMAC-SHA1 of hostname keyed by the salt. There is no trailing
+// separator, and both fields use standard base64 encoding with padding.
+//
+// Unlike HashHostname, hostname is hashed exactly as supplied, so it must
+// already be in the form OpenSSH looks up: a lowercase host for port 22, or
+// "[host]:port" otherwise. This is useful for tooling which generates
+// known_hosts content from plaintext patterns.
+//
+// An error is only returned if the system's secure random number generator
+// fails.
+func HashPattern(hostname string) (string, error) {
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return HashPatternWithSalt(hostname, salt), nil
+}
+
+// HashPatternWithSalt behaves like HashPattern, but uses the supplied salt
+// instead of a random one. This is primarily useful for producing deterministic
+// output in tests. OpenSSH always uses a salt of 20 bytes, which is the length
+// of a SHA1 digest.
+func HashPatternWithSalt(hostname string, salt []byte) string {
+	return hashMagic + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(hashDigest(salt, []byte(hostname)))
+}
+
+// MatchHashedPattern returns true if the hashed pattern, such as one returned
+// by HashPattern, is a hash of hostname. As with HashPattern, hostname is
+// compared exactly as supplied; use MatchesPattern to normalize it first. An
+// error is returned if pattern is not a well-formed hashed pattern.
+func MatchHashedPattern(pattern, hostname string) (bool, error) {
+	salt, hash, ok := decodeHashedPattern(pattern)
+	if !ok {
+		return false, fmt.Errorf("knownhosts: malformed hashed host pattern %q", pattern)
+	}
+	return hmac.Equal(hashDigest(salt, []byte(hostname)), hash), nil
+}
+
+// hashDigest returns the HMAC-SHA1 of hostname keyed by salt.
+func hashDigest(salt, hostname []byte) []byte {
+	mac := hmac.New(sha1.New, salt)
+	mac.Write(hostname)
+	return mac.Sum(nil)
+}
+
+// HashHostname returns a hashed form of the supplied hostname, suitable for
+// use as a known_hosts pattern, in the format described by HashPattern. The
+// hostname is first normalized using Normalize, so for example "host:22" and
+// "host" will hash identically for a given salt.
+//
+// An error is only returned if the system's secure random number generator
+// fails.
+func HashHostname(hostname string) (string, error) {
+	return HashPattern(Normalize(hostname))
+}
+
+// HashHostnameWithSalt behaves like HashHostname, but uses the supplied salt
+// instead of a random one. This is primarily useful for producing deterministic
+// output in tests.
+func HashHostnameWithSalt(hostname string, salt []byte) string {
+	return HashPatternWithSalt(Normalize(hostname), salt)
+}
+
+// HashFile rewrites the known_hosts file at path, replacing plaintext host
+// patterns with hashed ones, similar to OpenSSH's "ssh-keygen -H". Since hashed
+// patterns cannot be comma-separated, lines listing multiple patterns are split
+// into one line per pattern. Comments, blank lines, lines with a marker such as
+// @cert-authority or @revoked, and lines which are already hashed are all left
+// unchanged.
+//
+// As with OpenSSH, IPv6 addresses on port 22 are hashed in their unbracketed
+// form; callbacks returned by New still recognize these entries.
+//
+// Lines containing wildcard or negated patterns cannot be meaningfully hashed,
+// so they are also left unchanged; the line numbers of any such lines are
+// returned, so that the caller can report them or handle them separately.
+//
+// The file is locked and rewritten atomically. Supply the WithBackup option to
+// retain a copy of the original file.
+func HashFile(path string, opts ...WriteOption) (unhashedLines []int, err error) {
+	err = rewriteLines(path, newWriteOptions(opts), func(lineNum int, line string) (string, error) {
+		hashedLine, ok, err := hashLine(line)
+		if !ok {
+			unhashedLines = append(unhashedLines, lineNum)
+		}
+		return hashedLine, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return unhashedLines, nil
+}
+
+// hashLine returns a hashed form of a single known_hosts line, including its
+// original line ending if any. Lines which do not need hashing are returned
+// unchanged. The returned bool is false if the line contains plaintext patterns
+// which cannot be hashed, in which case the line is also returned unchanged.
+func hashLine(line string) (string, bool, error) {
+	content, eol := splitLineEnding(line)
+	leading, patterns, rest := splitFirstField(content)
+	if patterns == "" || patterns[0] == '#' || patterns[0] == '@' || strings.HasPrefix(patterns, hashMagic) {
+		return line, true, nil
+	}
+	if strings.ContainsAny(patterns, "*?!") {
+		return line, false, nil
+	}
+	sep := eol
+	if sep == "" {
+		sep = "\n"
+	}
+	var b strings.Builder
+	for n, pattern := range strings.Split(patterns, ",") {
+		hashed, err := HashHostname(pattern)
+		if err != nil {
+			return "", false, err
+		}
+		if n > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(leading + hashed + rest)
+	}
+	b.WriteString(eol)
+	return b.String(), true, nil
+}
+
+// ResolveHashedEntries determines which of the supplied entries' hashed host
+// patterns correspond to each of the candidate hostnames, for example to label
+// the lines of a hashed known_hosts file using an inventory of known hosts.
+// Candidates may include a port, and are normalized using Normalize before
+// hashing. Entries with only plaintext patterns are ignored.
+//
+// The returned map is keyed by candidate, exactly as supplied, and only
+// includes candidates which matched at least one entry. Hashed entries which
+// did not match any candidate are returned in unmatched; in an up-to-date
+// inventory, these are likely stale. Entries may be obtained from ReadEntries.
+func ResolveHashedEntries(entries []Entry, candidates []string) (matches map[string][]Entry, unmatched []Entry) {
+	// Decode each hashed pattern once up-front, rather than once per candidate
+	type hashedPattern struct {
+		salt, hash []byte
+		entry      int
+	}
+	var patterns []hashedPattern
+	for n, e := range entries {
+		for _, pattern := range e.Patterns {
+			if salt, hash, ok := decodeHashedPattern(pattern); ok {
+				patterns = append(patterns, hashedPattern{salt: salt, hash: hash, entry: n})
+			}
+		}
+	}
+
+	matches = make(map[string][]Entry)
+	matched := make([]bool, len(entries))
+	for _, candidate := range candidates {
+		normalized := []byte(Normalize(candidate))
+		lastEntry := -1
+		for _, p := range patterns {
+			if p.entry == lastEntry {
+				continue // entry already matched this candidate via another pattern
+			}
+			if hmac.Equal(hashDigest(p.salt, normalized), p.hash) {
+				matches[candidate] = append(matches[candidate], entries[p.entry])
+				matched[p.entry] = true
+				lastEntry = p.entry
+			}
+		}
+	}
+	seen := make(map[int]bool)
+	for _, p := range patterns {
+		if !matched[p.entry] && !seen[p.entry] {
+			seen[p.entry] = true
+			unmatched = append(unmatched, entries[p.entry])
+		}
+	}
+	return matches, unmatched
+}
+
+// decodeHashedPattern returns the decoded salt and hash of a hashed pattern. If
+// pattern is not a valid hashed pattern, the returned bool is false.
+func decodeHashedPattern(pattern string) (salt, hash []byte, ok bool) {
+	if !strings.HasPrefix(pattern, hashMagic) {
+		return nil, nil, false
+	}
+	parts := strings.Split(pattern[len(hashMagic):], "|")
+	if len(parts) != 2 {
+		return nil, nil, false
+	}
+	salt, saltErr := base64.StdEncoding.DecodeString(parts[0])
+	hash, hashErr := base64.StdEncoding.DecodeString(parts[1])
+	return salt, hash, saltErr == nil && hashErr == nil
+}
+
+// UnhashedError is returned by RequireHashed if any entries have plaintext
+// host patterns. Its Warnings have the code WarningPlaintextPattern, or
+// WarningUnhashablePattern for entries with wildcard or negated patterns,
+// which can never be hashed and so require an explicit exception.
+type UnhashedError struct {
+	Warnings []Warning
+}
+
+// Error satisfies the error interface, listing the location of every entry.
+func (ue *UnhashedError) Error() string {
+	locations := make([]string, len(ue.Warnings))
+	for n, w := range ue.Warnings {
+		locations[n] = w.String()
+	}
+	return fmt.Sprintf("knownhosts: %d entries are not hashed:\n%s", len(ue.Warnings), strings.Join(locations, "\n"))
+}
+
+// RequireHashed returns an *UnhashedError if any of entries, such as those
+// returned by ReadEntries, have a plaintext host pattern. Lines with a marker
+// are not considered, since OpenSSH never hashes them. Callers which only want
+// to log the offending entries, rather than fail, may use the error's Warnings
+// directly. See also WithRequireHashed, for enforcing hashing on writes.
+func RequireHashed(entries []Entry) error {
+	var warnings []Warning
+	for _, e := range entries {
+		if e.Marker != MarkerNone {
+			continue
+		}
+		var plain, unhashable []string
+		for _, pattern := range e.Patterns {
+			if strings.HasPrefix(pattern, hashMagic) {
+				continue
+			} else if isHostPattern(pattern) {
+				unhashable = append(unhashable, pattern)
+			} else {
+				plain = append(plain, pattern)
+			}
+		}
+		w := Warning{Filename: e.Filename, Line: e.Line}
+		if len(unhashable) > 0 {
+			w.Code = WarningUnhashablePattern
+			w.Message = fmt.Sprintf("wildcard or negated patterns %s cannot be hashed", strings.Join(unhashable, ","))
+		} else if len(plain) > 0 {
+			w.Code = WarningPlaintextPattern
+			w.Message = fmt.Sprintf("plaintext patterns %s", strings.Join(plain, ","))
+		} else {
+			continue
+		}
+		warnings = append(warnings, w)
+	}
+	if len(warnings) > 0 {
+		return &UnhashedError{Warnings: warnings}
+	}
+	return nil
+}
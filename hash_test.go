@@ -0,0 +1,416 @@
+package knownhosts
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHashHostname(t *testing.T) {
+	// Expected values were generated by OpenSSH's ssh-keygen -H
+	cases := []struct {
+		hostname string
+		salt     string
+		want     string
+	}{
+		{"server.example.test", "JMMUjp1iAQOLEV3XOY5Fxwz8NxY=", "|1|JMMUjp1iAQOLEV3XOY5Fxwz8NxY=|LaDnlNg0PJviXJRY/DOYlMNn2jY="},
+		{"server.example.test:22", "JMMUjp1iAQOLEV3XOY5Fxwz8NxY=", "|1|JMMUjp1iAQOLEV3XOY5Fxwz8NxY=|LaDnlNg0PJviXJRY/DOYlMNn2jY="},
+		{"[::1]:2222", "P74tqx+JOf7C9Afi9RwQTZsvMYU=", "|1|P74tqx+JOf7C9Afi9RwQTZsvMYU=|X1MoSMphJV2i89W2Fh6SPKygUHM="},
+	}
+	for _, c := range cases {
+		salt, _ := base64.StdEncoding.DecodeString(c.salt)
+		if got := HashHostnameWithSalt(c.hostname, salt); got != c.want {
+			t.Errorf("HashHostnameWithSalt(%q) = %q, want %q", c.hostname, got, c.want)
+		}
+	}
+
+	// Random salts should differ between calls
+	a, err := HashHostname("server.example.test")
+	if err != nil {
+		t.Fatalf("Unexpected error from HashHostname: %v", err)
+	}
+	b, _ := HashHostname("server.example.test")
+	if a == b || !strings.HasPrefix(a, "|1|") {
+		t.Errorf("Unexpected results from HashHostname: %q, %q", a, b)
+	}
+}
+
+func TestHashPattern(t *testing.T) {
+	// HashPattern does not normalize, so only the exact OpenSSH form matches the
+	// values generated by ssh-keygen -H
+	salt, _ := base64.StdEncoding.DecodeString("JMMUjp1iAQOLEV3XOY5Fxwz8NxY=")
+	const want = "|1|JMMUjp1iAQOLEV3XOY5Fxwz8NxY=|LaDnlNg0PJviXJRY/DOYlMNn2jY="
+	if got := HashPatternWithSalt("server.example.test", salt); got != want {
+		t.Errorf("HashPatternWithSalt = %q, want %q", got, want)
+	}
+	if got := HashPatternWithSalt("server.example.test:22", salt); got == want {
+		t.Error("Expected HashPatternWithSalt to hash hostname without normalizing it")
+	}
+
+	pattern, err := HashPattern("[::1]:2222")
+	if err != nil {
+		t.Fatalf("Unexpected error from HashPattern: %v", err)
+	}
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 || parts[0] != "" || parts[1] != "1" {
+		t.Fatalf("Unexpected format from HashPattern: %q", pattern)
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(parts[2]); err != nil || len(decoded) != 20 {
+		t.Errorf("Expected 20-byte salt from HashPattern, instead found %q", parts[2])
+	}
+
+	cases := []struct {
+		pattern  string
+		hostname string
+		want     bool
+	}{
+		{want, "server.example.test", true},
+		{want, "server.example.test:22", false},
+		{want, "other.example.test", false},
+		{pattern, "[::1]:2222", true},
+		{pattern, "[::1]:22", false},
+	}
+	for _, c := range cases {
+		if got, err := MatchHashedPattern(c.pattern, c.hostname); err != nil || got != c.want {
+			t.Errorf("MatchHashedPattern(%q, %q) = %t, %v; want %t", c.pattern, c.hostname, got, err, c.want)
+		}
+	}
+	for _, malformed := range []string{"server.example.test", "|1|", "|1|abc", want + "|", "|2|" + parts[2] + "|" + parts[3], "|1|!!!|" + parts[3]} {
+		if _, err := MatchHashedPattern(malformed, "server.example.test"); err == nil {
+			t.Errorf("Expected error from MatchHashedPattern(%q), but it succeeded", malformed)
+		}
+	}
+}
+
+func TestWriteKnownHostHashed(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	pubKey := generatePubKeyEd25519(t)
+	remote, _ := net.ResolveTCPAddr("tcp", "192.168.0.1:2222")
+	var buf bytes.Buffer
+	if err := WriteKnownHost(&buf, "hashed.example.test:2222", remote, pubKey, WithHashedHostnames()); err != nil {
+		t.Fatalf("Unexpected error from WriteKnownHost: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines from WriteKnownHost, instead found %d: %q", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "|1|") || strings.Contains(line, "example.test") || strings.Contains(line, "192.168") {
+			t.Errorf("Line %q does not appear to be hashed", line)
+		}
+	}
+	if err := os.WriteFile(khPath, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+
+	// Both the hostname and the remote should be known via the callback
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	for _, host := range []string{"hashed.example.test:2222", "192.168.0.1:2222"} {
+		if err := kh(host, noAddr, pubKey); err != nil {
+			t.Errorf("Unexpected error from callback for %s: %v", host, err)
+		}
+	}
+	if err := kh("hashed.example.test:22", noAddr, pubKey); !IsHostUnknown(err) {
+		t.Errorf("Expected host on different port to be unknown, instead found %v", err)
+	}
+
+	// AppendKnownHost should also support the option
+	if err := AppendKnownHost(khPath, "appended.example.test", noAddr, pubKey, WithHashedHostnames()); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	if kh, err = New(khPath); err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	if err := kh("appended.example.test:22", noAddr, pubKey); err != nil {
+		t.Errorf("Unexpected error from callback for appended host: %v", err)
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	keys := []ssh.PublicKey{generatePubKeyEd25519(t), generatePubKeyECDSA(t), generatePubKeyEd25519(t), generatePubKeyEd25519(t)}
+	caKey := generatePubKeyEd25519(t)
+	preHashed, _ := HashHostname("prehashed.example.test")
+	original := "# comment line\n" +
+		"\n" +
+		Line([]string{"multi.example.test", "10.0.0.5", "[multi.example.test]:2222"}, keys[0]) + " trailing comment\n" +
+		Line([]string{"single.example.test"}, keys[1]) + "\n" +
+		"@cert-authority *.ca.example.test " + keyString(caKey) + "\n" +
+		preHashed + " " + keyString(keys[2]) + "\n" +
+		"*.wild.example.test,!bad.wild.example.test " + keyString(keys[3]) + "\n" +
+		Line([]string{"nonewline.example.test"}, keys[3])
+	if err := os.WriteFile(khPath, []byte(original), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+
+	unhashed, err := HashFile(khPath, WithBackup())
+	if err != nil {
+		t.Fatalf("Unexpected error from HashFile: %v", err)
+	}
+	if len(unhashed) != 1 || unhashed[0] != 7 {
+		t.Errorf("Expected HashFile to report line 7 as unhashed, instead found %v", unhashed)
+	}
+	if backup, err := os.ReadFile(khPath + ".old"); err != nil || string(backup) != original {
+		t.Errorf("Backup file does not match original contents; err=%v", err)
+	}
+	contents, err := os.ReadFile(khPath)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %v", khPath, err)
+	}
+	lines := strings.Split(string(contents), "\n")
+	if len(lines) != 10 {
+		t.Fatalf("Expected 10 lines after HashFile, instead found %d: %q", len(lines), contents)
+	}
+	for n, expected := range map[int]string{
+		0: "# comment line",
+		1: "",
+		6: "@cert-authority *.ca.example.test " + keyString(caKey),
+		7: preHashed + " " + keyString(keys[2]),
+		8: "*.wild.example.test,!bad.wild.example.test " + keyString(keys[3]),
+	} {
+		if lines[n] != expected {
+			t.Errorf("Expected line %d to be unchanged as %q, instead found %q", n+1, expected, lines[n])
+		}
+	}
+	for _, n := range []int{2, 3, 4} {
+		if !strings.HasPrefix(lines[n], "|1|") || !strings.HasSuffix(lines[n], " trailing comment") {
+			t.Errorf("Expected line %d to be hashed with comment retained, instead found %q", n+1, lines[n])
+		}
+	}
+	for _, n := range []int{5, 9} {
+		if !strings.HasPrefix(lines[n], "|1|") {
+			t.Errorf("Expected line %d to be hashed, instead found %q", n+1, lines[n])
+		}
+	}
+	if strings.Contains(string(contents), "multi.example.test") || strings.Contains(string(contents), "nonewline") {
+		t.Errorf("Plaintext hostnames unexpectedly remain in file: %q", contents)
+	}
+
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	for host, key := range map[string]ssh.PublicKey{
+		"multi.example.test:22":     keys[0],
+		"10.0.0.5:22":               keys[0],
+		"multi.example.test:2222":   keys[0],
+		"single.example.test:22":    keys[1],
+		"prehashed.example.test:22": keys[2],
+		"nonewline.example.test:22": keys[3],
+	} {
+		if err := kh(host, noAddr, key); err != nil {
+			t.Errorf("Unexpected error from callback for %s after HashFile: %v", host, err)
+		}
+	}
+
+	// IPv6 addresses on port 22 are hashed unbracketed, but are still found by
+	// New afterwards
+	v6Path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(v6Path, []byte(Line([]string{"2001:db8::1", "[2001:db8::2]:2222"}, keys[0])+"\n"), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", v6Path, err)
+	}
+	if unhashed, err := HashFile(v6Path); err != nil || len(unhashed) != 0 {
+		t.Fatalf("Unexpected result from HashFile: %v, %v", unhashed, err)
+	}
+	if contents, err := os.ReadFile(v6Path); err != nil || strings.Contains(string(contents), "2001:db8") {
+		t.Errorf("Expected IPv6 addresses to be hashed, instead found %q (err=%v)", contents, err)
+	}
+	kh, err = New(v6Path)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	for _, host := range []string{"[2001:db8::1]:22", "[2001:db8::2]:2222"} {
+		if err := kh(host, noAddr, keys[0]); err != nil {
+			t.Errorf("Unexpected error from callback for %s after HashFile: %v", host, err)
+		}
+	}
+}
+
+// keyString returns the type and base64-encoded form of key, as used in
+// known_hosts lines.
+func keyString(key ssh.PublicKey) string {
+	return key.Type() + " " + base64.StdEncoding.EncodeToString(key.Marshal())
+}
+
+func TestWriteKnownHostHashedAddresses(t *testing.T) {
+	pubKey := generatePubKeyEd25519(t)
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	cases := []struct {
+		hostname string
+		remote   string
+		lookups  []string // each must match independently after reading back
+	}{
+		{"v6.example.test:22", "[2001:db8::1]:22", []string{"v6.example.test:22", "[2001:db8::1]:22"}},
+		{"v6.example.test:2222", "[2001:db8::1]:2222", []string{"v6.example.test:2222", "[2001:db8::1]:2222"}},
+		{"[2001:db8::2]:2222", "[2001:db8::2]:2222", []string{"[2001:db8::2]:2222"}},
+		{"port.example.test:2222", "192.168.0.9:2222", []string{"port.example.test:2222", "192.168.0.9:2222"}},
+		{"192.168.0.10:22", "192.168.0.10:22", []string{"192.168.0.10:22"}},
+		{"noremote.example.test:2222", "0.0.0.0:0", []string{"noremote.example.test:2222"}},
+	}
+	for _, c := range cases {
+		remote, err := net.ResolveTCPAddr("tcp", c.remote)
+		if err != nil {
+			t.Fatalf("Unable to resolve %s: %v", c.remote, err)
+		}
+		var buf bytes.Buffer
+		if err := WriteKnownHost(&buf, c.hostname, remote, pubKey, WithHashedHostnames()); err != nil {
+			t.Fatalf("Unexpected error from WriteKnownHost: %v", err)
+		}
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) != len(c.lookups) {
+			t.Errorf("Expected %d lines from WriteKnownHost(%q, %q), instead found %d: %q", len(c.lookups), c.hostname, c.remote, len(lines), buf.String())
+			continue
+		}
+		for _, line := range lines {
+			if !strings.HasPrefix(line, "|1|") || strings.Contains(line, ",") {
+				t.Errorf("Line %q does not appear to be a single hashed pattern", line)
+			}
+		}
+		khPath := filepath.Join(t.TempDir(), "known_hosts")
+		if err := os.WriteFile(khPath, buf.Bytes(), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %v", khPath, err)
+		}
+		kh, err := New(khPath)
+		if err != nil {
+			t.Fatalf("Unexpected error from New: %v", err)
+		}
+		for n, host := range c.lookups {
+			// IPv6 addresses on port 22 are hashed in unbracketed form, like OpenSSH
+			if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]:22") {
+				_, pattern, _ := splitFirstField(lines[n])
+				if !patternMatchesAddress(pattern, host) || strings.Contains(Normalize(host), "[") {
+					t.Errorf("Expected line %d to be hashed from unbracketed %s, instead found %q", n+1, host, lines[n])
+				}
+			}
+			// Each lookup must be satisfied by its own line alone
+			single := filepath.Join(t.TempDir(), "known_hosts")
+			if err := os.WriteFile(single, []byte(lines[n]+"\n"), 0600); err != nil {
+				t.Fatalf("Unable to write %s: %v", single, err)
+			}
+			singleKH, err := New(single)
+			if err != nil {
+				t.Fatalf("Unexpected error from New: %v", err)
+			}
+			if err := singleKH(host, noAddr, pubKey); err != nil {
+				t.Errorf("Unexpected error from callback for %s using line %d only: %v", host, n+1, err)
+			}
+			if err := kh(host, noAddr, pubKey); err != nil {
+				t.Errorf("Unexpected error from callback for %s: %v", host, err)
+			}
+		}
+	}
+}
+
+func TestResolveHashedEntries(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	keys := []ssh.PublicKey{generatePubKeyEd25519(t), generatePubKeyECDSA(t)}
+	var contents string
+	for _, host := range []string{"web1.example.test", "[web1.example.test]:2222", "10.0.0.5", "stale.example.test"} {
+		contents += Line([]string{host}, keys[0], WithHashedHostnames()) + "\n"
+	}
+	contents += Line([]string{"web1.example.test"}, keys[1], WithHashedHostnames()) + "\n"
+	contents += Line([]string{"plain.example.test"}, keys[1]) + "\n"
+	if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	entries, err := ReadEntries(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadEntries: %v", err)
+	}
+
+	candidates := []string{"web1.example.test:22", "web1.example.test:2222", "10.0.0.5", "plain.example.test", "missing.example.test"}
+	matches, unmatched := ResolveHashedEntries(entries, candidates)
+	expectLines := map[string][]int{
+		"web1.example.test:22":   {1, 5},
+		"web1.example.test:2222": {2},
+		"10.0.0.5":               {3},
+	}
+	if len(matches) != len(expectLines) {
+		t.Errorf("Expected %d candidates to match, instead found %d: %+v", len(expectLines), len(matches), matches)
+	}
+	for candidate, lines := range expectLines {
+		var got []int
+		for _, e := range matches[candidate] {
+			got = append(got, e.Line)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(lines) {
+			t.Errorf("Expected candidate %s to match lines %v, instead found %v", candidate, lines, got)
+		}
+	}
+	if len(unmatched) != 1 || unmatched[0].Line != 4 {
+		t.Errorf("Expected only line 4 to be unmatched, instead found %+v", unmatched)
+	}
+}
+
+func TestRequireHashed(t *testing.T) {
+	key := generatePubKeyEd25519(t)
+	hashed := Line([]string{"hashed.example.test"}, key, WithRequireHashed())
+	if !strings.HasPrefix(hashed, hashMagic) {
+		t.Errorf("Expected Line with WithRequireHashed to be hashed, instead found %q", hashed)
+	}
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	contents := strings.Join([]string{
+		hashed,
+		"plain.example.test,[plain.example.test]:2222 " + keyString(key),
+		"@cert-authority *.example.test " + keyString(key),
+		"*.example.test,other.example.test " + keyString(key),
+	}, "\n") + "\n"
+	if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	entries, err := ReadEntries(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadEntries: %v", err)
+	}
+	if err := RequireHashed(entries[:1]); err != nil {
+		t.Errorf("Unexpected error from RequireHashed for hashed entry: %v", err)
+	}
+	var ue *UnhashedError
+	if err := RequireHashed(entries); !errors.As(err, &ue) {
+		t.Fatalf("Expected *UnhashedError from RequireHashed, instead found %v", err)
+	} else if len(ue.Warnings) != 2 {
+		t.Fatalf("Expected 2 warnings, instead found %+v", ue.Warnings)
+	}
+	if w := ue.Warnings[0]; w.Line != 2 || w.Code != WarningPlaintextPattern || !strings.Contains(w.Message, "[plain.example.test]:2222") {
+		t.Errorf("Unexpected first warning: %+v", w)
+	}
+	if w := ue.Warnings[1]; w.Line != 4 || w.Code != WarningUnhashablePattern || !strings.Contains(w.Message, "*.example.test") {
+		t.Errorf("Unexpected second warning: %+v", w)
+	}
+	if msg := ue.Error(); !strings.Contains(msg, khPath+":2: plaintext-pattern") || !strings.Contains(msg, khPath+":4: unhashable-pattern") {
+		t.Errorf("Unexpected error message: %s", msg)
+	}
+
+	// Writes are hashed even if other options would write plaintext
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	if err := AppendKnownHost(khPath, "plain.example.test:2200", noAddr, key, WithAutoStyle(StylePlain), WithMergeAddresses(), WithRequireHashed()); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	after, err := os.ReadFile(khPath)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %v", khPath, err)
+	}
+	if !strings.HasPrefix(string(after), contents) {
+		t.Errorf("Expected existing lines to be unchanged, instead found %q", after)
+	}
+	if appended := strings.TrimPrefix(string(after), contents); !strings.HasPrefix(appended, hashMagic) || strings.Count(appended, "\n") != 1 {
+		t.Errorf("Expected one hashed line to be appended, instead found %q", appended)
+	}
+	var b strings.Builder
+	if err := WriteKnownHostAddresses(&b, []string{"*.example.test"}, key, WithRequireHashed()); err == nil {
+		t.Errorf("Expected error writing wildcard pattern with WithRequireHashed, instead wrote %q", b.String())
+	}
+}
@@ -0,0 +1,203 @@
+package knownhosts
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	hostKeysRequest      = "hostkeys-00@openssh.com"
+	hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+)
+
+// HostKeyUpdate describes the outcome of processing a host key announcement
+// from a server, as reported by HandleHostKeyUpdates.
+type HostKeyUpdate struct {
+	Hostname string          // hostname the connection was established with
+	Added    []ssh.PublicKey // proven keys which were appended to known_hosts
+	Removed  []ssh.PublicKey // known keys which the server no longer announces
+	Err      error           // error which occurred while processing, if any
+}
+
+// HandleHostKeyUpdates processes host key announcements sent by OpenSSH
+// servers via the hostkeys-00@openssh.com global request, similar to the
+// client side of OpenSSH's UpdateHostKeys option. OpenSSH servers send this
+// request shortly after authentication, listing all of their host keys.
+//
+// Announced keys which are not yet known for hostname in the known_hosts file
+// at path are verified by asking the server to prove possession of their
+// private keys, using the hostkeys-prove-00@openssh.com request. Keys whose
+// signatures verify are appended to the file via AppendKnownHosts, with the
+// supplied options. Since the proof is bound to the session identifier of an
+// already-verified connection, this cannot introduce keys from a MitM. Keys
+// which are known for hostname but no longer announced are reported in
+// HostKeyUpdate.Removed, but are not removed from the file; see RemoveByKey.
+// Certificates and keys with markers are not considered. Since lookups use
+// HostKeys, at most one known key of each type is considered for removal.
+//
+// The conn and reqs should be obtained from ssh.NewClientConn. Any requests
+// other than hostkeys-00@openssh.com are forwarded to the returned channel,
+// which should be passed to ssh.NewClient; it is closed once reqs is closed.
+// For example:
+//
+//	c, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
+//	// ... handle err
+//	reqs = knownhosts.HandleHostKeyUpdates(c, reqs, addr, netConn.RemoteAddr(), khPath, nil)
+//	client := ssh.NewClient(c, chans, reqs)
+//
+// If report is non-nil, it is called from a separate goroutine once each
+// announcement has been processed. Callbacks created via New before the update
+// do not reflect appended keys.
+func HandleHostKeyUpdates(conn ssh.Conn, reqs <-chan *ssh.Request, hostname string, remote net.Addr, path string, report func(HostKeyUpdate), opts ...WriteOption) <-chan *ssh.Request {
+	out := make(chan *ssh.Request)
+	go func() {
+		defer close(out)
+		for req := range reqs {
+			if req.Type != hostKeysRequest {
+				out <- req
+				continue
+			}
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			// Process the announcement separately, since proving keys requires a
+			// round trip, during which further requests must still be consumed
+			go func(payload []byte) {
+				update := processHostKeys(conn, payload, hostname, remote, path, opts)
+				if report != nil {
+					report(update)
+				}
+			}(req.Payload)
+		}
+	}()
+	return out
+}
+
+// processHostKeys handles a single hostkeys-00@openssh.com announcement.
+func processHostKeys(conn ssh.Conn, payload []byte, hostname string, remote net.Addr, path string, opts []WriteOption) HostKeyUpdate {
+	update := HostKeyUpdate{Hostname: hostname}
+	announced, err := parseKeyBlobs(payload)
+	if err != nil {
+		update.Err = err
+		return update
+	}
+	kh, err := New(path)
+	if err != nil {
+		update.Err = err
+		return update
+	}
+
+	var unknown []ssh.PublicKey
+	isAnnounced := make(map[string]bool, len(announced))
+	for _, key := range announced {
+		isAnnounced[string(key.Marshal())] = true
+		if err := kh(hostname, remote, key); err != nil {
+			unknown = append(unknown, key)
+		}
+	}
+	for _, key := range kh.HostKeys(hostname) {
+		if _, isCert := key.(*ssh.Certificate); !isCert && !isAnnounced[string(key.Marshal())] {
+			update.Removed = append(update.Removed, key)
+		}
+	}
+	if len(unknown) == 0 {
+		return update
+	}
+
+	proven, err := proveHostKeys(conn, unknown)
+	if len(proven) > 0 {
+		if appendErr := AppendKnownHosts(path, hostname, remote, proven, opts...); appendErr != nil {
+			update.Err = appendErr
+			return update
+		}
+		update.Added = proven
+	}
+	update.Err = err
+	return update
+}
+
+// parseKeyBlobs parses a payload consisting of a sequence of SSH strings, each
+// containing a public key blob. Certificates are skipped.
+func parseKeyBlobs(payload []byte) ([]ssh.PublicKey, error) {
+	blobs, err := parseStrings(payload)
+	if err != nil {
+		return nil, fmt.Errorf("knownhosts: malformed %s request: %w", hostKeysRequest, err)
+	}
+	var keys []ssh.PublicKey
+	for _, blob := range blobs {
+		key, err := ssh.ParsePublicKey(blob)
+		if err != nil {
+			// Unsupported key types are ignored, as in OpenSSH
+			continue
+		}
+		if _, isCert := key.(*ssh.Certificate); !isCert {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// parseStrings splits data into a sequence of SSH wire-format strings.
+func parseStrings(data []byte) ([][]byte, error) {
+	var result [][]byte
+	for len(data) > 0 {
+		var s struct {
+			Value []byte
+			Rest  []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(data, &s); err != nil {
+			return nil, err
+		}
+		result = append(result, s.Value)
+		data = s.Rest
+	}
+	return result, nil
+}
+
+// proveHostKeys asks the server to prove possession of the private keys for
+// keys, returning the subset whose signatures verify. If any key could not be
+// proven, a non-nil error is also returned.
+func proveHostKeys(conn ssh.Conn, keys []ssh.PublicKey) ([]ssh.PublicKey, error) {
+	var payload []byte
+	for _, key := range keys {
+		payload = append(payload, ssh.Marshal(struct{ Blob []byte }{key.Marshal()})...)
+	}
+	ok, response, err := conn.SendRequest(hostKeysProveRequest, true, payload)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errors.New("knownhosts: server rejected " + hostKeysProveRequest + " request")
+	}
+	sigBlobs, err := parseStrings(response)
+	if err != nil || len(sigBlobs) != len(keys) {
+		return nil, errors.New("knownhosts: malformed " + hostKeysProveRequest + " response")
+	}
+
+	var proven []ssh.PublicKey
+	var failed int
+	for n, key := range keys {
+		var sig ssh.Signature
+		if err := ssh.Unmarshal(sigBlobs[n], &sig); err != nil || key.Verify(hostKeyProofData(conn.SessionID(), key), &sig) != nil {
+			failed++
+			continue
+		}
+		proven = append(proven, key)
+	}
+	if failed > 0 {
+		return proven, fmt.Errorf("knownhosts: server failed to prove possession of %d host key(s)", failed)
+	}
+	return proven, nil
+}
+
+// hostKeyProofData returns the data which a server signs to prove possession
+// of key in response to a hostkeys-prove-00@openssh.com request.
+func hostKeyProofData(sessionID []byte, key ssh.PublicKey) []byte {
+	return ssh.Marshal(struct {
+		Request   string
+		SessionID []byte
+		Blob      []byte
+	}{hostKeysProveRequest, sessionID, key.Marshal()})
+}
@@ -0,0 +1,96 @@
+package knownhosts_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skeema/knownhosts"
+	"github.com/skeema/knownhosts/knownhoststest"
+	"golang.org/x/crypto/ssh"
+)
+
+// mismatchedSigner announces one key but signs with another, simulating a
+// server which cannot prove possession of an announced key.
+type mismatchedSigner struct {
+	ssh.Signer
+	announced ssh.PublicKey
+}
+
+func (s mismatchedSigner) PublicKey() ssh.PublicKey {
+	return s.announced
+}
+
+func TestHandleHostKeyUpdates(t *testing.T) {
+	edSigner, ecSigner := knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519), knownhoststest.GenerateSigner(t, ssh.KeyAlgoECDSA256)
+	oldKey, fakeKey := knownhoststest.GenerateKey(t, ssh.KeyAlgoECDSA256), knownhoststest.GenerateKey(t, ssh.KeyAlgoED25519)
+
+	// The server only proves possession of ecSigner's key; fakeKey is "proven"
+	// using a different private key
+	server := knownhoststest.StartServer(t, edSigner)
+	server.AnnounceHostKeys(edSigner, ecSigner, mismatchedSigner{knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519), fakeKey})
+	addr := server.Addr
+
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	if err := knownhosts.AppendKnownHosts(khPath, addr, noAddr, []ssh.PublicKey{edSigner.PublicKey(), oldKey}); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHosts: %v", err)
+	}
+	kh, err := knownhosts.New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+
+	netConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Unable to dial %s: %v", addr, err)
+	}
+	defer netConn.Close()
+	config := &ssh.ClientConfig{
+		User:              "test",
+		HostKeyCallback:   kh.HostKeyCallback(),
+		HostKeyAlgorithms: []string{ssh.KeyAlgoED25519},
+	}
+	c, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewClientConn: %v", err)
+	}
+	updates := make(chan knownhosts.HostKeyUpdate, 1)
+	reqs = knownhosts.HandleHostKeyUpdates(c, reqs, addr, netConn.RemoteAddr(), khPath, func(update knownhosts.HostKeyUpdate) {
+		updates <- update
+	})
+	client := ssh.NewClient(c, chans, reqs)
+	defer client.Close()
+
+	var update knownhosts.HostKeyUpdate
+	select {
+	case update = <-updates:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for host key update")
+	}
+	if update.Err == nil {
+		t.Error("Expected an error for the unproven key, but found none")
+	}
+	if len(update.Added) != 1 || !bytes.Equal(update.Added[0].Marshal(), ecSigner.PublicKey().Marshal()) {
+		t.Errorf("Unexpected added keys: %v", update.Added)
+	}
+	if len(update.Removed) != 1 || !bytes.Equal(update.Removed[0].Marshal(), oldKey.Marshal()) {
+		t.Errorf("Unexpected removed keys: %v", update.Removed)
+	}
+
+	// Only the proven key should have been appended
+	contents, err := os.ReadFile(khPath)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %v", khPath, err)
+	}
+	if !bytes.Contains(contents, []byte(base64.StdEncoding.EncodeToString(ecSigner.PublicKey().Marshal()))) {
+		t.Error("Proven key was not appended")
+	}
+	if bytes.Contains(contents, []byte(base64.StdEncoding.EncodeToString(fakeKey.Marshal()))) {
+		t.Error("Unproven key was unexpectedly appended")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package auth
+
+import "io"
+
+func platformAgentSources() []agentSource {
+	return []agentSource{authSockSource()}
+}
+
+func isNamedPipe(path string) bool {
+	return false
+}
+
+func openPipe(path string) (io.ReadWriteCloser, error) {
+	return nil, errNoAgent
+}
@@ -0,0 +1,42 @@
+//go:build windows
+// +build windows
+
+package auth
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// openSSHPipe is the named pipe of the Windows OpenSSH agent service.
+const openSSHPipe = `\\.\pipe\openssh-ssh-agent`
+
+func platformAgentSources() []agentSource {
+	return []agentSource{
+		{name: "Pageant", dial: dialPageant},
+		authSockSource(),
+		{
+			name: "OpenSSH agent service",
+			dial: func() (io.ReadWriteCloser, error) {
+				return openPipe(openSSHPipe)
+			},
+		},
+	}
+}
+
+func isNamedPipe(path string) bool {
+	return strings.HasPrefix(path, `\\.\pipe\`)
+}
+
+// openPipe opens the named pipe at path. An agent's pipe only exists while the
+// agent is running, so a missing pipe results in errNoAgent.
+func openPipe(path string) (io.ReadWriteCloser, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errNoAgent
+	}
+	return f, err
+}
@@ -0,0 +1,177 @@
+// Package auth selects SSH client authentication methods for the knownhosts
+// command-line tool, using whichever SSH agent is available on the current
+// platform, and optionally a private key file.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Options configures New.
+type Options struct {
+	// IdentityFile is the path to a private key file, as with ssh -i. If empty,
+	// only an agent is used.
+	IdentityFile string
+
+	// Passphrase is called to obtain the passphrase for an encrypted
+	// IdentityFile. If nil, encrypted identity files cannot be used.
+	Passphrase func(prompt string) ([]byte, error)
+
+	// NoAgent disables use of an SSH agent.
+	NoAgent bool
+}
+
+// Auth holds the credentials selected by New. Its Close method must be called
+// once it is no longer needed.
+type Auth struct {
+	// Sources describes where each credential came from, for example
+	// "agent at SSH_AUTH_SOCK" or "identity file /home/me/.ssh/id_ed25519", in
+	// order of preference.
+	Sources []string
+
+	agent  agent.ExtendedAgent
+	conn   io.Closer
+	signer ssh.Signer
+}
+
+// agentSource describes a way of connecting to an SSH agent.
+type agentSource struct {
+	name string
+	dial func() (io.ReadWriteCloser, error) // returns errNoAgent if not running
+}
+
+// errNoAgent is returned by an agentSource's dial function if the agent is not
+// running or not configured.
+var errNoAgent = errors.New("agent not available")
+
+// agentSources returns the agent sources for the current platform, in order of
+// preference. Tests may replace it.
+var agentSources = platformAgentSources
+
+// New selects credentials according to opts. The first available SSH agent is
+// used, followed by the identity file if one was supplied. It is not an error
+// for no credentials to be available, since some servers permit clients to
+// connect without authenticating; in this case Methods returns nil. An error is
+// returned if the identity file cannot be used, or if an agent appears to be
+// configured but cannot be reached.
+func New(opts Options) (*Auth, error) {
+	a := &Auth{}
+	if !opts.NoAgent {
+		var agentErrs []string
+		for _, src := range agentSources() {
+			conn, err := src.dial()
+			if errors.Is(err, errNoAgent) {
+				continue
+			} else if err != nil {
+				agentErrs = append(agentErrs, fmt.Sprintf("%s: %v", src.name, err))
+				continue
+			}
+			a.agent, a.conn = agent.NewClient(conn), conn
+			a.Sources = append(a.Sources, src.name)
+			break
+		}
+		if a.agent == nil && len(agentErrs) > 0 && opts.IdentityFile == "" {
+			return nil, fmt.Errorf("unable to connect to SSH agent: %s", strings.Join(agentErrs, "; "))
+		}
+	}
+	if opts.IdentityFile != "" {
+		signer, err := loadIdentity(opts.IdentityFile, opts.Passphrase)
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+		a.signer = signer
+		a.Sources = append(a.Sources, "identity file "+opts.IdentityFile)
+	}
+	return a, nil
+}
+
+// Methods returns the authentication methods to supply in an ssh.ClientConfig,
+// or nil if no credentials are available. Keys from the agent and identity file
+// are combined into a single public key method, since golang.org/x/crypto/ssh
+// only attempts each method type once.
+func (a *Auth) Methods() []ssh.AuthMethod {
+	if a.agent == nil && a.signer == nil {
+		return nil
+	}
+	return []ssh.AuthMethod{ssh.PublicKeysCallback(a.signers)}
+}
+
+// signers returns the agent's signers followed by the identity file's signer.
+// If the agent cannot be queried, the identity file's signer is still
+// returned.
+func (a *Auth) signers() ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	var err error
+	if a.agent != nil {
+		signers, err = a.agent.Signers()
+	}
+	if a.signer != nil {
+		return append(signers, a.signer), nil
+	}
+	return signers, err
+}
+
+// Close closes the agent connection, if any.
+func (a *Auth) Close() error {
+	if a.conn == nil {
+		return nil
+	}
+	err := a.conn.Close()
+	a.agent, a.conn = nil, nil
+	return err
+}
+
+// loadIdentity parses the private key file at path, calling passphrase if the
+// key is encrypted.
+func loadIdentity(path string, passphrase func(prompt string) ([]byte, error)) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missingErr *ssh.PassphraseMissingError
+	if !errors.As(err, &missingErr) {
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse private key %s: %w", path, err)
+		}
+		return signer, nil
+	}
+	if passphrase == nil {
+		return nil, fmt.Errorf("private key %s is encrypted, and no passphrase is available", path)
+	}
+	pass, err := passphrase("Enter passphrase for key " + path + ": ")
+	if err != nil {
+		return nil, err
+	}
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(data, pass)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt private key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// authSockSource returns an agentSource for the agent at SSH_AUTH_SOCK, which
+// is a Unix socket path, or on Windows may also be a named pipe path.
+func authSockSource() agentSource {
+	return agentSource{
+		name: "agent at SSH_AUTH_SOCK",
+		dial: func() (io.ReadWriteCloser, error) {
+			sock := os.Getenv("SSH_AUTH_SOCK")
+			if sock == "" {
+				return nil, errNoAgent
+			} else if isNamedPipe(sock) {
+				return openPipe(sock)
+			}
+			return net.Dial("unix", sock)
+		},
+	}
+}
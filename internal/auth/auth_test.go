@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// fakeAgentSource returns an agentSource serving an in-memory agent holding a
+// newly generated key, along with that key.
+func fakeAgentSource(t *testing.T, name string) (agentSource, ssh.PublicKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %v", err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatalf("Unexpected error adding key to keyring: %v", err)
+	}
+	pub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		t.Fatalf("Unexpected error from NewPublicKey: %v", err)
+	}
+	src := agentSource{
+		name: name,
+		dial: func() (io.ReadWriteCloser, error) {
+			client, server := net.Pipe()
+			go agent.ServeAgent(keyring, server)
+			return client, nil
+		},
+	}
+	return src, pub
+}
+
+// failingAgentSource returns an agentSource whose dial function returns err.
+func failingAgentSource(name string, err error) agentSource {
+	return agentSource{
+		name: name,
+		dial: func() (io.ReadWriteCloser, error) {
+			return nil, err
+		},
+	}
+}
+
+// setAgentSources replaces agentSources for the duration of the test.
+func setAgentSources(t *testing.T, sources ...agentSource) {
+	orig := agentSources
+	agentSources = func() []agentSource { return sources }
+	t.Cleanup(func() { agentSources = orig })
+}
+
+// writeKeyFile writes a PEM-encoded private key file, returning its path.
+func writeKeyFile(t *testing.T, block *pem.Block) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "id_test")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", path, err)
+	}
+	return path
+}
+
+// signers returns the signers offered by a's auth methods.
+func signers(t *testing.T, a *Auth) []ssh.Signer {
+	t.Helper()
+	if methods := a.Methods(); len(methods) != 1 {
+		t.Fatalf("Expected 1 auth method, instead found %d", len(methods))
+	}
+	signers, err := a.signers()
+	if err != nil {
+		t.Fatalf("Unexpected error from signers: %v", err)
+	}
+	return signers
+}
+
+func TestNewAgent(t *testing.T) {
+	first, firstKey := fakeAgentSource(t, "first")
+	second, _ := fakeAgentSource(t, "second")
+	setAgentSources(t, failingAgentSource("missing", errNoAgent), first, second)
+	a, err := New(Options{})
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	defer a.Close()
+	if len(a.Sources) != 1 || a.Sources[0] != "first" {
+		t.Errorf("Expected first available agent to be used, instead found sources %v", a.Sources)
+	}
+	if s := signers(t, a); len(s) != 1 || string(s[0].PublicKey().Marshal()) != string(firstKey.Marshal()) {
+		t.Errorf("Unexpected signers from agent: %v", s)
+	}
+	if err := a.Close(); err != nil {
+		t.Errorf("Unexpected error from Close: %v", err)
+	}
+	if a.Methods() != nil {
+		t.Error("Expected Methods to return nil after Close")
+	}
+
+	// Unreachable agents are skipped, but reported if nothing else is available
+	setAgentSources(t, failingAgentSource("broken", errors.New("connection refused")), second)
+	if a, err := New(Options{}); err != nil || len(a.Sources) != 1 || a.Sources[0] != "second" {
+		t.Errorf("Unexpected result from New with broken first agent: %v, %v", a, err)
+	}
+	setAgentSources(t, failingAgentSource("broken", errors.New("connection refused")))
+	if _, err := New(Options{}); err == nil {
+		t.Error("Expected error from New with only a broken agent, but error was nil")
+	}
+
+	// No agent at all is not an error
+	setAgentSources(t, failingAgentSource("missing", errNoAgent))
+	if a, err := New(Options{}); err != nil || a.Methods() != nil || len(a.Sources) != 0 {
+		t.Errorf("Unexpected result from New without agent: %v, %v", a, err)
+	}
+	setAgentSources(t, first)
+	if a, err := New(Options{NoAgent: true}); err != nil || a.Methods() != nil {
+		t.Errorf("Unexpected result from New with NoAgent: %v, %v", a, err)
+	}
+}
+
+func TestNewIdentityFile(t *testing.T) {
+	agentSrc, agentKey := fakeAgentSource(t, "agent")
+	setAgentSources(t, failingAgentSource("broken", errors.New("connection refused")))
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("Unexpected error from MarshalPKCS8PrivateKey: %v", err)
+	}
+	path := writeKeyFile(t, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	// A broken agent is not an error when an identity file is available
+	a, err := New(Options{IdentityFile: path})
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	if s := signers(t, a); len(s) != 1 || len(a.Sources) != 1 || a.Sources[0] != "identity file "+path {
+		t.Errorf("Unexpected signers %v or sources %v", s, a.Sources)
+	}
+
+	// Agent keys are offered before the identity file
+	setAgentSources(t, agentSrc)
+	a, err = New(Options{IdentityFile: path})
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	defer a.Close()
+	if s := signers(t, a); len(s) != 2 || string(s[0].PublicKey().Marshal()) != string(agentKey.Marshal()) || len(a.Sources) != 2 {
+		t.Errorf("Unexpected signers %v or sources %v", s, a.Sources)
+	}
+
+	for _, bad := range []string{filepath.Join(t.TempDir(), "nonexistent"), writeKeyFile(t, &pem.Block{Type: "PRIVATE KEY", Bytes: []byte("bogus")})} {
+		if _, err := New(Options{IdentityFile: bad}); err == nil {
+			t.Errorf("Expected error from New with identity file %s, but error was nil", bad)
+		}
+	}
+}
+
+func TestNewEncryptedIdentityFile(t *testing.T) {
+	setAgentSources(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %v", err)
+	}
+	// Legacy PEM encryption is deprecated, but golang.org/x/crypto/ssh can still
+	// parse it, and cannot generate encrypted keys in OpenSSH's own format
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey), []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatalf("Unexpected error from EncryptPEMBlock: %v", err)
+	}
+	path := writeKeyFile(t, block)
+
+	var prompts []string
+	passphrase := func(pass string) func(string) ([]byte, error) {
+		return func(prompt string) ([]byte, error) {
+			prompts = append(prompts, prompt)
+			return []byte(pass), nil
+		}
+	}
+	a, err := New(Options{IdentityFile: path, Passphrase: passphrase("secret")})
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	if s := signers(t, a); len(s) != 1 || s[0].PublicKey().Type() != ssh.KeyAlgoRSA {
+		t.Errorf("Unexpected signers: %v", s)
+	}
+	if len(prompts) != 1 || prompts[0] != "Enter passphrase for key "+path+": " {
+		t.Errorf("Unexpected passphrase prompts: %q", prompts)
+	}
+
+	if _, err := New(Options{IdentityFile: path, Passphrase: passphrase("wrong")}); err == nil {
+		t.Error("Expected error from New with wrong passphrase, but error was nil")
+	}
+	if _, err := New(Options{IdentityFile: path}); err == nil {
+		t.Error("Expected error from New without passphrase function, but error was nil")
+	}
+	cancelled := errors.New("cancelled")
+	if _, err := New(Options{IdentityFile: path, Passphrase: func(string) ([]byte, error) { return nil, cancelled }}); !errors.Is(err, cancelled) {
+		t.Errorf("Expected passphrase function error to be returned, instead found %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+//go:build windows
+// +build windows
+
+package auth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Pageant predates Windows named pipe support in SSH agents, and is queried by
+// copying each request into a shared memory mapping, and then sending the
+// mapping's name to Pageant's window in a WM_COPYDATA message. Pageant writes
+// its response into the same mapping.
+const (
+	pageantCopyDataID = 0x804e50ba // identifies agent requests to Pageant
+	pageantMaxMsgLen  = 8192
+	wmCopyData        = 0x004a
+)
+
+var (
+	user32           = windows.NewLazySystemDLL("user32.dll")
+	procFindWindowW  = user32.NewProc("FindWindowW")
+	procSendMessageW = user32.NewProc("SendMessageW")
+)
+
+// copyDataStruct is the COPYDATASTRUCT of a WM_COPYDATA message.
+type copyDataStruct struct {
+	dwData uintptr
+	cbData uint32
+	lpData uintptr
+}
+
+// pageantWindow returns the handle of Pageant's window, or 0 if Pageant is not
+// running.
+func pageantWindow() uintptr {
+	name, _ := windows.UTF16PtrFromString("Pageant")
+	hwnd, _, _ := procFindWindowW.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(name)))
+	return hwnd
+}
+
+// dialPageant returns a connection to Pageant, or errNoAgent if Pageant is not
+// running.
+func dialPageant() (io.ReadWriteCloser, error) {
+	if pageantWindow() == 0 {
+		return nil, errNoAgent
+	}
+	return &pageantConn{}, nil
+}
+
+// pageantConn adapts Pageant's message-based interface to the stream expected
+// by agent.NewClient. Each complete request written is sent to Pageant, and its
+// response is buffered for reading.
+type pageantConn struct {
+	req  bytes.Buffer
+	resp bytes.Buffer
+}
+
+func (c *pageantConn) Write(p []byte) (int, error) {
+	c.req.Write(p)
+	for c.req.Len() >= 4 {
+		msgLen := 4 + int(binary.BigEndian.Uint32(c.req.Bytes()))
+		if msgLen > pageantMaxMsgLen {
+			c.req.Reset()
+			return 0, errors.New("pageant: request too large")
+		} else if c.req.Len() < msgLen {
+			break
+		}
+		resp, err := pageantQuery(c.req.Next(msgLen))
+		if err != nil {
+			return 0, err
+		}
+		c.resp.Write(resp)
+	}
+	return len(p), nil
+}
+
+func (c *pageantConn) Read(p []byte) (int, error) {
+	return c.resp.Read(p)
+}
+
+func (c *pageantConn) Close() error {
+	return nil
+}
+
+// pageantQuery sends the agent request msg, including its length prefix, to
+// Pageant and returns the response, including its length prefix.
+func pageantQuery(msg []byte) ([]byte, error) {
+	hwnd := pageantWindow()
+	if hwnd == 0 {
+		return nil, errors.New("pageant: not running")
+	}
+	mapName := fmt.Sprintf("PageantRequest%08x", windows.GetCurrentThreadId())
+	mapNameUTF16, err := windows.UTF16PtrFromString(mapName)
+	if err != nil {
+		return nil, err
+	}
+	fileMap, err := windows.CreateFileMapping(windows.InvalidHandle, nil, windows.PAGE_READWRITE, 0, pageantMaxMsgLen, mapNameUTF16)
+	if err != nil {
+		return nil, fmt.Errorf("pageant: CreateFileMapping: %w", err)
+	}
+	defer windows.CloseHandle(fileMap)
+	addr, err := windows.MapViewOfFile(fileMap, windows.FILE_MAP_WRITE, 0, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("pageant: MapViewOfFile: %w", err)
+	}
+	defer windows.UnmapViewOfFile(addr)
+	shared := (*[pageantMaxMsgLen]byte)(*(*unsafe.Pointer)(unsafe.Pointer(&addr)))
+	copy(shared[:], msg)
+
+	mapNameBytes := append([]byte(mapName), 0) // Pageant expects an ANSI string
+	cds := copyDataStruct{
+		dwData: pageantCopyDataID,
+		cbData: uint32(len(mapNameBytes)),
+		lpData: uintptr(unsafe.Pointer(&mapNameBytes[0])),
+	}
+	if ret, _, _ := procSendMessageW.Call(hwnd, wmCopyData, 0, uintptr(unsafe.Pointer(&cds))); ret == 0 {
+		return nil, errors.New("pageant: request failed")
+	}
+	respLen := 4 + int(binary.BigEndian.Uint32(shared[:4]))
+	if respLen > pageantMaxMsgLen {
+		return nil, errors.New("pageant: response too large")
+	}
+	return append([]byte(nil), shared[:respLen]...), nil
+}
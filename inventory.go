@@ -0,0 +1,219 @@
+package knownhosts
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// InventoryStatus classifies how well known_hosts entries cover a host.
+type InventoryStatus int
+
+// Constants representing possible values of HostInventory.Status.
+const (
+	InventoryUnknown   InventoryStatus = iota // no entries for the host
+	InventoryKnown                            // at least one key of a modern type, or a matching CA
+	InventoryWeakOnly                         // only keys of types reported by IsWeakKeyType
+	InventoryOtherPort                        // no entries for the host's port, but entries for another port
+)
+
+// String returns an uppercase name for the status, such as "KNOWN".
+func (s InventoryStatus) String() string {
+	switch s {
+	case InventoryUnknown:
+		return "UNKNOWN"
+	case InventoryKnown:
+		return "KNOWN"
+	case InventoryWeakOnly:
+		return "WEAK-ONLY"
+	case InventoryOtherPort:
+		return "OTHER-PORT"
+	}
+	return "INVALID"
+}
+
+// HostInventory describes the known_hosts entries for a single host, as
+// determined by CheckInventory.
+type HostInventory struct {
+	Host   string // normalized using Normalize
+	Status InventoryStatus
+
+	Keys          []ssh.PublicKey // keys of matching entries without a marker
+	CertAuthority bool            // whether a matching @cert-authority entry exists
+
+	// HashedOnly is true if every matching entry has hashed patterns. Such
+	// hosts are known, but cannot be attributed to any particular line without
+	// knowing which hostname was hashed, and any entries for the host on other
+	// ports cannot be found.
+	HashedOnly bool
+
+	// OtherPorts lists addresses of the same host on different ports which have
+	// plaintext entries, as returned by OtherPorts. It is only populated if
+	// Status is InventoryOtherPort.
+	OtherPorts []string
+}
+
+// InventoryReport summarizes the result of CheckInventory.
+type InventoryReport struct {
+	Hosts []HostInventory // in the same order as the hosts passed to CheckInventory
+}
+
+// WithStatus returns the inventories of hosts with the supplied status.
+func (ir InventoryReport) WithStatus(status InventoryStatus) []HostInventory {
+	var hosts []HostInventory
+	for _, hi := range ir.Hosts {
+		if hi.Status == status {
+			hosts = append(hosts, hi)
+		}
+	}
+	return hosts
+}
+
+// HashedOnly returns the inventories of hosts whose matching entries are all
+// hashed.
+func (ir InventoryReport) HashedOnly() []HostInventory {
+	var hosts []HostInventory
+	for _, hi := range ir.Hosts {
+		if hi.HashedOnly {
+			hosts = append(hosts, hi)
+		}
+	}
+	return hosts
+}
+
+// String returns a human-readable summary of the report: a line of counts per
+// status, followed by one line per host which is not InventoryKnown, and one
+// line per host whose matching entries are all hashed.
+func (ir InventoryReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d hosts: %d known, %d weak-only, %d other-port, %d unknown\n",
+		len(ir.Hosts),
+		len(ir.WithStatus(InventoryKnown)),
+		len(ir.WithStatus(InventoryWeakOnly)),
+		len(ir.WithStatus(InventoryOtherPort)),
+		len(ir.WithStatus(InventoryUnknown)),
+	)
+	for _, hi := range ir.Hosts {
+		switch hi.Status {
+		case InventoryWeakOnly:
+			types := make([]string, len(hi.Keys))
+			for n, key := range hi.Keys {
+				types[n] = key.Type()
+			}
+			fmt.Fprintf(&b, "%s: %s %s\n", hi.Host, hi.Status, strings.Join(types, ","))
+		case InventoryOtherPort:
+			fmt.Fprintf(&b, "%s: %s %s\n", hi.Host, hi.Status, strings.Join(hi.OtherPorts, ","))
+		case InventoryUnknown:
+			fmt.Fprintf(&b, "%s: %s\n", hi.Host, hi.Status)
+		}
+	}
+	for _, hi := range ir.HashedOnly() {
+		fmt.Fprintf(&b, "%s: %s (hashed entries only)\n", hi.Host, hi.Status)
+	}
+	return b.String()
+}
+
+// CheckInventory classifies each of hosts according to the known_hosts
+// entries which match it, as per MatchesLine, without any network access. This
+// is intended for determining which hosts would be treated as unknown before
+// connecting to many hosts, so that their keys may be obtained in advance.
+// Hosts lacking a port are treated as being on port 22.
+//
+// Entries with the @revoked marker are ignored. A host with a matching
+// @cert-authority entry is considered known, since certificates signed by the
+// CA would be accepted. Otherwise, a host is known if it has a key of a type
+// for which IsWeakKeyType returns false; if it only has keys of weak types, it
+// is reported as InventoryWeakOnly.
+func CheckInventory(entries []Entry, hosts []string) InventoryReport {
+	report := InventoryReport{Hosts: make([]HostInventory, len(hosts))}
+	for n, host := range hosts {
+		hi := &report.Hosts[n]
+		hi.Host = Normalize(host)
+		var matched, plaintext, modern bool
+		for _, e := range entries {
+			if e.Marker == MarkerRevoked || !MatchesLine(e.Patterns, hi.Host) {
+				continue
+			}
+			matched = true
+			if !e.Hashed() {
+				plaintext = true
+			}
+			if e.Marker == MarkerCertAuthority {
+				hi.CertAuthority = true
+			} else if e.Marker == MarkerNone && e.Key != nil {
+				hi.Keys = append(hi.Keys, e.Key)
+				if !IsWeakKeyType(e.Key.Type()) {
+					modern = true
+				}
+			}
+		}
+		hi.HashedOnly = matched && !plaintext
+		switch {
+		case hi.CertAuthority || modern:
+			hi.Status = InventoryKnown
+		case len(hi.Keys) > 0:
+			hi.Status = InventoryWeakOnly
+		default:
+			if hi.OtherPorts = OtherPorts(entries, hi.Host); len(hi.OtherPorts) > 0 {
+				hi.Status = InventoryOtherPort
+			}
+		}
+	}
+	return report
+}
+
+// IsWeakKeyType returns true if keyType, such as the result of
+// ssh.PublicKey.Type, is "ssh-dss" or "ssh-rsa", or a certificate type based
+// on one of these. DSA keys are unsupported by modern versions of OpenSSH.
+// RSA keys remain usable with the rsa-sha2-256 and rsa-sha2-512 signature
+// algorithms, but are commonly treated as legacy, as they are much larger and
+// slower than ECDSA or Ed25519 keys of comparable strength.
+func IsWeakKeyType(keyType string) bool {
+	switch keyType {
+	case ssh.KeyAlgoDSA, ssh.KeyAlgoRSA, ssh.CertAlgoDSAv01, ssh.CertAlgoRSAv01:
+		return true
+	}
+	return false
+}
+
+// OtherPorts returns the distinct addresses, normalized using Normalize, of
+// plaintext host patterns in entries which refer to the same host as
+// hostWithPort, but on a different port. Hosts lacking a port are treated as
+// being on port 22. Hashed, wildcard, and negated patterns are not considered,
+// nor are entries with the @revoked marker.
+//
+// This is useful for diagnosing a host which is unknown because it is being
+// accessed on a different port than the one it was recorded under.
+func OtherPorts(entries []Entry, hostWithPort string) []string {
+	host, port := splitNormalized(Normalize(hostWithPort))
+	var others []string
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if e.Marker == MarkerRevoked {
+			continue
+		}
+		for _, pattern := range e.Patterns {
+			if strings.HasPrefix(pattern, hashMagic) || strings.ContainsAny(pattern, "*?!") {
+				continue
+			}
+			address := Normalize(pattern)
+			if patternHost, patternPort := splitNormalized(address); patternHost == host && patternPort != port && !seen[address] {
+				seen[address] = true
+				others = append(others, address)
+			}
+		}
+	}
+	return others
+}
+
+// splitNormalized splits an address returned by Normalize into its host and
+// port.
+func splitNormalized(address string) (host, port string) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address, "22"
+	}
+	return host, port
+}
@@ -0,0 +1,93 @@
+package knownhosts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckInventory(t *testing.T) {
+	edKey, rsaKey, caKey := generatePubKeyEd25519(t), generatePubKeyRSA(t), generatePubKeyEd25519(t)
+	hashed, err := HashHostname("hashed.example.test")
+	if err != nil {
+		t.Fatalf("Unexpected error from HashHostname: %v", err)
+	}
+	entries := []Entry{
+		{Patterns: []string{"known.example.test", "10.0.0.1"}, Key: edKey},
+		{Patterns: []string{"known.example.test"}, Key: rsaKey},
+		{Patterns: []string{"legacy.example.test"}, Key: rsaKey},
+		{Patterns: []string{"[moved.example.test]:2222", "[moved.example.test]:2200"}, Key: edKey},
+		{Patterns: []string{hashed}, Key: edKey},
+		{Marker: MarkerCertAuthority, Patterns: []string{"*.ca.example.test"}, Key: caKey},
+		{Marker: MarkerRevoked, Patterns: []string{"revoked.example.test"}, Key: edKey},
+	}
+	hosts := []string{
+		"known.example.test",
+		"KNOWN.example.test:22",
+		"10.0.0.1:2222",
+		"legacy.example.test",
+		"moved.example.test",
+		"[moved.example.test]:2200",
+		"hashed.example.test",
+		"web.ca.example.test",
+		"revoked.example.test",
+		"unknown.example.test",
+	}
+	expected := []struct {
+		host   string
+		status InventoryStatus
+		keys   int
+	}{
+		{"known.example.test", InventoryKnown, 2},
+		{"known.example.test", InventoryKnown, 2},
+		{"[10.0.0.1]:2222", InventoryOtherPort, 0},
+		{"legacy.example.test", InventoryWeakOnly, 1},
+		{"moved.example.test", InventoryOtherPort, 0},
+		{"[moved.example.test]:2200", InventoryKnown, 1},
+		{"hashed.example.test", InventoryKnown, 1},
+		{"web.ca.example.test", InventoryKnown, 0},
+		{"revoked.example.test", InventoryUnknown, 0},
+		{"unknown.example.test", InventoryUnknown, 0},
+	}
+	report := CheckInventory(entries, hosts)
+	if len(report.Hosts) != len(expected) {
+		t.Fatalf("Expected %d hosts in report, instead found %d", len(expected), len(report.Hosts))
+	}
+	for n, hi := range report.Hosts {
+		if hi.Host != expected[n].host || hi.Status != expected[n].status || len(hi.Keys) != expected[n].keys {
+			t.Errorf("Unexpected inventory for %s: %+v", hosts[n], hi)
+		}
+	}
+	if others := report.Hosts[4].OtherPorts; strings.Join(others, ",") != "[moved.example.test]:2222,[moved.example.test]:2200" {
+		t.Errorf("Unexpected OtherPorts for moved.example.test: %v", others)
+	}
+	if !report.Hosts[7].CertAuthority {
+		t.Error("Expected CertAuthority to be true for web.ca.example.test")
+	}
+	if hashedOnly := report.HashedOnly(); len(hashedOnly) != 1 || hashedOnly[0].Host != "hashed.example.test" {
+		t.Errorf("Unexpected result from HashedOnly: %+v", hashedOnly)
+	}
+
+	expectSummary := "10 hosts: 5 known, 1 weak-only, 2 other-port, 2 unknown\n" +
+		"[10.0.0.1]:2222: OTHER-PORT 10.0.0.1\n" +
+		"legacy.example.test: WEAK-ONLY ssh-rsa\n" +
+		"moved.example.test: OTHER-PORT [moved.example.test]:2222,[moved.example.test]:2200\n" +
+		"revoked.example.test: UNKNOWN\n" +
+		"unknown.example.test: UNKNOWN\n" +
+		"hashed.example.test: KNOWN (hashed entries only)\n"
+	if summary := report.String(); summary != expectSummary {
+		t.Errorf("Unexpected summary\nexpected:\n%s\nfound:\n%s", expectSummary, summary)
+	}
+}
+
+func TestIsWeakKeyType(t *testing.T) {
+	for _, keyType := range []string{"ssh-dss", "ssh-rsa", "ssh-rsa-cert-v01@openssh.com"} {
+		if !IsWeakKeyType(keyType) {
+			t.Errorf("Expected IsWeakKeyType(%q) to return true", keyType)
+		}
+	}
+	for _, keyType := range []string{"ssh-ed25519", "ecdsa-sha2-nistp256", "sk-ssh-ed25519@openssh.com", "rsa-sha2-512"} {
+		if IsWeakKeyType(keyType) {
+			t.Errorf("Expected IsWeakKeyType(%q) to return false", keyType)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package knownhosts
+
+import (
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyDB is implemented by types which can verify host keys and report the
+// known keys and algorithms of a host, such as HostKeyCallback. Code which only
+// needs these capabilities, such as ClientConfigFor, PolicyCallback, and Dial,
+// accepts a KeyDB so that callers may supply any implementation, for example a
+// wrapper adding logging or an alternative source of keys.
+type KeyDB interface {
+	// HostKeyCallback returns a callback for use in ssh.ClientConfig.
+	HostKeyCallback() ssh.HostKeyCallback
+
+	// HostKeys returns the known keys of hostWithPort, or nil if the host is
+	// not known.
+	HostKeys(hostWithPort string) []ssh.PublicKey
+
+	// HostKeyAlgorithms returns the host key algorithms to use when
+	// connecting to hostWithPort, or nil if the host is not known.
+	HostKeyAlgorithms(hostWithPort string) []string
+}
+
+var _ KeyDB = HostKeyCallback(nil)
+
+// ClientConfigFor behaves like HostKeyCallback.ClientConfig, for any KeyDB.
+func ClientConfigFor(db KeyDB, hostWithPort string, base *ssh.ClientConfig) *ssh.ClientConfig {
+	var config ssh.ClientConfig
+	if base != nil {
+		config = *base
+	}
+	config.HostKeyCallback = db.HostKeyCallback()
+	config.HostKeyAlgorithms = db.HostKeyAlgorithms(hostWithPort)
+	return &config
+}
+
+// PolicyCallback behaves like HostKeyCallback.WithPolicy, for any KeyDB. The
+// returned callback's methods, such as HostKeys, reflect keys accepted by the
+// policy in addition to those known by db's callback.
+func PolicyCallback(db KeyDB, p Policy) HostKeyCallback {
+	return HostKeyCallback(db.HostKeyCallback()).WithPolicy(p)
+}
+
+// WithDefaultPort returns a KeyDB which behaves like hkcb, except that its
+// HostKeys and HostKeyAlgorithms methods treat a host which lacks a port as
+// being on port, rather than on port 22. For example, with
+// WithDefaultPort("2222"), HostKeyAlgorithms("host") returns the algorithms of
+// the entries for "[host]:2222", and HostKeyAlgorithms("host:22") must be used
+// for the entries for port 22. This is useful when every host is accessed on
+// the same nonstandard port, for example with ClientConfigFor.
+//
+// Entries in known_hosts files are still interpreted in the standard manner,
+// in which a host pattern without a port refers to port 22. The callback
+// returned by the KeyDB's HostKeyCallback method is hkcb itself, since
+// golang.org/x/crypto/ssh always supplies a host:port to callbacks. Use the
+// WithDefaultPort write option to apply the same default when writing entries.
+func (hkcb HostKeyCallback) WithDefaultPort(port string) KeyDB {
+	return defaultPortDB{hkcb: hkcb, port: port}
+}
+
+// defaultPortDB is the KeyDB returned by HostKeyCallback.WithDefaultPort.
+type defaultPortDB struct {
+	hkcb HostKeyCallback
+	port string
+}
+
+func (db defaultPortDB) HostKeyCallback() ssh.HostKeyCallback {
+	return db.hkcb.HostKeyCallback()
+}
+
+func (db defaultPortDB) HostKeys(address string) []ssh.PublicKey {
+	return db.hkcb.HostKeys(withDefaultPort(address, db.port))
+}
+
+func (db defaultPortDB) HostKeyAlgorithms(address string) []string {
+	return db.hkcb.HostKeyAlgorithms(withDefaultPort(address, db.port))
+}
+
+// isNilKeyDB returns true if db is nil, or is a nil HostKeyCallback.
+func isNilKeyDB(db KeyDB) bool {
+	hkcb, ok := db.(HostKeyCallback)
+	return db == nil || (ok && hkcb == nil)
+}
@@ -0,0 +1,168 @@
+package knownhosts_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/skeema/knownhosts"
+	"github.com/skeema/knownhosts/knownhoststest"
+	"golang.org/x/crypto/ssh"
+)
+
+// staticKeyDB is a minimal KeyDB which is not a HostKeyCallback, trusting a
+// single key for a single host, and counting the algorithm lookups made on it.
+type staticKeyDB struct {
+	host    string
+	key     ssh.PublicKey
+	lookups int
+}
+
+func (db *staticKeyDB) HostKeyCallback() ssh.HostKeyCallback {
+	return knownhosts.HostKeyCallback(db.callback).HostKeyCallback()
+}
+
+func (db *staticKeyDB) callback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	kh, err := knownhosts.NewFromEntries([]knownhosts.Entry{{Patterns: []string{db.host}, Key: db.key}})
+	if err != nil {
+		return err
+	}
+	return kh(hostname, remote, key)
+}
+
+func (db *staticKeyDB) HostKeys(hostWithPort string) []ssh.PublicKey {
+	if knownhosts.Normalize(hostWithPort) == db.host {
+		return []ssh.PublicKey{db.key}
+	}
+	return nil
+}
+
+func (db *staticKeyDB) HostKeyAlgorithms(hostWithPort string) []string {
+	db.lookups++
+	if knownhosts.Normalize(hostWithPort) == db.host {
+		return []string{db.key.Type()}
+	}
+	return nil
+}
+
+func TestKeyDB(t *testing.T) {
+	signer := knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519)
+	addr := knownhoststest.StartServer(t, signer).Addr
+	db := &staticKeyDB{host: knownhosts.Normalize(addr), key: signer.PublicKey()}
+
+	base := &ssh.ClientConfig{User: "someone"}
+	config := knownhosts.ClientConfigFor(db, addr, base)
+	if config.User != "someone" || !reflect.DeepEqual(config.HostKeyAlgorithms, []string{ssh.KeyAlgoED25519}) || base.HostKeyAlgorithms != nil {
+		t.Errorf("Unexpected result from ClientConfigFor: %+v", config)
+	}
+
+	// Keys accepted by PolicyCallback are visible through its HostKeys
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	newKey := knownhoststest.GenerateKey(t, ssh.KeyAlgoECDSA256)
+	cb := knownhosts.PolicyCallback(db, knownhosts.Policy{Mode: knownhosts.PolicyAcceptNew})
+	if err := cb("new.example.test:22", noAddr, newKey); err != nil {
+		t.Errorf("Expected accept-new policy to accept unknown host, instead found %v", err)
+	}
+	if keys := cb.HostKeys("new.example.test:22"); len(keys) != 1 || !knownhosts.KeysEqual(keys[0], newKey) {
+		t.Errorf("Expected HostKeys to include accepted key, instead found %v", keys)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	db.lookups = 0
+	client, err := knownhosts.Dial(ctx, "tcp", addr, &knownhosts.DialConfig{Callback: db})
+	if err != nil {
+		t.Fatalf("Unexpected error from Dial: %v", err)
+	}
+	client.Close()
+	if db.lookups != 1 {
+		t.Errorf("Expected Dial to look up algorithms from the KeyDB once, instead found %d", db.lookups)
+	}
+
+	// A nil HostKeyCallback in DialConfig is treated like a nil KeyDB
+	var nilCallback knownhosts.HostKeyCallback
+	if _, err := knownhosts.Dial(ctx, "tcp", addr, &knownhosts.DialConfig{Callback: nilCallback}); !knownhosts.IsHostUnknown(err) {
+		t.Errorf("Expected Dial with nil HostKeyCallback to reject unknown host, instead found %v", err)
+	}
+}
+
+func TestWithDefaultPort(t *testing.T) {
+	key22, key2222, key2200 := knownhoststest.GenerateKey(t, ssh.KeyAlgoED25519), knownhoststest.GenerateKey(t, ssh.KeyAlgoECDSA256), knownhoststest.GenerateKey(t, ssh.KeyAlgoRSA)
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	contents := knownhosts.Line([]string{"host.example.test"}, key22) + "\n" +
+		knownhosts.Line([]string{"[host.example.test]:2222"}, key2222) + "\n" +
+		knownhosts.Line([]string{"[host.example.test]:2200"}, key2200) + "\n"
+	if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	kh, err := knownhosts.New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	db := kh.WithDefaultPort("2222")
+	cases := map[string]ssh.PublicKey{
+		"host.example.test":         key2222,
+		"[host.example.test]":       key2222,
+		"host.example.test:2222":    key2222,
+		"host.example.test:22":      key22,
+		"[host.example.test]:22":    key22,
+		"[host.example.test]:2200":  key2200,
+		"[host.example.test]:2201":  nil,
+		"other.example.test":        nil,
+		"user@host.example.test":    key2222,
+		"user@host.example.test:22": key22,
+		"HOST.example.test.:2200":   key2200,
+		"host.example.test:ssh":     key22,
+	}
+	for address, want := range cases {
+		keys := db.HostKeys(address)
+		if want == nil && len(keys) > 0 {
+			t.Errorf("Expected no keys from HostKeys(%q), instead found %v", address, keys)
+		} else if want != nil && (len(keys) != 1 || !knownhosts.KeysEqual(keys[0], want)) {
+			t.Errorf("Unexpected result from HostKeys(%q): %v", address, keys)
+		}
+	}
+	if config := knownhosts.ClientConfigFor(db, "host.example.test", nil); len(config.HostKeyAlgorithms) != 1 || config.HostKeyAlgorithms[0] != key2222.Type() {
+		t.Errorf("Unexpected HostKeyAlgorithms from ClientConfigFor: %v", config.HostKeyAlgorithms)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	if err := db.HostKeyCallback()("host.example.test:2222", noAddr, key2222); err != nil {
+		t.Errorf("Unexpected error from callback: %v", err)
+	}
+	if err := db.HostKeyCallback()("host.example.test:22", noAddr, key2222); !knownhosts.IsHostKeyChanged(err) {
+		t.Errorf("Expected changed key error from callback for port 22, instead found %v", err)
+	}
+
+	// Writes use the same default, but always write entries in standard form
+	newKey, rotatedKey := knownhoststest.GenerateKey(t, ssh.KeyAlgoED25519), knownhoststest.GenerateKey(t, ssh.KeyAlgoECDSA256)
+	if err := knownhosts.AppendKnownHost(khPath, "new.example.test", nil, newKey, knownhosts.WithDefaultPort("2222")); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	if err := knownhosts.AppendKnownHost(khPath, "new.example.test:22", nil, newKey, knownhosts.WithDefaultPort("2222")); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	if err := knownhosts.ReplaceHostKey(khPath, "host.example.test", rotatedKey, knownhosts.WithDefaultPort("2222")); err != nil {
+		t.Fatalf("Unexpected error from ReplaceHostKey: %v", err)
+	}
+	expected := knownhosts.Line([]string{"host.example.test"}, key22) + "\n" +
+		knownhosts.Line([]string{"[host.example.test]:2222"}, rotatedKey) + "\n" +
+		knownhosts.Line([]string{"[host.example.test]:2200"}, key2200) + "\n" +
+		knownhosts.Line([]string{"[new.example.test]:2222"}, newKey) + "\n" +
+		knownhosts.Line([]string{"new.example.test"}, newKey) + "\n"
+	if actual, err := os.ReadFile(khPath); err != nil {
+		t.Fatalf("Unable to read %s: %v", khPath, err)
+	} else if string(actual) != expected {
+		t.Errorf("Unexpected contents\nexpected:\n%s\nfound:\n%s", expected, actual)
+	}
+	var buf bytes.Buffer
+	if err := knownhosts.WriteKnownHost(&buf, "[::1]", nil, newKey, knownhosts.WithDefaultPort("2222")); err != nil {
+		t.Fatalf("Unexpected error from WriteKnownHost: %v", err)
+	} else if buf.String() != knownhosts.Line([]string{"[::1]:2222"}, newKey)+"\n" {
+		t.Errorf("Unexpected output from WriteKnownHost: %q", buf.String())
+	}
+}
@@ -0,0 +1,64 @@
+package knownhosts
+
+import (
+	"bytes"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KeysEqual returns true if a and b are the same public key, by comparing
+// their marshaled wire format, which includes the key type. Two nil keys are
+// equal, but a nil key never equals a non-nil key.
+//
+// The signature algorithm negotiated for a key does not affect its identity:
+// an RSA host key is the same key regardless of whether the server uses it
+// with ssh-rsa, rsa-sha2-256, or rsa-sha2-512 signatures. However, a
+// certificate never equals a plain key, even the one it certifies; use
+// KeysEquivalent for that comparison.
+func KeysEqual(a, b ssh.PublicKey) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return bytes.Equal(a.Marshal(), b.Marshal())
+}
+
+// KeysEquivalent is like KeysEqual, except that a certificate is considered
+// equivalent to the key which it certifies. Two certificates for the same key
+// are also considered equivalent, even if their other fields differ.
+func KeysEquivalent(a, b ssh.PublicKey) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return KeysEqual(plainKey(a), plainKey(b))
+}
+
+// dedupeKeys returns keys without nil values or later duplicates according to
+// KeysEqual, preserving order.
+func dedupeKeys(keys []ssh.PublicKey) []ssh.PublicKey {
+	result := make([]ssh.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		if key != nil && !containsKey(result, key) {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// containsKey returns true if keys contains key according to KeysEqual.
+func containsKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, k := range keys {
+		if KeysEqual(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// plainKey returns the key certified by key if it is a certificate, or key
+// itself otherwise.
+func plainKey(key ssh.PublicKey) ssh.PublicKey {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		return cert.Key
+	}
+	return key
+}
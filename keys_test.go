@@ -0,0 +1,51 @@
+package knownhosts
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKeysEqual(t *testing.T) {
+	edKey, rsaKey := generatePubKeyEd25519(t), generatePubKeyRSA(t)
+	caSigner := generateSignerEd25519(t)
+	cert := generateHostCert(t, caSigner, edKey, "host.example.test")
+	otherCert := generateHostCert(t, caSigner, edKey, "other.example.test")
+
+	// A copy obtained by parsing, rather than the same value
+	parsedEdKey, err := ssh.ParsePublicKey(edKey.Marshal())
+	if err != nil {
+		t.Fatalf("Unexpected error from ParsePublicKey: %v", err)
+	}
+
+	cases := []struct {
+		a, b       ssh.PublicKey
+		equal      bool
+		equivalent bool
+	}{
+		{edKey, edKey, true, true},
+		{edKey, parsedEdKey, true, true},
+		{edKey, rsaKey, false, false},
+		{cert, edKey, false, true},
+		{edKey, cert, false, true},
+		{cert, otherCert, false, true},
+		{cert, rsaKey, false, false},
+		{cert, caSigner.PublicKey(), false, false},
+		{nil, nil, true, true},
+		{nil, edKey, false, false},
+		{cert, nil, false, false},
+	}
+	for n, tc := range cases {
+		if actual := KeysEqual(tc.a, tc.b); actual != tc.equal {
+			t.Errorf("Case %d: expected KeysEqual to return %t, instead found %t", n, tc.equal, actual)
+		}
+		if actual := KeysEquivalent(tc.a, tc.b); actual != tc.equivalent {
+			t.Errorf("Case %d: expected KeysEquivalent to return %t, instead found %t", n, tc.equivalent, actual)
+		}
+	}
+
+	deduped := dedupeKeys([]ssh.PublicKey{nil, edKey, rsaKey, parsedEdKey, cert, nil, rsaKey})
+	if len(deduped) != 3 || !KeysEqual(deduped[0], edKey) || !KeysEqual(deduped[1], rsaKey) || !KeysEqual(deduped[2], cert) {
+		t.Errorf("Unexpected result from dedupeKeys: %v", deduped)
+	}
+}
@@ -0,0 +1,159 @@
+package knownhosts
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// KeyscanErrors is returned by ImportKeyscanOutput when one or more lines
+// could not be imported. Each element has its Line field set to the 1-based
+// line number of the offending line.
+type KeyscanErrors []*ParseError
+
+// Error satisfies the error interface.
+func (ke KeyscanErrors) Error() string {
+	msgs := make([]string, len(ke))
+	for n, pe := range ke {
+		msgs[n] = fmt.Sprintf("line %d: column %d: invalid %s: %v", pe.Line, pe.Column, pe.Field, pe.Err)
+	}
+	return fmt.Sprintf("knownhosts: unable to import %d keyscan line(s): %s", len(ke), strings.Join(msgs, "; "))
+}
+
+// ImportKeyscanOutput parses the output of ssh-keyscan, with or without its -H
+// option, returning an entry for each key line. Each entry's Line field is set
+// to its 1-based line number in r. Blank lines and comment lines, such as the
+// "# host:22 SSH-2.0-..." lines which ssh-keyscan prints, are skipped. CRLF line
+// endings are permitted.
+//
+// Lines which are not valid known_hosts lines, such as error messages from
+// ssh-keyscan's stderr which were mixed into its output, do not prevent the
+// other lines from being imported. Instead, the entries which could be parsed
+// are returned along with a KeyscanErrors listing a *ParseError for each bad
+// line. Since ssh-keyscan never outputs markers, lines with a @cert-authority or
+// @revoked marker are also reported as errors. Any other error indicates a
+// failure to read from r.
+func ImportKeyscanOutput(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var failures KeyscanErrors
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		e, ok, err := parseEntry(scanner.Text())
+		if err == nil && e.Marker != MarkerNone {
+			err = &ParseError{Column: strings.Index(scanner.Text(), string(e.Marker)) + 1, Field: "marker", Err: errors.New("markers are not permitted in ssh-keyscan output")}
+		}
+		var pe *ParseError
+		if errors.As(err, &pe) {
+			pe.Line = lineNum
+			failures = append(failures, pe)
+		} else if err != nil {
+			return entries, err
+		} else if ok {
+			e.Line = lineNum
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("knownhosts: unable to read ssh-keyscan output: %w", err)
+	} else if len(failures) > 0 {
+		return entries, failures
+	}
+	return entries, nil
+}
+
+// AppendEntries appends entries, such as those returned by
+// ImportKeyscanOutput, to the known_hosts file at path, omitting any which are
+// already present. It returns the number of lines appended. File creation,
+// newline handling, locking, and error behavior are the same as in
+// AppendKnownHost.
+//
+// Plaintext patterns are normalized, and are omitted if the file already has a
+// line for the same key with a pattern matching the same host, whether
+// plaintext or hashed. The WithHashedHostnames and WithComment options apply to
+// the lines written for plaintext patterns. Hashed patterns are written as-is,
+// and are only omitted if the file already has a line for the same key with an
+// identical hashed pattern, since differently-salted hashes of the same host
+// cannot be detected.
+//
+// An error is returned, without modifying the file, if any entry has a marker,
+// a nil key, no patterns, or a wildcard or negated pattern. An
+// *InvalidHostnameError is returned, also without modifying the file, for any
+// plaintext pattern rejected by NormalizeStrict, or any malformed hashed
+// pattern.
+func AppendEntries(path string, entries []Entry, opts ...WriteOption) (added int, err error) {
+	for n, e := range entries {
+		if e.Marker != MarkerNone {
+			return 0, fmt.Errorf("knownhosts: entry %d has marker %s", n+1, e.Marker)
+		} else if err := validateKey(e.Key); err != nil {
+			return 0, fmt.Errorf("knownhosts: entry %d: %w", n+1, err)
+		} else if len(e.Patterns) == 0 {
+			return 0, fmt.Errorf("knownhosts: entry %d has no patterns", n+1)
+		}
+		for _, pattern := range e.Patterns {
+			if err := validateEntryPattern(pattern); err != nil {
+				return 0, err
+			} else if !strings.HasPrefix(pattern, hashMagic) && strings.ContainsAny(pattern, "*?!") {
+				return 0, fmt.Errorf("knownhosts: entry %d has non-literal pattern %q", n+1, pattern)
+			}
+		}
+	}
+	wo := newWriteOptions(opts)
+	if err := validateComment(wo.comment); err != nil {
+		return 0, err
+	}
+	err = appendToFile(path, wo, func(existing []byte) ([]byte, error) {
+		var buf bytes.Buffer
+		for _, e := range entries {
+			// Check against both the existing contents and lines generated so
+			// far, so that duplicate entries are only appended once
+			contents := append(append([]byte(nil), existing...), buf.Bytes()...)
+			var hashed, plain []string
+			for _, pattern := range e.Patterns {
+				if !strings.HasPrefix(pattern, hashMagic) {
+					plain = append(plain, pattern)
+				} else if !hasHashedPattern(contents, pattern, e.Key.Marshal()) {
+					hashed = append(hashed, pattern)
+				}
+			}
+			// Hashed patterns cannot be comma-separated, so each gets its own line
+			for _, pattern := range hashed {
+				buf.WriteString(Entry{Patterns: []string{pattern}, Key: e.Key}.Format() + "\n")
+				added++
+			}
+			if missing := missingAddresses(contents, plain, e.Key); len(missing) > 0 {
+				lines, err := knownHostLines(missing, e.Key, wo)
+				if err != nil {
+					return nil, err
+				}
+				buf.WriteString(lines + "\n")
+				added += strings.Count(lines, "\n") + 1
+			}
+		}
+		return buf.Bytes(), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// hasHashedPattern returns true if contents has an unmarked line for
+// marshaledKey which includes the hashed pattern verbatim.
+func hasHashedPattern(contents []byte, pattern string, marshaledKey []byte) bool {
+	for _, line := range strings.Split(string(contents), "\n") {
+		_, patterns, rest := splitFirstField(strings.TrimRight(line, "\r"))
+		if !strings.Contains(patterns, pattern) || !keyFieldsMatch(rest, marshaledKey) {
+			continue
+		}
+		for _, p := range strings.Split(patterns, ",") {
+			if p == pattern {
+				return true
+			}
+		}
+	}
+	return false
+}
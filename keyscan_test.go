@@ -0,0 +1,139 @@
+package knownhosts
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportKeyscanOutput(t *testing.T) {
+	key, otherKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t)
+	hashedLine := Line([]string{"hashed.example.test"}, otherKey, WithHashedHostnames())
+	output := strings.Join([]string{
+		"# plain.example.test:22 SSH-2.0-OpenSSH_9.3",
+		Line([]string{"plain.example.test"}, key) + "\r",
+		"plain.example.test: Connection closed by remote host",
+		"",
+		"# hashed.example.test:22 SSH-2.0-OpenSSH_9.3",
+		hashedLine,
+		"@cert-authority *.example.test " + strings.SplitN(Line([]string{"x"}, key), " ", 2)[1],
+		"",
+	}, "\n")
+
+	entries, err := ImportKeyscanOutput(strings.NewReader(output))
+	var failures KeyscanErrors
+	if !errors.As(err, &failures) {
+		t.Fatalf("Expected ImportKeyscanOutput to return KeyscanErrors, instead found %v", err)
+	}
+	if len(failures) != 2 || failures[0].Line != 3 || failures[1].Line != 7 || failures[1].Field != "marker" || failures[1].Column != 1 {
+		t.Errorf("Unexpected failures from ImportKeyscanOutput: %v", failures)
+	}
+	if !strings.Contains(err.Error(), "line 3: ") {
+		t.Errorf("Expected error message to include line number, instead found %q", err.Error())
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, instead found %d", len(entries))
+	}
+	if entries[0].Line != 2 || entries[0].Patterns[0] != "plain.example.test" || entries[0].Comment != "" {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].Line != 6 || !entries[1].Hashed() || entries[1].Format() != hashedLine {
+		t.Errorf("Unexpected second entry: %+v", entries[1])
+	}
+
+	// Clean output returns no error
+	if entries, err := ImportKeyscanOutput(strings.NewReader(hashedLine + "\r\n")); err != nil || len(entries) != 1 {
+		t.Errorf("Unexpected result from ImportKeyscanOutput: %v, %v", entries, err)
+	}
+}
+
+func TestAppendEntries(t *testing.T) {
+	key, otherKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t)
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	existing := Line([]string{"existing.example.test"}, key) + "\n" +
+		Line([]string{"hashedexisting.example.test"}, key, WithHashedHostnames()) + "\n"
+	if err := os.WriteFile(path, []byte(existing), 0600); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	hashedLine := Line([]string{"hashed.example.test"}, otherKey, WithHashedHostnames())
+	output := strings.Join([]string{
+		Line([]string{"existing.example.test"}, key),
+		Line([]string{"hashedexisting.example.test"}, key),
+		Line([]string{"new.example.test", "[new.example.test]:2222"}, key),
+		Line([]string{"new.example.test"}, key), // duplicate within the batch
+		hashedLine,
+		hashedLine,
+	}, "\n")
+	entries, err := ImportKeyscanOutput(strings.NewReader(output))
+	if err != nil {
+		t.Fatalf("Unexpected error from ImportKeyscanOutput: %v", err)
+	}
+
+	added, err := AppendEntries(path, entries)
+	if err != nil {
+		t.Fatalf("Unexpected error from AppendEntries: %v", err)
+	} else if added != 2 {
+		t.Errorf("Expected 2 lines to be added, instead found %d", added)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read file: %v", err)
+	}
+	expected := existing + Line([]string{"new.example.test", "[new.example.test]:2222"}, key) + "\n" + hashedLine + "\n"
+	if string(contents) != expected {
+		t.Errorf("Unexpected file contents after AppendEntries; expected:\n%s\nfound:\n%s", expected, contents)
+	}
+
+	// Appending again is a no-op
+	if added, err := AppendEntries(path, entries); err != nil || added != 0 {
+		t.Errorf("Expected repeated AppendEntries to add nothing, instead found %d, %v", added, err)
+	}
+
+	// Invalid entries are rejected
+	for _, e := range []Entry{
+		{Marker: "@revoked", Patterns: []string{"x.example.test"}, Key: key},
+		{Patterns: []string{"*.example.test"}, Key: key},
+		{Patterns: []string{"x.example.test"}},
+		{Key: key},
+	} {
+		if _, err := AppendEntries(path, []Entry{e}); err == nil {
+			t.Errorf("Expected AppendEntries to return an error for %+v, but it did not", e)
+		}
+	}
+
+	// Patterns which would corrupt or inject lines are rejected without
+	// modifying the file
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read file: %v", err)
+	}
+	for _, pattern := range []string{"a,b", "x y", "|1|YWJj|\nevil.example.test " + keyString(otherKey), "new\n* " + keyString(otherKey)} {
+		var ihe *InvalidHostnameError
+		entries := []Entry{{Patterns: []string{"valid.example.test"}, Key: key}, {Patterns: []string{pattern}, Key: key}}
+		if _, err := AppendEntries(path, entries); !errors.As(err, &ihe) {
+			t.Errorf("Expected *InvalidHostnameError from AppendEntries for pattern %q, instead found %v", pattern, err)
+		}
+	}
+	if after, err := os.ReadFile(path); err != nil || !bytes.Equal(before, after) {
+		t.Errorf("Expected file to be unmodified after invalid AppendEntries calls; err=%v", err)
+	}
+
+	// Several hashed patterns in one entry are written on separate lines
+	hashedA, _ := HashHostname("a.example.test")
+	hashedB, _ := HashHostname("b.example.test")
+	if added, err := AppendEntries(path, []Entry{{Patterns: []string{hashedA, hashedB}, Key: otherKey}}); err != nil || added != 2 {
+		t.Errorf("Expected 2 lines to be added for hashed patterns, instead found %d, %v", added, err)
+	}
+	kh, err := New(path)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	for _, host := range []string{"a.example.test:22", "b.example.test:22"} {
+		if err := kh(host, nil, otherKey); err != nil {
+			t.Errorf("Unexpected error from callback for %s: %v", host, err)
+		}
+	}
+}
@@ -1,5 +1,6 @@
-// Package knownhosts is a thin wrapper around golang.org/x/crypto/ssh/knownhosts,
-// adding the ability to obtain the list of host key algorithms for a known host.
+// Package knownhosts is a drop-in replacement for
+// golang.org/x/crypto/ssh/knownhosts, using its types and error values, adding
+// the ability to obtain the list of host key algorithms for a known host.
 package knownhosts
 
 import (
@@ -8,7 +9,9 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 
 	"golang.org/x/crypto/ssh"
@@ -21,11 +24,116 @@ type HostKeyCallback ssh.HostKeyCallback
 
 // New creates a host key callback from the given OpenSSH host key files. The
 // returned value may be used in ssh.ClientConfig.HostKeyCallback by casting it
-// to ssh.HostKeyCallback, or using its HostKeyCallback method. Otherwise, it
-// operates the same as the New function in golang.org/x/crypto/ssh/knownhosts.
+// to ssh.HostKeyCallback, or using its HostKeyCallback method.
+//
+// Files are parsed as leniently as by the New function in
+// golang.org/x/crypto/ssh/knownhosts, so any file accepted there is accepted
+// here, with the same error for any line which is not. Lookups differ in that
+// host patterns are matched in the same manner as MatchesLine, following
+// OpenSSH: matching is case-insensitive, wildcards apply to the whole
+// "[host]:port" form of non-default ports, and hashed entries for IPv6
+// addresses on port 22 are recognized in both the unbracketed form written by
+// OpenSSH and the bracketed form written by golang.org/x/crypto/ssh/knownhosts.
+// Certificates are verified, and @revoked lines applied, in the same manner as
+// golang.org/x/crypto/ssh/knownhosts.
+//
+// Files larger than DefaultMaxFileSize, or with lines longer than
+// DefaultMaxLineLength, are rejected with a *LimitError. The number of entries
+// is not limited; use NewWithOptions for files from untrusted sources.
 func New(files ...string) (HostKeyCallback, error) {
-	cb, err := xknownhosts.New(files...)
-	return HostKeyCallback(cb), err
+	return newCallback(files, readOptions{maxFileSize: DefaultMaxFileSize, maxLineLength: DefaultMaxLineLength})
+}
+
+// NewWithOptions behaves like New, but with the limits configured by opts, or
+// their defaults as described by ReadEntries. Reading stops as soon as a limit
+// is exceeded, returning a *LimitError.
+func NewWithOptions(files []string, opts ...ReadOption) (HostKeyCallback, error) {
+	return newCallback(files, newReadOptions(opts))
+}
+
+// newCallback creates a host key callback from files, which are read within
+// the limits in ro.
+func newCallback(files []string, ro readOptions) (HostKeyCallback, error) {
+	db := &memoryDB{revoked: make(map[string]*xknownhosts.KnownKey), now: ro.now}
+	for _, path := range files {
+		if err := db.readFile(path, ro); err != nil {
+			return nil, err
+		}
+	}
+	return lenientLookup(db.callback()), nil
+}
+
+// lenientLookup wraps cb so that hostnames are looked up in the same form
+// produced by Normalize: lowercase, and without any trailing dot or ipv6 zone
+// identifier. If that form of the hostname is not known, the original hostname
+// is tried as well, so that entries written with uppercase characters, trailing
+// dots, or zones before Normalize began removing them are still found. Errors
+// for unknown hosts are returned as an *UnknownHostError.
+//
+// Remote addresses which are not in host:port form, such as unix socket paths
+// or the addresses of ProxyCommand pipes, are replaced with a zero TCP address,
+// since the lookup otherwise fails even though the remote address is only
+// checked if hostname is empty.
+func lenientLookup(cb ssh.HostKeyCallback) HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if remote == nil {
+			remote = zeroTCPAddr
+		} else if _, _, err := net.SplitHostPort(remote.String()); err != nil {
+			remote = zeroTCPAddr
+		}
+		canonical := lookupHostname(hostname)
+		err := cb(canonical, remote, key)
+		if canonical != hostname && IsHostUnknown(err) {
+			err = cb(hostname, remote, key)
+		}
+		if IsHostUnknown(err) {
+			err = newUnknownHostError(hostname, remote, key, err, writeOptions{})
+		}
+		return err
+	}
+}
+
+// lookupAddress returns address normalized using Normalize, in the host:port
+// form expected by host key callbacks, using port 22 if address has no port.
+func lookupAddress(address string) string {
+	normalized := Normalize(address)
+	if _, _, err := net.SplitHostPort(normalized); err == nil {
+		return normalized
+	}
+	return net.JoinHostPort(normalized, "22")
+}
+
+// withDefaultPort returns address with port appended, if address lacks a port
+// and port is neither empty nor "22". Otherwise address is returned as-is.
+func withDefaultPort(address, port string) string {
+	if port == "" || port == "22" {
+		return address
+	} else if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	host := address
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// zeroTCPAddr is substituted for remote addresses which do not identify a host.
+var zeroTCPAddr net.Addr = &net.TCPAddr{IP: net.IPv4zero}
+
+// lookupHostname returns hostname, which should be in host:port form, with
+// its host portion lowercased and stripped of any trailing dot or ipv6 zone.
+func lookupHostname(hostname string) string {
+	host, port, err := net.SplitHostPort(hostname)
+	if err != nil {
+		return trimTrailingDot(stripZone(lowerASCII(hostname)))
+	}
+	canonical := trimTrailingDot(stripZone(lowerASCII(host)))
+	if canonical == host && (hostname[0] != '[' || strings.Contains(host, ":")) {
+		// Avoid reallocating the common case of an already-canonical hostname
+		return hostname
+	}
+	return net.JoinHostPort(canonical, port)
 }
 
 // HostKeyCallback simply casts the receiver back to ssh.HostKeyCallback, for
@@ -34,25 +142,50 @@ func (hkcb HostKeyCallback) HostKeyCallback() ssh.HostKeyCallback {
 	return ssh.HostKeyCallback(hkcb)
 }
 
-// HostKeys returns a slice of known host public keys for the supplied host:port
+// HostKeys returns a slice of known host public keys for the supplied address
 // found in the known_hosts file(s), or an empty slice if the host is not
 // already known. For hosts that have multiple known_hosts entries (for
 // different key types), the result will be sorted by known_hosts filename and
 // line number.
-func (hkcb HostKeyCallback) HostKeys(hostWithPort string) (keys []ssh.PublicKey) {
+//
+// The address is normalized using Normalize before the lookup, and a bare
+// host is treated as being on port 22. So for example "example.com",
+// "example.com:22", and "user@example.com" all find entries for example.com on
+// port 22, whereas "example.com:2222" and "[example.com]:2222" only find
+// entries for port 2222. Similarly, ipv6 addresses may be supplied as "::1",
+// "[::1]", or "[::1]:22" for port 22, or as "[::1]:2222" for another port. Use
+// HostKeysExact to look up hostWithPort without this normalization.
+func (hkcb HostKeyCallback) HostKeys(address string) []ssh.PublicKey {
+	hostWithPort := lookupAddress(address)
+	keys := hkcb.HostKeysExact(hostWithPort)
+	if len(keys) == 0 && hostWithPort != address {
+		// Also try the address as supplied, in case it matches entries written
+		// in a form which Normalize would alter, such as with a symbolic port
+		keys = hkcb.HostKeysExact(address)
+	}
+	return keys
+}
+
+// HostKeysExact is like HostKeys, but passes hostWithPort to the callback
+// as-is, without normalizing it or supplying a default port. As with the
+// callback itself, hostWithPort must be in host:port form, otherwise an empty
+// slice is returned.
+func (hkcb HostKeyCallback) HostKeysExact(hostWithPort string) (keys []ssh.PublicKey) {
 	var keyErr *xknownhosts.KeyError
-	placeholderAddr := &net.TCPAddr{IP: []byte{0, 0, 0, 0}}
-	placeholderPubKey := &fakePublicKey{}
-	var kkeys []xknownhosts.KnownKey
 	if hkcbErr := hkcb(hostWithPort, placeholderAddr, placeholderPubKey); errors.As(hkcbErr, &keyErr) {
-		kkeys = append(kkeys, keyErr.Want...)
+		// keyErr.Want is freshly allocated by each call, so it may be sorted in
+		// place. It is normally already in order, so avoid sort.Slice's overhead
+		// in that case.
+		kkeys := keyErr.Want
 		knownKeyLess := func(i, j int) bool {
 			if kkeys[i].Filename < kkeys[j].Filename {
 				return true
 			}
 			return (kkeys[i].Filename == kkeys[j].Filename && kkeys[i].Line < kkeys[j].Line)
 		}
-		sort.Slice(kkeys, knownKeyLess)
+		if !sort.SliceIsSorted(kkeys, knownKeyLess) {
+			sort.Slice(kkeys, knownKeyLess)
+		}
 		keys = make([]ssh.PublicKey, len(kkeys))
 		for n := range kkeys {
 			keys[n] = kkeys[n].Key
@@ -61,32 +194,62 @@ func (hkcb HostKeyCallback) HostKeys(hostWithPort string) (keys []ssh.PublicKey)
 	return keys
 }
 
+// Exists returns true if key is among the known host public keys for the
+// supplied address, as returned by HostKeys. Keys are compared using
+// KeysEqual. Note that golang.org/x/crypto/ssh/knownhosts only exposes the
+// first known key of each key type for a host, so Exists may return false if
+// the host has multiple keys of the same type and key is not the first one.
+func (hkcb HostKeyCallback) Exists(address string, key ssh.PublicKey) bool {
+	return containsKey(hkcb.HostKeys(address), key)
+}
+
 // HostKeyAlgorithms returns a slice of host key algorithms for the supplied
-// host:port found in the known_hosts file(s), or an empty slice if the host
+// address found in the known_hosts file(s), or an empty slice if the host
 // is not already known. The result may be used in ssh.ClientConfig's
 // HostKeyAlgorithms field, either as-is or after filtering (if you wish to
 // ignore or prefer particular algorithms). For hosts that have multiple
 // known_hosts entries (for different key types), the result will be sorted by
-// known_hosts filename and line number.
-func (hkcb HostKeyCallback) HostKeyAlgorithms(hostWithPort string) (algos []string) {
+// known_hosts filename and line number. The address may be in any of the forms
+// accepted by HostKeys.
+func (hkcb HostKeyCallback) HostKeyAlgorithms(address string) []string {
+	return keyAlgorithms(hkcb.HostKeys(address))
+}
+
+// HostKeyAlgorithmsExact is like HostKeyAlgorithms, but looks up hostWithPort
+// as-is, in the same manner as HostKeysExact.
+func (hkcb HostKeyCallback) HostKeyAlgorithmsExact(hostWithPort string) []string {
+	return keyAlgorithms(hkcb.HostKeysExact(hostWithPort))
+}
+
+// keyAlgorithms returns the host key algorithms corresponding to hostKeys, in
+// the same order, without duplicates.
+func keyAlgorithms(hostKeys []ssh.PublicKey) (algos []string) {
 	// We ensure that algos never contains duplicates. This is done for robustness
 	// even though currently golang.org/x/crypto/ssh/knownhosts never exposes
 	// multiple keys of the same type. This way our behavior here is unaffected
 	// even if https://github.com/golang/go/issues/28870 is implemented, for
 	// example by https://github.com/golang/crypto/pull/254.
-	hostKeys := hkcb.HostKeys(hostWithPort)
-	seen := make(map[string]struct{}, len(hostKeys))
+	if len(hostKeys) > 0 {
+		algos = make([]string, 0, len(hostKeys)+2)
+	}
 	addAlgo := func(typ string) {
-		if _, already := seen[typ]; !already {
-			algos = append(algos, typ)
-			seen[typ] = struct{}{}
+		// Hosts have few keys, so a linear scan is cheaper than a map here
+		for _, algo := range algos {
+			if algo == typ {
+				return
+			}
 		}
+		algos = append(algos, typ)
 	}
 	for _, key := range hostKeys {
 		typ := key.Type()
-		cert, ok := key.(*ssh.Certificate)
-		if ok {
+		if cert, ok := key.(*ssh.Certificate); ok {
 			typ = cert.Type()
+		} else if _, ok := key.(*CertAuthorityKey); ok && certAlgos[typ] != "" {
+			// The host presents a certificate signed by this key, whose algorithm
+			// corresponds to the type of the host's own key. This is assumed to
+			// be the same as the CA key's type, which is typical in practice.
+			typ = certAlgos[typ]
 		}
 		switch typ {
 		case ssh.KeyAlgoRSA:
@@ -128,38 +291,443 @@ func IsHostUnknown(err error) bool {
 	return errors.As(err, &keyErr) && len(keyErr.Want) == 0
 }
 
+// UnknownHostError is returned by callbacks from New, and other callbacks
+// provided by this package, when the host is not known. It wraps the error
+// from golang.org/x/crypto/ssh/knownhosts, so IsHostUnknown returns true for
+// it. The SuggestedLine may be shown to the user, for example as a line to add
+// to their known_hosts file if they trust the key.
+type UnknownHostError struct {
+	Host   string // hostname supplied to the callback
+	Remote net.Addr
+	Key    ssh.PublicKey
+
+	// SuggestedLine is the known_hosts line which WriteKnownHost would write
+	// for Host, Remote, and Key, without a trailing newline. If hostnames are
+	// hashed, it consists of one line per address, separated by newlines. It is
+	// empty if the hostname cannot be written to a known_hosts file.
+	SuggestedLine string
+
+	Err error // underlying error, normally a *knownhosts.KeyError
+}
+
+// newUnknownHostError returns an *UnknownHostError wrapping err, with its
+// SuggestedLine formatted using wo.
+func newUnknownHostError(hostname string, remote net.Addr, key ssh.PublicKey, err error, wo writeOptions) error {
+	if _, placeholder := key.(*fakePublicKey); placeholder {
+		// Lookups via HostKeys discard the error, so skip formatting the line
+		return err
+	}
+	var line string
+	if addresses, addrErr := knownHostAddresses(hostname, remote, wo); addrErr == nil && validateKey(key) == nil {
+		line, _ = knownHostLines(addresses, key, wo)
+	}
+	return &UnknownHostError{Host: hostname, Remote: remote, Key: key, SuggestedLine: line, Err: err}
+}
+
+// Error satisfies the error interface, returning the underlying error's text.
+func (e *UnknownHostError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *UnknownHostError) Unwrap() error {
+	return e.Err
+}
+
+// SuggestedLineFromError returns the SuggestedLine of an *UnknownHostError in
+// err's chain, such as an error returned by ssh.Dial when the host key callback
+// rejected an unknown host. False is returned if there is no such error, or if
+// its SuggestedLine is empty.
+func SuggestedLineFromError(err error) (string, bool) {
+	var unknownErr *UnknownHostError
+	if errors.As(err, &unknownErr) && unknownErr.SuggestedLine != "" {
+		return unknownErr.SuggestedLine, true
+	}
+	return "", false
+}
+
+// WithSuggestedLines returns a callback which behaves like hkcb, except that
+// errors for unknown hosts are returned as an *UnknownHostError whose
+// SuggestedLine is formatted using opts, in the same manner as WriteKnownHost.
+// For example, supply WithHashedHostnames to suggest hashed lines. This may
+// also be used to obtain an *UnknownHostError from callbacks which were not
+// created by this package.
+func (hkcb HostKeyCallback) WithSuggestedLines(opts ...WriteOption) HostKeyCallback {
+	wo := newWriteOptions(opts)
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := hkcb(hostname, remote, key)
+		if IsHostUnknown(err) {
+			if unknownErr, ok := err.(*UnknownHostError); ok {
+				err = unknownErr.Err
+			}
+			err = newUnknownHostError(hostname, remote, key, err, wo)
+		}
+		return err
+	}
+}
+
 // Normalize normalizes an address into the form used in known_hosts. This
 // implementation includes a fix for https://github.com/golang/go/issues/53463
 // and will omit brackets around ipv6 addresses on standard port 22.
+//
+// Any leading "user@" portion of address is removed, so that for example
+// "git@github.com" and "deploy@10.0.0.5:2222" normalize to "github.com" and
+// "[10.0.0.5]:2222". If address contains multiple '@' characters, everything
+// up to the last one is removed. Since an ipv6 zone identifier may itself
+// contain '@', only '@' characters preceding any '%' are considered.
+//
+// Since DNS names are case-insensitive, ASCII letters in the host are folded
+// to lowercase, as OpenSSH does before writing known_hosts entries. Any ipv6
+// zone identifier, such as the "%eth0" in "fe80::1%eth0", is removed, since
+// zones are specific to the local machine's interfaces; callbacks returned by
+// New match hosts with or without a zone. A single trailing dot is removed
+// from absolute DNS names such as "host.example.com.", so that they match the
+// form users typically dial. Hashed patterns are returned unchanged.
+//
+// A symbolic port, such as the "ssh" in "host:ssh", is converted to its
+// numeric form using the system's services database. Unknown service names are
+// left as-is.
+//
+// Addresses in the form of an ssh:// URI are handled by NormalizeURI. If the
+// URI cannot be parsed, it is normalized as an ordinary address instead.
 func Normalize(address string) string {
+	if strings.HasPrefix(address, hashMagic) {
+		return address
+	} else if len(address) > len(uriPrefix) && strings.EqualFold(address[:len(uriPrefix)], uriPrefix) {
+		if normalized, err := NormalizeURI(address); err == nil {
+			return normalized
+		}
+	}
+	address = lowerASCII(stripUser(address))
 	host, port, err := net.SplitHostPort(address)
 	if err != nil {
 		host = address
 		port = "22"
+		if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		}
+	} else if resolved, ok := resolvePort(port); ok {
+		port = resolved
 	}
-	entry := host
+	host = trimTrailingDot(stripZone(host))
 	if port != "22" {
-		entry = "[" + entry + "]:" + port
-	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
-		entry = entry[1 : len(entry)-1]
+		return "[" + host + "]:" + port
+	}
+	return host
+}
+
+// uriPrefix is the scheme prefix of URIs accepted by NormalizeURI.
+const uriPrefix = "ssh://"
+
+// NormalizeURI converts an ssh:// URI, such as "ssh://user@host:2200/path",
+// into the form used in known_hosts, following the same rules as Normalize:
+// the result for that example is "[host]:2200". Any user info, path, query,
+// or fragment is ignored. An error is returned if uri cannot be parsed, does
+// not use the ssh scheme, or has no host.
+func NormalizeURI(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("knownhosts: invalid URI %q: %w", uri, err)
+	} else if !strings.EqualFold(u.Scheme, "ssh") {
+		return "", fmt.Errorf("knownhosts: URI %q does not use the ssh scheme", uri)
+	} else if u.Hostname() == "" {
+		return "", fmt.Errorf("knownhosts: URI %q has no host", uri)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	return Normalize(net.JoinHostPort(u.Hostname(), port)), nil
+}
+
+// NormalizeStrict normalizes address in the same manner as Normalize, but
+// returns an *InvalidHostnameError if address cannot be interpreted as a
+// host with an optional port, rather than normalizing it anyway. In addition to
+// the checks described by InvalidHostnameError, the host must be non-empty,
+// any port must be between 1 and 65535 (or a service name known to the system,
+// such as "ssh"), and brackets must
+// surround the entire host. An address with multiple colons and no brackets
+// is only accepted if it is an ipv6 address.
+//
+// All functions which write known_hosts entries use NormalizeStrict, so that
+// invalid input is rejected rather than persisted. Functions which look up
+// existing entries use the more lenient Normalize.
+func NormalizeStrict(address string) (string, error) {
+	normalized := Normalize(address)
+	if err := validateAddress(normalized); err != nil {
+		return "", err
+	}
+	invalid := func(reason string) (string, error) {
+		return "", &InvalidHostnameError{Address: address, Reason: reason}
+	}
+
+	hostPort := stripUser(address)
+	if len(hostPort) > len(uriPrefix) && strings.EqualFold(hostPort[:len(uriPrefix)], uriPrefix) {
+		if _, err := NormalizeURI(address); err != nil {
+			return invalid("is not a valid ssh URI")
+		}
+		u, _ := url.Parse(address)
+		hostPort = u.Host
+	}
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		// No port, which is only valid for a bare host, a fully-bracketed host, or
+		// an ipv6 address
+		host = hostPort
+		if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		} else if strings.Count(host, ":") == 1 {
+			return invalid("has an empty host or port")
+		} else if strings.Contains(host, ":") && net.ParseIP(stripZone(host)) == nil {
+			return invalid("has too many colons")
+		}
+	} else if resolved, ok := resolvePort(port); !ok {
+		return invalid(fmt.Sprintf("has unknown service port '%s'", port))
+	} else if portNum, err := strconv.Atoi(resolved); err != nil || portNum < 1 || portNum > 65535 {
+		return invalid(fmt.Sprintf("has invalid port '%s'", port))
 	}
-	return entry
+	if strings.ContainsAny(host, "[]") {
+		return invalid("has unbalanced brackets")
+	} else if trimTrailingDot(stripZone(host)) == "" {
+		return invalid("has an empty host")
+	}
+	return normalized, nil
+}
+
+// lookupPort resolves service names to port numbers. It may be replaced in
+// tests to avoid a dependency on the system's services database.
+var lookupPort = net.LookupPort
+
+// resolvePort converts port to numeric form, looking it up as a tcp service
+// name if it isn't already numeric. The returned bool is false if port is a
+// service name which could not be resolved, in which case port is returned
+// as-is. An empty port is returned as-is, with a true bool.
+func resolvePort(port string) (string, bool) {
+	if port == "" || strings.Trim(port, "0123456789") == "" {
+		return port, true
+	}
+	if num, err := lookupPort("tcp", port); err == nil {
+		return strconv.Itoa(num), true
+	}
+	return port, false
+}
+
+// stripZone removes the zone identifier, such as "%eth0", from an ipv6 host.
+func stripZone(host string) string {
+	if zone := strings.IndexByte(host, '%'); zone >= 0 && strings.Count(host[:zone], ":") >= 2 {
+		return host[:zone]
+	}
+	return host
+}
+
+// trimTrailingDot removes a single trailing dot from an absolute DNS name such
+// as "host.example.com.". A bare "." is returned as-is.
+func trimTrailingDot(host string) string {
+	if len(host) > 1 && strings.HasSuffix(host, ".") {
+		return host[:len(host)-1]
+	}
+	return host
+}
+
+// lowerASCII returns s with ASCII letters folded to lowercase. Other
+// characters are left as-is.
+func lowerASCII(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, s)
+}
+
+// stripUser removes a leading "user@" portion from address, if present.
+func stripUser(address string) string {
+	searchEnd := len(address)
+	if zone := strings.IndexByte(address, '%'); zone >= 0 {
+		searchEnd = zone
+	}
+	if at := strings.LastIndexByte(address[:searchEnd], '@'); at >= 0 {
+		return address[at+1:]
+	}
+	return address
 }
 
 // Line returns a line to append to the known_hosts files. This implementation
 // uses the local patched implementation of Normalize in order to solve
 // https://github.com/golang/go/issues/53463.
-func Line(addresses []string, key ssh.PublicKey) string {
+//
+// If the WithHashedHostnames or WithRequireHashed option is supplied, each
+// address is hashed and placed on its own line, since hashed patterns cannot
+// be comma-separated; in this case the returned string contains one
+// newline-separated line per address. Wildcard and negated patterns cannot be
+// meaningfully hashed, so if any address is such a pattern, Line returns the
+// usual unhashed line instead, as HashFile does for existing lines. All other
+// options are ignored by Line. To generate lines with a marker or comment, use
+// LineWithOptions instead.
+//
+// Line does not validate its addresses, so it should not be used with
+// untrusted input. LineWithOptions and the WriteKnownHost family of functions
+// all reject addresses which would corrupt the line.
+func Line(addresses []string, key ssh.PublicKey, opts ...WriteOption) string {
+	hashed := newWriteOptions(opts).hashed
+	for _, a := range addresses {
+		hashed = hashed && !isHostPattern(a)
+	}
+	lines, err := knownHostLines(addresses, key, writeOptions{hashed: hashed})
+	if err != nil {
+		// Without a comment or host patterns being hashed, this can only fail
+		// if the system's random number generator fails. This matches the
+		// behavior of golang.org/x/crypto/ssh/knownhosts.HashHostname, which
+		// panics.
+		panic(err)
+	}
+	return lines
+}
+
+// knownHostLines returns the known_hosts line for addresses and key, or one
+// line per address if wo specifies hashing. The comment and hashing options in
+// wo are applied; all other options are ignored. The result has no trailing
+// newline.
+func knownHostLines(addresses []string, key ssh.PublicKey, wo writeOptions) (string, error) {
+	lineOpts := LineOptions{Comment: wo.comment, HashHostnames: wo.hashed}
+	if !wo.hashed {
+		return formatLine(addresses, key, lineOpts)
+	}
+	lines := make([]string, len(addresses))
+	for n, a := range addresses {
+		line, err := formatLine([]string{a}, key, lineOpts)
+		if err != nil {
+			return "", err
+		}
+		lines[n] = line
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// LineOptions configures optional components of a known_hosts line generated
+// by LineWithOptions. The zero value generates the same line as Line.
+type LineOptions struct {
+	// Marker optionally prefixes the line with a marker, which must be either
+	// MarkerCertAuthority or MarkerRevoked. MarkerNone means no marker.
+	Marker Marker
+
+	// Comment is optional text appended after the key, separated by a space. It
+	// must not contain newlines or other control characters, and must not begin
+	// or end with whitespace.
+	Comment string
+
+	// HashHostnames causes the address to be hashed, in the same format used by
+	// OpenSSH's HashKnownHosts option. Since hashed patterns cannot be
+	// comma-separated, exactly one address must be supplied when this is true.
+	HashHostnames bool
+}
+
+// LineWithOptions returns a line to append to the known_hosts files, in the
+// same manner as Line, but with the additional components specified by opts.
+// An error is returned if opts contains an unknown marker or an invalid
+// comment, or if HashHostnames is used with anything other than exactly one
+// address. Unlike Line, LineWithOptions also validates each address, returning
+// an *InvalidHostnameError for any address which would corrupt the line, as
+// well as validating that the key is non-empty.
+//
+// In addition to plain addresses, the addresses may include host patterns:
+// wildcard patterns containing "*" or "?", such as "*.example.com", and negated
+// patterns beginning with "!", such as "!untrusted.example.com". Wildcard
+// patterns which are not also valid addresses, such as "[*.example.com]:*",
+// are written as-is apart from being lowercased. Any address following a "!"
+// is normalized like other addresses. Since a line consisting only of negated patterns would never match
+// any host, an error is returned if every address is negated. Patterns cannot
+// be combined with HashHostnames.
+func LineWithOptions(addresses []string, key ssh.PublicKey, opts LineOptions) (string, error) {
+	var positive bool
+	for _, a := range addresses {
+		if err := validateWritePattern(a); err != nil {
+			return "", err
+		}
+		positive = positive || !strings.HasPrefix(a, "!")
+	}
+	if len(addresses) > 0 && !positive {
+		return "", fmt.Errorf("knownhosts: host patterns %q are all negated, so the line would not match any host", addresses)
+	}
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	return formatLine(addresses, key, opts)
+}
+
+// isHostPattern returns true if address is a wildcard or negated host pattern,
+// rather than a plain address.
+func isHostPattern(address string) bool {
+	return strings.HasPrefix(address, "!") || strings.ContainsAny(address, "*?")
+}
+
+// validateWritePattern returns an error if pattern, which may be a plain
+// address or a wildcard or negated host pattern, cannot be written to a
+// known_hosts line. Plain addresses, including those following a "!", are
+// validated using NormalizeStrict.
+func validateWritePattern(pattern string) error {
+	address := strings.TrimPrefix(pattern, "!")
+	_, err := NormalizeStrict(address)
+	if err != nil && strings.ContainsAny(address, "*?") {
+		return validateAddress(address)
+	}
+	return err
+}
+
+// normalizePattern normalizes a plain address using Normalize, and applies the
+// same rules to the remainder of a negated pattern. A wildcard pattern is also
+// normalized if NormalizeStrict accepts it, or is otherwise just lowercased,
+// since forms such as "[*.example.com]:*" are not valid addresses.
+func normalizePattern(pattern string) string {
+	if strings.HasPrefix(pattern, "!") {
+		return "!" + normalizePattern(pattern[1:])
+	} else if strings.ContainsAny(pattern, "*?") {
+		if normalized, err := NormalizeStrict(pattern); err == nil {
+			return normalized
+		}
+		return lowerASCII(pattern)
+	}
+	return Normalize(pattern)
+}
+
+// formatLine implements LineWithOptions, without validating the addresses or
+// key.
+func formatLine(addresses []string, key ssh.PublicKey, opts LineOptions) (string, error) {
+	if _, err := ParseMarker(string(opts.Marker)); err != nil {
+		return "", err
+	}
+	if err := validateComment(opts.Comment); err != nil {
+		return "", err
+	}
 	var trimmed []string
 	for _, a := range addresses {
-		trimmed = append(trimmed, Normalize(a))
+		trimmed = append(trimmed, normalizePattern(a))
+	}
+	patterns := strings.Join(trimmed, ",")
+	if opts.HashHostnames {
+		if len(trimmed) != 1 {
+			return "", fmt.Errorf("knownhosts: hashed lines must have exactly one address, but %d were supplied", len(trimmed))
+		} else if isHostPattern(addresses[0]) {
+			return "", fmt.Errorf("knownhosts: host pattern '%s' cannot be hashed", addresses[0])
+		}
+		var err error
+		if patterns, err = HashHostname(trimmed[0]); err != nil {
+			return "", err
+		}
 	}
 
-	return strings.Join([]string{
-		strings.Join(trimmed, ","),
+	fields := []string{
+		patterns,
 		key.Type(),
 		base64.StdEncoding.EncodeToString(key.Marshal()),
-	}, " ")
+	}
+	if opts.Marker != MarkerNone {
+		fields = append([]string{string(opts.Marker)}, fields...)
+	}
+	if opts.Comment != "" {
+		fields = append(fields, opts.Comment)
+	}
+	return strings.Join(fields, " "), nil
 }
 
 // WriteKnownHost writes a known_hosts line to writer for the supplied hostname,
@@ -167,28 +735,296 @@ func Line(addresses []string, key ssh.PublicKey) string {
 // wraps a callback obtained from knownhosts.New to provide additional
 // known_hosts management functionality. The hostname, remote, and key typically
 // correspond to the callback's args.
-func WriteKnownHost(w io.Writer, hostname string, remote net.Addr, key ssh.PublicKey) error {
+//
+// The remote address is only recorded if it consists of an IP address and
+// port, and differs from hostname. Other kinds of remote address, such as a
+// unix socket path or the address of a ProxyCommand pipe, are ignored, so only
+// the hostname is recorded.
+//
+// If the WithHostnameOnly option is supplied, the remote is never recorded.
+// If the WithHashedHostnames option is supplied, the hostname and remote are
+// hashed and written as separate lines. To write a @cert-authority line
+// instead, use WriteKnownHostCA or AppendKnownHostCA.
+func WriteKnownHost(w io.Writer, hostname string, remote net.Addr, key ssh.PublicKey, opts ...WriteOption) error {
+	addresses, err := knownHostAddresses(hostname, remote, newWriteOptions(opts))
+	if err != nil {
+		return err
+	}
+	return WriteKnownHostAddresses(w, addresses, key, opts...)
+}
+
+// WriteKnownHostAddresses writes a known_hosts line to w for key, listing
+// exactly the supplied addresses as its host patterns. This is useful when the
+// caller knows precisely which names and addresses a host should be recorded
+// under, rather than relying on the hostname and remote rules of
+// WriteKnownHost. Each address is normalized using Normalize, and addresses
+// which are identical after normalization are only listed once. An error is
+// returned if addresses is empty, or an *InvalidHostnameError if any address
+// cannot be safely written as a host pattern.
+//
+// If the WithHashedHostnames option is supplied, each address is hashed and
+// written as a separate line.
+func WriteKnownHostAddresses(w io.Writer, addresses []string, key ssh.PublicKey, opts ...WriteOption) error {
+	normalized, err := normalizeAddresses(addresses)
+	if err != nil {
+		return err
+	} else if err := validateKey(key); err != nil {
+		return err
+	}
+	lines, err := knownHostLines(normalized, key, newWriteOptions(opts))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte(lines + "\n"))
+	return err
+}
+
+// WriteKnownHostPattern writes a known_hosts line to w trusting key for every
+// host matching pattern, which is typically a wildcard such as
+// "*.workers.internal". This is useful for a group of hosts sharing one host
+// key, such as an autoscaling group. The pattern is normalized in the same
+// manner as LineWithOptions, and matching follows the rules described by
+// MatchesPattern.
+//
+// A pattern without a port only matches hosts on port 22. To match hosts on
+// another port, supply either "*.workers.internal:2222" or
+// "[*.workers.internal]:2222"; both are written in the bracketed form. The form
+// "[*.workers.internal]:*" matches hosts on any port other than 22, both by
+// MatchesPattern and in lookups by callbacks such as those from New.
+//
+// An error is returned if pattern is empty, negated, hashed, or contains
+// whitespace, commas, or other characters which would corrupt the line, or if
+// the WithHashedHostnames option is supplied. The WithComment option is
+// supported. If the pattern matches every host, such as "*" or "*.*", the line
+// is still written, but a warning with code WarningBroadPattern is returned so
+// that the caller can confirm this was intended.
+func WriteKnownHostPattern(w io.Writer, pattern string, key ssh.PublicKey, opts ...WriteOption) ([]Warning, error) {
+	if strings.HasPrefix(pattern, "!") {
+		return nil, fmt.Errorf("knownhosts: negated pattern '%s' would not match any host", pattern)
+	} else if strings.HasPrefix(pattern, hashMagic) {
+		return nil, fmt.Errorf("knownhosts: pattern '%s' is already hashed", pattern)
+	} else if err := validateWritePattern(pattern); err != nil {
+		return nil, err
+	} else if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	line, err := knownHostLines([]string{pattern}, key, newWriteOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, line+"\n"); err != nil {
+		return nil, err
+	}
+	var warnings []Warning
+	normalized := normalizePattern(pattern)
+	host := normalized
+	if strings.HasPrefix(host, "[") {
+		if end := strings.LastIndex(host, "]:"); end > 0 {
+			host = host[1:end]
+		}
+	}
+	if strings.Trim(host, "*?.") == "" {
+		warnings = append(warnings, Warning{Code: WarningBroadPattern, Message: fmt.Sprintf("pattern %q matches every host", normalized)})
+	}
+	return warnings, nil
+}
+
+// normalizeAddresses returns addresses normalized and de-duplicated, or an
+// error if any address cannot be used as a known_hosts pattern.
+func normalizeAddresses(addresses []string) ([]string, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("knownhosts: no addresses supplied")
+	}
+	normalized := make([]string, 0, len(addresses))
+	seen := make(map[string]bool, len(addresses))
+	for _, a := range addresses {
+		n, err := NormalizeStrict(a)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[n] {
+			seen[n] = true
+			normalized = append(normalized, n)
+		}
+	}
+	return normalized, nil
+}
+
+// WriteKnownHosts writes known_hosts lines to w for the supplied hostname and
+// remote, with one line per key. This is useful when a host's full set of keys
+// is obtained at once, for example from a key scan. The addresses on each line
+// are determined in the same manner as WriteKnownHost, and the same options are
+// supported. If keys contains the same key multiple times, it is only written
+// once. If a write fails, the returned error indicates which key could not be
+// written, and no further keys are written.
+func WriteKnownHosts(w io.Writer, hostname string, remote net.Addr, keys []ssh.PublicKey, opts ...WriteOption) error {
+	wo := newWriteOptions(opts)
+	addresses, err := knownHostAddresses(hostname, remote, wo)
+	if err != nil {
+		return err
+	}
+	if err := validateComment(wo.comment); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := validateKey(key); err != nil {
+			return err
+		}
+	}
+	for _, key := range dedupeKeys(keys) {
+		lines, err := knownHostLines(addresses, key, wo)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte(lines + "\n")); err != nil {
+			return fmt.Errorf("knownhosts: unable to write %s key %s: %w", key.Type(), Fingerprint(key), err)
+		}
+	}
+	return nil
+}
+
+// knownHostAddresses returns the normalized addresses to write in a
+// known_hosts line for hostname and remote. The remote is ignored if the
+// WithHostnameOnly option was supplied, and hostname is treated as being on
+// the port supplied via WithDefaultPort if it lacks a port.
+func knownHostAddresses(hostname string, remote net.Addr, wo writeOptions) ([]string, error) {
 	// Always include hostname; only also include remote if it isn't a zero value
 	// and doesn't normalize to the same string as hostname.
-	hostnameNormalized := Normalize(hostname)
-	if strings.ContainsAny(hostnameNormalized, "\t ") {
-		return fmt.Errorf("knownhosts: hostname '%s' contains spaces", hostnameNormalized)
+	hostnameNormalized, err := NormalizeStrict(withDefaultPort(hostname, wo.defaultPort))
+	if err != nil {
+		return nil, err
 	}
 	addresses := []string{hostnameNormalized}
-	remoteStrNormalized := Normalize(remote.String())
-	if remoteStrNormalized != "[0.0.0.0]:0" && remoteStrNormalized != hostnameNormalized &&
-		!strings.ContainsAny(remoteStrNormalized, "\t ") {
-		addresses = append(addresses, remoteStrNormalized)
+	if wo.hostnameOnly {
+		return addresses, nil
+	}
+	if remoteNormalized, ok := remoteAddress(remote); ok && remoteNormalized != hostnameNormalized {
+		addresses = append(addresses, remoteNormalized)
 	}
-	line := Line(addresses, key) + "\n"
-	_, err := w.Write([]byte(line))
+	return addresses, nil
+}
+
+// remoteAddress returns the normalized form of remote, if it consists of an IP
+// address and port, such as the address of a TCP connection. False is returned
+// for a nil or zero address, as well as for other kinds of address, such as a
+// unix socket path, the address of a ProxyCommand pipe, or a custom net.Addr
+// whose String method does not return an IP address and port, since these do
+// not identify the host.
+func remoteAddress(remote net.Addr) (string, bool) {
+	if remote == nil {
+		return "", false
+	}
+	host, port, err := net.SplitHostPort(remote.String())
+	if err != nil || net.ParseIP(stripZone(host)) == nil {
+		return "", false
+	}
+	normalized, err := NormalizeStrict(net.JoinHostPort(host, port))
+	if err != nil || normalized == "[0.0.0.0]:0" {
+		return "", false
+	}
+	return normalized, true
+}
+
+// InvalidHostnameError is returned by functions which write known_hosts lines,
+// when a hostname or address cannot be safely written as a host pattern. For
+// example, a hostname containing a comma would be interpreted as two separate
+// patterns, and one beginning with "#" would turn the line into a comment.
+type InvalidHostnameError struct {
+	Address string // the offending address
+	Reason  string // description of the problem, for example "contains spaces"
+}
+
+// Error satisfies the error interface.
+func (e *InvalidHostnameError) Error() string {
+	return fmt.Sprintf("knownhosts: hostname '%s' %s", e.Address, e.Reason)
+}
+
+// validateAddress returns an *InvalidHostnameError if the normalized address
+// cannot be used as a host pattern without changing the meaning of a
+// known_hosts line.
+func validateAddress(address string) error {
+	var reason string
+	switch {
+	case address == "":
+		reason = "is empty"
+	case strings.ContainsAny(address, "\t "):
+		reason = "contains spaces"
+	case strings.Contains(address, ","):
+		reason = "contains a comma"
+	case strings.IndexFunc(address, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0:
+		reason = "contains control characters"
+	case strings.ContainsAny(address[:1], "#|@!"):
+		reason = fmt.Sprintf("begins with '%c'", address[0])
+	default:
+		return nil
+	}
+	return &InvalidHostnameError{Address: address, Reason: reason}
+}
+
+// validateKey returns an error if key cannot be written to a known_hosts line.
+func validateKey(key ssh.PublicKey) error {
+	if key == nil || len(key.Marshal()) == 0 || key.Type() == "" {
+		return errors.New("knownhosts: key is empty")
+	}
+	return nil
+}
+
+// validateComment returns an error if comment cannot be safely appended to a
+// known_hosts line. Control characters could split or corrupt the line, and
+// surrounding whitespace would not survive a round trip through the parser.
+func validateComment(comment string) error {
+	for _, r := range comment {
+		if (r < 0x20 && r != '\t') || r == 0x7f {
+			return fmt.Errorf("knownhosts: comment %q contains a newline or control character", comment)
+		}
+	}
+	if strings.TrimSpace(comment) != comment {
+		return fmt.Errorf("knownhosts: comment %q has leading or trailing whitespace", comment)
+	}
+	return nil
+}
+
+// WriteKnownHostCA writes a @cert-authority line to w, indicating that caKey
+// is trusted to sign host certificates for any host matching hostPatterns. Each
+// pattern may be a hostname, an address, or a wildcard pattern such as
+// "*.example.com" or "[*.example.com]:2222"; patterns are normalized in the same
+// manner as addresses in Line. An error is returned if hostPatterns is empty,
+// or if any pattern is empty or contains whitespace or commas.
+func WriteKnownHostCA(w io.Writer, hostPatterns []string, caKey ssh.PublicKey) error {
+	line, err := caLine(hostPatterns, caKey)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte(line + "\n"))
 	return err
 }
 
+// caLine returns a @cert-authority line for the supplied patterns and key,
+// without a trailing newline.
+func caLine(hostPatterns []string, caKey ssh.PublicKey) (string, error) {
+	if len(hostPatterns) == 0 {
+		return "", errors.New("knownhosts: no host patterns supplied for @cert-authority line")
+	}
+	for _, pattern := range hostPatterns {
+		if pattern == "" || strings.ContainsAny(pattern, "\t ,") {
+			return "", fmt.Errorf("knownhosts: invalid host pattern '%s' for @cert-authority line", pattern)
+		}
+	}
+	return LineWithOptions(hostPatterns, caKey, LineOptions{Marker: MarkerCertAuthority})
+}
+
 // fakePublicKey is used as part of the work-around for
 // https://github.com/golang/go/issues/29286
 type fakePublicKey struct{}
 
+// placeholderAddr and placeholderPubKey are passed to callbacks by HostKeys.
+// They are shared across calls to avoid allocating them on every lookup, and
+// must not be modified.
+var (
+	placeholderAddr   = &net.TCPAddr{IP: []byte{0, 0, 0, 0}}
+	placeholderPubKey = &fakePublicKey{}
+)
+
 func (fakePublicKey) Type() string {
 	return "fake-public-key"
 }
@@ -7,12 +7,17 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
 )
 
 func TestNew(t *testing.T) {
@@ -34,6 +39,25 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestExists(t *testing.T) {
+	khPath := getTestKnownHosts(t)
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	for _, key := range kh.HostKeys("multi.example.test:2233") {
+		if !kh.Exists("multi.example.test:2233", key) {
+			t.Errorf("Expected Exists to return true for %s key of multi.example.test:2233", key.Type())
+		}
+		if kh.Exists("multi.example.test:22", key) {
+			t.Errorf("Expected Exists to return false for %s key of multi.example.test on different port", key.Type())
+		}
+	}
+	if kh.Exists("multi.example.test:2233", generatePubKeyEd25519(t)) {
+		t.Error("Expected Exists to return false for a new key")
+	}
+}
+
 func TestHostKeyAlgorithms(t *testing.T) {
 	khPath := getTestKnownHosts(t)
 	kh, err := New(khPath)
@@ -66,6 +90,50 @@ func TestHostKeyAlgorithms(t *testing.T) {
 	}
 }
 
+func TestLookupHostname(t *testing.T) {
+	for input, expected := range map[string]string{
+		"host.example.test:22":    "host.example.test:22",
+		"HOST.Example.test.:2222": "host.example.test:2222",
+		"[host.example.test]:22":  "host.example.test:22",
+		"[fe80::1]:22":            "[fe80::1]:22",
+		"[fe80::1%eth0]:22":       "[fe80::1]:22",
+		"192.168.1.102:2222":      "192.168.1.102:2222",
+		"Host.Example.Test":       "host.example.test",
+	} {
+		if actual := lookupHostname(input); actual != expected {
+			t.Errorf("Expected lookupHostname(%q) to return %q, instead found %q", input, expected, actual)
+		}
+	}
+}
+
+func BenchmarkHostKeys(b *testing.B) {
+	kh, err := New(getTestKnownHosts(b))
+	if err != nil {
+		b.Fatalf("Unexpected error from New: %v", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if keys := kh.HostKeys("multi.example.test:2233"); len(keys) != 3 {
+			b.Fatalf("Expected 3 keys, instead found %d", len(keys))
+		}
+	}
+}
+
+func BenchmarkHostKeyAlgorithms(b *testing.B) {
+	kh, err := New(getTestKnownHosts(b))
+	if err != nil {
+		b.Fatalf("Unexpected error from New: %v", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if algos := kh.HostKeyAlgorithms("multi.example.test:2233"); len(algos) != 5 {
+			b.Fatalf("Expected 5 algorithms, instead found %d", len(algos))
+		}
+	}
+}
+
 func TestIsHostKeyChanged(t *testing.T) {
 	khPath := getTestKnownHosts(t)
 	kh, err := New(khPath)
@@ -152,6 +220,35 @@ func TestNormalize(t *testing.T) {
 		"[abcd::abcd:abcd:abcd]":    "abcd::abcd:abcd:abcd",
 		"[abcd::abcd:abcd:abcd]:22": "abcd::abcd:abcd:abcd",
 		"[abcd::abcd:abcd:abcd]:23": "[abcd::abcd:abcd:abcd]:23",
+		"git@github.com":            "github.com",
+		"git@github.com:22":         "github.com",
+		"deploy@10.0.0.5:2222":      "[10.0.0.5]:2222",
+		"deploy@[10.0.0.5]:2222":    "[10.0.0.5]:2222",
+		"a@b@host.test:23":          "[host.test]:23",
+		"user@[abcd::abcd]:22":      "abcd::abcd",
+		"user@[fe80::1%en@0]:23":    "[fe80::1]:23",
+		"fe80::1%en@0":              "fe80::1",
+		"fe80::1%eth0":              "fe80::1",
+		"[fe80::1%eth0]:22":         "fe80::1",
+		"[fe80::1%eth0]:2222":       "[fe80::1]:2222",
+		"Server01.EXAMPLE.com":      "server01.example.com",
+		"[Server01.EXAMPLE.com]:23": "[server01.example.com]:23",
+		"[ABCD::ABCD%EN0]:23":       "[abcd::abcd]:23",
+		"|1|AbCd|EfGh":              "|1|AbCd|EfGh",
+		"db1.prod.example.com.":     "db1.prod.example.com",
+		"db1.prod.example.com.:22":  "db1.prod.example.com",
+		"[db1.example.com.]:2222":   "[db1.example.com]:2222",
+		".":                         ".",
+		"host..":                    "host.",
+		// ssh:// URIs, with expected values matching the hostname and port that
+		// "ssh -G" reports for the same URI
+		"ssh://user@Host.Example.test:2200/path": "[host.example.test]:2200",
+		"ssh://host.example.test":                "host.example.test",
+		"SSH://host.example.test:22/":            "host.example.test",
+		"ssh://[2001:DB8::1]:2222":               "[2001:db8::1]:2222",
+		"ssh://deploy@[2001:db8::1]":             "2001:db8::1",
+		"ssh://[fe80::1%25eth0]:2222":            "[fe80::1]:2222",
+		"ssh://bad host":                         "[ssh]://bad host", // falls back to address handling
 	} {
 		got := Normalize(in)
 		if got != want {
@@ -160,6 +257,226 @@ func TestNormalize(t *testing.T) {
 	}
 }
 
+func TestNormalizeStrict(t *testing.T) {
+	for in, want := range map[string]string{
+		"host.example.test":          "host.example.test",
+		"host.example.test:2222":     "[host.example.test]:2222",
+		"[host.example.test]":        "host.example.test",
+		"user@[2001:db8::1]:22":      "2001:db8::1",
+		"2001:db8::1":                "2001:db8::1",
+		"fe80::1%eth0":               "fe80::1",
+		"*.example.test":             "*.example.test",
+		"ssh://host.example.test:23": "[host.example.test]:23",
+	} {
+		if got, err := NormalizeStrict(in); err != nil || got != want {
+			t.Errorf("NormalizeStrict(%q) returned %q, %v; want %q, nil", in, got, err, want)
+		}
+	}
+	for _, in := range []string{
+		"", ":", "host:", "host:port:extra", "host:0", "host:65536", "host:-1", "host:abc",
+		"[host", "host]", "[ho[st]:22", "[]:22", "bad host", "a,b", "ssh://", "user@", "fe80::zz",
+	} {
+		var hostErr *InvalidHostnameError
+		if got, err := NormalizeStrict(in); !errors.As(err, &hostErr) {
+			t.Errorf("Expected InvalidHostnameError from NormalizeStrict(%q), instead found %q, %v", in, got, err)
+		}
+	}
+
+	// Writers should reject the same input
+	var buf bytes.Buffer
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	if err := WriteKnownHost(&buf, "host:port:extra", noAddr, generatePubKeyEd25519(t)); err == nil || buf.Len() > 0 {
+		t.Errorf("Expected WriteKnownHost to reject invalid address without writing, instead found %v, %q", err, buf.String())
+	}
+}
+
+func TestServiceNamePorts(t *testing.T) {
+	defer func(orig func(string, string) (int, error)) { lookupPort = orig }(lookupPort)
+	lookupPort = func(network, service string) (int, error) {
+		switch service {
+		case "ssh":
+			return 22, nil
+		case "altssh":
+			return 2222, nil
+		}
+		return 0, errors.New("unknown service")
+	}
+
+	for in, want := range map[string]string{
+		"host.example.test:ssh":    "host.example.test",
+		"host.example.test:altssh": "[host.example.test]:2222",
+		"[2001:db8::1]:altssh":     "[2001:db8::1]:2222",
+		"host.example.test:nope":   "[host.example.test]:nope", // lenient: passed through
+	} {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got, err := NormalizeStrict("host.example.test:altssh"); err != nil || got != "[host.example.test]:2222" {
+		t.Errorf("Unexpected result from NormalizeStrict with service port: %q, %v", got, err)
+	}
+	var hostErr *InvalidHostnameError
+	if got, err := NormalizeStrict("host.example.test:nope"); !errors.As(err, &hostErr) {
+		t.Errorf("Expected InvalidHostnameError from NormalizeStrict with unknown service, instead found %q, %v", got, err)
+	}
+}
+
+func TestNormalizeURI(t *testing.T) {
+	if got, err := NormalizeURI("ssh://user@host.example.test:2200/some/path?x=y#z"); err != nil || got != "[host.example.test]:2200" {
+		t.Errorf("Unexpected result from NormalizeURI: %q, %v", got, err)
+	}
+	for _, uri := range []string{"ssh://bad host", "https://host.example.test", "ssh:///path", "host.example.test", "ssh://user@:22"} {
+		if got, err := NormalizeURI(uri); err == nil {
+			t.Errorf("Expected error from NormalizeURI(%q), instead found %q", uri, got)
+		}
+	}
+}
+
+func TestLenientLookup(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	newKey, legacyKey := generatePubKeyEd25519(t), generatePubKeyECDSA(t)
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	var buf bytes.Buffer
+	if err := WriteKnownHost(&buf, "Server01.EXAMPLE.test", noAddr, newKey); err != nil {
+		t.Fatalf("Unexpected error from WriteKnownHost: %v", err)
+	}
+	if want := "server01.example.test " + keyString(newKey) + "\n"; buf.String() != want {
+		t.Errorf("Expected WriteKnownHost to write %q, instead found %q", want, buf.String())
+	}
+	if err := WriteKnownHost(&buf, "dotted.example.test.", noAddr, newKey); err != nil {
+		t.Fatalf("Unexpected error from WriteKnownHost: %v", err)
+	}
+	// Simulate entries written before hostnames were lowercased and stripped of
+	// trailing dots
+	buf.WriteString("Legacy.EXAMPLE.test " + keyString(legacyKey) + "\n")
+	buf.WriteString("legacy-dotted.example.test. " + keyString(legacyKey) + "\n")
+	buf.WriteString("undotted.example.test " + keyString(legacyKey) + "\n")
+	if err := os.WriteFile(khPath, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	for host, key := range map[string]ssh.PublicKey{
+		"server01.example.test:22":       newKey,
+		"SERVER01.Example.Test:22":       newKey,
+		"Legacy.EXAMPLE.test:22":         legacyKey,
+		"dotted.example.test:22":         newKey,
+		"dotted.example.test.:22":        newKey,
+		"legacy-dotted.example.test.:22": legacyKey,
+		"undotted.example.test.:22":      legacyKey,
+	} {
+		if err := kh(host, noAddr, key); err != nil {
+			t.Errorf("Unexpected error from callback for %s: %v", host, err)
+		}
+	}
+	if err := kh("SERVER01.example.test:22", noAddr, legacyKey); !IsHostKeyChanged(err) {
+		t.Errorf("Expected host key changed error for mismatched key, instead found %v", err)
+	}
+}
+
+func TestNewLenientParsing(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	key, otherKey, anyKey := generatePubKeyEd25519(t), generatePubKeyECDSA(t), generatePubKeyEd25519(t)
+	encoded := strings.SplitN(keyString(key), " ", 2)[1]
+	lines := []string{
+		// golang.org/x/crypto/ssh/knownhosts ignores the key type field, and skips
+		// empty patterns, unlike ReadEntries
+		"mismatched.example.test ssh-rsa " + encoded,
+		"a.example.test,,b.example.test, " + keyString(otherKey),
+		"   indented.example.test " + keyString(key) + "   ",
+		"* " + keyString(anyKey),
+	}
+	if err := os.WriteFile(khPath, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	if _, err := ReadEntries(khPath); err == nil {
+		t.Error("Expected ReadEntries to reject lines which New accepts, but it did not")
+	}
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	for host, key := range map[string]ssh.PublicKey{
+		"mismatched.example.test:22": key,
+		"a.example.test:22":          otherKey,
+		"b.example.test:22":          otherKey,
+		"indented.example.test:22":   key,
+		"x:22":                       anyKey,
+	} {
+		if err := kh(host, noAddr, key); err != nil {
+			t.Errorf("Unexpected error from callback for %s: %v", host, err)
+		}
+	}
+
+	// Callbacks match patterns in the same manner as MatchesPattern, so "*" also
+	// matches hosts on other ports, unlike golang.org/x/crypto/ssh/knownhosts
+	if matched, err := MatchesPattern("*", "x:2222"); !matched || err != nil {
+		t.Errorf("Expected MatchesPattern to match x:2222, instead found %t, %v", matched, err)
+	}
+	if err := kh("x:2222", noAddr, anyKey); err != nil {
+		t.Errorf("Unexpected error from callback for x:2222: %v", err)
+	}
+
+	for _, line := range []string{
+		"@bogus host.example.test " + keyString(key),
+		"host.example.test ssh-ed25519",
+		"host.example.test ssh-ed25519 !!!",
+		"!,host.example.test " + keyString(key),
+	} {
+		if err := os.WriteFile(khPath, []byte("# ok\n"+line+"\n"), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %v", khPath, err)
+		}
+		_, err := New(khPath)
+		if err == nil || !strings.HasPrefix(err.Error(), "knownhosts: "+khPath+":2: ") {
+			t.Errorf("Expected New to fail with an error for line 2 of %s, instead found %v", line, err)
+		}
+	}
+}
+
+func TestZoneLookup(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	newKey, legacyKey := generatePubKeyEd25519(t), generatePubKeyECDSA(t)
+	// Remote addresses as produced by dialing a link-local address
+	remote := &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 22, Zone: "eth0"}
+	remote2222 := &net.TCPAddr{IP: net.ParseIP("fe80::2"), Port: 2222, Zone: "eth0"}
+	var buf bytes.Buffer
+	if err := WriteKnownHost(&buf, remote.String(), remote, newKey); err != nil {
+		t.Fatalf("Unexpected error from WriteKnownHost: %v", err)
+	}
+	if err := WriteKnownHost(&buf, "linklocal.example.test:2222", remote2222, newKey); err != nil {
+		t.Fatalf("Unexpected error from WriteKnownHost: %v", err)
+	}
+	want := "fe80::1 " + keyString(newKey) + "\n" + "[linklocal.example.test]:2222,[fe80::2]:2222 " + keyString(newKey) + "\n"
+	if buf.String() != want {
+		t.Errorf("Expected WriteKnownHost to write zone-less lines %q, instead found %q", want, buf.String())
+	}
+	// Simulate an entry written with a zone before zones were stripped
+	buf.WriteString("fe80::3%eth1 " + keyString(legacyKey) + "\n")
+	if err := os.WriteFile(khPath, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	for host, key := range map[string]ssh.PublicKey{
+		remote.String():      newKey,
+		"[fe80::1%wlan0]:22": newKey,
+		"[fe80::1]:22":       newKey,
+		remote2222.String():  newKey,
+		"[fe80::2]:2222":     newKey,
+		"[fe80::3%eth1]:22":  legacyKey,
+	} {
+		if err := kh(host, noAddr, key); err != nil {
+			t.Errorf("Unexpected error from callback for %s: %v", host, err)
+		}
+	}
+}
+
 func TestLine(t *testing.T) {
 	edKeyStr := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIF9Wn63tLEhSWl9Ye+4x2GnruH8cq0LIh2vum/fUHrFQ"
 	edKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(edKeyStr))
@@ -177,6 +494,78 @@ func TestLine(t *testing.T) {
 			t.Errorf("Line(%q) = %q, want %q", in, got, want)
 		}
 	}
+
+	// Host patterns cannot be hashed, so the unhashed line is returned instead
+	// of panicking
+	for _, addresses := range [][]string{{"*.example.org"}, {"*.example.org", "!bad.example.org"}, {"server.org", "!bad.example.org"}} {
+		want := Line(addresses, edKey)
+		if got := Line(addresses, edKey, WithHashedHostnames()); got != want {
+			t.Errorf("Line(%q) with hashing = %q, want %q", addresses, got, want)
+		}
+	}
+}
+
+func TestLineWithOptions(t *testing.T) {
+	edKeyStr := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIF9Wn63tLEhSWl9Ye+4x2GnruH8cq0LIh2vum/fUHrFQ"
+	edKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(edKeyStr))
+	if err != nil {
+		t.Fatalf("Unable to parse authorized key: %v", err)
+	}
+	for _, m := range []struct {
+		addresses []string
+		opts      LineOptions
+		want      string
+		err       bool
+	}{
+		{addresses: []string{"server.org:22", "server.org:23"}, want: "server.org,[server.org]:23 " + edKeyStr},
+		{addresses: []string{"*.server.org"}, opts: LineOptions{Marker: "@cert-authority"}, want: "@cert-authority *.server.org " + edKeyStr},
+		{addresses: []string{"server.org"}, opts: LineOptions{Marker: "@revoked", Comment: "rotated 2024-05-01"}, want: "@revoked server.org " + edKeyStr + " rotated 2024-05-01"},
+		{addresses: []string{"server.org"}, opts: LineOptions{Marker: "@bogus"}, err: true},
+		{addresses: []string{"server.org"}, opts: LineOptions{Comment: "multi\nline"}, err: true},
+		{addresses: []string{"server.org", "other.org"}, opts: LineOptions{HashHostnames: true}, err: true},
+		{addresses: []string{"*.Example.com", "!Untrusted.example.com:2222", "!*.bad.example.com"}, want: "*.example.com,![untrusted.example.com]:2222,!*.bad.example.com " + edKeyStr},
+		{addresses: []string{"[*.example.com]:*", "?.example.com"}, want: "[*.example.com]:*,?.example.com " + edKeyStr},
+		{addresses: []string{"!untrusted.example.com", "!*.bad.example.com"}, err: true},
+		{addresses: []string{"*.example.com", "!"}, err: true},
+		{addresses: []string{"*.example.com", "!!host"}, err: true},
+		{addresses: []string{"*.example.com", "!host:bogus"}, err: true},
+		{addresses: []string{"*.example.com"}, opts: LineOptions{HashHostnames: true}, err: true},
+		{addresses: []string{"!host"}, opts: LineOptions{HashHostnames: true}, err: true},
+	} {
+		got, err := LineWithOptions(m.addresses, edKey, m.opts)
+		if m.err {
+			if err == nil {
+				t.Errorf("LineWithOptions(%q, %+v) expected error, but error was nil", m.addresses, m.opts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error from LineWithOptions(%q, %+v): %v", m.addresses, m.opts, err)
+		} else if got != m.want {
+			t.Errorf("LineWithOptions(%q, %+v) = %q, want %q", m.addresses, m.opts, got, m.want)
+		}
+	}
+
+	// Negated patterns survive a round trip through ParseLine
+	line, err := LineWithOptions([]string{"*.example.com", "!untrusted.example.com"}, edKey, LineOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error from LineWithOptions with negated pattern: %v", err)
+	}
+	if e, err := ParseLine([]byte(line)); err != nil || len(e.Patterns) != 2 || e.Patterns[1] != "!untrusted.example.com" {
+		t.Errorf("Unexpected result from ParseLine(%q): %+v, err=%v", line, e, err)
+	} else if !MatchesLine(e.Patterns, "trusted.example.com") || MatchesLine(e.Patterns, "untrusted.example.com") {
+		t.Errorf("Unexpected matching for patterns %q", e.Patterns)
+	}
+
+	// Hashed line with a single address should have its pattern hashed, with key
+	// and comment intact
+	hashed, err := LineWithOptions([]string{"server.org:23"}, edKey, LineOptions{HashHostnames: true, Comment: "hashed"})
+	if err != nil {
+		t.Fatalf("Unexpected error from LineWithOptions with HashHostnames: %v", err)
+	}
+	if !strings.HasPrefix(hashed, "|1|") || !strings.HasSuffix(hashed, " "+edKeyStr+" hashed") {
+		t.Errorf("Unexpected hashed line from LineWithOptions: %q", hashed)
+	}
 }
 
 func TestWriteKnownHost(t *testing.T) {
@@ -195,11 +584,14 @@ func TestWriteKnownHost(t *testing.T) {
 		{hostname: "127.0.0.1", remoteAddr: "127.0.0.1:22", want: "127.0.0.1 " + edKeyStr + "\n"},
 		{hostname: "ipv4.test", remoteAddr: "192.168.0.1:23", want: "ipv4.test,[192.168.0.1]:23 " + edKeyStr + "\n"},
 		{hostname: "ipv6.test", remoteAddr: "[ff01::1234]:23", want: "ipv6.test,[ff01::1234]:23 " + edKeyStr + "\n"},
-		{hostname: "normal.zone", remoteAddr: "[fe80::1%en0]:22", want: "normal.zone,fe80::1%en0 " + edKeyStr + "\n"},
-		{hostname: "spaces.zone", remoteAddr: "[fe80::1%Ethernet  1]:22", want: "spaces.zone " + edKeyStr + "\n"},
-		{hostname: "spaces.zone", remoteAddr: "[fe80::1%Ethernet\t2]:23", want: "spaces.zone " + edKeyStr + "\n"},
-		{hostname: "[fe80::1%Ethernet 1]:22", err: "knownhosts: hostname 'fe80::1%Ethernet 1' contains spaces"},
-		{hostname: "[fe80::1%Ethernet\t2]:23", err: "knownhosts: hostname '[fe80::1%Ethernet\t2]:23' contains spaces"},
+		{hostname: "deploy@user.test:2222", remoteAddr: "192.168.0.1:2222", want: "[user.test]:2222,[192.168.0.1]:2222 " + edKeyStr + "\n"},
+		{hostname: "normal.zone", remoteAddr: "[fe80::1%en0]:22", want: "normal.zone,fe80::1 " + edKeyStr + "\n"},
+		{hostname: "spaces.zone", remoteAddr: "[fe80::1%Ethernet  1]:22", want: "spaces.zone,fe80::1 " + edKeyStr + "\n"},
+		{hostname: "spaces.zone", remoteAddr: "[fe80::1%Ethernet\t2]:23", want: "spaces.zone,[fe80::1]:23 " + edKeyStr + "\n"},
+		{hostname: "[fe80::1%Ethernet 1]:22", remoteAddr: "[fe80::1%Ethernet 1]:22", want: "fe80::1 " + edKeyStr + "\n"},
+		{hostname: "[fe80::1%Ethernet\t2]:23", remoteAddr: "0.0.0.0:0", want: "[fe80::1]:23 " + edKeyStr + "\n"},
+		{hostname: "bad host.test", err: "knownhosts: hostname 'bad host.test' contains spaces"},
+		{hostname: "[bad\thost.test]:23", err: "knownhosts: hostname '[bad\thost.test]:23' contains spaces"},
 	} {
 		remote, err := net.ResolveTCPAddr("tcp", m.remoteAddr)
 		if err != nil {
@@ -222,13 +614,388 @@ func TestWriteKnownHost(t *testing.T) {
 	}
 }
 
+// TestWriteKnownHostHostile confirms that no hostname, however malicious, can
+// cause WriteKnownHost to emit anything other than a single well-formed line
+// for the requested key.
+func TestWriteKnownHostHostile(t *testing.T) {
+	key := generatePubKeyEd25519(t)
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	hostnames := []string{
+		"a,b", "#comment", "|1|AAAA|BBBB", "@revoked", "!negated", "", "host\nevil.test ssh-ed25519 AAAA",
+		"host\rx", "host\x00", "host\x7f", "[#x]:22", "host ", "\thost", "host,*", "host:22,other",
+	}
+	rng := mathrand.New(mathrand.NewSource(1))
+	const alphabet = "ab.:[]%#|@!,* \t\r\n\x00\x7f"
+	for n := 0; n < 2000; n++ {
+		b := make([]byte, 1+rng.Intn(12))
+		for i := range b {
+			b[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		hostnames = append(hostnames, string(b))
+	}
+
+	for _, hostname := range hostnames {
+		var buf bytes.Buffer
+		err := WriteKnownHost(&buf, hostname, noAddr, key)
+		var hostErr *InvalidHostnameError
+		if err != nil {
+			if !errors.As(err, &hostErr) {
+				t.Errorf("Expected InvalidHostnameError from WriteKnownHost(%q), instead found %v", hostname, err)
+			} else if buf.Len() > 0 {
+				t.Errorf("Expected nothing written by WriteKnownHost(%q) after error, instead found %q", hostname, buf.String())
+			}
+			// LineWithOptions also accepts wildcard and negated patterns, which
+			// must still yield a single well-formed line
+			line, lineErr := LineWithOptions([]string{hostname}, key, LineOptions{})
+			if !isHostPattern(hostname) && !errors.As(lineErr, &hostErr) {
+				t.Errorf("Expected InvalidHostnameError from LineWithOptions(%q), instead found %v", hostname, lineErr)
+			} else if lineErr == nil {
+				if e, err := ParseLine([]byte(line)); err != nil || strings.Contains(line, "\n") || len(e.Patterns) != 1 || e.Marker != "" || keyString(e.Key) != keyString(key) {
+					t.Errorf("LineWithOptions(%q) returned %q, which parses as %+v, err=%v", hostname, line, e, err)
+				}
+			}
+			continue
+		}
+		if _, lineErr := LineWithOptions([]string{hostname}, key, LineOptions{}); lineErr != nil {
+			t.Errorf("LineWithOptions(%q) returned error %v, but WriteKnownHost succeeded", hostname, lineErr)
+		}
+		out := buf.String()
+		if strings.Count(out, "\n") != 1 || !strings.HasSuffix(out, "\n") {
+			t.Errorf("WriteKnownHost(%q) wrote %q, which is not exactly one line", hostname, out)
+			continue
+		}
+		e, err := ParseLine(buf.Bytes())
+		if err != nil || e.Key == nil || e.Marker != "" || len(e.Patterns) != 1 || e.Comment != "" || e.Hashed() {
+			t.Errorf("WriteKnownHost(%q) wrote %q, which parses as %+v, err=%v", hostname, out, e, err)
+		} else if e.Patterns[0] != Normalize(hostname) || keyString(e.Key) != keyString(key) {
+			t.Errorf("WriteKnownHost(%q) wrote %q, which does not match the requested hostname and key", hostname, out)
+		}
+	}
+}
+
+func TestWriteKnownHosts(t *testing.T) {
+	keys := []ssh.PublicKey{generatePubKeyEd25519(t), generatePubKeyECDSA(t)}
+	keys = append(keys, keys[0]) // duplicate should be skipped
+	remote, _ := net.ResolveTCPAddr("tcp", "192.168.0.1:23")
+	var got bytes.Buffer
+	if err := WriteKnownHosts(&got, "multi.test", remote, keys); err != nil {
+		t.Fatalf("Unexpected error from WriteKnownHosts: %v", err)
+	}
+	want := Line([]string{"multi.test", "192.168.0.1:23"}, keys[0]) + "\n" + Line([]string{"multi.test", "192.168.0.1:23"}, keys[1]) + "\n"
+	if got.String() != want {
+		t.Errorf("WriteKnownHosts returned %q, want %q", got.String(), want)
+	}
+
+	// Write failure should indicate which key failed, and stop writing
+	fw := &failingWriter{remaining: 1}
+	err := WriteKnownHosts(fw, "multi.test", remote, keys)
+	if err == nil || !strings.Contains(err.Error(), ssh.FingerprintSHA256(keys[1])) {
+		t.Errorf("Expected error from WriteKnownHosts to mention second key, instead found %v", err)
+	}
+	if fw.writes != 2 {
+		t.Errorf("Expected WriteKnownHosts to stop after first failed write, but %d writes were attempted", fw.writes)
+	}
+
+	// Invalid hostname should error before anything is written
+	if err := WriteKnownHosts(&got, "bad host", remote, keys); err == nil {
+		t.Error("Expected error from WriteKnownHosts with invalid hostname, but error was nil")
+	}
+}
+
+func TestWriteKnownHostAddresses(t *testing.T) {
+	key := generatePubKeyEd25519(t)
+	var got bytes.Buffer
+	addresses := []string{"host", "host.example.test:22", "192.0.2.10", "[host]:22", "[2001:db8::1]:2222"}
+	if err := WriteKnownHostAddresses(&got, addresses, key); err != nil {
+		t.Fatalf("Unexpected error from WriteKnownHostAddresses: %v", err)
+	}
+	want := "host,host.example.test,192.0.2.10,[2001:db8::1]:2222 " + keyString(key) + "\n"
+	if got.String() != want {
+		t.Errorf("WriteKnownHostAddresses returned %q, want %q", got.String(), want)
+	}
+
+	for _, bad := range [][]string{nil, {""}, {"host", "bad host"}, {"a,b"}, {"host\n"}} {
+		got.Reset()
+		if err := WriteKnownHostAddresses(&got, bad, key); err == nil {
+			t.Errorf("Expected error from WriteKnownHostAddresses(%q), but error was nil", bad)
+		} else if got.Len() > 0 {
+			t.Errorf("Expected nothing to be written for WriteKnownHostAddresses(%q), instead found %q", bad, got.String())
+		}
+	}
+}
+
+func TestWriteKnownHostPattern(t *testing.T) {
+	key := generatePubKeyEd25519(t)
+	cases := []struct {
+		pattern string
+		written string
+		broad   bool
+		matches []string
+		misses  []string
+	}{
+		{"*.Workers.internal", "*.workers.internal", false,
+			[]string{"a.workers.internal:22", "b.c.workers.internal:22"},
+			[]string{"workers.internal:22", "a.workers.internal:2222", "a.workers.internal.evil.test:22"}},
+		{"*.workers.internal:2222", "[*.workers.internal]:2222", false,
+			[]string{"a.workers.internal:2222"},
+			[]string{"a.workers.internal:22", "a.workers.internal:2223"}},
+		{"[*.workers.internal]:2222", "[*.workers.internal]:2222", false,
+			[]string{"a.workers.internal:2222"},
+			[]string{"a.workers.internal:22"}},
+		{"[*.workers.internal]:*", "[*.workers.internal]:*", false,
+			[]string{"a.workers.internal:2222", "b.c.workers.internal:2200"},
+			[]string{"a.workers.internal:22", "workers.internal:2222"}},
+		{"10.0.0.?", "10.0.0.?", false,
+			[]string{"10.0.0.1:22", "10.0.0.9:22"},
+			[]string{"10.0.0.10:22", "10.0.0.1:2222"}},
+		{"*", "*", true, []string{"anything.test:22", "192.0.2.1:22"}, nil},
+		{"*.*", "*.*", true, []string{"anything.test:22"}, nil},
+		{"[*]:2222", "[*]:2222", true, []string{"anything.test:2222"}, []string{"anything.test:22"}},
+	}
+	for _, c := range cases {
+		var got bytes.Buffer
+		warnings, err := WriteKnownHostPattern(&got, c.pattern, key)
+		if err != nil {
+			t.Errorf("Unexpected error from WriteKnownHostPattern(%q): %v", c.pattern, err)
+			continue
+		}
+		if want := c.written + " " + keyString(key) + "\n"; got.String() != want {
+			t.Errorf("WriteKnownHostPattern(%q) wrote %q, want %q", c.pattern, got.String(), want)
+		}
+		if broad := len(warnings) == 1 && warnings[0].Code == WarningBroadPattern; broad != c.broad || (!c.broad && len(warnings) > 0) {
+			t.Errorf("Unexpected warnings from WriteKnownHostPattern(%q): %v", c.pattern, warnings)
+		}
+
+		// The line read back must match the expected hosts, both by MatchesLine
+		// and by a callback from New
+		e, err := ParseLine(got.Bytes())
+		if err != nil {
+			t.Fatalf("Unexpected error from ParseLine: %v", err)
+		}
+		khPath := filepath.Join(t.TempDir(), "known_hosts")
+		if err := os.WriteFile(khPath, got.Bytes(), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %v", khPath, err)
+		}
+		kh, err := New(khPath)
+		if err != nil {
+			t.Fatalf("Unexpected error from New: %v", err)
+		}
+		noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+		for _, host := range c.matches {
+			if !MatchesLine(e.Patterns, host) {
+				t.Errorf("Expected pattern %q to match %s", c.written, host)
+			}
+			if err := kh(host, noAddr, key); err != nil {
+				t.Errorf("Expected callback for pattern %q to accept %s, instead found %v", c.written, host, err)
+			}
+		}
+		for _, host := range c.misses {
+			if MatchesLine(e.Patterns, host) {
+				t.Errorf("Expected pattern %q not to match %s", c.written, host)
+			}
+			if err := kh(host, noAddr, key); !IsHostUnknown(err) {
+				t.Errorf("Expected callback for pattern %q to reject %s as unknown, instead found %v", c.written, host, err)
+			}
+		}
+	}
+
+	for _, bad := range []string{"", "!*.workers.internal", "|1|abc=|def=", "*.workers internal", "a,*.b", "*\n"} {
+		var got bytes.Buffer
+		if _, err := WriteKnownHostPattern(&got, bad, key); err == nil || got.Len() > 0 {
+			t.Errorf("Expected error and no output from WriteKnownHostPattern(%q), instead found %v, %q", bad, err, got.String())
+		}
+	}
+	var got bytes.Buffer
+	if _, err := WriteKnownHostPattern(&got, "*.workers.internal", key, WithHashedHostnames()); err == nil || got.Len() > 0 {
+		t.Errorf("Expected error and no output when hashing a pattern, instead found %v, %q", err, got.String())
+	}
+	if _, err := WriteKnownHostPattern(&got, "*.workers.internal", key, WithComment("asg workers")); err != nil || !strings.HasSuffix(got.String(), " asg workers\n") {
+		t.Errorf("Unexpected result with WithComment: %v, %q", err, got.String())
+	}
+}
+
+// stringAddr is a custom net.Addr, such as a wrapper used for connections
+// through a ProxyCommand.
+type stringAddr string
+
+func (a stringAddr) Network() string { return "custom" }
+func (a stringAddr) String() string  { return string(a) }
+
+func TestNonTCPRemote(t *testing.T) {
+	key := generatePubKeyEd25519(t)
+	cases := map[net.Addr]string{
+		&net.UnixAddr{Name: "/run/ssh/host.sock", Net: "unix"}: "host.example.test",
+		&net.UnixAddr{Name: "@abstract", Net: "unix"}:          "host.example.test",
+		stringAddr("pipe:/usr/bin/ssh -W host:22 bastion"):     "host.example.test",
+		stringAddr("bastion.example.test:22"):                  "host.example.test",
+		stringAddr("192.0.2.5:22"):                             "host.example.test,192.0.2.5",
+		stringAddr("[fe80::1%eth0]:2222"):                      "host.example.test,[fe80::1]:2222",
+		nil:                                                    "host.example.test",
+	}
+	for remote, patterns := range cases {
+		var got bytes.Buffer
+		if err := WriteKnownHost(&got, "host.example.test:22", remote, key); err != nil {
+			t.Errorf("Unexpected error from WriteKnownHost with remote %v: %v", remote, err)
+		} else if want := patterns + " " + keyString(key) + "\n"; got.String() != want {
+			t.Errorf("WriteKnownHost with remote %v wrote %q, want %q", remote, got.String(), want)
+		}
+	}
+
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(khPath, []byte(Line([]string{"host.example.test"}, key)+"\n"), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	for remote := range cases {
+		if err := kh("host.example.test:22", remote, key); err != nil {
+			t.Errorf("Unexpected error from callback with remote %v: %v", remote, err)
+		}
+		if err := kh("other.example.test:22", remote, key); !IsHostUnknown(err) {
+			t.Errorf("Expected unknown host error from callback with remote %v, instead found %v", remote, err)
+		}
+	}
+
+	// Keys accepted by a policy are recorded under the hostname only
+	unixAddr := &net.UnixAddr{Name: "/run/ssh/new.sock", Net: "unix"}
+	cb := kh.WithPolicy(Policy{Mode: PolicyAcceptNew, AppendPath: khPath})
+	if err := cb("new.example.test:22", unixAddr, key); err != nil {
+		t.Fatalf("Unexpected error from callback with policy: %v", err)
+	}
+	entries, err := ReadEntries(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadEntries: %v", err)
+	}
+	if len(entries) != 2 || strings.Join(entries[1].Patterns, ",") != "new.example.test" {
+		t.Errorf("Expected appended entry for new.example.test only, instead found %+v", entries)
+	}
+}
+
+func TestWriteKnownHostCA(t *testing.T) {
+	edKeyStr := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIF9Wn63tLEhSWl9Ye+4x2GnruH8cq0LIh2vum/fUHrFQ"
+	edKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(edKeyStr))
+	if err != nil {
+		t.Fatalf("Unable to parse authorized key: %v", err)
+	}
+	for _, m := range []struct {
+		patterns []string
+		want     string
+		err      bool
+	}{
+		{patterns: []string{"*.example.test"}, want: "@cert-authority *.example.test " + edKeyStr + "\n"},
+		{patterns: []string{"*.example.test", "[*.example.test]:2222", "host:22"}, want: "@cert-authority *.example.test,[*.example.test]:2222,host " + edKeyStr + "\n"},
+		{patterns: nil, err: true},
+		{patterns: []string{""}, err: true},
+		{patterns: []string{"*.example.test", "bad pattern"}, err: true},
+		{patterns: []string{"a.example.test,b.example.test"}, err: true},
+	} {
+		var got bytes.Buffer
+		err := WriteKnownHostCA(&got, m.patterns, edKey)
+		if m.err {
+			if err == nil {
+				t.Errorf("WriteKnownHostCA(%q) expected error, but error was nil", m.patterns)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error from WriteKnownHostCA(%q): %v", m.patterns, err)
+		} else if got.String() != m.want {
+			t.Errorf("WriteKnownHostCA(%q) = %q, want %q", m.patterns, got.String(), m.want)
+		}
+	}
+}
+
+// TestCertAuthorityScope confirms that a @cert-authority line only trusts its
+// CA for hosts matching the line's patterns, including hashed, wildcard, and
+// negated patterns, regardless of the principals in the certificate.
+func TestCertAuthorityScope(t *testing.T) {
+	prodCA, testCA, hashedCA := generateSignerEd25519(t), generateSignerEd25519(t), generateSignerEd25519(t)
+	hashed, _ := HashHostname("hashed.example.com")
+	kh, err := NewFromEntries([]Entry{
+		{Marker: MarkerCertAuthority, Patterns: []string{"*.prod.example.com", "!db.prod.example.com"}, Key: prodCA.PublicKey()},
+		{Marker: MarkerCertAuthority, Patterns: []string{"*.test.example.com"}, Key: testCA.PublicKey()},
+		{Marker: MarkerCertAuthority, Patterns: []string{hashed}, Key: hashedCA.PublicKey()},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromEntries: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	hostKey := generatePubKeyEd25519(t)
+	cases := []struct {
+		ca       ssh.Signer
+		host     string
+		expectOK bool
+	}{
+		{prodCA, "web.prod.example.com", true},
+		{testCA, "web.test.example.com", true},
+		{hashedCA, "hashed.example.com", true},
+		{prodCA, "evil.test.example.com", false},
+		{testCA, "web.prod.example.com", false},
+		{prodCA, "db.prod.example.com", false},
+		{hashedCA, "other.example.com", false},
+		{hashedCA, "web.prod.example.com", false},
+	}
+	for _, tc := range cases {
+		// Each certificate's principals include the host, so only the CA's scope
+		// determines the outcome
+		cert := generateHostCert(t, tc.ca, hostKey, tc.host)
+		err := kh(tc.host+":22", noAddr, cert)
+		if tc.expectOK && err != nil {
+			t.Errorf("Expected certificate for %s to be trusted, instead found %v", tc.host, err)
+		} else if !tc.expectOK && err == nil {
+			t.Errorf("Expected certificate for %s to be rejected by CA scope, but it was trusted", tc.host)
+		}
+	}
+}
+
+func TestAppendKnownHostCA(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	caSigner := generateSignerEd25519(t)
+	if err := AppendKnownHostCA(khPath, []string{"*.ca.example.test"}, caSigner.PublicKey()); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHostCA: %v", err)
+	}
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+
+	// A host certificate signed by the CA should be accepted for a host matching
+	// the pattern, but not for other hosts
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	cert := generateHostCert(t, caSigner, generatePubKeyEd25519(t), "host.ca.example.test", "other.example.test")
+	if err := kh("host.ca.example.test:22", noAddr, cert); err != nil {
+		t.Errorf("Unexpected error from callback for host certificate signed by trusted CA: %v", err)
+	}
+	if err := kh("other.example.test:22", noAddr, cert); err == nil {
+		t.Error("Expected error from callback for host certificate presented by host not matching CA pattern, but error was nil")
+	}
+}
+
+// failingWriter is an io.Writer which returns an error once its remaining
+// count of successful writes reaches zero.
+type failingWriter struct {
+	remaining int
+	writes    int
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	fw.writes++
+	if fw.remaining <= 0 {
+		return 0, errors.New("write failed")
+	}
+	fw.remaining--
+	return len(p), nil
+}
+
 var testKnownHostsContents []byte
 
 // getTestKnownHosts returns a path to a test known_hosts file. The file path
 // will differ between test functions, but the contents are always the same,
 // containing keys generated upon the first invocation. The file is removed
 // upon test completion.
-func getTestKnownHosts(t *testing.T) string {
+func getTestKnownHosts(t testing.TB) string {
 	// Re-use previously memoized result
 	if len(testKnownHostsContents) > 0 {
 		dir := t.TempDir()
@@ -250,7 +1017,7 @@ func getTestKnownHosts(t *testing.T) string {
 // file path to it. The generated file contains several hosts with a mix of
 // key types; each known host has between 1 and 4 different known host keys.
 // If generating or writing the file fails, the test fails.
-func writeTestKnownHosts(t *testing.T) string {
+func writeTestKnownHosts(t testing.TB) string {
 	t.Helper()
 	hosts := map[string][]ssh.PublicKey{
 		"only-rsa.example.test:22":     {generatePubKeyRSA(t)},
@@ -279,7 +1046,7 @@ func writeTestKnownHosts(t *testing.T) string {
 	return khPath
 }
 
-func generatePubKeyRSA(t *testing.T) ssh.PublicKey {
+func generatePubKeyRSA(t testing.TB) ssh.PublicKey {
 	t.Helper()
 	privKey, err := rsa.GenerateKey(rand.Reader, 4096)
 	if err != nil {
@@ -292,7 +1059,7 @@ func generatePubKeyRSA(t *testing.T) ssh.PublicKey {
 	return pub
 }
 
-func generatePubKeyECDSA(t *testing.T) ssh.PublicKey {
+func generatePubKeyECDSA(t testing.TB) ssh.PublicKey {
 	t.Helper()
 	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -305,7 +1072,7 @@ func generatePubKeyECDSA(t *testing.T) ssh.PublicKey {
 	return pub
 }
 
-func generatePubKeyEd25519(t *testing.T) ssh.PublicKey {
+func generatePubKeyEd25519(t testing.TB) ssh.PublicKey {
 	t.Helper()
 	rawPub, _, err := ed25519.GenerateKey(nil)
 	if err != nil {
@@ -317,3 +1084,163 @@ func generatePubKeyEd25519(t *testing.T) ssh.PublicKey {
 	}
 	return pub
 }
+
+func generateSignerEd25519(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, privKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Unable to generate ed25519 key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(privKey)
+	if err != nil {
+		t.Fatalf("Unable to create signer: %v", err)
+	}
+	return signer
+}
+
+func generateSignerECDSA(t *testing.T) ssh.Signer {
+	t.Helper()
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate ECDSA key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(privKey)
+	if err != nil {
+		t.Fatalf("Unable to create signer: %v", err)
+	}
+	return signer
+}
+
+// generateHostCert returns a host certificate for key, signed by caSigner and
+// valid for the supplied principals.
+func generateHostCert(t *testing.T, caSigner ssh.Signer, key ssh.PublicKey, principals ...string) *ssh.Certificate {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.HostCert,
+		ValidPrincipals: principals,
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatalf("Unable to sign host certificate: %v", err)
+	}
+	return cert
+}
+
+func TestHostKeysAddressForms(t *testing.T) {
+	key22, key2222 := generatePubKeyEd25519(t), generatePubKeyECDSA(t)
+	ip22, ip2222 := generatePubKeyRSA(t), generatePubKeyEd25519(t)
+	kh, err := NewFromEntries([]Entry{
+		{Patterns: []string{"example.test"}, Key: key22},
+		{Patterns: []string{"[example.test]:2222"}, Key: key2222},
+		{Patterns: []string{"2001:db8::1"}, Key: ip22},
+		{Patterns: []string{"[2001:db8::1]:2222"}, Key: ip2222},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromEntries: %v", err)
+	}
+	cases := []struct {
+		address string
+		want    ssh.PublicKey
+		exact   bool // whether HostKeysExact also finds the key
+	}{
+		{"example.test", key22, false},
+		{"Example.Test.", key22, false},
+		{"example.test:22", key22, true},
+		{"[example.test]:22", key22, true},
+		{"user@example.test", key22, false},
+		{"example.test:2222", key2222, true},
+		{"[example.test]:2222", key2222, true},
+		{"2001:db8::1", ip22, false},
+		{"[2001:db8::1]", ip22, false},
+		{"[2001:db8::1]:22", ip22, true},
+		{"[2001:db8::1]:2222", ip2222, true},
+	}
+	for _, tc := range cases {
+		if keys := kh.HostKeys(tc.address); len(keys) != 1 || !KeysEqual(keys[0], tc.want) {
+			t.Errorf("Unexpected result from HostKeys(%q): %v", tc.address, keys)
+		}
+		if algos := kh.HostKeyAlgorithms(tc.address); len(algos) == 0 || algos[len(algos)-1] != tc.want.Type() {
+			t.Errorf("Unexpected result from HostKeyAlgorithms(%q): %v", tc.address, algos)
+		}
+		if !kh.Exists(tc.address, tc.want) {
+			t.Errorf("Expected Exists(%q) to return true", tc.address)
+		}
+		if keys := kh.HostKeysExact(tc.address); tc.exact != (len(keys) == 1) {
+			t.Errorf("Unexpected result from HostKeysExact(%q): %v", tc.address, keys)
+		}
+		if algos := kh.HostKeyAlgorithmsExact(tc.address); tc.exact != (len(algos) > 0) {
+			t.Errorf("Unexpected result from HostKeyAlgorithmsExact(%q): %v", tc.address, algos)
+		}
+	}
+
+	// Ports are never interchangeable
+	for _, address := range []string{"example.test:2200", "[2001:db8::1]:2200", "other.test"} {
+		if keys := kh.HostKeys(address); len(keys) > 0 {
+			t.Errorf("Expected no keys from HostKeys(%q), instead found %v", address, keys)
+		}
+	}
+}
+
+func TestUnknownHostError(t *testing.T) {
+	khPath := getTestKnownHosts(t)
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	key := generatePubKeyEd25519(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+	err = kh("New.Example.Test:22", remote, key)
+	var unknownErr *UnknownHostError
+	if !IsHostUnknown(err) || !errors.As(err, &unknownErr) {
+		t.Fatalf("Expected *UnknownHostError, instead found %T %v", err, err)
+	}
+	expectLine := "new.example.test,192.0.2.1 " + keyString(key)
+	if unknownErr.Host != "New.Example.Test:22" || unknownErr.Remote != remote || !KeysEqual(unknownErr.Key, key) || unknownErr.SuggestedLine != expectLine {
+		t.Errorf("Unexpected fields in *UnknownHostError: %+v", unknownErr)
+	}
+	if err.Error() != "knownhosts: key is unknown" {
+		t.Errorf("Unexpected error text: %q", err.Error())
+	}
+
+	// The line is still available after further wrapping, such as by ssh.Dial
+	wrapped := fmt.Errorf("ssh: handshake failed: %w", err)
+	if line, ok := SuggestedLineFromError(wrapped); !ok || line != expectLine {
+		t.Errorf("Unexpected result from SuggestedLineFromError: %q, %t", line, ok)
+	}
+	if !IsHostUnknown(kh.WithPolicy(Policy{Mode: PolicyStrict})("new.example.test:22", remote, key)) {
+		t.Error("Expected policy callback to return unknown host error")
+	}
+
+	// Other errors do not carry a line
+	known := kh.HostKeys("only-ed25519.example.test:22")
+	if len(known) == 0 {
+		t.Fatal("Test known_hosts is missing expected host")
+	}
+	err = kh("only-ed25519.example.test:22", remote, key)
+	if _, ok := SuggestedLineFromError(err); ok || !IsHostKeyChanged(err) {
+		t.Errorf("Expected changed key error without suggested line, instead found %v", err)
+	}
+	if _, ok := SuggestedLineFromError(nil); ok {
+		t.Error("Expected SuggestedLineFromError(nil) to return false")
+	}
+
+	// WithSuggestedLines applies write options, and also works with callbacks
+	// from golang.org/x/crypto/ssh/knownhosts
+	xcb, err := xknownhosts.New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from xknownhosts.New: %v", err)
+	}
+	for _, cb := range []HostKeyCallback{kh, HostKeyCallback(xcb)} {
+		line, ok := SuggestedLineFromError(cb.WithSuggestedLines(WithHashedHostnames())("new.example.test:22", remote, key))
+		lines := strings.Split(line, "\n")
+		if !ok || len(lines) != 2 {
+			t.Fatalf("Unexpected suggested line with WithHashedHostnames: %q", line)
+		}
+		for n, address := range []string{"new.example.test", "192.0.2.1"} {
+			if e, err := ParseLine([]byte(lines[n])); err != nil || !e.Hashed() || !MatchesLine(e.Patterns, address) {
+				t.Errorf("Unexpected hashed suggested line %q for %s: %+v, %v", lines[n], address, e, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Package knownhoststest provides helpers for tests of code which uses
+// github.com/skeema/knownhosts: generating host keys, writing known_hosts
+// files to temporary directories, and canned fixtures covering hashed entries,
+// @cert-authority lines, and @revoked lines.
+//
+// All helpers accept a testing.TB, fail the test immediately upon error, and
+// arrange for any files they create to be removed when the test completes.
+package knownhoststest
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+)
+
+// GenerateSigner returns a new random host key of the supplied key type, which
+// may be ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384,
+// ssh.KeyAlgoECDSA521, or ssh.KeyAlgoRSA. RSA keys are 2048 bits. Any other
+// key type fails the test.
+func GenerateSigner(tb testing.TB, keyType string) ssh.Signer {
+	tb.Helper()
+	var privKey interface{}
+	var err error
+	switch keyType {
+	case ssh.KeyAlgoED25519:
+		_, privKey, err = ed25519.GenerateKey(rand.Reader)
+	case ssh.KeyAlgoECDSA256:
+		privKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ssh.KeyAlgoECDSA384:
+		privKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case ssh.KeyAlgoECDSA521:
+		privKey, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case ssh.KeyAlgoRSA:
+		privKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		tb.Fatalf("knownhoststest: unsupported key type %q", keyType)
+	}
+	if err != nil {
+		tb.Fatalf("knownhoststest: unable to generate %s key: %v", keyType, err)
+	}
+	signer, err := ssh.NewSignerFromKey(privKey)
+	if err != nil {
+		tb.Fatalf("knownhoststest: unable to create signer: %v", err)
+	}
+	return signer
+}
+
+// GenerateKey returns the public key of a new random host key of the supplied
+// key type, as described in GenerateSigner.
+func GenerateKey(tb testing.TB, keyType string) ssh.PublicKey {
+	tb.Helper()
+	return GenerateSigner(tb, keyType).PublicKey()
+}
+
+// WriteTempKnownHosts writes the supplied entries to a known_hosts file in a
+// temporary directory, and returns the file's path. Entries are written using
+// Entry.Format, so patterns are written as-is. The file is removed when the
+// test completes.
+func WriteTempKnownHosts(tb testing.TB, entries ...knownhosts.Entry) string {
+	tb.Helper()
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(e.Format() + "\n")
+	}
+	path := filepath.Join(tb.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		tb.Fatalf("knownhoststest: unable to write %s: %v", path, err)
+	}
+	return path
+}
+
+// FakeRemote returns a net.Addr for use as the remote argument of a host key
+// callback. If addr lacks a port, port 22 is used. If the host portion of addr
+// is an IP address, the result is a *net.TCPAddr; otherwise, it is an address
+// whose String method returns addr in host:port form. No DNS lookups are
+// performed.
+func FakeRemote(addr string) net.Addr {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = strings.Trim(addr, "[]"), "22"
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if portNum, err := net.LookupPort("tcp", port); err == nil {
+			return &net.TCPAddr{IP: ip, Port: portNum}
+		}
+	}
+	return fakeAddr(net.JoinHostPort(host, port))
+}
+
+// fakeAddr is a net.Addr for a remote host which is not an IP address.
+type fakeAddr string
+
+func (fa fakeAddr) Network() string { return "tcp" }
+func (fa fakeAddr) String() string  { return string(fa) }
+
+// Fixtures contains canned known_hosts entries, along with the signers for
+// their keys. Create them using NewFixtures.
+type Fixtures struct {
+	// MultiKey has an entry for each of ed25519, ECDSA, and RSA keys, for host
+	// MultiKeyHost.
+	MultiKeyHost    string
+	MultiKeySigners []ssh.Signer
+	MultiKey        []knownhosts.Entry
+
+	// Hashed is an entry with a hashed pattern for host HashedHost.
+	HashedHost   string
+	HashedSigner ssh.Signer
+	Hashed       knownhosts.Entry
+
+	// CertAuthority is a @cert-authority entry trusting CASigner for hosts
+	// matching CertAuthorityPattern.
+	CertAuthorityPattern string
+	CASigner             ssh.Signer
+	CertAuthority        knownhosts.Entry
+
+	// Revoked is a @revoked entry for the key of RevokedSigner, which is also
+	// listed in an ordinary entry for host RevokedHost.
+	RevokedHost   string
+	RevokedSigner ssh.Signer
+	Revoked       []knownhosts.Entry
+}
+
+// NewFixtures generates a new set of canned fixtures using random keys. The
+// hosts use names under example.test.
+func NewFixtures(tb testing.TB) *Fixtures {
+	tb.Helper()
+	f := &Fixtures{
+		MultiKeyHost:         "multi.example.test",
+		HashedHost:           "hashed.example.test",
+		CertAuthorityPattern: "*.ca.example.test",
+		RevokedHost:          "revoked.example.test",
+	}
+	for _, keyType := range []string{ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoRSA} {
+		signer := GenerateSigner(tb, keyType)
+		f.MultiKeySigners = append(f.MultiKeySigners, signer)
+		f.MultiKey = append(f.MultiKey, knownhosts.Entry{Patterns: []string{f.MultiKeyHost}, Key: signer.PublicKey()})
+	}
+
+	f.HashedSigner = GenerateSigner(tb, ssh.KeyAlgoED25519)
+	hashed, err := knownhosts.HashHostname(f.HashedHost)
+	if err != nil {
+		tb.Fatalf("knownhoststest: unable to hash hostname: %v", err)
+	}
+	f.Hashed = knownhosts.Entry{Patterns: []string{hashed}, Key: f.HashedSigner.PublicKey()}
+
+	f.CASigner = GenerateSigner(tb, ssh.KeyAlgoED25519)
+	f.CertAuthority = knownhosts.Entry{Marker: knownhosts.MarkerCertAuthority, Patterns: []string{f.CertAuthorityPattern}, Key: f.CASigner.PublicKey()}
+
+	f.RevokedSigner = GenerateSigner(tb, ssh.KeyAlgoED25519)
+	f.Revoked = []knownhosts.Entry{
+		{Patterns: []string{f.RevokedHost}, Key: f.RevokedSigner.PublicKey()},
+		{Marker: knownhosts.MarkerRevoked, Patterns: []string{"*"}, Key: f.RevokedSigner.PublicKey()},
+	}
+	return f
+}
+
+// Entries returns all of the fixtures' entries.
+func (f *Fixtures) Entries() []knownhosts.Entry {
+	entries := append([]knownhosts.Entry{}, f.MultiKey...)
+	entries = append(entries, f.Hashed, f.CertAuthority)
+	return append(entries, f.Revoked...)
+}
+
+// HostCertSigner returns a signer for a new host certificate for hostname,
+// signed by f.CASigner, suitable for use as a host key by an SSH server.
+// hostname should match f.CertAuthorityPattern for the certificate to be
+// trusted.
+func (f *Fixtures) HostCertSigner(tb testing.TB, hostname string) ssh.Signer {
+	tb.Helper()
+	hostSigner := GenerateSigner(tb, ssh.KeyAlgoED25519)
+	cert := &ssh.Certificate{
+		Key:             hostSigner.PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{hostname},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, f.CASigner); err != nil {
+		tb.Fatalf("knownhoststest: unable to sign certificate: %v", err)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, hostSigner)
+	if err != nil {
+		tb.Fatalf("knownhoststest: unable to create certificate signer: %v", err)
+	}
+	return certSigner
+}
@@ -0,0 +1,82 @@
+package knownhoststest
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestGenerateKey(t *testing.T) {
+	for _, keyType := range []string{ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521, ssh.KeyAlgoRSA} {
+		if key := GenerateKey(t, keyType); key.Type() != keyType {
+			t.Errorf("Expected GenerateKey to return a %s key, instead found %s", keyType, key.Type())
+		}
+	}
+	if a, b := GenerateKey(t, ssh.KeyAlgoED25519), GenerateKey(t, ssh.KeyAlgoED25519); string(a.Marshal()) == string(b.Marshal()) {
+		t.Error("Expected GenerateKey to return different keys on each call")
+	}
+}
+
+func TestFakeRemote(t *testing.T) {
+	for input, expected := range map[string]string{
+		"10.0.0.5":              "10.0.0.5:22",
+		"10.0.0.5:2222":         "10.0.0.5:2222",
+		"[fe80::1]:2222":        "[fe80::1]:2222",
+		"fe80::1":               "[fe80::1]:22",
+		"host.example.test":     "host.example.test:22",
+		"host.example.test:222": "host.example.test:222",
+	} {
+		addr := FakeRemote(input)
+		if addr.String() != expected || addr.Network() != "tcp" {
+			t.Errorf("Unexpected result from FakeRemote(%q): %s %s", input, addr.Network(), addr)
+		}
+	}
+	if _, ok := FakeRemote("10.0.0.5").(*net.TCPAddr); !ok {
+		t.Error("Expected FakeRemote to return a *net.TCPAddr for an IP address")
+	}
+}
+
+func TestFixtures(t *testing.T) {
+	f := NewFixtures(t)
+	kh, err := knownhosts.New(WriteTempKnownHosts(t, f.Entries()...))
+	if err != nil {
+		t.Fatalf("Unexpected error from knownhosts.New: %v", err)
+	}
+
+	multiHost := f.MultiKeyHost + ":22"
+	if keys := kh.HostKeys(multiHost); len(keys) != 3 {
+		t.Errorf("Expected 3 keys for %s, instead found %d", multiHost, len(keys))
+	}
+	for _, signer := range f.MultiKeySigners {
+		if err := kh(multiHost, FakeRemote(multiHost), signer.PublicKey()); err != nil {
+			t.Errorf("Unexpected error from callback for %s key: %v", signer.PublicKey().Type(), err)
+		}
+	}
+
+	hashedHost := f.HashedHost + ":22"
+	if err := kh(hashedHost, FakeRemote(hashedHost), f.HashedSigner.PublicKey()); err != nil {
+		t.Errorf("Unexpected error from callback for hashed host: %v", err)
+	}
+	if err := kh(hashedHost, FakeRemote(hashedHost), GenerateKey(t, ssh.KeyAlgoED25519)); !knownhosts.IsHostKeyChanged(err) {
+		t.Errorf("Expected host key changed error for hashed host, instead found %v", err)
+	}
+
+	certHost := "server.ca.example.test"
+	cert := f.HostCertSigner(t, certHost).PublicKey()
+	if err := kh(certHost+":22", FakeRemote(certHost), cert); err != nil {
+		t.Errorf("Unexpected error from callback for host certificate: %v", err)
+	}
+	if err := kh("other.example.test:22", FakeRemote("other.example.test"), cert); err == nil {
+		t.Error("Expected error from callback for host certificate outside of CA pattern, but error was nil")
+	}
+
+	revokedHost := f.RevokedHost + ":22"
+	var revokedErr *xknownhosts.RevokedError
+	if err := kh(revokedHost, FakeRemote(revokedHost), f.RevokedSigner.PublicKey()); !errors.As(err, &revokedErr) {
+		t.Errorf("Expected RevokedError, instead found %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package knownhoststest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Server is an in-process SSH server listening on a random loopback port,
+// for exercising host key callbacks in a genuine SSH handshake. It permits
+// clients without authentication, and rejects all channels and requests.
+type Server struct {
+	// Addr is the server's address in host:port form.
+	Addr string
+
+	// Signers are the server's host keys, which may include certificates.
+	Signers []ssh.Signer
+
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	announce []ssh.Signer
+}
+
+// OpenSSH request types used by AnnounceHostKeys.
+const (
+	hostKeysRequest      = "hostkeys-00@openssh.com"
+	hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+)
+
+// StartServer starts a Server using the supplied host keys, at least one of
+// which must be supplied. Host certificates, such as those returned by
+// Fixtures.HostCertSigner, may be included. The server is stopped when the
+// test completes.
+func StartServer(tb testing.TB, hostKeys ...ssh.Signer) *Server {
+	tb.Helper()
+	if len(hostKeys) == 0 {
+		tb.Fatal("knownhoststest: StartServer requires at least one host key")
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	for _, hostKey := range hostKeys {
+		config.AddHostKey(hostKey)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("knownhoststest: unable to listen: %v", err)
+	}
+	s := &Server{
+		Addr:    ln.Addr().String(),
+		Signers: hostKeys,
+		ln:      ln,
+	}
+	s.wg.Add(1)
+	go s.serve(config)
+	tb.Cleanup(func() {
+		ln.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+// serve accepts connections until the listener is closed.
+func (s *Server) serve(config *ssh.ServerConfig) {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(30 * time.Second))
+			sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				return
+			}
+			go func() {
+				for newChan := range chans {
+					newChan.Reject(ssh.Prohibited, "knownhoststest server does not permit channels")
+				}
+			}()
+			s.mu.Lock()
+			announce := s.announce
+			s.mu.Unlock()
+			if len(announce) == 0 {
+				go ssh.DiscardRequests(reqs)
+			} else {
+				go serveHostKeys(sconn, reqs, announce)
+			}
+			sconn.Wait()
+		}()
+	}
+}
+
+// AnnounceHostKeys causes the server to send a hostkeys-00@openssh.com request
+// listing the public keys of signers after each subsequent handshake, and to
+// answer hostkeys-prove-00@openssh.com requests for those keys by signing with
+// signers, as OpenSSH servers do for clients which enable UpdateHostKeys. This
+// permits testing knownhosts.HandleHostKeyUpdates. To simulate a server which
+// cannot prove possession of a key, supply a signer whose PublicKey method
+// returns a key other than its own.
+func (s *Server) AnnounceHostKeys(signers ...ssh.Signer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.announce = signers
+}
+
+// serveHostKeys announces the public keys of signers on sconn, then answers
+// proof requests for them until reqs is closed. All other requests are
+// rejected.
+func serveHostKeys(sconn *ssh.ServerConn, reqs <-chan *ssh.Request, signers []ssh.Signer) {
+	var payload []byte
+	for _, signer := range signers {
+		payload = append(payload, ssh.Marshal(struct{ Blob []byte }{signer.PublicKey().Marshal()})...)
+	}
+	sconn.SendRequest(hostKeysRequest, false, payload)
+	for req := range reqs {
+		if req.Type != hostKeysProveRequest {
+			req.Reply(false, nil)
+			continue
+		}
+		response, ok := proveHostKeys(sconn.SessionID(), req.Payload, signers)
+		req.Reply(ok, response)
+	}
+}
+
+// proveHostKeys returns the response to a hostkeys-prove-00@openssh.com
+// request with the supplied payload, which must only list keys of signers.
+func proveHostKeys(sessionID, payload []byte, signers []ssh.Signer) (response []byte, ok bool) {
+	for len(payload) > 0 {
+		var blob struct {
+			Value []byte
+			Rest  []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(payload, &blob); err != nil {
+			return nil, false
+		}
+		payload = blob.Rest
+		var sig *ssh.Signature
+		for _, signer := range signers {
+			if pub := signer.PublicKey(); bytes.Equal(pub.Marshal(), blob.Value) {
+				data := ssh.Marshal(struct {
+					Request   string
+					SessionID []byte
+					Blob      []byte
+				}{hostKeysProveRequest, sessionID, blob.Value})
+				if sig, _ = signer.Sign(rand.Reader, data); sig != nil {
+					break
+				}
+			}
+		}
+		if sig == nil {
+			return nil, false
+		}
+		response = append(response, ssh.Marshal(struct{ Blob []byte }{ssh.Marshal(sig)})...)
+	}
+	return response, true
+}
+
+// Handshake connects to the server and performs an SSH handshake using config,
+// then disconnects. The hostname passed to config.HostKeyCallback is
+// hostWithPort, or s.Addr if hostWithPort is empty; this permits testing
+// lookups of hostnames, rather than only of the loopback address. If
+// config.User is empty, "test" is used. The handshake error is returned as-is;
+// note that golang.org/x/crypto/ssh does not wrap host key callback errors, so
+// their types are not preserved.
+func (s *Server) Handshake(hostWithPort string, config *ssh.ClientConfig) error {
+	if hostWithPort == "" {
+		hostWithPort = s.Addr
+	}
+	cfg := *config
+	if cfg.User == "" {
+		cfg.User = "test"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	conn, err := net.DialTimeout("tcp", s.Addr, cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(cfg.Timeout))
+	c, chans, reqs, err := ssh.NewClientConn(conn, hostWithPort, &cfg)
+	if err != nil {
+		return err
+	}
+	return ssh.NewClient(c, chans, reqs).Close()
+}
+
+// HostKeys returns the public keys of the server's host keys.
+func (s *Server) HostKeys() []ssh.PublicKey {
+	keys := make([]ssh.PublicKey, len(s.Signers))
+	for n, signer := range s.Signers {
+		keys[n] = signer.PublicKey()
+	}
+	return keys
+}
@@ -0,0 +1,43 @@
+package knownhoststest
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestStartServer(t *testing.T) {
+	signer := GenerateSigner(t, ssh.KeyAlgoED25519)
+	s := StartServer(t, signer)
+	if keys := s.HostKeys(); len(keys) != 1 || string(keys[0].Marshal()) != string(signer.PublicKey().Marshal()) {
+		t.Errorf("Unexpected result from HostKeys: %v", keys)
+	}
+
+	var seenHost string
+	var seenKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			seenHost, seenKey = hostname, key
+			return nil
+		},
+	}
+	if err := s.Handshake("", config); err != nil {
+		t.Fatalf("Unexpected error from Handshake: %v", err)
+	} else if seenHost != s.Addr || string(seenKey.Marshal()) != string(signer.PublicKey().Marshal()) {
+		t.Errorf("Unexpected callback arguments: %s %v", seenHost, seenKey)
+	}
+	if err := s.Handshake("named.example.test:22", config); err != nil {
+		t.Fatalf("Unexpected error from Handshake: %v", err)
+	} else if seenHost != "named.example.test:22" {
+		t.Errorf("Expected callback to receive supplied hostname, instead found %s", seenHost)
+	}
+
+	config.HostKeyCallback = func(string, net.Addr, ssh.PublicKey) error {
+		return errors.New("rejected")
+	}
+	if err := s.Handshake("", config); err == nil {
+		t.Error("Expected error from Handshake when callback rejects key, but error was nil")
+	}
+}
@@ -0,0 +1,211 @@
+package knownhosts
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// Default limits applied when reading known_hosts files, unless overridden
+// using ReadOption values. These are far beyond the size of any legitimate
+// known_hosts file, and only serve to bound the resources consumed by a
+// corrupted or malicious one.
+const (
+	DefaultMaxFileSize   int64 = 1 << 30  // 1 GiB per file
+	DefaultMaxLineLength       = 1 << 20  // 1 MiB per line, excluding the line ending
+	DefaultMaxEntries          = 10000000 // per file, excluding comments and blank lines
+)
+
+// ReadOption configures how known_hosts files are read by functions such as
+// ReadEntriesWithOptions, NewWithOptions, and NewLowMemoryWithOptions, such as
+// the limits on their size, and the behavior of any resulting callback.
+type ReadOption func(*readOptions)
+
+type readOptions struct {
+	maxFileSize   int64
+	maxLineLength int
+	maxEntries    int
+	now           func() time.Time // see WithCertClock
+}
+
+func newReadOptions(opts []ReadOption) readOptions {
+	ro := readOptions{
+		maxFileSize:   DefaultMaxFileSize,
+		maxLineLength: DefaultMaxLineLength,
+		maxEntries:    DefaultMaxEntries,
+	}
+	for _, opt := range opts {
+		opt(&ro)
+	}
+	return ro
+}
+
+// WithMaxFileSize sets the maximum size of each known_hosts file, in bytes.
+// Reading a larger file fails with a *LimitError. A value of zero or less
+// removes the limit. The default is DefaultMaxFileSize.
+func WithMaxFileSize(n int64) ReadOption {
+	return func(ro *readOptions) {
+		ro.maxFileSize = n
+	}
+}
+
+// WithMaxLineLength sets the maximum length of each known_hosts line, in
+// bytes, excluding the line ending. Reading a longer line fails with a
+// *LimitError, without buffering the rest of the line. A value of zero or less
+// removes the limit. The default is DefaultMaxLineLength.
+func WithMaxLineLength(n int) ReadOption {
+	return func(ro *readOptions) {
+		ro.maxLineLength = n
+	}
+}
+
+// WithMaxEntries sets the maximum number of entries in each known_hosts file,
+// not counting comments and blank lines. Reading a file with more entries fails
+// with a *LimitError. A value of zero or less removes the limit. The default is
+// DefaultMaxEntries.
+func WithMaxEntries(n int) ReadOption {
+	return func(ro *readOptions) {
+		ro.maxEntries = n
+	}
+}
+
+// Limit identifies one of the limits configured by a ReadOption.
+type Limit int
+
+// Constants representing possible values of LimitError.Limit.
+const (
+	LimitFileSize   Limit = iota // see WithMaxFileSize
+	LimitLineLength              // see WithMaxLineLength
+	LimitEntries                 // see WithMaxEntries
+)
+
+// String returns a description of the limit, such as "line length".
+func (l Limit) String() string {
+	switch l {
+	case LimitFileSize:
+		return "file size"
+	case LimitLineLength:
+		return "line length"
+	case LimitEntries:
+		return "entries"
+	}
+	return "limit"
+}
+
+// LimitError is returned when a known_hosts file exceeds one of the limits
+// configured by WithMaxFileSize, WithMaxLineLength, or WithMaxEntries, or
+// their defaults.
+type LimitError struct {
+	Limit    Limit
+	Max      int64
+	Filename string
+	Line     int // 1-based line number at which the limit was exceeded, if known
+}
+
+// Error satisfies the error interface.
+func (le *LimitError) Error() string {
+	location := le.Filename
+	if le.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, le.Line)
+	}
+	return fmt.Sprintf("knownhosts: %s: exceeds maximum %s of %d", location, le.Limit, le.Max)
+}
+
+// openLimited opens the known_hosts file at path, returning the file along
+// with a reader for its contents which fails with a *LimitError if the file
+// exceeds ro.maxFileSize. Regular files which are already too large fail
+// immediately. The caller must close the file.
+func openLimited(path string, ro readOptions) (*os.File, io.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ro.maxFileSize <= 0 {
+		return f, f, nil
+	}
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() && fi.Size() > ro.maxFileSize {
+		f.Close()
+		return nil, nil, &LimitError{Limit: LimitFileSize, Max: ro.maxFileSize, Filename: path}
+	}
+	return f, &limitedReader{r: f, max: ro.maxFileSize, path: path}, nil
+}
+
+// limitedReader wraps r, failing with a *LimitError once more than max bytes
+// have been read.
+type limitedReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+	path string
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.read > lr.max {
+		return 0, &LimitError{Limit: LimitFileSize, Max: lr.max, Filename: lr.path}
+	}
+	if remaining := lr.max - lr.read + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := lr.r.Read(p)
+	if lr.read += int64(n); lr.read > lr.max {
+		return n - 1, &LimitError{Limit: LimitFileSize, Max: lr.max, Filename: lr.path}
+	}
+	return n, err
+}
+
+// scanBufferSize returns the maximum buffer size for a bufio.Scanner which
+// must accept lines of up to ro.maxLineLength bytes plus a CRLF line ending.
+func (ro readOptions) scanBufferSize() int {
+	if ro.maxLineLength <= 0 || ro.maxLineLength > math.MaxInt32-2 {
+		return math.MaxInt32
+	}
+	return ro.maxLineLength + 2
+}
+
+// lineTooLong returns true if a line of n bytes, excluding its line ending,
+// exceeds ro.maxLineLength.
+func (ro readOptions) lineTooLong(n int) bool {
+	return ro.maxLineLength > 0 && n > ro.maxLineLength
+}
+
+// errLineTooLong is returned by readLine for lines exceeding the maximum
+// length. Callers convert it to a *LimitError with the line's location.
+var errLineTooLong = errors.New("knownhosts: line too long")
+
+// readLine reads a line from r, including its line ending if any, in the same
+// manner as r.ReadString('\n'). If the line exceeds ro.maxLineLength bytes
+// excluding its line ending, errLineTooLong is returned without reading the
+// remainder of the line.
+func (ro readOptions) readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if ro.lineTooLong(len(bytes.TrimRight(line, "\r\n"))) {
+			return "", errLineTooLong
+		} else if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+
+// limitScanError converts err, from a bufio.Scanner reading line lineNum of
+// path, to a *LimitError if it was caused by exceeding a limit in ro. Other
+// errors are wrapped with the path, and a nil err is returned as-is.
+func limitScanError(err error, path string, lineNum int, ro readOptions) error {
+	var le *LimitError
+	if err == nil {
+		return nil
+	} else if errors.Is(err, bufio.ErrTooLong) {
+		return &LimitError{Limit: LimitLineLength, Max: int64(ro.maxLineLength), Filename: path, Line: lineNum}
+	} else if errors.As(err, &le) {
+		le.Line = lineNum
+		return le
+	}
+	return fmt.Errorf("knownhosts: unable to read %s: %w", path, err)
+}
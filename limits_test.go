@@ -0,0 +1,188 @@
+package knownhosts
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// limitBackends are the functions which read known_hosts files subject to the
+// limits configured by ReadOption values.
+var limitBackends = map[string]func(files []string, opts ...ReadOption) error{
+	"ReadEntriesWithOptions": func(files []string, opts ...ReadOption) error {
+		_, err := ReadEntriesWithOptions(files, opts...)
+		return err
+	},
+	"NewWithOptions": func(files []string, opts ...ReadOption) error {
+		_, err := NewWithOptions(files, opts...)
+		return err
+	},
+	"NewLowMemoryWithOptions": func(files []string, opts ...ReadOption) error {
+		_, err := NewLowMemoryWithOptions(files, opts...)
+		return err
+	},
+}
+
+func TestReadLimits(t *testing.T) {
+	key := generatePubKeyEd25519(t)
+	lines := []string{
+		"# comment",
+		Line([]string{"host1.example.test"}, key),
+		"",
+		Line([]string{"host2.example.test"}, key) + " " + strings.Repeat("x", 200),
+		Line([]string{"host3.example.test"}, key),
+	}
+	contents := strings.Join(lines, "\r\n") + "\r\n"
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", path, err)
+	}
+	longest := len(lines[3])
+
+	cases := []struct {
+		opts        []ReadOption
+		expectErr   bool
+		expectLimit Limit
+		expectLine  int
+	}{
+		{nil, false, 0, 0},
+		{[]ReadOption{WithMaxEntries(3)}, false, 0, 0},
+		{[]ReadOption{WithMaxEntries(2)}, true, LimitEntries, 5},
+		{[]ReadOption{WithMaxLineLength(longest)}, false, 0, 0},
+		{[]ReadOption{WithMaxLineLength(longest - 1)}, true, LimitLineLength, 4},
+		{[]ReadOption{WithMaxFileSize(int64(len(contents)))}, false, 0, 0},
+		{[]ReadOption{WithMaxFileSize(int64(len(contents) - 1))}, true, LimitFileSize, 0},
+		{[]ReadOption{WithMaxEntries(0), WithMaxLineLength(0), WithMaxFileSize(0)}, false, 0, 0},
+	}
+	for name, read := range limitBackends {
+		for n, tc := range cases {
+			err := read([]string{path}, tc.opts...)
+			var le *LimitError
+			if !tc.expectErr {
+				if err != nil {
+					t.Errorf("%s case %d: unexpected error: %v", name, n, err)
+				}
+			} else if !errors.As(err, &le) {
+				t.Errorf("%s case %d: expected *LimitError, instead found %v", name, n, err)
+			} else if le.Limit != tc.expectLimit || le.Filename != path || (tc.expectLimit != LimitFileSize && le.Line != tc.expectLine) {
+				t.Errorf("%s case %d: unexpected LimitError %+v", name, n, le)
+			}
+		}
+	}
+
+	// The file size limit is enforced up-front for oversized files, which need
+	// not be read. The default applies to New as well.
+	huge := filepath.Join(t.TempDir(), "huge")
+	if err := os.WriteFile(huge, nil, 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", huge, err)
+	}
+	if err := os.Truncate(huge, DefaultMaxFileSize+1); err != nil {
+		t.Skipf("Unable to create sparse file: %v", err)
+	}
+	backends := map[string]func(files []string, opts ...ReadOption) error{
+		"New": func(files []string, _ ...ReadOption) error {
+			_, err := New(files...)
+			return err
+		},
+	}
+	for name, read := range limitBackends {
+		backends[name] = read
+	}
+	for name, read := range backends {
+		var le *LimitError
+		if err := read([]string{path, huge}); !errors.As(err, &le) || le.Limit != LimitFileSize || le.Filename != huge || le.Max != DefaultMaxFileSize {
+			t.Errorf("%s: expected file size LimitError for %s, instead found %v", name, huge, err)
+		}
+	}
+}
+
+// endlessReader endlessly repeats its data.
+type endlessReader struct {
+	data string
+	pos  int
+}
+
+func (er *endlessReader) Read(p []byte) (int, error) {
+	for n := range p {
+		p[n] = er.data[er.pos]
+		er.pos = (er.pos + 1) % len(er.data)
+	}
+	return len(p), nil
+}
+
+// TestReadLimitsAdversarial confirms that endless input fails with a
+// *LimitError, while allocating memory in proportion to the limits rather than
+// to the input.
+func TestReadLimitsAdversarial(t *testing.T) {
+	key := generatePubKeyEd25519(t)
+	cases := map[string]struct {
+		data        string
+		opts        []ReadOption
+		expectLimit Limit
+	}{
+		"long line": {
+			data:        "a",
+			expectLimit: LimitLineLength,
+		},
+		"many patterns": {
+			data:        "host.example.test,",
+			expectLimit: LimitLineLength,
+		},
+		"many entries": {
+			data:        Line([]string{"host.example.test"}, key) + "\n",
+			opts:        []ReadOption{WithMaxEntries(10000)},
+			expectLimit: LimitEntries,
+		},
+		"large file": {
+			data:        "# comment\n",
+			opts:        []ReadOption{WithMaxFileSize(4 << 20)},
+			expectLimit: LimitFileSize,
+		},
+	}
+	const maxAlloc = 32 << 20
+	for name, tc := range cases {
+		ro := newReadOptions(tc.opts)
+		measure := func(desc string, fn func() error) {
+			t.Helper()
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			err := fn()
+			runtime.ReadMemStats(&after)
+			var le *LimitError
+			if !errors.As(err, &le) || le.Limit != tc.expectLimit {
+				t.Errorf("%s via %s: expected %s LimitError, instead found %v", name, desc, tc.expectLimit, err)
+			}
+			if alloc := after.TotalAlloc - before.TotalAlloc; alloc > maxAlloc {
+				t.Errorf("%s via %s: allocated %d bytes, more than expected maximum of %d", name, desc, alloc, maxAlloc)
+			}
+		}
+		r := func() *limitedReader {
+			return &limitedReader{r: &endlessReader{data: tc.data}, max: ro.maxFileSize, path: "endless"}
+		}
+		measure("parseEntries", func() error {
+			_, err := parseEntries(r(), "endless", nil, ro)
+			return err
+		})
+		measure("readLine", func() error {
+			br := bufio.NewReader(r())
+			var entries int
+			for {
+				line, err := ro.readLine(br)
+				if err == errLineTooLong {
+					return &LimitError{Limit: LimitLineLength}
+				} else if err != nil {
+					return err
+				} else if !strings.HasPrefix(line, "#") {
+					if entries++; entries > ro.maxEntries {
+						return &LimitError{Limit: LimitEntries}
+					}
+				}
+			}
+		})
+	}
+}
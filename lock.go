@@ -0,0 +1,70 @@
+package knownhosts
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// DefaultLockTimeout is the maximum amount of time that functions modifying a
+// known_hosts file path will wait to obtain an exclusive lock on the file, if
+// not overridden by WithLockTimeout.
+const DefaultLockTimeout = 10 * time.Second
+
+// ErrLockTimeout is returned by functions modifying a known_hosts file path if
+// an exclusive lock on the file could not be obtained before the lock timeout
+// elapsed.
+var ErrLockTimeout = errors.New("knownhosts: timed out waiting for lock on known_hosts file")
+
+// lockRetryInterval controls how often a contended lock is retried.
+const lockRetryInterval = 10 * time.Millisecond
+
+// openLocked opens the file at path using the supplied flag, and then obtains
+// an exclusive advisory lock on it, waiting up to timeout. Since another
+// process may replace the file (by renaming a new file over it) while we wait
+// for the lock, after locking we confirm that path still refers to the same
+// file, and otherwise retry. The returned file must be closed via
+// closeLocked.
+func openLocked(path string, flag int, timeout time.Duration) (*os.File, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := openFile(path, flag, 0600)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			locked, err := tryLockFile(f)
+			if err != nil {
+				f.Close()
+				return nil, err
+			} else if locked {
+				break
+			} else if time.Now().After(deadline) {
+				f.Close()
+				return nil, ErrLockTimeout
+			}
+			time.Sleep(lockRetryInterval)
+		}
+		fileInfo, err := f.Stat()
+		if err != nil {
+			closeLocked(f)
+			return nil, err
+		}
+		if pathInfo, err := os.Stat(path); err == nil && os.SameFile(fileInfo, pathInfo) {
+			return f, nil
+		} else if err != nil && !os.IsNotExist(err) {
+			closeLocked(f)
+			return nil, err
+		}
+		closeLocked(f)
+		if time.Now().After(deadline) {
+			return nil, ErrLockTimeout
+		}
+	}
+}
+
+// closeLocked releases the lock obtained by openLocked, and closes the file.
+func closeLocked(f *os.File) error {
+	unlockFile(f)
+	return f.Close()
+}
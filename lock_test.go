@@ -0,0 +1,123 @@
+package knownhosts
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAppendKnownHostConcurrent(t *testing.T) {
+	if !lockSupported {
+		t.Skip("File locking not supported on this platform")
+	}
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	const workers, appendsPerWorker = 8, 25
+	keys := make([]ssh.PublicKey, workers)
+	for n := range keys {
+		keys[n] = generatePubKeyEd25519(t)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*appendsPerWorker)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; n < appendsPerWorker; n++ {
+				host := fmt.Sprintf("host-%d-%d.example.test", w, n)
+				errs <- AppendKnownHost(khPath, host, noAddr, keys[w])
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+		}
+	}
+
+	// Every line must be a complete, distinct entry
+	f, err := os.Open(khPath)
+	if err != nil {
+		t.Fatalf("Unable to open %s: %v", khPath, err)
+	}
+	defer f.Close()
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if seen[line] {
+			t.Errorf("Duplicate line found in %s: %q", khPath, line)
+		}
+		seen[line] = true
+	}
+	if len(seen) != workers*appendsPerWorker {
+		t.Errorf("Expected %d lines in %s, found %d", workers*appendsPerWorker, khPath, len(seen))
+	}
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	for w := 0; w < workers; w++ {
+		for n := 0; n < appendsPerWorker; n++ {
+			host := fmt.Sprintf("host-%d-%d.example.test:22", w, n)
+			if err := kh(host, noAddr, keys[w]); err != nil {
+				t.Errorf("Unexpected error from callback for %s: %v", host, err)
+			}
+		}
+	}
+}
+
+func TestAppendKnownHostConcurrentSameEntry(t *testing.T) {
+	if !lockSupported {
+		t.Skip("File locking not supported on this platform")
+	}
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	pubKey := generatePubKeyEd25519(t)
+	var wg sync.WaitGroup
+	for n := 0; n < 16; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := AppendKnownHost(khPath, "racy.example.test", noAddr, pubKey); err != nil {
+				t.Errorf("Unexpected error from AppendKnownHost: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	want := Line([]string{"racy.example.test"}, pubKey) + "\n"
+	if contents, err := os.ReadFile(khPath); err != nil || string(contents) != want {
+		t.Errorf("Expected exactly one entry after concurrent appends, instead found %q (err=%v)", contents, err)
+	}
+}
+
+func TestAppendKnownHostLockTimeout(t *testing.T) {
+	if !lockSupported {
+		t.Skip("File locking not supported on this platform")
+	}
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	f, err := openLocked(khPath, os.O_RDWR|os.O_CREATE, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error from openLocked: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	err = AppendKnownHost(khPath, "host.example.test", noAddr, generatePubKeyEd25519(t), WithLockTimeout(50*time.Millisecond))
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("Expected ErrLockTimeout while file is locked, instead found %v", err)
+	}
+	closeLocked(f)
+	if err := AppendKnownHost(khPath, "host.example.test", noAddr, generatePubKeyEd25519(t), WithLockTimeout(50*time.Millisecond)); err != nil {
+		t.Errorf("Unexpected error from AppendKnownHost after lock released: %v", err)
+	}
+}
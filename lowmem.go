@@ -0,0 +1,247 @@
+package knownhosts
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrStale is returned, wrapped with the file path, by callbacks created by
+// NewLowMemory if a known_hosts file has been modified since it was loaded, and
+// by a FileDB using StaleError. Use errors.Is to check for it. A new callback
+// must be created, or FileDB.Reload called, to pick up the changes.
+var ErrStale = errors.New("knownhosts: known_hosts file modified since it was loaded")
+
+// lowMemoryFile tracks a known_hosts file loaded by NewLowMemory.
+type lowMemoryFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+	digest  [sha256.Size]byte // used by SaveCache
+}
+
+// lowMemoryRef locates a known_hosts line which was indexed by NewLowMemory.
+type lowMemoryRef struct {
+	file   int32 // index into lowMemoryDB.files
+	line   int32
+	offset int64
+}
+
+// lowMemoryDB holds the index used by callbacks created by NewLowMemory.
+type lowMemoryDB struct {
+	files        []lowMemoryFile
+	index        map[uint64][]lowMemoryRef // hash of host and port => plaintext lines
+	resident     []Entry                   // lines which are not indexed
+	residentRefs []lowMemoryRef            // location of each resident entry, used by SaveCache
+	now          func() time.Time          // see WithCertClock
+}
+
+// NewLowMemory creates a host key callback from the given known_hosts files,
+// like New, but without keeping every entry in memory. This is intended for
+// memory-constrained systems using very large known_hosts files.
+//
+// Only an index of plaintext host patterns to file offsets is kept in memory.
+// On each lookup, the lines indexed under the host being looked up are re-read
+// from disk and parsed, so lookups are considerably slower than with New, and
+// may fail with an I/O error. Lines containing hashed, wildcard, or negated
+// patterns, as well as @cert-authority and @revoked lines, are kept in memory,
+// since they cannot be indexed by host and are typically few. Lookup results,
+// including the Filename and Line of KnownKey values in errors, are the same
+// as for a callback returned by New.
+//
+// The files are fully parsed when the callback is created, and an error is
+// returned if any line is malformed, with the same behavior as ReadEntries. If
+// a file's size or modification time changes after it was loaded, lookups
+// fail with an error wrapping ErrStale. The default limits described by
+// ReadEntries apply; use NewLowMemoryWithOptions to configure them.
+func NewLowMemory(files ...string) (HostKeyCallback, error) {
+	return NewLowMemoryWithOptions(files)
+}
+
+// NewLowMemoryWithOptions behaves like NewLowMemory, but with the limits
+// configured by opts, such as WithMaxLineLength. Reading stops as soon as a
+// limit is exceeded, returning a *LimitError.
+func NewLowMemoryWithOptions(files []string, opts ...ReadOption) (HostKeyCallback, error) {
+	ro := newReadOptions(opts)
+	db := &lowMemoryDB{index: make(map[uint64][]lowMemoryRef), now: ro.now}
+	for n, path := range files {
+		if err := db.load(int32(n), path, ro); err != nil {
+			return nil, err
+		}
+	}
+	return lenientLookup(db.check), nil
+}
+
+// load indexes the known_hosts file at path, subject to the limits in ro.
+func (db *lowMemoryDB) load(fileNum int32, path string, ro readOptions) error {
+	f, limited, err := openLimited(path, ro)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	file := lowMemoryFile{path: path, size: fi.Size(), modTime: fi.ModTime()}
+	h := sha256.New()
+	defer func() {
+		copy(file.digest[:], h.Sum(nil))
+		db.files = append(db.files, file)
+	}()
+
+	r := bufio.NewReader(io.TeeReader(limited, h))
+	var offset int64
+	var entries int
+	for lineNum := int32(1); ; lineNum++ {
+		line, readErr := ro.readLine(r)
+		var le *LimitError
+		if readErr == errLineTooLong {
+			return &LimitError{Limit: LimitLineLength, Max: int64(ro.maxLineLength), Filename: path, Line: int(lineNum)}
+		} else if errors.As(readErr, &le) {
+			le.Line = int(lineNum)
+			return le
+		} else if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("knownhosts: unable to read %s: %w", path, readErr)
+		} else if line == "" {
+			return nil
+		}
+		e, ok, err := parseEntry(line)
+		if err != nil {
+			var pe *ParseError
+			if errors.As(err, &pe) {
+				pe.Filename, pe.Line = path, int(lineNum)
+			}
+			return err
+		}
+		if ok {
+			if entries++; ro.maxEntries > 0 && entries > ro.maxEntries {
+				return &LimitError{Limit: LimitEntries, Max: int64(ro.maxEntries), Filename: path, Line: int(lineNum)}
+			}
+			ref := lowMemoryRef{file: fileNum, line: lineNum, offset: offset}
+			if isResident(e) {
+				e.Filename, e.Line = path, int(lineNum)
+				db.resident = append(db.resident, e)
+				db.residentRefs = append(db.residentRefs, ref)
+			} else {
+				for _, pattern := range e.Patterns {
+					hash := addressHash(Normalize(pattern))
+					db.index[hash] = append(db.index[hash], ref)
+				}
+			}
+		}
+		offset += int64(len(line))
+	}
+}
+
+// isResident returns true if e cannot be indexed by host, and so must be kept
+// in memory by NewLowMemory.
+func isResident(e Entry) bool {
+	return e.Marker != MarkerNone || strings.ContainsAny(strings.Join(e.Patterns, ","), "*?!|")
+}
+
+// check implements the callback returned by NewLowMemory.
+func (db *lowMemoryDB) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	address := hostname
+	if address == "" {
+		address = remote.String()
+	}
+	entries, err := db.readRefs(db.lookupRefs(Normalize(address)))
+	if err != nil {
+		return err
+	}
+	entries = append(entries, db.resident...)
+	fileNums := make(map[string]int, len(db.files))
+	for n, file := range db.files {
+		fileNums[file.path] = n
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		fi, fj := fileNums[entries[i].Filename], fileNums[entries[j].Filename]
+		return fi < fj || (fi == fj && entries[i].Line < entries[j].Line)
+	})
+	cb, err := newFromEntries(entries, db.now)
+	if err != nil {
+		return err
+	}
+	return cb(hostname, remote, key)
+}
+
+// lookupRefs returns the indexed lines which may match address, which must be
+// normalized, removing duplicates from lines with multiple patterns for the
+// same host.
+func (db *lowMemoryDB) lookupRefs(address string) []lowMemoryRef {
+	var refs []lowMemoryRef
+	seen := make(map[lowMemoryRef]bool)
+	for _, ref := range db.index[addressHash(address)] {
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// readRefs re-reads and parses the lines located by refs, after verifying that
+// none of the files have changed.
+func (db *lowMemoryDB) readRefs(refs []lowMemoryRef) ([]Entry, error) {
+	for _, file := range db.files {
+		fi, err := os.Stat(file.path)
+		if err != nil {
+			return nil, err
+		} else if fi.Size() != file.size || !fi.ModTime().Equal(file.modTime) {
+			return nil, fmt.Errorf("%w: %s", ErrStale, file.path)
+		}
+	}
+	entries := make([]Entry, 0, len(refs)+len(db.resident))
+	var f *os.File
+	var fileNum int32 = -1
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	for _, ref := range refs {
+		file := db.files[ref.file]
+		if ref.file != fileNum {
+			if f != nil {
+				f.Close()
+			}
+			var err error
+			if f, err = os.Open(file.path); err != nil {
+				return nil, err
+			}
+			fileNum = ref.file
+		}
+		line, err := bufio.NewReader(io.NewSectionReader(f, ref.offset, file.size-ref.offset)).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("knownhosts: unable to read %s: %w", file.path, err)
+		}
+		e, ok, err := parseEntry(line)
+		if err != nil || !ok {
+			return nil, fmt.Errorf("%w: %s", ErrStale, file.path)
+		}
+		e.Filename, e.Line = file.path, int(ref.line)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// addressHash returns a hash of a normalized address for use as an index key.
+// Plaintext patterns without wildcards are indexed by the hash of their
+// normalized form, since MatchesPattern only matches such a pattern to a host
+// with the same normalized form.
+func addressHash(address string) uint64 {
+	h := fnv.New64a()
+	io.WriteString(h, address)
+	return h.Sum64()
+}
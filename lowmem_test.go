@@ -0,0 +1,176 @@
+package knownhosts
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// describeCallbackResult returns a deterministic description of the result of
+// calling a host key callback, for comparing callbacks.
+func describeCallbackResult(err error) string {
+	var keyErr *xknownhosts.KeyError
+	var revokedErr *xknownhosts.RevokedError
+	if errors.As(err, &keyErr) {
+		var want []string
+		for _, kk := range keyErr.Want {
+			want = append(want, fmt.Sprintf("%s:%d:%s", kk.Filename, kk.Line, ssh.FingerprintSHA256(kk.Key)))
+		}
+		sort.Strings(want)
+		return "KeyError" + fmt.Sprint(want)
+	} else if errors.As(err, &revokedErr) {
+		return fmt.Sprintf("RevokedError %s:%d", revokedErr.Revoked.Filename, revokedErr.Revoked.Line)
+	} else if err != nil {
+		return "error " + err.Error()
+	}
+	return "ok"
+}
+
+func TestNewLowMemory(t *testing.T) {
+	dir := t.TempDir()
+	keys := []ssh.PublicKey{generatePubKeyEd25519(t), generatePubKeyEd25519(t), generatePubKeyECDSA(t), generatePubKeyEd25519(t)}
+	revokedKey, caKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t)
+	encoded := func(key ssh.PublicKey) string {
+		return strings.SplitN(Line([]string{"x"}, key), " ", 2)[1]
+	}
+	hashed, err := HashHostname("hashed.example.test")
+	if err != nil {
+		t.Fatalf("Unexpected error from HashHostname: %v", err)
+	}
+	files := map[string]string{
+		"first": strings.Join([]string{
+			"# comment",
+			"plain.example.test " + encoded(keys[0]),
+			"plain.example.test,[plain.example.test]:2222 " + encoded(keys[2]),
+			"plain.example.test " + encoded(keys[1]), // second key of same type is ignored
+			"",
+			"*.wild.example.test,!bad.wild.example.test " + encoded(keys[0]),
+			hashed + " " + encoded(keys[1]),
+			"UPPER.example.test " + encoded(keys[3]),
+			"10.0.0.5 " + encoded(keys[3]),
+			"@revoked * " + encoded(revokedKey),
+			"@cert-authority *.example.test " + encoded(caKey),
+		}, "\n") + "\n",
+		"second": strings.Join([]string{
+			"plain.example.test " + encoded(keys[3]),
+			"[plain.example.test]:2222 " + encoded(keys[1]),
+			"other.example.test " + encoded(keys[1]),
+			"fe80::1 " + encoded(keys[0]),
+		}, "\r\n"),
+	}
+	var paths []string
+	for _, name := range []string{"first", "second"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(files[name]), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %v", path, err)
+		}
+		paths = append(paths, path)
+	}
+
+	inMemory, err := New(paths...)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	lowMemory, err := NewLowMemory(paths...)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewLowMemory: %v", err)
+	}
+	remote, _ := net.ResolveTCPAddr("tcp", "10.0.0.5:22")
+	hosts := []string{
+		"plain.example.test:22", "plain.example.test:2222", "PLAIN.example.test.:22",
+		"a.wild.example.test:22", "bad.wild.example.test:22", "hashed.example.test:22",
+		"upper.example.test:22", "UPPER.example.test:22", "other.example.test:22",
+		"[fe80::1]:22", "10.0.0.5:22", "unknown.example.test:22", "",
+	}
+	for _, hostname := range hosts {
+		for _, key := range append([]ssh.PublicKey{revokedKey, generatePubKeyEd25519(t)}, keys...) {
+			expected := describeCallbackResult(inMemory(hostname, remote, key))
+			if actual := describeCallbackResult(lowMemory(hostname, remote, key)); actual != expected {
+				t.Errorf("Mismatched results for %q with %s key: expected %s, found %s", hostname, key.Type(), expected, actual)
+			}
+		}
+		if hostname == "" {
+			continue
+		}
+		expected, actual := inMemory.HostKeyAlgorithms(hostname), lowMemory.HostKeyAlgorithms(hostname)
+		if fmt.Sprint(expected) != fmt.Sprint(actual) {
+			t.Errorf("Mismatched HostKeyAlgorithms for %q: expected %v, found %v", hostname, expected, actual)
+		}
+	}
+
+	if err := lowMemory("plain.example.test:22", remote, keys[0]); err != nil {
+		t.Errorf("Unexpected error from callback: %v", err)
+	}
+	if err := lowMemory("plain.example.test:2222", remote, keys[0]); !IsHostKeyChanged(err) {
+		t.Errorf("Expected host key changed error, instead found %v", err)
+	}
+
+	// Modification is detected
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(paths[1], later, later); err != nil {
+		t.Fatalf("Unable to change times: %v", err)
+	}
+	if err := lowMemory("plain.example.test:22", remote, keys[0]); !errors.Is(err, ErrStale) {
+		t.Errorf("Expected error wrapping ErrStale, instead found %v", err)
+	}
+
+	// Malformed lines are rejected at load time
+	bad := filepath.Join(dir, "bad")
+	if err := os.WriteFile(bad, []byte("host ssh-ed25519 AAAA\n"), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", bad, err)
+	}
+	var pe *ParseError
+	if _, err := NewLowMemory(paths[0], bad); !errors.As(err, &pe) || pe.Filename != bad || pe.Line != 1 {
+		t.Errorf("Expected ParseError for %s:1, instead found %v", bad, err)
+	}
+}
+
+// BenchmarkNewLowMemory compares the retained heap size of callbacks from New
+// and NewLowMemory for a 50000-line file.
+func BenchmarkNewLowMemory(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "known_hosts")
+	key := generatePubKeyEd25519(b)
+	var buf bytes.Buffer
+	for line := 0; line < 50000; line++ {
+		buf.WriteString(Line([]string{fmt.Sprintf("host%d.example.test", line)}, key) + "\n")
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		b.Fatalf("Unable to write %s: %v", path, err)
+	}
+	for _, bm := range []struct {
+		name string
+		new  func(...string) (HostKeyCallback, error)
+	}{
+		{"New", New},
+		{"NewLowMemory", NewLowMemory},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			var before, after runtime.MemStats
+			var heap int64
+			for n := 0; n < b.N; n++ {
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				cb, err := bm.new(path)
+				if err != nil {
+					b.Fatalf("Unexpected error: %v", err)
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				heap += int64(after.HeapAlloc) - int64(before.HeapAlloc)
+				runtime.KeepAlive(cb)
+			}
+			b.ReportMetric(float64(heap)/float64(b.N), "heap-B/op")
+		})
+	}
+}
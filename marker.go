@@ -0,0 +1,66 @@
+package knownhosts
+
+import (
+	"fmt"
+)
+
+// Marker is an optional annotation at the start of a known_hosts line, which
+// changes the meaning of the line's key.
+type Marker string
+
+// Constants representing the markers supported by OpenSSH.
+const (
+	MarkerNone          Marker = ""                // the key is a host key
+	MarkerCertAuthority Marker = "@cert-authority" // the key is a CA trusted to sign host certificates
+	MarkerRevoked       Marker = "@revoked"        // the key must never be accepted
+)
+
+// ParseMarker returns the Marker corresponding to s, which must be empty or one
+// of the markers supported by OpenSSH. An error is returned for any other
+// value.
+func ParseMarker(s string) (Marker, error) {
+	switch m := Marker(s); m {
+	case MarkerNone, MarkerCertAuthority, MarkerRevoked:
+		return m, nil
+	}
+	return MarkerNone, fmt.Errorf("knownhosts: unknown marker %q", s)
+}
+
+// known returns true if m is one of the markers supported by OpenSSH, or
+// MarkerNone.
+func (m Marker) known() bool {
+	_, err := ParseMarker(string(m))
+	return err == nil
+}
+
+// NewIgnoringMarkers creates a host key callback from the given known_hosts
+// files, like New, except that lines with any of the ignored markers are
+// skipped entirely. For example, ignoring MarkerCertAuthority means that no CA
+// is trusted, so host certificates are only accepted if the certificate's key
+// itself is known; ignoring MarkerRevoked means that revoked keys are no longer
+// rejected. Skipped lines are never treated as plain host keys. Only
+// MarkerCertAuthority and MarkerRevoked may be ignored.
+//
+// The files are read using ReadEntries, so the skipped lines remain available
+// for reporting by calling ReadEntries separately. Errors from the callback
+// report the same Filename and Line as a callback returned by New.
+func NewIgnoringMarkers(ignored []Marker, files ...string) (HostKeyCallback, error) {
+	ignore := make(map[Marker]bool, len(ignored))
+	for _, m := range ignored {
+		if m != MarkerCertAuthority && m != MarkerRevoked {
+			return nil, fmt.Errorf("knownhosts: cannot ignore marker %q", m)
+		}
+		ignore[m] = true
+	}
+	entries, err := ReadEntries(files...)
+	if err != nil {
+		return nil, err
+	}
+	kept := entries[:0]
+	for _, e := range entries {
+		if !ignore[e.Marker] {
+			kept = append(kept, e)
+		}
+	}
+	return NewFromEntries(kept)
+}
@@ -0,0 +1,100 @@
+package knownhosts
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestParseMarker(t *testing.T) {
+	for input, expected := range map[string]Marker{
+		"":                MarkerNone,
+		"@cert-authority": MarkerCertAuthority,
+		"@revoked":        MarkerRevoked,
+	} {
+		if actual, err := ParseMarker(input); err != nil || actual != expected {
+			t.Errorf("Unexpected result from ParseMarker(%q): %q, %v", input, actual, err)
+		}
+	}
+	for _, input := range []string{"@bogus", "cert-authority", "@Revoked", "@revoked "} {
+		if actual, err := ParseMarker(input); err == nil || actual != MarkerNone {
+			t.Errorf("Expected error from ParseMarker(%q), instead found %q, %v", input, actual, err)
+		}
+	}
+}
+
+func TestNewIgnoringMarkers(t *testing.T) {
+	caSigner := generateSignerEd25519(t)
+	hostKey, revokedKey := generatePubKeyEd25519(t), generatePubKeyECDSA(t)
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	contents := strings.Join([]string{
+		"# comment",
+		"@cert-authority *.example.test " + keyString(caSigner.PublicKey()),
+		"plain.example.org " + keyString(hostKey),
+		"plain.example.org " + keyString(revokedKey),
+		"@revoked * " + keyString(revokedKey),
+	}, "\n") + "\n"
+	if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	cert := generateHostCert(t, caSigner, generatePubKeyEd25519(t), "web.example.test")
+
+	// By default, both markers are honored
+	kh, err := NewIgnoringMarkers(nil, khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewIgnoringMarkers: %v", err)
+	}
+	if err := kh("web.example.test:22", noAddr, cert); err != nil {
+		t.Errorf("Expected certificate to be accepted, instead found %v", err)
+	}
+	var revokedErr *xknownhosts.RevokedError
+	if err := kh("plain.example.org:22", noAddr, revokedKey); !errors.As(err, &revokedErr) || revokedErr.Revoked.Filename != khPath || revokedErr.Revoked.Line != 5 {
+		t.Errorf("Expected revoked key to be rejected with its location, instead found %v", err)
+	}
+
+	// Ignoring @cert-authority rejects the certificate, without trusting the
+	// CA key as a host key either
+	kh, err = NewIgnoringMarkers([]Marker{MarkerCertAuthority}, khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewIgnoringMarkers: %v", err)
+	}
+	if err := kh("web.example.test:22", noAddr, cert); err == nil {
+		t.Error("Expected certificate to be rejected, but received nil error")
+	}
+	if err := kh("web.example.test:22", noAddr, caSigner.PublicKey()); !IsHostUnknown(err) {
+		t.Errorf("Expected CA key to be rejected as unknown, instead found %v", err)
+	}
+	if err := kh("plain.example.org:22", noAddr, revokedKey); !errors.As(err, &revokedErr) {
+		t.Errorf("Expected revoked key to still be rejected, instead found %v", err)
+	}
+
+	// Ignoring @revoked accepts the revoked key, and file locations in errors
+	// are still those of the original lines
+	kh, err = NewIgnoringMarkers([]Marker{MarkerRevoked}, khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewIgnoringMarkers: %v", err)
+	}
+	if err := kh("plain.example.org:22", noAddr, revokedKey); err != nil {
+		t.Errorf("Expected revoked key to be accepted, instead found %v", err)
+	}
+	var keyErr *xknownhosts.KeyError
+	if err := kh("plain.example.org:22", noAddr, generatePubKeyEd25519(t)); !errors.As(err, &keyErr) || len(keyErr.Want) != 2 {
+		t.Errorf("Expected key mismatch reporting 2 keys, instead found %v", err)
+	} else {
+		// The order of Want is not deterministic
+		lines := map[int]bool{keyErr.Want[0].Line: true, keyErr.Want[1].Line: true}
+		if !lines[3] || !lines[4] || keyErr.Want[0].Filename != khPath {
+			t.Errorf("Expected key mismatch reporting %s lines 3 and 4, instead found %+v", khPath, keyErr.Want)
+		}
+	}
+
+	if _, err := NewIgnoringMarkers([]Marker{MarkerNone}, khPath); err == nil {
+		t.Error("Expected error ignoring MarkerNone, but received nil")
+	}
+}
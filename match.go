@@ -0,0 +1,115 @@
+package knownhosts
+
+import (
+	"errors"
+	"strings"
+)
+
+// MatchesPattern returns true if the supplied host matches a single known_hosts
+// host pattern, following OpenSSH's rules. The host is first normalized using
+// Normalize, so a host on a nonstandard port is matched in the form
+// "[host]:port". The pattern may be:
+//
+//   - a hashed pattern beginning with "|1|", which matches if it is the hash of
+//     the normalized host, or for an IPv6 address on port 22, of the bracketed
+//     form written by golang.org/x/crypto/ssh/knownhosts;
+//   - a plaintext pattern, which is compared to the normalized host
+//     case-insensitively, with "*" matching any sequence of characters and "?"
+//     matching any single character. A pattern without wildcards is normalized
+//     in the same manner as the host before comparing them, so that entries
+//     written with trailing dots, IPv6 zones, or an unbracketed "host:port", as
+//     accepted by golang.org/x/crypto/ssh/knownhosts, are still matched;
+//   - a negated pattern beginning with "!", in which case the result indicates
+//     whether host matches the remainder of the pattern. A match of a negated
+//     pattern causes MatchesLine to reject the whole line.
+//
+// As in OpenSSH, wildcards are matched against the entire normalized host,
+// including any brackets and port. For example "*" matches every host on every
+// port, and "[*.example.com]:*" matches hosts in example.com on any port other
+// than 22. Note that golang.org/x/crypto/ssh/knownhosts instead compares the
+// port separately, such that a pattern without a port only matches port 22.
+//
+// An error is returned if pattern is empty, or is a malformed hashed pattern.
+func MatchesPattern(pattern, hostWithPort string) (bool, error) {
+	pattern = strings.TrimPrefix(pattern, "!")
+	if pattern == "" {
+		return false, errors.New("knownhosts: empty host pattern")
+	}
+	address := Normalize(hostWithPort)
+	if strings.HasPrefix(pattern, hashMagic) {
+		matched, err := MatchHashedPattern(pattern, address)
+		if !matched && err == nil && strings.Contains(address, ":") && address[0] != '[' {
+			// golang.org/x/crypto/ssh/knownhosts hashes IPv6 addresses on port 22
+			// in bracketed form
+			matched, err = MatchHashedPattern(pattern, "["+address+"]")
+		}
+		return matched, err
+	}
+	if !strings.ContainsAny(pattern, "*?") {
+		return Normalize(pattern) == address, nil
+	}
+	return wildcardMatch(lowerASCII(pattern), address), nil
+}
+
+// MatchesLine returns true if host matches the patterns of a single known_hosts
+// line, such as those in Entry.Patterns, following OpenSSH's rules: host must
+// match at least one pattern as per MatchesPattern, and must not match any
+// negated pattern. For example, "a.bad.example.com" does not match the patterns
+// "!*.bad.example.com" and "*.example.com", regardless of their order. Empty or
+// malformed patterns are ignored.
+func MatchesLine(patterns []string, host string) bool {
+	var matched bool
+	for _, pattern := range patterns {
+		if ok, err := MatchesPattern(pattern, host); ok && err == nil {
+			if pattern[0] == '!' {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// wildcardMatch returns true if s matches pattern, in which "*" matches any
+// sequence of characters and "?" matches any single character.
+func wildcardMatch(pattern, s string) bool {
+	// On mismatch, backtrack to the most recent "*" and let it consume one more
+	// character of s
+	var p, i int
+	star, starMatch := -1, 0
+	for i < len(s) {
+		if p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]) {
+			p++
+			i++
+		} else if p < len(pattern) && pattern[p] == '*' {
+			star, starMatch = p, i
+			p++
+		} else if star >= 0 {
+			starMatch++
+			p, i = star+1, starMatch
+		} else {
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// LookupRaw returns the Raw text of each of entries whose patterns match
+// hostWithPort as per MatchesLine, in order. This includes hashed entries and
+// entries with any marker, and is intended for showing exactly which lines of
+// a known_hosts file apply to a host, for example when diagnosing a key
+// mismatch. Callbacks from New and NewFromEntries use the same matching, so the
+// lines they consult for a host are always among those returned. Entries which
+// were not parsed from text, and so lack Raw text, are omitted.
+func LookupRaw(entries []Entry, hostWithPort string) []string {
+	var lines []string
+	for _, e := range entries {
+		if e.Raw != "" && MatchesLine(e.Patterns, hostWithPort) {
+			lines = append(lines, e.Raw)
+		}
+	}
+	return lines
+}
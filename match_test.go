@@ -0,0 +1,140 @@
+package knownhosts
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestMatchesLine(t *testing.T) {
+	// Expected results obtained from ssh-keygen -F against a known_hosts line
+	// with each set of patterns
+	cases := []struct {
+		patterns string
+		host     string
+		expected bool
+	}{
+		{"!*.bad.example.com,*.example.com", "a.bad.example.com", false},
+		{"!*.bad.example.com,*.example.com", "good.example.com", true},
+		{"*.example.com,!*.bad.example.com", "a.bad.example.com", false},
+		{"*", "[host]:2222", true},
+		{"*", "host", true},
+		{"host", "[host]:2222", false},
+		{"[host]:*", "[host]:2222", true},
+		{"[host]:*", "host", false},
+		{"[*.example.com]:2222", "[a.example.com]:2222", true},
+		{"HOST.Example.com", "host.example.com", true},
+		{"host.example.com", "HOST.example.com", true},
+		{"h?st", "host", true},
+		{"h?st", "hst", false},
+		{"192.168.1.*", "192.168.1.7", true},
+		{"!host", "host", false},
+		{"!other", "host", false},
+		{"*.example.com", "example.com", false},
+		{"*ample*", "www.example.com", true},
+		{"[::1]:2222", "[::1]:2222", true},
+		{"::1", "::1", true},
+
+		// Hosts are normalized before matching
+		{"host", "host:22", true},
+		{"[host]:2222", "host:2222", true},
+		{"[*]:2222", "user@host:2222", true},
+
+		// Hashed patterns, including alongside others. This is the hash of
+		// target.example.test with salt "0123456789abcdefghij".
+		{"|1|MDEyMzQ1Njc4OWFiY2RlZmdoaWo=|zO0rWiKz3dERiQ0hnItIBZ3EavY=", "target.example.test", true},
+		{"|1|MDEyMzQ1Njc4OWFiY2RlZmdoaWo=|zO0rWiKz3dERiQ0hnItIBZ3EavY=", "other.example.test", false},
+		{"|1|MDEyMzQ1Njc4OWFiY2RlZmdoaWo=|zO0rWiKz3dERiQ0hnItIBZ3EavY=,!target.example.test", "target.example.test", false},
+
+		// Empty and malformed patterns are ignored
+		{"", "host", false},
+		{"!,host", "host", true},
+		{"|1|bogus,host", "host", true},
+	}
+	for _, tc := range cases {
+		if actual := MatchesLine(strings.Split(tc.patterns, ","), tc.host); actual != tc.expected {
+			t.Errorf("Expected MatchesLine(%q, %q) to return %t, instead found %t", tc.patterns, tc.host, tc.expected, actual)
+		}
+	}
+}
+
+func TestMatchesPattern(t *testing.T) {
+	if ok, err := MatchesPattern("!*.example.com", "a.example.com"); !ok || err != nil {
+		t.Errorf("Expected negated pattern to report a match of its remainder, instead found %t, %v", ok, err)
+	}
+	if ok, err := MatchesPattern("[*]:22", "host"); ok || err != nil {
+		t.Errorf("Expected pattern with explicit port 22 not to match normalized host, instead found %t, %v", ok, err)
+	}
+	for _, pattern := range []string{"", "!", "|1|bogus", "|1|a|b|c"} {
+		if _, err := MatchesPattern(pattern, "host"); err == nil {
+			t.Errorf("Expected error from MatchesPattern with pattern %q, but err was nil", pattern)
+		}
+	}
+}
+
+func TestLookupRaw(t *testing.T) {
+	key, caKey, anyKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t), generatePubKeyECDSA(t)
+	hashed, err := HashHostname("web.example.test")
+	if err != nil {
+		t.Fatalf("Unexpected error from HashHostname: %v", err)
+	}
+	rawLines := []string{
+		"  web.example.test,10.0.0.5\t" + keyString(key) + "   added by  hand ",
+		"other.example.test " + keyString(key),
+		hashed + " " + keyString(key),
+		"@cert-authority *.example.test " + keyString(caKey),
+		"@revoked web.example.test " + keyString(caKey),
+		"*.example.test,!web.example.test " + keyString(key),
+		"* " + keyString(anyKey),
+	}
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	contents := "# header\r\n" + strings.Join(rawLines, "\r\n") + "\r\n"
+	if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	entries, err := ReadEntries(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadEntries: %v", err)
+	}
+	expected := []string{rawLines[0], rawLines[2], rawLines[3], rawLines[4], rawLines[6]}
+	if actual := LookupRaw(entries, "web.example.test:22"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected result from LookupRaw:\nexpected %q\nfound    %q", expected, actual)
+	}
+	if actual := LookupRaw(entries, "missing.test"); !reflect.DeepEqual(actual, rawLines[6:]) {
+		t.Errorf("Expected only the wildcard line for other host, instead found %q", actual)
+	}
+
+	// LookupRaw must agree with a callback from New about which lines apply to a
+	// host, including on ports other than 22
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	for _, host := range []string{"web.example.test:22", "web.example.test:2222", "a.example.test:22", "a.example.test:2222", "x.test:2222"} {
+		raw := LookupRaw(entries, host)
+		var keyErr *xknownhosts.KeyError
+		if err := kh(host, noAddr, generatePubKeyEd25519(t)); !errors.As(err, &keyErr) {
+			t.Fatalf("Expected KeyError from callback for %s, instead found %v", host, err)
+		}
+		for _, kk := range keyErr.Want {
+			if line := rawLines[kk.Line-2]; !containsString(raw, line) {
+				t.Errorf("Callback for %s used line %q, which LookupRaw did not return: %q", host, line, raw)
+			}
+		}
+		if err := kh(host, noAddr, anyKey); err != nil {
+			t.Errorf("Expected callback for %s to accept the wildcard line's key, instead found %v", host, err)
+		} else if !containsString(raw, rawLines[6]) {
+			t.Errorf("Expected LookupRaw for %s to include the wildcard line, instead found %q", host, raw)
+		}
+	}
+	if e, err := ParseLine([]byte(rawLines[0] + "\n")); err != nil || e.Raw != rawLines[0] {
+		t.Errorf("Unexpected result from ParseLine: Raw=%q, err=%v", e.Raw, err)
+	}
+}
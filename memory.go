@@ -0,0 +1,262 @@
+package knownhosts
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// NewFromReader creates a host key callback from known_hosts data read from r,
+// such as a known_hosts file kept in a secrets store rather than on disk. The
+// data is parsed in the same manner as ReadEntries, with name used as the
+// Filename of the resulting entries, and in any *ParseError. The callback is
+// created by NewFromEntries, so no files are read or written at any point.
+//
+// The default limits described by ReadEntries apply, and may be configured
+// using opts; the file size limit applies to the total number of bytes read
+// from r.
+func NewFromReader(r io.Reader, name string, opts ...ReadOption) (HostKeyCallback, error) {
+	ro := newReadOptions(opts)
+	if ro.maxFileSize > 0 {
+		r = &limitedReader{r: r, max: ro.maxFileSize, path: name}
+	}
+	entries, err := parseEntries(r, name, nil, ro)
+	if err != nil {
+		return nil, err
+	}
+	return newFromEntries(entries, ro.now)
+}
+
+// NewFromBytes creates a host key callback from known_hosts data held in
+// memory. It behaves like NewFromReader.
+func NewFromBytes(data []byte, name string, opts ...ReadOption) (HostKeyCallback, error) {
+	return NewFromReader(bytes.NewReader(data), name, opts...)
+}
+
+// memoryDB holds the entries of a callback created by New or NewFromEntries.
+// Lines are matched using MatchesLine, so that lookups agree with
+// MatchesPattern, LookupRaw, and CertAuthorityFor. Otherwise lookups follow
+// golang.org/x/crypto/ssh/knownhosts.
+type memoryDB struct {
+	lines   []memoryLine
+	revoked map[string]*xknownhosts.KnownKey // keyed by marshaled key
+	now     func() time.Time                 // see WithCertClock
+}
+
+// memoryLine is a non-revoked line of a memoryDB. Its patterns are either a
+// single hashed pattern, or any number of plaintext patterns.
+type memoryLine struct {
+	certAuthority bool
+	patterns      []string
+	knownKey      xknownhosts.KnownKey
+}
+
+// add adds entry e to db, using kk to identify it in errors from lookups.
+// Patterns which golang.org/x/crypto/ssh/knownhosts would reject are also
+// rejected here.
+func (db *memoryDB) add(e Entry, kk xknownhosts.KnownKey) error {
+	if e.Marker == MarkerRevoked {
+		db.revoked[string(e.Key.Marshal())] = &kk
+		return nil
+	}
+	if patterns := strings.Join(e.Patterns, ","); patterns[0] == '|' {
+		if _, _, ok := decodeHashedPattern(patterns); !ok {
+			return fmt.Errorf("malformed hashed host pattern %q", patterns)
+		}
+	} else {
+		for _, pattern := range e.Patterns {
+			pattern = strings.TrimPrefix(pattern, "!")
+			if pattern == "" {
+				return errors.New("negation without following hostname")
+			} else if pattern[0] == '[' {
+				if _, _, err := net.SplitHostPort(pattern); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	db.lines = append(db.lines, memoryLine{
+		certAuthority: e.Marker == MarkerCertAuthority,
+		patterns:      e.Patterns,
+		knownKey:      kk,
+	})
+	return nil
+}
+
+// readFile adds the lines of the known_hosts file at path to db, within the
+// limits in ro. Lines are parsed in the same manner as by
+// golang.org/x/crypto/ssh/knownhosts, which is more lenient than ReadEntries:
+// the key type field is ignored in favor of the type encoded in the key, and
+// empty patterns in a comma-separated list are skipped. Malformed lines fail
+// with the same errors as in that package.
+func (db *memoryDB) readFile(path string, ro readOptions) error {
+	f, r, err := openLimited(path, ro)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, ro.scanBufferSize())
+	var entries int
+	lineNum := 1
+	for ; scanner.Scan(); lineNum++ {
+		line := scanner.Bytes()
+		if ro.lineTooLong(len(line)) {
+			return &LimitError{Limit: LimitLineLength, Max: int64(ro.maxLineLength), Filename: path, Line: lineNum}
+		}
+		if line = bytes.TrimSpace(line); len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if entries++; ro.maxEntries > 0 && entries > ro.maxEntries {
+			return &LimitError{Limit: LimitEntries, Max: int64(ro.maxEntries), Filename: path, Line: lineNum}
+		}
+		if err := db.addLine(string(line), path, lineNum); err != nil {
+			return fmt.Errorf("knownhosts: %s:%d: %v", path, lineNum, err)
+		}
+	}
+	return limitScanError(scanner.Err(), path, lineNum, ro)
+}
+
+// addLine parses line, which has already been trimmed of whitespace, and adds
+// it to db.
+func (db *memoryDB) addLine(line, path string, lineNum int) error {
+	var marker Marker
+	if strings.HasPrefix(line, "@") {
+		var word string
+		var err error
+		word, line = nextWord(line)
+		if marker, err = ParseMarker(word); err != nil {
+			return fmt.Errorf("unknown marker: %q", word)
+		}
+	}
+	host, line := nextWord(line)
+	if line == "" {
+		return errors.New("knownhosts: missing host pattern")
+	}
+	// The key type is ignored, since it is also encoded in the key itself.
+	_, line = nextWord(line)
+	if line == "" {
+		return errors.New("knownhosts: missing key type pattern")
+	}
+	keyBlob, _ := nextWord(line)
+	keyBytes, err := base64.StdEncoding.DecodeString(keyBlob)
+	if err != nil {
+		return err
+	}
+	key, err := ssh.ParsePublicKey(keyBytes)
+	if err != nil {
+		return err
+	}
+	e := Entry{Marker: marker, Key: key}
+	if host[0] == '|' {
+		e.Patterns = []string{host}
+	} else {
+		for _, pattern := range strings.Split(host, ",") {
+			if pattern != "" {
+				e.Patterns = append(e.Patterns, pattern)
+			}
+		}
+		if len(e.Patterns) == 0 && marker != MarkerRevoked {
+			return nil // matches no hosts
+		}
+	}
+	return db.add(e, xknownhosts.KnownKey{Key: key, Filename: path, Line: lineNum})
+}
+
+// nextWord returns the first whitespace-delimited word of line, and the
+// remainder of line with leading whitespace removed.
+func nextWord(line string) (word, rest string) {
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i:])
+}
+
+// matches returns true if the line matches hostWithPort.
+func (line *memoryLine) matches(hostWithPort string) bool {
+	return MatchesLine(line.patterns, hostWithPort)
+}
+
+// callback returns a host key callback for db, which verifies certificates
+// using @cert-authority and @revoked lines in the same manner as
+// golang.org/x/crypto/ssh/knownhosts, but using db.now as the current time if
+// set.
+func (db *memoryDB) callback() ssh.HostKeyCallback {
+	certChecker := &ssh.CertChecker{
+		IsHostAuthority: db.isHostAuthority,
+		IsRevoked:       db.isRevoked,
+		HostKeyFallback: db.check,
+		Clock:           db.now,
+	}
+	return certChecker.CheckHostKey
+}
+
+func (db *memoryDB) isHostAuthority(auth ssh.PublicKey, address string) bool {
+	for n := range db.lines {
+		if db.lines[n].certAuthority && trustsAuthority(db.lines[n].patterns, db.lines[n].knownKey.Key, auth, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustsAuthority returns true if a @cert-authority line with the supplied
+// patterns and CA key trusts auth, the signature key of a host certificate
+// presented by address. It is used by both callbacks and CertAuthorityFor, so
+// that they agree on which lines trust a certificate.
+func trustsAuthority(patterns []string, key, auth ssh.PublicKey, address string) bool {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return false
+	}
+	return KeysEqual(key, auth) && MatchesLine(patterns, address)
+}
+
+func (db *memoryDB) isRevoked(cert *ssh.Certificate) bool {
+	_, ok := db.revoked[string(cert.Marshal())]
+	return ok
+}
+
+// check looks up a plain host key. The first matching line of each key type,
+// including @cert-authority lines, is reported in any *xknownhosts.KeyError, in
+// the order the lines were added.
+func (db *memoryDB) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if revoked := db.revoked[string(key.Marshal())]; revoked != nil {
+		return &xknownhosts.RevokedError{Revoked: *revoked}
+	}
+	address := remote.String()
+	if hostname != "" {
+		address = hostname
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("knownhosts: SplitHostPort(%s): %v", address, err)
+	}
+
+	keyErr := &xknownhosts.KeyError{}
+	var known ssh.PublicKey
+	types := make(map[string]bool)
+	for n := range db.lines {
+		kk := db.lines[n].knownKey
+		if types[kk.Key.Type()] || !db.lines[n].matches(address) {
+			continue
+		}
+		types[kk.Key.Type()] = true
+		keyErr.Want = append(keyErr.Want, kk)
+		if kk.Key.Type() == key.Type() {
+			known = kk.Key
+		}
+	}
+	if known == nil || !KeysEqual(known, key) {
+		return keyErr
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package knownhosts
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// TestNewFromBytes verifies that in-memory lookups find the keys of exactly
+// the lines matched by MatchesLine, including the edge cases of its pattern
+// matching, which differ from golang.org/x/crypto/ssh/knownhosts.
+func TestNewFromBytes(t *testing.T) {
+	keys := []ssh.PublicKey{generatePubKeyEd25519(t), generatePubKeyEd25519(t), generatePubKeyECDSA(t), generatePubKeyEd25519(t)}
+	revokedKey := generatePubKeyEd25519(t)
+	caSigner, otherCASigner := generateSignerEd25519(t), generateSignerEd25519(t)
+	encoded := func(key ssh.PublicKey) string {
+		return strings.SplitN(Line([]string{"x"}, key), " ", 2)[1]
+	}
+	revokedCert := generateHostCert(t, caSigner, keys[3], "ca.example.test")
+	data := strings.Join([]string{
+		"# comment",
+		"plain.example.test " + encoded(keys[0]),
+		"plain.example.test,[plain.example.test]:2222 " + encoded(keys[2]),
+		"plain.example.test " + encoded(keys[1]),
+		"*.wild.example.test,!bad.wild.example.test " + encoded(keys[0]),
+		"[*.wild.example.test]:2222 " + encoded(keys[1]),
+		"[*.anyport.test]:* " + encoded(keys[2]),
+		"q?.example.test " + encoded(keys[3]),
+		"UPPER.example.test " + encoded(keys[3]),
+		"!negated.example.test " + encoded(keys[1]),
+		xknownhosts.HashHostname("[fe80::1]") + " " + encoded(keys[1]),
+		xknownhosts.HashHostname("hashed.example.test") + " " + encoded(keys[2]),
+		"10.0.0.5 " + encoded(keys[3]),
+		"@revoked * " + encoded(revokedKey),
+		"@revoked * " + encoded(revokedCert),
+		"@cert-authority *.example.test " + encoded(caSigner.PublicKey()),
+		"  indented.example.test\t" + encoded(keys[0]) + " comment",
+	}, "\r\n") + "\n"
+	kh, err := NewFromBytes([]byte(data), "fleet")
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromBytes: %v", err)
+	}
+	entries, err := parseEntries(strings.NewReader(data), "fleet", nil, newReadOptions(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error from parseEntries: %v", err)
+	}
+	hosts := []string{
+		"plain.example.test:22", "plain.example.test:2222", "PLAIN.example.test:22",
+		"a.wild.example.test:22", "bad.wild.example.test:22", ".wild.example.test:22",
+		"a.wild.example.test:2222", "a.anyport.test:2200", "a.anyport.test:22",
+		"qa.example.test:22", "q.example.test:22", "upper.example.test:22", "UPPER.example.test:22",
+		"negated.example.test:22", "[fe80::1]:22", "hashed.example.test:22", "indented.example.test:22",
+		"ca.example.test:22", "10.0.0.5:22", "unknown.example.test:22",
+	}
+	for _, hostname := range hosts {
+		// The first matching line of each key type is used, including
+		// @cert-authority lines
+		var expected []ssh.PublicKey
+		types := make(map[string]bool)
+		for _, e := range entries {
+			if e.Marker != MarkerRevoked && !types[e.Key.Type()] && MatchesLine(e.Patterns, hostname) {
+				types[e.Key.Type()] = true
+				expected = append(expected, e.Key)
+			}
+		}
+		actual := kh.HostKeysExact(hostname)
+		if len(actual) != len(expected) {
+			t.Errorf("Expected %d keys for %s, instead found %d", len(expected), hostname, len(actual))
+			continue
+		}
+		for n := range actual {
+			if !KeysEqual(actual[n], expected[n]) {
+				t.Errorf("Unexpected key %d for %s", n, hostname)
+			}
+		}
+		for _, key := range expected {
+			if err := kh(hostname, nil, key); err != nil {
+				t.Errorf("Unexpected error from callback for %s: %v", hostname, err)
+			}
+		}
+	}
+
+	remote, _ := net.ResolveTCPAddr("tcp", "10.0.0.5:22")
+
+	// Unlike golang.org/x/crypto/ssh/knownhosts, matching is case-insensitive,
+	// and wildcards also apply to the port
+	for hostname, known := range map[string]bool{
+		"UPPER.example.test:22": true,
+		"a.anyport.test:2200":   true,
+		"a.anyport.test:22":     false,
+	} {
+		if err := kh(hostname, remote, keys[0]); IsHostUnknown(err) == known {
+			t.Errorf("Unexpected result from callback for %s: %v", hostname, err)
+		}
+	}
+
+	// Certificates are trusted via matching @cert-authority lines, unless
+	// revoked, and revoked keys are rejected regardless of host
+	if err := kh("ca.example.test:22", remote, generateHostCert(t, caSigner, keys[0], "ca.example.test")); err != nil {
+		t.Errorf("Unexpected error from callback for valid certificate: %v", err)
+	}
+	for _, cert := range []ssh.PublicKey{
+		generateHostCert(t, caSigner, keys[0], "other.example.test"),
+		generateHostCert(t, otherCASigner, keys[0], "ca.example.test"),
+		revokedCert,
+	} {
+		if err := kh("ca.example.test:22", remote, cert); err == nil {
+			t.Error("Expected error from callback for untrusted certificate, but err was nil")
+		}
+	}
+	var revokedErr *xknownhosts.RevokedError
+	if err := kh("plain.example.test:22", remote, revokedKey); !errors.As(err, &revokedErr) {
+		t.Errorf("Expected revoked key error, instead found %v", err)
+	}
+
+	// Unlike golang.org/x/crypto/ssh/knownhosts, hashed IPv6 addresses on port
+	// 22 are also found in the unbracketed form written by OpenSSH
+	unbracketed, err := NewFromBytes([]byte(HashPatternWithSalt("2001:db8::1", []byte("salt"))+" "+encoded(keys[0])+"\n"), "openssh")
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromBytes: %v", err)
+	}
+	for _, hostname := range []string{"[2001:db8::1]:22", "[2001:DB8::1]:22"} {
+		if err := unbracketed(hostname, remote, keys[0]); err != nil {
+			t.Errorf("Unexpected error from callback for %s with unbracketed hashed entry: %v", hostname, err)
+		}
+	}
+	if err := unbracketed("[2001:db8::1]:2222", remote, keys[0]); !IsHostUnknown(err) {
+		t.Errorf("Expected unbracketed hashed entry not to match port 2222, instead found %v", err)
+	}
+
+	// NewFromReader enforces the configured limits, and reports parse errors
+	// using the supplied name
+	var le *LimitError
+	if _, err := NewFromReader(strings.NewReader(data), "fleet", WithMaxFileSize(100)); !errors.As(err, &le) || le.Limit != LimitFileSize || le.Filename != "fleet" {
+		t.Errorf("Expected *LimitError for file size from NewFromReader, instead found %v", err)
+	}
+	if _, err := NewFromReader(strings.NewReader(data), "fleet", WithMaxEntries(2)); !errors.As(err, &le) || le.Limit != LimitEntries {
+		t.Errorf("Expected *LimitError for entries from NewFromReader, instead found %v", err)
+	}
+	var pe *ParseError
+	if _, err := NewFromReader(strings.NewReader("\nhost ssh-ed25519 invalid\n"), "fleet"); !errors.As(err, &pe) || pe.Filename != "fleet" || pe.Line != 2 {
+		t.Errorf("Expected *ParseError at fleet:2 from NewFromReader, instead found %v", err)
+	}
+}
+
+func TestNewFromEntriesInvalidPatterns(t *testing.T) {
+	key := generatePubKeyEd25519(t)
+	hashed, err := HashHostname("example.test")
+	if err != nil {
+		t.Fatalf("Unexpected error from HashHostname: %v", err)
+	}
+	cases := [][]string{
+		{"!"},
+		{"[example.test"},
+		{"|1|invalid"},
+		{hashed, "example.test"},
+	}
+	for _, patterns := range cases {
+		if _, err := NewFromEntries([]Entry{{Patterns: patterns, Key: key}}); err == nil {
+			t.Errorf("Expected error from NewFromEntries with patterns %q, but err was nil", patterns)
+		}
+	}
+
+	// Patterns of @revoked lines are not used, so they are not validated, as
+	// with New
+	if _, err := NewFromEntries([]Entry{{Marker: MarkerRevoked, Patterns: []string{"!"}, Key: key}}); err != nil {
+		t.Errorf("Unexpected error from NewFromEntries: %v", err)
+	}
+}
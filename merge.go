@@ -0,0 +1,175 @@
+package knownhosts
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// MergeOptions configures the behavior of MergeFiles.
+type MergeOptions struct {
+	// SortByHost causes the merged output to be sorted by host patterns, which
+	// makes diffs of the output easier to review. Lines with hashed patterns are
+	// placed after all other lines, in their original relative order. If false,
+	// lines are output in the order they were read.
+	SortByHost bool
+}
+
+// SourceLine identifies a line of a known_hosts file.
+type SourceLine struct {
+	Filename string
+	Line     int
+	Text     string // line contents, without line ending
+}
+
+// String returns the filename and line number in "filename:line" form.
+func (sl SourceLine) String() string {
+	return fmt.Sprintf("%s:%d", sl.Filename, sl.Line)
+}
+
+// MergeConflict describes two known_hosts lines which specify different keys
+// of the same type for the same host pattern.
+type MergeConflict struct {
+	Pattern string
+	KeyType string
+	First   SourceLine // line which appeared first
+	Other   SourceLine // conflicting line which appeared later
+}
+
+// MergeReport summarizes the result of MergeFiles.
+type MergeReport struct {
+	// Duplicates lists lines which were omitted from the output, because an
+	// equivalent line (same marker, host patterns, and key) appeared earlier.
+	Duplicates []SourceLine
+
+	// Conflicts lists pairs of lines which specify different keys of the same
+	// type for the same host pattern. Both lines of each pair are retained in
+	// the output, since MergeFiles cannot determine which one is correct.
+	Conflicts []MergeConflict
+}
+
+// MergeFiles reads the known_hosts files at srcs, in order, and writes a
+// merged and de-duplicated copy of their entries to dst. Lines are considered
+// duplicates if they have the same marker, the same host patterns (after
+// normalization), and the same key; only the first such line is kept, including
+// its comment if any. Hashed patterns only de-duplicate if they are identical,
+// since differently-salted hashes of the same host cannot be detected.
+//
+// Whole-line comments and blank lines are not included in the output. An error
+// is returned if any source file cannot be read, or contains a malformed line.
+// The returned MergeReport lists duplicate lines which were removed, as well as
+// conflicts where the same host pattern has different keys of the same type.
+// Conflicting lines are always kept in the output, so that the caller can
+// decide how to resolve them.
+func MergeFiles(dst io.Writer, srcs []string, opts MergeOptions) (MergeReport, error) {
+	var report MergeReport
+	type mergeLine struct {
+		SourceLine
+		patterns string
+		hashed   bool
+	}
+	var kept []mergeLine
+	seen := make(map[string]bool)
+	firstKeys := make(map[[2]string]mergeLine) // [pattern, key type] => line with first key
+
+	for _, src := range srcs {
+		f, err := os.Open(src)
+		if err != nil {
+			return MergeReport{}, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1024*1024)
+		for lineNum := 1; scanner.Scan(); lineNum++ {
+			content := strings.TrimRight(scanner.Text(), "\r")
+			_, first, rest := splitFirstField(content)
+			if first == "" || first[0] == '#' {
+				continue
+			}
+			marker := ""
+			if first[0] == '@' {
+				marker = first
+				_, first, rest = splitFirstField(rest)
+			}
+			_, keyType, afterType := splitFirstField(rest)
+			_, keyData, _ := splitFirstField(afterType)
+			if first == "" || keyData == "" {
+				f.Close()
+				return MergeReport{}, fmt.Errorf("knownhosts: %s:%d: malformed line", src, lineNum)
+			}
+
+			ml := mergeLine{
+				SourceLine: SourceLine{Filename: src, Line: lineNum, Text: content},
+				patterns:   normalizePatterns(first),
+				hashed:     strings.HasPrefix(first, hashMagic),
+			}
+			dedupeKey := marker + " " + ml.patterns + " " + keyType + " " + keyData
+			if seen[dedupeKey] {
+				report.Duplicates = append(report.Duplicates, ml.SourceLine)
+				continue
+			}
+			seen[dedupeKey] = true
+			kept = append(kept, ml)
+			if marker != "" {
+				continue
+			}
+			for _, pattern := range strings.Split(ml.patterns, ",") {
+				conflictKey := [2]string{pattern, keyType}
+				if prev, ok := firstKeys[conflictKey]; !ok {
+					firstKeys[conflictKey] = ml
+				} else if _, _, prevRest := splitFirstField(prev.Text); !keyFieldsEqual(prevRest, rest) {
+					report.Conflicts = append(report.Conflicts, MergeConflict{
+						Pattern: pattern,
+						KeyType: keyType,
+						First:   prev.SourceLine,
+						Other:   ml.SourceLine,
+					})
+				}
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return MergeReport{}, fmt.Errorf("knownhosts: unable to read %s: %w", src, err)
+		}
+	}
+
+	if opts.SortByHost {
+		sort.SliceStable(kept, func(i, j int) bool {
+			if kept[i].hashed != kept[j].hashed {
+				return !kept[i].hashed
+			}
+			return !kept[i].hashed && kept[i].patterns < kept[j].patterns
+		})
+	}
+	w := bufio.NewWriter(dst)
+	for _, ml := range kept {
+		w.WriteString(ml.Text + "\n")
+	}
+	return report, w.Flush()
+}
+
+// normalizePatterns normalizes each pattern in a comma-separated list of
+// known_hosts patterns. Hashed patterns are left as-is.
+func normalizePatterns(patterns string) string {
+	split := strings.Split(patterns, ",")
+	for n, pattern := range split {
+		if !strings.HasPrefix(pattern, hashMagic) {
+			split[n] = Normalize(pattern)
+		}
+	}
+	return strings.Join(split, ",")
+}
+
+// keyFieldsEqual returns true if a and b, which should each be the remainder of
+// a known_hosts line after its host patterns, begin with the same key type and
+// key data.
+func keyFieldsEqual(a, b string) bool {
+	_, aType, aRest := splitFirstField(a)
+	_, bType, bRest := splitFirstField(b)
+	_, aData, _ := splitFirstField(aRest)
+	_, bData, _ := splitFirstField(bRest)
+	return aType == bType && aData == bData
+}
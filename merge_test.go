@@ -0,0 +1,90 @@
+package knownhosts
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMergeFiles(t *testing.T) {
+	dir := t.TempDir()
+	keys := []ssh.PublicKey{generatePubKeyEd25519(t), generatePubKeyEd25519(t), generatePubKeyECDSA(t)}
+	hashed, _ := HashHostname("hashed.example.test")
+	files := map[string][]string{
+		"team1": {
+			"# team1 comment",
+			Line([]string{"zeta.example.test"}, keys[0]) + " zeta comment",
+			Line([]string{"alpha.example.test"}, keys[2]),
+			hashed + " " + keyString(keys[0]),
+			"@cert-authority *.example.test " + keyString(keys[1]),
+		},
+		"team2": {
+			"[zeta.example.test]:22 " + keyString(keys[0]) + " different comment", // duplicate after normalization
+			Line([]string{"alpha.example.test"}, keys[2]),                         // exact duplicate
+			Line([]string{"zeta.example.test"}, keys[1]),                          // conflict
+			hashed + " " + keyString(keys[0]),                                     // identical hash
+			"@cert-authority *.example.test " + keyString(keys[1]),                // duplicate marker line
+			"",
+			Line([]string{"beta.example.test"}, keys[2]),
+		},
+	}
+	var srcs []string
+	for _, name := range []string{"team1", "team2"} {
+		path := filepath.Join(dir, name)
+		var contents string
+		for _, line := range files[name] {
+			contents += line + "\n"
+		}
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %v", path, err)
+		}
+		srcs = append(srcs, path)
+	}
+
+	var buf bytes.Buffer
+	report, err := MergeFiles(&buf, srcs, MergeOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error from MergeFiles: %v", err)
+	}
+	want := files["team1"][1] + "\n" + files["team1"][2] + "\n" + files["team1"][3] + "\n" + files["team1"][4] + "\n" +
+		files["team2"][2] + "\n" + files["team2"][6] + "\n"
+	if buf.String() != want {
+		t.Errorf("Unexpected output from MergeFiles:\n%s\nExpected:\n%s", buf.String(), want)
+	}
+	if len(report.Duplicates) != 4 {
+		t.Errorf("Expected 4 duplicates, instead found %d: %+v", len(report.Duplicates), report.Duplicates)
+	}
+	if len(report.Conflicts) != 1 {
+		t.Fatalf("Expected 1 conflict, instead found %d: %+v", len(report.Conflicts), report.Conflicts)
+	}
+	conflict := report.Conflicts[0]
+	if conflict.Pattern != "zeta.example.test" || conflict.First.String() != srcs[0]+":2" || conflict.Other.String() != srcs[1]+":3" {
+		t.Errorf("Unexpected conflict: %+v", conflict)
+	}
+
+	// Sorted output: plaintext lines sorted by pattern, then hashed lines
+	buf.Reset()
+	if _, err := MergeFiles(&buf, srcs, MergeOptions{SortByHost: true}); err != nil {
+		t.Fatalf("Unexpected error from MergeFiles: %v", err)
+	}
+	want = files["team1"][4] + "\n" + files["team1"][2] + "\n" + files["team2"][6] + "\n" +
+		files["team1"][1] + "\n" + files["team2"][2] + "\n" + files["team1"][3] + "\n"
+	if buf.String() != want {
+		t.Errorf("Unexpected sorted output from MergeFiles:\n%s\nExpected:\n%s", buf.String(), want)
+	}
+
+	// Malformed lines and missing files should return errors
+	badPath := filepath.Join(dir, "bad")
+	if err := os.WriteFile(badPath, []byte("onlyonefield\n"), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", badPath, err)
+	}
+	if _, err := MergeFiles(&buf, []string{srcs[0], badPath}, MergeOptions{}); err == nil {
+		t.Error("Expected error from MergeFiles with malformed line, but error was nil")
+	}
+	if _, err := MergeFiles(&buf, []string{filepath.Join(dir, "missing")}, MergeOptions{}); err == nil {
+		t.Error("Expected error from MergeFiles with missing file, but error was nil")
+	}
+}
@@ -0,0 +1,120 @@
+package knownhosts
+
+import (
+	"errors"
+	"expvar"
+	"net"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Indexes into Metrics.counts
+const (
+	metricLookups = iota
+	metricHits
+	metricUnknown
+	metricChanged
+	metricRevoked
+	metricCertificates
+	metricErrors
+	metricAccepted
+	numMetrics
+)
+
+// Metrics counts the outcomes of host key verification, for monitoring. A
+// spike in unknown hosts or changed keys often indicates DNS churn, an
+// inventory problem, or an attack. Counters are maintained atomically, so a
+// single Metrics may be shared by any number of callbacks used concurrently.
+// The zero value is ready to use. A Metrics must not be copied after first use.
+type Metrics struct {
+	// counts is the first field so that it is 64-bit aligned, as required by
+	// sync/atomic on 32-bit platforms
+	counts [numMetrics]int64
+}
+
+// MetricsSnapshot is a point-in-time copy of the counters of a Metrics.
+type MetricsSnapshot struct {
+	Lookups      int64 // host keys checked by callbacks from WithMetrics
+	Hits         int64 // lookups which succeeded
+	Unknown      int64 // lookups which failed because the host is unknown
+	Changed      int64 // lookups which failed because the host has a different key
+	Revoked      int64 // lookups which failed because the key is revoked
+	Certificates int64 // lookups of host certificates, regardless of outcome
+	Errors       int64 // lookups which failed for any other reason
+	Accepted     int64 // keys of unknown hosts accepted by a Policy
+}
+
+// Snapshot returns the current values of the counters. Since each counter is
+// read separately, a snapshot taken during concurrent lookups may reflect
+// some lookups only partially, for example in Lookups but not yet in Hits.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	load := func(n int) int64 {
+		return atomic.LoadInt64(&m.counts[n])
+	}
+	return MetricsSnapshot{
+		Lookups:      load(metricLookups),
+		Hits:         load(metricHits),
+		Unknown:      load(metricUnknown),
+		Changed:      load(metricChanged),
+		Revoked:      load(metricRevoked),
+		Certificates: load(metricCertificates),
+		Errors:       load(metricErrors),
+		Accepted:     load(metricAccepted),
+	}
+}
+
+// Reset sets all counters to zero.
+func (m *Metrics) Reset() {
+	for n := range m.counts {
+		atomic.StoreInt64(&m.counts[n], 0)
+	}
+}
+
+// Publish exports the counters as an expvar variable with the supplied name,
+// whose value is the JSON form of the current snapshot. Like expvar.Publish,
+// it panics if the name is already in use.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return m.Snapshot()
+	}))
+}
+
+// inc increments the counter at index n.
+func (m *Metrics) inc(n int) {
+	atomic.AddInt64(&m.counts[n], 1)
+}
+
+// WithMetrics returns a host key callback which behaves like hkcb, but also
+// records the outcome of each call in m. Internal lookups made by methods such
+// as HostKeys and HostKeyAlgorithms are not counted. To also count keys
+// accepted by a Policy, set its Metrics field; WithMetrics may be applied
+// either before or after WithPolicy, which determines whether keys accepted
+// by the policy are counted as hits or as unknown hosts.
+func (hkcb HostKeyCallback) WithMetrics(m *Metrics) HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := hkcb(hostname, remote, key)
+		if _, placeholder := key.(*fakePublicKey); placeholder {
+			return err
+		}
+		m.inc(metricLookups)
+		if _, ok := key.(*ssh.Certificate); ok {
+			m.inc(metricCertificates)
+		}
+		var revokedErr *xknownhosts.RevokedError
+		switch {
+		case err == nil:
+			m.inc(metricHits)
+		case IsHostUnknown(err):
+			m.inc(metricUnknown)
+		case IsHostKeyChanged(err):
+			m.inc(metricChanged)
+		case errors.As(err, &revokedErr):
+			m.inc(metricRevoked)
+		default:
+			m.inc(metricErrors)
+		}
+		return err
+	}
+}
@@ -0,0 +1,85 @@
+package knownhosts
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestWithMetrics(t *testing.T) {
+	caSigner := generateSignerEd25519(t)
+	hostKey, otherKey, revokedKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t), generatePubKeyECDSA(t)
+	kh, err := NewFromEntries([]Entry{
+		{Patterns: []string{"known.example.test"}, Key: hostKey},
+		{Marker: MarkerRevoked, Patterns: []string{"*"}, Key: revokedKey},
+		{Marker: MarkerCertAuthority, Patterns: []string{"*.example.test"}, Key: caSigner.PublicKey()},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromEntries: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	cert := generateHostCert(t, caSigner, otherKey, "web.example.test")
+
+	var m Metrics
+	cb := kh.WithPolicy(Policy{Mode: PolicyAcceptNew, Metrics: &m}).WithMetrics(&m)
+	strict := kh.WithMetrics(&m)
+	const workers, iterations = 8, 25
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < iterations; n++ {
+				strict("known.example.test:22", noAddr, hostKey)    // hit
+				strict("known.example.test:22", noAddr, otherKey)   // changed
+				strict("unknown.example.org:22", noAddr, hostKey)   // unknown
+				strict("known.example.test:22", noAddr, revokedKey) // revoked
+				strict("web.example.test:22", noAddr, cert)         // hit, certificate
+				strict("other.example.test:22", noAddr, cert)       // error, certificate
+				cb.HostKeys("known.example.test:22")                // not counted
+				m.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+	if err := cb("new.example.org:22", noAddr, otherKey); err != nil {
+		t.Errorf("Expected accept-new policy to accept unknown host, instead found %v", err)
+	}
+
+	total := int64(workers * iterations)
+	expected := MetricsSnapshot{
+		Lookups:      6*total + 1,
+		Hits:         2*total + 1,
+		Unknown:      total,
+		Changed:      total,
+		Revoked:      total,
+		Certificates: 2 * total,
+		Errors:       total,
+		Accepted:     1,
+	}
+	if actual := m.Snapshot(); actual != expected {
+		t.Errorf("Unexpected snapshot: expected %+v, found %+v", expected, actual)
+	}
+
+	// expvar names cannot be unpublished, so use a fresh name for each run of
+	// the test, eg with -count
+	name := "knownhosts_test_metrics"
+	for n := 2; expvar.Get(name) != nil; n++ {
+		name = fmt.Sprintf("knownhosts_test_metrics_%d", n)
+	}
+	m.Publish(name)
+	var published MetricsSnapshot
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &published); err != nil {
+		t.Errorf("Unexpected error unmarshaling published metrics: %v", err)
+	} else if published != expected {
+		t.Errorf("Unexpected published metrics: expected %+v, found %+v", expected, published)
+	}
+
+	m.Reset()
+	if actual := m.Snapshot(); actual != (MetricsSnapshot{}) {
+		t.Errorf("Expected Reset to zero all counters, instead found %+v", actual)
+	}
+}
@@ -0,0 +1,146 @@
+package knownhosts
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// NormalizeFile rewrites the known_hosts file at path, converting legacy host
+// patterns for port 22 in bracketed form, such as "[host]:22" or
+// "[2001:db8::1]:22", to the unbracketed form written by this package, such as
+// "host" or "2001:db8::1". Older versions of golang.org/x/crypto/ssh/knownhosts
+// wrote such patterns for ipv6 addresses (see
+// https://github.com/golang/go/issues/53463), and OpenSSH never matches them,
+// since it looks up hosts on port 22 without brackets. Negated and wildcard
+// patterns are converted in the same manner, so for example "![*.example.com]:22"
+// becomes "!*.example.com". Hashed patterns cannot be converted, since the
+// hostname they were derived from is unknown.
+//
+// If the converted pattern already appears on the same line, or on another line
+// with the same marker and key, the legacy pattern is removed instead, and a
+// line left without patterns is removed entirely. This merges the duplicate
+// entries which result from writing the same host in both forms. Everything
+// else, including the other patterns, whitespace, comments, and line endings,
+// is preserved exactly.
+//
+// The line numbers of the lines which were modified or removed are returned, in
+// ascending order. Supply the WithDryRun option to obtain these without
+// modifying the file, or WithPlan to obtain a description of each change.
+// Otherwise, the file is locked and rewritten atomically; supply the
+// WithBackup option to retain a copy of the original file.
+func NormalizeFile(path string, opts ...WriteOption) (changedLines []int, err error) {
+	err = rewriteFile(path, newWriteOptions(opts), func(in io.Reader, out io.Writer) error {
+		contents, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+
+		// Find the existing non-legacy patterns of each marker and key
+		existing := make(map[string]map[string]bool)
+		transformLines(bytes.NewReader(contents), io.Discard, func(_ int, line string) (string, error) {
+			if identity, patterns, _, _, ok := splitPatterns(line); ok {
+				for _, pattern := range patterns {
+					if _, legacy := legacyPattern(pattern); !legacy {
+						addPattern(existing, identity, pattern)
+					}
+				}
+			}
+			return line, nil
+		})
+
+		return transformLines(bytes.NewReader(contents), out, func(lineNum int, line string) (string, error) {
+			identity, patterns, before, after, ok := splitPatterns(line)
+			if !ok {
+				return line, nil
+			}
+			kept := make([]string, 0, len(patterns))
+			var changed bool
+			for _, pattern := range patterns {
+				canonical, legacy := legacyPattern(pattern)
+				if !legacy {
+					kept = append(kept, pattern)
+					continue
+				}
+				changed = true
+				if !existing[identity][canonical] && !containsString(kept, canonical) {
+					kept = append(kept, canonical)
+					addPattern(existing, identity, canonical)
+				}
+			}
+			if !changed {
+				return line, nil
+			}
+			changedLines = append(changedLines, lineNum)
+			if len(kept) == 0 {
+				return "", nil
+			}
+			return before + strings.Join(kept, ",") + after, nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changedLines, nil
+}
+
+// splitPatterns splits a known_hosts line into its host patterns, the text
+// before and after them, and an identity consisting of its marker (if any),
+// key type, and key. If line is blank, a comment, or lacks a key, the returned
+// bool is false.
+func splitPatterns(line string) (identity string, patterns []string, before, after string, ok bool) {
+	content, eol := splitLineEnding(line)
+	leading, first, rest := splitFirstField(content)
+	var marker string
+	if strings.HasPrefix(first, "@") {
+		marker = first
+		var sep string
+		sep, first, rest = splitFirstField(rest)
+		leading += marker + sep
+	}
+	if first == "" || first[0] == '#' {
+		return "", nil, "", "", false
+	}
+	_, keyType, keyRest := splitFirstField(rest)
+	_, key, _ := splitFirstField(keyRest)
+	if key == "" {
+		return "", nil, "", "", false
+	}
+	return marker + " " + keyType + " " + key, strings.Split(first, ","), leading, rest + eol, true
+}
+
+// legacyPattern returns the unbracketed form of pattern if it is a plaintext
+// pattern in "[host]:22" form, optionally negated. Otherwise, the returned bool
+// is false.
+func legacyPattern(pattern string) (string, bool) {
+	negation := ""
+	if strings.HasPrefix(pattern, "!") {
+		negation, pattern = "!", pattern[1:]
+	}
+	if !strings.HasPrefix(pattern, "[") || !strings.HasSuffix(pattern, "]:22") {
+		return "", false
+	}
+	host := pattern[1 : len(pattern)-len("]:22")]
+	if host == "" || strings.ContainsAny(host, "[]") {
+		return "", false
+	}
+	return negation + host, true
+}
+
+// addPattern records pattern in patterns under identity.
+func addPattern(patterns map[string]map[string]bool, identity, pattern string) {
+	if patterns[identity] == nil {
+		patterns[identity] = make(map[string]bool)
+	}
+	patterns[identity][pattern] = true
+}
+
+// containsString returns true if s is among values.
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package knownhosts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeFile(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	k1, k2, k3, caKey := keyString(generatePubKeyEd25519(t)), keyString(generatePubKeyECDSA(t)), keyString(generatePubKeyEd25519(t)), keyString(generatePubKeyEd25519(t))
+	preHashed, _ := HashHostname("[hashed.example.test]:22")
+	original := strings.Join([]string{
+		"# [comment.example.test]:22 " + k1,
+		"[2001:db8::1]:22 " + k1 + " legacy ipv6",
+		"[host.example.test]:22,[host.example.test]:2222  " + k2,
+		"[dupe.example.test]:22 " + k3,
+		"dupe.example.test " + k3 + " canonical",
+		"[self.example.test]:22,self.example.test\t" + k3,
+		"@cert-authority [*.ca.example.test]:22,![bad.ca.example.test]:22 " + caKey,
+		"[dupe.example.test]:22 " + k1,
+		preHashed + " " + k1,
+		"unchanged.example.test,[unchanged.example.test]:2222 " + k2,
+		"[nonewline.example.test]:22 " + k2,
+	}, "\r\n")
+	if err := os.WriteFile(khPath, []byte(original), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	expected := strings.Join([]string{
+		"# [comment.example.test]:22 " + k1,
+		"2001:db8::1 " + k1 + " legacy ipv6",
+		"host.example.test,[host.example.test]:2222  " + k2,
+		"dupe.example.test " + k3 + " canonical",
+		"self.example.test\t" + k3,
+		"@cert-authority *.ca.example.test,!bad.ca.example.test " + caKey,
+		"dupe.example.test " + k1,
+		preHashed + " " + k1,
+		"unchanged.example.test,[unchanged.example.test]:2222 " + k2,
+		"nonewline.example.test " + k2,
+	}, "\r\n")
+	expectLines := "[2 3 4 6 7 8 11]"
+
+	// Dry run reports the lines without modifying the file
+	changed, err := NormalizeFile(khPath, WithDryRun())
+	if err != nil {
+		t.Fatalf("Unexpected error from NormalizeFile: %v", err)
+	} else if fmt.Sprint(changed) != expectLines {
+		t.Errorf("Expected NormalizeFile dry run to report lines %s, instead found %v", expectLines, changed)
+	}
+	if contents, _ := os.ReadFile(khPath); string(contents) != original {
+		t.Error("NormalizeFile modified file despite WithDryRun")
+	}
+
+	changed, err = NormalizeFile(khPath, WithBackup())
+	if err != nil {
+		t.Fatalf("Unexpected error from NormalizeFile: %v", err)
+	} else if fmt.Sprint(changed) != expectLines {
+		t.Errorf("Expected NormalizeFile to report lines %s, instead found %v", expectLines, changed)
+	}
+	if contents, err := os.ReadFile(khPath); err != nil || string(contents) != expected {
+		t.Errorf("Unexpected contents after NormalizeFile: %q (err=%v)", contents, err)
+	}
+	if backup, err := os.ReadFile(khPath + ".old"); err != nil || string(backup) != original {
+		t.Errorf("Backup file does not match original contents; err=%v", err)
+	}
+	if _, err := ReadEntries(khPath); err != nil {
+		t.Errorf("Unexpected error from ReadEntries after NormalizeFile: %v", err)
+	}
+
+	// Running again changes nothing
+	if changed, err := NormalizeFile(khPath); err != nil || len(changed) != 0 {
+		t.Errorf("Expected second NormalizeFile to change nothing, instead found %v, %v", changed, err)
+	}
+
+	if _, err := NormalizeFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected error from NormalizeFile for missing file, but it succeeded")
+	}
+}
@@ -0,0 +1,219 @@
+package knownhosts
+
+import (
+	"context"
+	"time"
+)
+
+// WriteOption configures optional behavior of functions which write
+// known_hosts entries.
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	lockTimeout  time.Duration
+	hashed       bool
+	backup       bool
+	markerLines  bool
+	mergeAddrs   bool
+	dryRun       bool
+	comment      string
+	autoStyle    bool
+	emptyStyle   Style
+	requireHash  bool
+	resolver     HostResolver
+	mergeRemote  bool
+	hostnameOnly bool
+	plan         *ChangeSet
+	defaultPort  string
+}
+
+func newWriteOptions(opts []WriteOption) writeOptions {
+	wo := writeOptions{
+		lockTimeout: DefaultLockTimeout,
+	}
+	for _, opt := range opts {
+		opt(&wo)
+	}
+	if wo.requireHash {
+		wo.hashed, wo.autoStyle = true, false
+	}
+	return wo
+}
+
+// WithLockTimeout sets the maximum amount of time to wait for an exclusive
+// lock on a known_hosts file path, in functions which modify a file path. If
+// the lock cannot be obtained in time, ErrLockTimeout is returned. This option
+// has no effect on functions which write to a caller-supplied io.Writer, since
+// those never perform any locking.
+func WithLockTimeout(timeout time.Duration) WriteOption {
+	return func(wo *writeOptions) {
+		wo.lockTimeout = timeout
+	}
+}
+
+// WithHashedHostnames causes known_hosts entries to be written with hashed
+// hostnames, like OpenSSH does when its HashKnownHosts option is enabled. Since
+// hashed patterns cannot be combined into a comma-separated list, each address
+// is written on its own line. See HashHostname for more information.
+//
+// Hashed IPv6 addresses on port 22 are written without brackets, matching
+// OpenSSH. Callbacks from New and NewFromEntries recognize such entries, even
+// though golang.org/x/crypto/ssh/knownhosts itself only matches the bracketed
+// form (see https://github.com/golang/go/issues/53463).
+func WithHashedHostnames() WriteOption {
+	return func(wo *writeOptions) {
+		wo.hashed = true
+	}
+}
+
+// WithBackup causes functions which rewrite an existing known_hosts file to
+// first save a copy of the original contents to the same path with ".old"
+// appended, similar to OpenSSH's ssh-keygen. Any previous backup at that path
+// is overwritten.
+func WithBackup() WriteOption {
+	return func(wo *writeOptions) {
+		wo.backup = true
+	}
+}
+
+// WithMarkerLines causes functions which remove known_hosts entries by key to
+// also consider lines with a marker, such as @cert-authority or @revoked. By
+// default, such lines are left unchanged.
+func WithMarkerLines() WriteOption {
+	return func(wo *writeOptions) {
+		wo.markerLines = true
+	}
+}
+
+// WithMergeAddresses causes AppendKnownHost and AppendKnownHosts to add new
+// addresses to an existing line for the same key, rather than appending a new
+// line. For example, if the file already has a line "host1 ssh-ed25519 AAAA...",
+// appending the same key for host2 rewrites that line as "host1,host2
+// ssh-ed25519 AAAA...". Lines with hashed patterns cannot be extended, so if
+// the key only appears on hashed lines, a new hashed line is appended instead.
+// This option has no effect when combined with WithHashedHostnames or
+// WithAutoStyle.
+func WithMergeAddresses() WriteOption {
+	return func(wo *writeOptions) {
+		wo.mergeAddrs = true
+	}
+}
+
+// WithDryRun causes functions which rewrite an existing known_hosts file, such
+// as RemoveHost or Prune, to determine and return their usual results without
+// actually modifying the file. Use WithPlan to also obtain a description of the
+// changes which would be made.
+func WithDryRun() WriteOption {
+	return func(wo *writeOptions) {
+		wo.dryRun = true
+	}
+}
+
+// WithComment causes newly written known_hosts entries to include comment
+// after the key, for example to record when or by whom an entry was added.
+// Functions which write entries return an error if comment contains newlines or
+// other control characters, or begins or ends with whitespace. Existing
+// comments are always preserved by functions which rewrite a known_hosts file,
+// and are available via Entry.Comment when reading entries back.
+//
+// This option is ignored by Line; use LineWithOptions to generate a single line
+// with a comment.
+func WithComment(comment string) WriteOption {
+	return func(wo *writeOptions) {
+		wo.comment = comment
+	}
+}
+
+// WithAutoStyle causes AppendKnownHost and AppendKnownHosts to match the
+// existing style of the known_hosts file: new entries are hashed if the file's
+// host key lines are predominantly hashed, and written in plaintext otherwise.
+// If the file has no host key lines yet, new entries are hashed only if
+// emptyDefault is StyleHashed. See DetectStyle for more information on how the
+// style is determined.
+//
+// This option overrides WithHashedHostnames. Since the style is determined
+// while holding the file lock, concurrent appends cannot observe a stale style.
+func WithAutoStyle(emptyDefault Style) WriteOption {
+	return func(wo *writeOptions) {
+		wo.autoStyle = true
+		wo.emptyStyle = emptyDefault
+	}
+}
+
+// WithRequireHashed causes all newly written known_hosts entries to have
+// hashed hostnames, regardless of the order or combination of other options:
+// it implies WithHashedHostnames, and overrides WithAutoStyle and
+// WithMergeAddresses. This is intended for enforcing a security baseline which
+// mandates OpenSSH's HashKnownHosts option. Wildcard and negated patterns
+// cannot be hashed, so writing them fails with an error, as it does with
+// WithHashedHostnames.
+//
+// Existing plaintext lines are not converted, including lines whose key is
+// replaced in place by ReplaceHostKey; use HashFile to convert them, and
+// RequireHashed to detect them.
+func WithRequireHashed() WriteOption {
+	return func(wo *writeOptions) {
+		wo.requireHash = true
+	}
+}
+
+// HostResolver looks up the current addresses of a hostname. *net.Resolver
+// satisfies this interface.
+type HostResolver interface {
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+}
+
+// WithResolvedRemote causes AppendKnownHost and AppendKnownHosts to look up
+// the hostname's current addresses using resolver, and to treat the remote
+// address as redundant if it has the same port as the hostname and its IP is
+// among the resolved addresses. This avoids accumulating a separate line for
+// each address of a host with multiple or changing DNS records.
+//
+// If merge is false, a redundant remote address is not recorded at all. If
+// merge is true, it is recorded, but added to an existing plaintext line for
+// the same key as described by WithMergeAddresses, rather than appended on a
+// new line.
+//
+// The lookup is performed before the file is locked, and is abandoned after
+// 5 seconds. If it fails, the remote address is recorded as if this option had
+// not been supplied. Hostnames which are IP addresses are never looked up.
+func WithResolvedRemote(resolver HostResolver, merge bool) WriteOption {
+	return func(wo *writeOptions) {
+		wo.resolver = resolver
+		wo.mergeRemote = merge
+	}
+}
+
+// WithHostnameOnly causes WriteKnownHost, WriteKnownHosts, AppendKnownHost,
+// and AppendKnownHosts to record only the hostname, never the remote address,
+// regardless of its value. This is useful for hosts reached via anycast or
+// virtual IP addresses, where the observed remote IP is not meaningful and
+// recording it would only accumulate churn in the known_hosts file. To apply
+// this to keys accepted by a callback from HostKeyCallback.WithPolicy, include
+// it in Policy.WriteOptions.
+//
+// This option takes precedence over WithResolvedRemote, since there is no
+// remote address left to resolve.
+func WithHostnameOnly() WriteOption {
+	return func(wo *writeOptions) {
+		wo.hostnameOnly = true
+	}
+}
+
+// WithDefaultPort causes WriteKnownHost, WriteKnownHosts, AppendKnownHost,
+// AppendKnownHosts, and ReplaceHostKey to treat a hostname which lacks a port
+// as being on port, rather than on port 22. For example, with
+// WithDefaultPort("2222"), a hostname of "host" is written as "[host]:2222".
+// Hostnames which include a port, such as those passed to host key callbacks,
+// are unaffected.
+//
+// Entries are always written in the standard form, in which only port 22 is
+// written without brackets, since OpenSSH and golang.org/x/crypto/ssh/knownhosts
+// both interpret an unbracketed host as being on port 22, regardless of any
+// Port setting in ssh_config. HostKeyCallback.WithDefaultPort provides the
+// same default for lookups.
+func WithDefaultPort(port string) WriteOption {
+	return func(wo *writeOptions) {
+		wo.defaultPort = port
+	}
+}
@@ -0,0 +1,352 @@
+package knownhosts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ChangeKind describes how a Change affects a known_hosts line.
+type ChangeKind int
+
+// Constants representing possible values of Change.Kind.
+const (
+	ChangeAdd    ChangeKind = iota // a new line is added
+	ChangeRemove                   // an existing line is removed
+	ChangeModify                   // an existing line is replaced
+)
+
+// String returns a one-character symbol for the kind of change: "+", "-", or
+// "~".
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdd:
+		return "+"
+	case ChangeRemove:
+		return "-"
+	case ChangeModify:
+		return "~"
+	}
+	return "?"
+}
+
+// Change describes a single line to be added, removed, or modified in a
+// known_hosts file.
+type Change struct {
+	Kind     ChangeKind
+	Filename string
+
+	// Line is the 1-based line number of the affected line in the original
+	// file. For ChangeAdd, it is the number of the original line which the new
+	// line follows, or 0 if the new line is added at the beginning of the file.
+	Line int
+
+	Before string // original line without its line ending; empty for ChangeAdd
+	After  string // new line without its line ending; empty for ChangeRemove
+}
+
+// String returns a description of the change in the form used by ChangeSet's
+// String method, for example "known_hosts:3: - host1 ssh-ed25519 AAAA...". For
+// ChangeModify, the original and new lines are separated by " => ".
+func (c Change) String() string {
+	text := c.After
+	switch c.Kind {
+	case ChangeRemove:
+		text = c.Before
+	case ChangeModify:
+		text = c.Before + " => " + c.After
+	}
+	return fmt.Sprintf("%s:%d: %s %s", c.Filename, c.Line, c.Kind, text)
+}
+
+// ChangeSet describes the changes which one or more operations would make to
+// a known_hosts file, as recorded by the WithPlan option. It retains the
+// resulting file contents, so that Apply writes exactly the previewed result.
+// The zero value is an empty ChangeSet, ready for use with WithPlan.
+//
+// A ChangeSet is not safe for concurrent use.
+type ChangeSet struct {
+	Path    string   // path of the file, after resolving any symlink
+	Changes []Change // changes in order of original line number
+
+	loaded     bool
+	origExists bool
+	orig       []byte
+	origDigest [sha256.Size]byte
+	exists     bool
+	contents   []byte
+}
+
+// WithPlan causes functions which modify a known_hosts file to record their
+// changes in cs, instead of modifying the file. The functions otherwise behave
+// as usual, returning the same results that they would if the file were
+// actually modified. This option is supported by RemoveHost, RemoveByKey,
+// ReplaceHostKey, Prune, HashFile, RewriteFile, AppendKnownHost,
+// AppendKnownHosts, and AppendKnownHostCA, including when AppendKnownHost
+// merges addresses into an existing line due to WithMergeAddresses.
+//
+// The same cs may be supplied to several such calls for the same file, in
+// which case each call sees the changes planned by the previous ones, and cs
+// describes their combined result. Calls for a different file return an error.
+// Use cs.Apply to make the planned changes.
+func WithPlan(cs *ChangeSet) WriteOption {
+	return func(wo *writeOptions) {
+		wo.plan = cs
+	}
+}
+
+// Empty returns true if cs contains no changes.
+func (cs *ChangeSet) Empty() bool {
+	return !cs.loaded || (cs.exists == cs.origExists && bytes.Equal(cs.orig, cs.contents))
+}
+
+// String returns the changes in cs, one per line, in the form returned by
+// Change.String.
+func (cs *ChangeSet) String() string {
+	var b strings.Builder
+	for _, c := range cs.Changes {
+		b.WriteString(c.String() + "\n")
+	}
+	return b.String()
+}
+
+// Contents returns the contents which Apply would write to the file.
+func (cs *ChangeSet) Contents() []byte {
+	return append([]byte(nil), cs.contents...)
+}
+
+// Apply writes the planned contents to the file, in the same manner as
+// RewriteFile, or by creating the file if it did not previously exist. The
+// file is only written if its contents are still identical to when the
+// changes were planned, as determined by comparing SHA256 digests; otherwise
+// an error wrapping ErrStale is returned and the file is left unchanged. The
+// comparison is made while holding the file lock, so a concurrent
+// modification via this package cannot be lost.
+//
+// The WithLockTimeout and WithBackup options are supported; other options have
+// no effect. Nothing is done if cs is empty.
+func (cs *ChangeSet) Apply(opts ...WriteOption) error {
+	if cs.Empty() {
+		return nil
+	}
+	wo := newWriteOptions(opts)
+	wo.plan, wo.dryRun = nil, false
+	stale := fmt.Errorf("%w: %s", ErrStale, cs.Path)
+	if !cs.origExists {
+		return appendToFile(cs.Path, wo, func(existing []byte) ([]byte, error) {
+			if len(existing) > 0 {
+				return nil, stale
+			}
+			return cs.contents, nil
+		})
+	}
+	err := rewriteFile(cs.Path, wo, func(in io.Reader, out io.Writer) error {
+		current, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		} else if sha256.Sum256(current) != cs.origDigest {
+			return stale
+		}
+		_, err = out.Write(cs.contents)
+		return err
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return stale
+	}
+	return err
+}
+
+// load returns the contents of the file at path as planned so far, reading
+// the file if this is the first operation planned in cs. The returned bool
+// indicates whether the file exists.
+func (cs *ChangeSet) load(path string, wo writeOptions) ([]byte, bool, error) {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	if cs.loaded {
+		if path != cs.Path {
+			return nil, false, fmt.Errorf("knownhosts: cannot plan changes to %s in ChangeSet for %s", path, cs.Path)
+		}
+		return cs.contents, cs.exists, nil
+	}
+	var contents []byte
+	f, err := openLocked(path, os.O_RDWR, wo.lockTimeout)
+	if err == nil {
+		contents, err = io.ReadAll(f)
+		closeLocked(f)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, false, wrapFileError(path, err)
+	}
+	exists := (err == nil)
+	*cs = ChangeSet{
+		Path:       path,
+		loaded:     true,
+		origExists: exists,
+		orig:       contents,
+		origDigest: sha256.Sum256(contents),
+		exists:     exists,
+		contents:   contents,
+	}
+	return contents, exists, nil
+}
+
+// rewrite plans a rewrite of the file at path by transform, in the same
+// manner as rewriteFile.
+func (cs *ChangeSet) rewrite(path string, wo writeOptions, transform func(in io.Reader, out io.Writer) error) error {
+	contents, exists, err := cs.load(path, wo)
+	if err != nil {
+		return err
+	} else if !exists {
+		return &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	var out bytes.Buffer
+	if err := transform(bytes.NewReader(contents), &out); err == errUnchanged {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	cs.update(out.Bytes())
+	return nil
+}
+
+// append plans an append to the file at path, in the same manner as
+// appendToFile.
+func (cs *ChangeSet) append(path string, wo writeOptions, generate func(existing []byte) ([]byte, error)) error {
+	contents, _, err := cs.load(path, wo)
+	if err != nil {
+		return err
+	}
+	data, err := generate(contents)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	newContents := append([]byte(nil), contents...)
+	if len(contents) > 0 && contents[len(contents)-1] != '\n' {
+		newContents = append(newContents, '\n')
+	}
+	cs.exists = true
+	cs.update(append(newContents, data...))
+	return nil
+}
+
+// update sets the planned contents of the file, and recomputes cs.Changes.
+func (cs *ChangeSet) update(contents []byte) {
+	cs.contents = contents
+	cs.Changes = diffLines(cs.Path, splitLines(cs.orig), splitLines(contents))
+}
+
+// splitLines splits contents into lines without their line endings.
+func splitLines(contents []byte) []string {
+	if len(contents) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(contents), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for n := range lines {
+		lines[n], _ = splitLineEnding(lines[n])
+	}
+	return lines
+}
+
+// diffLines returns the changes needed to transform the lines in before into
+// the lines in after. Since the operations in this package only modify, remove,
+// or insert lines in place, without reordering them, this uses a simple
+// approach: after each mismatch, the nearest following pair of identical lines
+// is found, and the lines in between are compared by diffHunk.
+func diffLines(filename string, before, after []string) (changes []Change) {
+	var i, j int
+	for i < len(before) || j < len(after) {
+		if i < len(before) && j < len(after) && before[i] == after[j] {
+			i, j = i+1, j+1
+			continue
+		}
+		nextI, nextJ := resync(before, after, i, j)
+		changes = append(changes, diffHunk(filename, before[i:nextI], after[j:nextJ], i)...)
+		i, j = nextI, nextJ
+	}
+	return changes
+}
+
+// diffHunk returns the changes needed to transform the lines in before, which
+// begin after line offset of the original file, into the lines in after. Each
+// original line is reported as modified if a new line has the same key and
+// comment, such as when its host patterns are changed or hashed, or otherwise
+// if the next new line does not have the key of a later original line, such
+// as when its key is replaced. Other lines are reported as removed or added.
+func diffHunk(filename string, before, after []string, offset int) (changes []Change) {
+	var j int
+	for i, line := range before {
+		m, found := j, false
+		for ; m < len(after); m++ {
+			if found = lineKeyFields(after[m]) == lineKeyFields(line); found {
+				break
+			}
+		}
+		if !found && j < len(after) && !hasKeyFields(before[i+1:], lineKeyFields(after[j])) {
+			m, found = j, true
+		}
+		if !found {
+			changes = append(changes, Change{Kind: ChangeRemove, Filename: filename, Line: offset + i + 1, Before: line})
+			continue
+		}
+		for ; j < m; j++ {
+			changes = append(changes, Change{Kind: ChangeAdd, Filename: filename, Line: offset + i, After: after[j]})
+		}
+		changes = append(changes, Change{Kind: ChangeModify, Filename: filename, Line: offset + i + 1, Before: line, After: after[m]})
+		j = m + 1
+	}
+	for ; j < len(after); j++ {
+		changes = append(changes, Change{Kind: ChangeAdd, Filename: filename, Line: offset + len(before), After: after[j]})
+	}
+	return changes
+}
+
+// lineKeyFields returns the portion of a known_hosts line following its marker
+// (if any) and host patterns, consisting of its key type, key, and comment.
+// Other lines, such as comments, are returned as-is.
+func lineKeyFields(line string) string {
+	_, patterns, rest := splitFirstField(line)
+	if strings.HasPrefix(patterns, "@") {
+		_, _, rest = splitFirstField(rest)
+	} else if patterns == "" || patterns[0] == '#' {
+		return line
+	}
+	return strings.TrimLeft(rest, " \t")
+}
+
+// hasKeyFields returns true if any of lines has the supplied key fields, as
+// returned by lineKeyFields.
+func hasKeyFields(lines []string, keyFields string) bool {
+	for _, line := range lines {
+		if lineKeyFields(line) == keyFields {
+			return true
+		}
+	}
+	return false
+}
+
+// resync returns the positions of the nearest pair of identical lines in
+// before and after, at or beyond positions i and j respectively, minimizing
+// the total number of lines skipped. If there is no such pair, the lengths of
+// before and after are returned.
+func resync(before, after []string, i, j int) (int, int) {
+	remaining := (len(before) - i) + (len(after) - j)
+	for d := 1; d <= remaining; d++ {
+		for di := 0; di <= d; di++ {
+			bi, aj := i+di, j+d-di
+			if bi < len(before) && aj < len(after) && before[bi] == after[aj] {
+				return bi, aj
+			}
+		}
+	}
+	return len(before), len(after)
+}
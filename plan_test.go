@@ -0,0 +1,248 @@
+package knownhosts
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestWithPlan(t *testing.T) {
+	key1, key2, key3 := generatePubKeyEd25519(t), generatePubKeyECDSA(t), generatePubKeyEd25519(t)
+	original := "# comment\n" +
+		"host1.example.test,10.0.0.1 " + keyString(key1) + "\n" +
+		"host2.example.test " + keyString(key2) + "\n" +
+		"host3.example.test " + keyString(key1) + "\n"
+	dir := t.TempDir()
+	writeFiles := func() (planPath, realPath string) {
+		planPath, realPath = filepath.Join(dir, "planned"), filepath.Join(dir, "real")
+		for _, path := range []string{planPath, realPath} {
+			if err := os.WriteFile(path, []byte(original), 0600); err != nil {
+				t.Fatalf("Unable to write %s: %v", path, err)
+			}
+		}
+		return planPath, realPath
+	}
+	readFile := func(path string) string {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Unable to read %s: %v", path, err)
+		}
+		return string(contents)
+	}
+
+	cases := []struct {
+		name    string
+		op      func(path string, opts ...WriteOption) error
+		changes []string // expected Change.String() output, with the path removed
+	}{
+		{
+			name: "RemoveHost",
+			op: func(path string, opts ...WriteOption) error {
+				_, err := RemoveHost(path, "host2.example.test", opts...)
+				return err
+			},
+			changes: []string{"3: - host2.example.test " + keyString(key2)},
+		},
+		{
+			name: "RemoveByKey",
+			op: func(path string, opts ...WriteOption) error {
+				_, err := RemoveByKey(path, key1, opts...)
+				return err
+			},
+			changes: []string{
+				"2: - host1.example.test,10.0.0.1 " + keyString(key1),
+				"4: - host3.example.test " + keyString(key1),
+			},
+		},
+		{
+			name: "ReplaceHostKey",
+			op: func(path string, opts ...WriteOption) error {
+				return ReplaceHostKey(path, "host3.example.test", key3, opts...)
+			},
+			changes: []string{"4: ~ host3.example.test " + keyString(key1) + " => host3.example.test " + keyString(key3)},
+		},
+		{
+			name: "Prune",
+			op: func(path string, opts ...WriteOption) error {
+				_, err := Prune(path, func(e Entry) bool { return e.Key.Type() != ssh.KeyAlgoED25519 }, opts...)
+				return err
+			},
+			changes: []string{
+				"2: - host1.example.test,10.0.0.1 " + keyString(key1),
+				"4: - host3.example.test " + keyString(key1),
+			},
+		},
+		{
+			name: "AppendKnownHostMerge",
+			op: func(path string, opts ...WriteOption) error {
+				remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 22}
+				return AppendKnownHost(path, "host2.example.test", remote, key2, append(opts, WithMergeAddresses())...)
+			},
+			changes: []string{"3: ~ host2.example.test " + keyString(key2) + " => host2.example.test,10.0.0.2 " + keyString(key2)},
+		},
+		{
+			name: "AppendKnownHost",
+			op: func(path string, opts ...WriteOption) error {
+				return AppendKnownHost(path, "host4.example.test", nil, key3, opts...)
+			},
+			changes: []string{"4: + host4.example.test " + keyString(key3)},
+		},
+		{
+			name: "Combined",
+			op: func(path string, opts ...WriteOption) error {
+				if _, err := RemoveHost(path, "host1.example.test", opts...); err != nil {
+					return err
+				}
+				return ReplaceHostKey(path, "host3.example.test", key3, opts...)
+			},
+			changes: []string{
+				"2: ~ host1.example.test,10.0.0.1 " + keyString(key1) + " => 10.0.0.1 " + keyString(key1),
+				"4: ~ host3.example.test " + keyString(key1) + " => host3.example.test " + keyString(key3),
+			},
+		},
+	}
+	for _, tc := range cases {
+		planPath, realPath := writeFiles()
+		var cs ChangeSet
+		if err := tc.op(planPath, WithPlan(&cs)); err != nil {
+			t.Fatalf("%s: Unexpected error planning changes: %v", tc.name, err)
+		}
+		if err := tc.op(realPath); err != nil {
+			t.Fatalf("%s: Unexpected error making changes: %v", tc.name, err)
+		}
+		if readFile(planPath) != original {
+			t.Errorf("%s: File was modified while planning changes", tc.name)
+		}
+		if cs.Path != planPath || cs.Empty() {
+			t.Errorf("%s: Unexpected ChangeSet path %q or emptiness %t", tc.name, cs.Path, cs.Empty())
+		}
+		expected := planPath + ":" + strings.Join(tc.changes, "\n"+planPath+":") + "\n"
+		if cs.String() != expected {
+			t.Errorf("%s: Unexpected changes\nexpected:\n%s\nfound:\n%s", tc.name, expected, cs.String())
+		}
+		if string(cs.Contents()) != readFile(realPath) {
+			t.Errorf("%s: Planned contents differ from actual result\nplanned:\n%s\nactual:\n%s", tc.name, cs.Contents(), readFile(realPath))
+		}
+		if err := cs.Apply(); err != nil {
+			t.Errorf("%s: Unexpected error from Apply: %v", tc.name, err)
+		} else if readFile(planPath) != readFile(realPath) {
+			t.Errorf("%s: Applied contents differ from actual result", tc.name)
+		}
+	}
+}
+
+func TestWithPlanHashFile(t *testing.T) {
+	key1, key2 := generatePubKeyEd25519(t), generatePubKeyECDSA(t)
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	original := "# comment\n" +
+		"host1.example.test,10.0.0.1 " + keyString(key1) + "\n" +
+		"host2.example.test " + keyString(key2) + "\n"
+	if err := os.WriteFile(khPath, []byte(original), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+
+	// Hashing splits line 2 into two lines, so it is reported as modified
+	// followed by an added line
+	var cs ChangeSet
+	if _, err := HashFile(khPath, WithPlan(&cs)); err != nil {
+		t.Fatalf("Unexpected error from HashFile: %v", err)
+	}
+	kinds := make([]string, len(cs.Changes))
+	for n, c := range cs.Changes {
+		kinds[n] = c.Kind.String() + strconv.Itoa(c.Line)
+	}
+	if actual := strings.Join(kinds, " "); actual != "~2 +2 ~3" {
+		t.Errorf("Unexpected changes from HashFile: %s\n%s", actual, cs.String())
+	}
+	if contents, _ := os.ReadFile(khPath); string(contents) != original {
+		t.Error("File was modified while planning changes")
+	}
+	if err := cs.Apply(); err != nil {
+		t.Fatalf("Unexpected error from Apply: %v", err)
+	}
+	entries, err := ReadEntries(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadEntries: %v", err)
+	}
+	if len(entries) != 3 || !entries[0].Hashed() || !entries[1].Hashed() || !entries[2].Hashed() {
+		t.Errorf("Expected 3 hashed entries after Apply, instead found %+v", entries)
+	}
+}
+
+func TestChangeSetApplyStale(t *testing.T) {
+	key := generatePubKeyEd25519(t)
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	original := "host1.example.test " + keyString(key) + "\n"
+	if err := os.WriteFile(khPath, []byte(original), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	var cs ChangeSet
+	if removed, err := RemoveHost(khPath, "host1.example.test", WithPlan(&cs)); err != nil || removed != 1 {
+		t.Fatalf("Unexpected result from RemoveHost: %d, %v", removed, err)
+	}
+
+	// Planning changes to another file with the same ChangeSet fails
+	if err := AppendKnownHost(khPath+".other", "host2.example.test", nil, key, WithPlan(&cs)); err == nil {
+		t.Error("Expected error planning changes to another file")
+	}
+
+	// A change made after planning prevents Apply, even if the size and
+	// modification time are preserved
+	fi, err := os.Stat(khPath)
+	if err != nil {
+		t.Fatalf("Unable to stat %s: %v", khPath, err)
+	}
+	modified := "host9.example.test " + keyString(key) + "\n"
+	if err := os.WriteFile(khPath, []byte(modified), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	os.Chtimes(khPath, fi.ModTime(), fi.ModTime())
+	if err := cs.Apply(); !errors.Is(err, ErrStale) {
+		t.Errorf("Expected error wrapping ErrStale from Apply, instead found %v", err)
+	}
+	if contents, _ := os.ReadFile(khPath); string(contents) != modified {
+		t.Errorf("Expected stale Apply to leave file unchanged, instead found %q", contents)
+	}
+	os.Remove(khPath)
+	if err := cs.Apply(); !errors.Is(err, ErrStale) {
+		t.Errorf("Expected error wrapping ErrStale from Apply after removal, instead found %v", err)
+	}
+
+	// Appending to a nonexistent file plans its creation, which fails if the
+	// file is created in the meantime
+	cs = ChangeSet{}
+	if err := AppendKnownHost(khPath, "host2.example.test", nil, key, WithPlan(&cs)); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+	}
+	if _, err := os.Stat(khPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected planned append to leave file nonexistent, instead found %v", err)
+	}
+	if len(cs.Changes) != 1 || cs.Changes[0].Kind != ChangeAdd || cs.Changes[0].Line != 0 {
+		t.Errorf("Unexpected changes from planned append: %+v", cs.Changes)
+	}
+	created := cs
+	if err := created.Apply(); err != nil {
+		t.Fatalf("Unexpected error from Apply: %v", err)
+	}
+	if contents, _ := os.ReadFile(khPath); string(contents) != string(cs.Contents()) {
+		t.Errorf("Unexpected contents after Apply: %q", contents)
+	}
+	if err := cs.Apply(); !errors.Is(err, ErrStale) {
+		t.Errorf("Expected error wrapping ErrStale from Apply after creation, instead found %v", err)
+	}
+
+	// Operations which change nothing produce an empty ChangeSet
+	cs = ChangeSet{}
+	if _, err := RemoveHost(khPath, "nonexistent.example.test", WithPlan(&cs)); err != nil {
+		t.Fatalf("Unexpected error from RemoveHost: %v", err)
+	}
+	if !cs.Empty() || len(cs.Changes) > 0 || cs.Apply() != nil {
+		t.Errorf("Expected empty ChangeSet, instead found %+v", cs)
+	}
+}
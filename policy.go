@@ -0,0 +1,155 @@
+package knownhosts
+
+import (
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// PolicyMode determines how a callback returned by HostKeyCallback.WithPolicy
+// treats hosts which are not yet known, similar to the values of OpenSSH's
+// StrictHostKeyChecking option.
+type PolicyMode int
+
+// Constants representing possible policy modes.
+const (
+	PolicyStrict    PolicyMode = iota // reject unknown hosts
+	PolicyAcceptNew                   // accept and record keys of unknown hosts
+	PolicyAsk                         // accept and record keys of unknown hosts if Policy.Ask returns true
+)
+
+// Policy configures trust-on-first-use behavior for unknown hosts. Hosts whose
+// key has changed are always rejected, regardless of the policy.
+type Policy struct {
+	Mode PolicyMode
+
+	// AppendPath, if non-empty, is a known_hosts file path to which accepted
+	// keys are appended using AppendKnownHost, with WriteOptions. Unless
+	// WriteOptions includes WithHashedHostnames, WithRequireHashed,
+	// WithAutoStyle, or WithMergeAddresses, WithAutoStyle(StylePlain) is
+	// applied, so that accepted keys match the existing style of the file.
+	AppendPath   string
+	WriteOptions []WriteOption
+
+	// Ask is called in PolicyAsk mode to determine whether to accept the key of
+	// an unknown host. If it is nil, unknown hosts are rejected. Other lookups
+	// are not blocked while Ask runs, so it must be safe for concurrent use if
+	// the callback is.
+	Ask func(hostname string, remote net.Addr, key ssh.PublicKey) bool
+
+	// Metrics, if non-nil, counts keys of unknown hosts accepted by the policy.
+	Metrics *Metrics
+}
+
+// WithPolicy returns a host key callback which behaves like hkcb for known
+// hosts, and handles unknown hosts according to p. If a key is accepted but
+// appending it to p.AppendPath fails, the append error is returned and the
+// connection is rejected.
+//
+// Keys accepted by the returned callback are also remembered in memory, so
+// that a subsequent connection to the same host presenting a different key of
+// the same type is rejected with an error satisfying IsHostKeyChanged, even if
+// p.AppendPath is empty.
+func (hkcb HostKeyCallback) WithPolicy(p Policy) HostKeyCallback {
+	writeOpts := p.WriteOptions
+	if wo := newWriteOptions(writeOpts); !wo.hashed && !wo.autoStyle && !wo.mergeAddrs {
+		writeOpts = append([]WriteOption{WithAutoStyle(StylePlain)}, writeOpts...)
+	}
+	var mu sync.Mutex
+	accepted := make(map[string][]ssh.PublicKey)
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := hkcb(hostname, remote, key)
+		if !IsHostUnknown(err) || p.Mode == PolicyStrict {
+			return err
+		}
+		if _, placeholder := key.(*fakePublicKey); placeholder {
+			// Lookups via HostKeys should see keys accepted so far, but must
+			// never accept the placeholder key
+			mu.Lock()
+			defer mu.Unlock()
+			return acceptedKeyError(accepted[lookupHostname(hostname)], err)
+		}
+
+		host := lookupHostname(hostname)
+		mu.Lock()
+		decided, result := checkAccepted(accepted[host], key, err)
+		mu.Unlock()
+		if decided {
+			return result
+		}
+		// Ask may block for user input, so other connections must not wait on mu
+		// meanwhile. Another key for the host may have been accepted by the time
+		// it returns, so the accepted keys are checked again afterwards.
+		if p.Mode == PolicyAsk && (p.Ask == nil || !p.Ask(hostname, remote, key)) {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if decided, result := checkAccepted(accepted[host], key, err); decided {
+			return result
+		}
+		if p.AppendPath != "" {
+			if appendErr := AppendKnownHost(p.AppendPath, hostname, remote, key, writeOpts...); appendErr != nil {
+				return appendErr
+			}
+		}
+		accepted[host] = append(accepted[host], key)
+		if p.Metrics != nil {
+			p.Metrics.inc(metricAccepted)
+		}
+		return nil
+	}
+}
+
+// checkAccepted returns true if the result of looking up key is already decided
+// by keys, the keys previously accepted for the host, along with that result:
+// nil if key is among them, or an error satisfying IsHostKeyChanged if another
+// key of the same type is. Otherwise it returns false.
+func checkAccepted(keys []ssh.PublicKey, key ssh.PublicKey, err error) (bool, error) {
+	if containsKey(keys, key) {
+		return true, nil
+	}
+	for _, prev := range keys {
+		if prev.Type() == key.Type() {
+			return true, acceptedKeyError(keys, err)
+		}
+	}
+	return false, nil
+}
+
+// acceptedKeyError returns a *xknownhosts.KeyError listing keys, or err if keys
+// is empty.
+func acceptedKeyError(keys []ssh.PublicKey, err error) error {
+	if len(keys) == 0 {
+		return err
+	}
+	keyErr := &xknownhosts.KeyError{}
+	for _, key := range keys {
+		keyErr.Want = append(keyErr.Want, xknownhosts.KnownKey{Key: key, Filename: "accepted"})
+	}
+	return keyErr
+}
+
+// ClientConfig returns a copy of base suitable for connecting to hostWithPort,
+// with its HostKeyCallback set to hkcb and its HostKeyAlgorithms set to the
+// result of hkcb.HostKeyAlgorithms(hostWithPort). If the host is not known,
+// HostKeyAlgorithms is left nil, so that the defaults of golang.org/x/crypto/ssh
+// are used. All other fields are copied from base as-is. If base is nil, a new
+// ssh.ClientConfig is returned.
+//
+// Since HostKeyAlgorithms depends on the host being dialed, a separate config
+// should be obtained for each host, rather than sharing one config across
+// hosts.
+func (hkcb HostKeyCallback) ClientConfig(hostWithPort string, base *ssh.ClientConfig) *ssh.ClientConfig {
+	return ClientConfigFor(hkcb, hostWithPort, base)
+}
+
+// ClientConfigWithPolicy is like ClientConfig, but sets HostKeyCallback to
+// hkcb.WithPolicy(p), so that unknown hosts are handled according to p.
+func (hkcb HostKeyCallback) ClientConfigWithPolicy(hostWithPort string, base *ssh.ClientConfig, p Policy) *ssh.ClientConfig {
+	config := hkcb.ClientConfig(hostWithPort, base)
+	config.HostKeyCallback = hkcb.WithPolicy(p).HostKeyCallback()
+	return config
+}
@@ -0,0 +1,160 @@
+package knownhosts
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestWithPolicy(t *testing.T) {
+	khPath := getTestKnownHosts(t)
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	key, otherKey, ecKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t), generatePubKeyECDSA(t)
+
+	if err := kh.WithPolicy(Policy{Mode: PolicyStrict})("new.example.test:22", noAddr, key); !IsHostUnknown(err) {
+		t.Errorf("Expected strict policy to reject unknown host, instead found %v", err)
+	}
+
+	var asked int
+	answer := false
+	ask := kh.WithPolicy(Policy{Mode: PolicyAsk, Ask: func(string, net.Addr, ssh.PublicKey) bool {
+		asked++
+		return answer
+	}})
+	if err := ask("new.example.test:22", noAddr, key); !IsHostUnknown(err) || asked != 1 {
+		t.Errorf("Expected declined key to be rejected, instead found %v", err)
+	}
+	answer = true
+	if err := ask("new.example.test:22", noAddr, key); err != nil || asked != 2 {
+		t.Errorf("Expected approved key to be accepted, instead found %v", err)
+	}
+	if err := kh.WithPolicy(Policy{Mode: PolicyAsk})("new.example.test:22", noAddr, key); !IsHostUnknown(err) {
+		t.Errorf("Expected unknown host error with nil Ask, instead found %v", err)
+	}
+
+	cb := kh.WithPolicy(Policy{Mode: PolicyAcceptNew, AppendPath: khPath})
+	if err := cb("new.example.test:22", noAddr, key); err != nil {
+		t.Errorf("Expected accept-new policy to accept unknown host, instead found %v", err)
+	}
+	if keys := cb.HostKeys("new.example.test:22"); len(keys) != 1 {
+		t.Errorf("Expected HostKeys to include accepted key, instead found %v", keys)
+	}
+	if err := cb("NEW.example.test:22", noAddr, otherKey); !IsHostKeyChanged(err) {
+		t.Errorf("Expected different key for accepted host to be rejected, instead found %v", err)
+	}
+	if err := cb("new.example.test:22", noAddr, ecKey); err != nil {
+		t.Errorf("Expected key of another type to be accepted, instead found %v", err)
+	}
+	if err := cb("multi.example.test:2233", noAddr, key); !IsHostKeyChanged(err) {
+		t.Errorf("Expected changed key to be rejected regardless of policy, instead found %v", err)
+	}
+	if kh, err = New(khPath); err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	} else if err := kh("new.example.test:22", noAddr, key); err != nil {
+		t.Errorf("Expected accepted key to be appended, instead found %v", err)
+	}
+	if keys := kh.HostKeys("unknown.example.test:22"); len(keys) != 0 {
+		t.Errorf("Expected placeholder key never to be accepted, instead found %v", keys)
+	}
+
+	// Accepted keys match the style of the file by default, unless the style
+	// is set explicitly via WriteOptions
+	hashedPath := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(hashedPath, []byte(Line([]string{"existing.example.test"}, key, WithHashedHostnames())+"\n"), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", hashedPath, err)
+	}
+	if err := kh.WithPolicy(Policy{Mode: PolicyAcceptNew, AppendPath: hashedPath})("auto.example.test:22", noAddr, key); err != nil {
+		t.Errorf("Expected accept-new policy to accept unknown host, instead found %v", err)
+	}
+	if err := kh.WithPolicy(Policy{Mode: PolicyAcceptNew, AppendPath: hashedPath, WriteOptions: []WriteOption{WithMergeAddresses()}})("plain.example.test:22", noAddr, otherKey); err != nil {
+		t.Errorf("Expected accept-new policy to accept unknown host, instead found %v", err)
+	}
+	if contents, err := os.ReadFile(hashedPath); err != nil {
+		t.Fatalf("Unable to read %s: %v", hashedPath, err)
+	} else if strings.Contains(string(contents), "auto.example.test") || !strings.Contains(string(contents), "plain.example.test") {
+		t.Errorf("Unexpected contents after policy appends: %q", contents)
+	}
+	if style, err := DetectStyle(hashedPath); err != nil || style.Style != StyleMixed {
+		t.Errorf("Expected StyleMixed after policy appends, instead found %+v, %v", style, err)
+	}
+}
+
+func TestWithPolicyConcurrentAsk(t *testing.T) {
+	kh, err := NewFromEntries(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromEntries: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	slowKey, fastKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t)
+
+	// Ask blocks for slowKey until released, simulating a user prompt
+	asking, release := make(chan struct{}), make(chan struct{})
+	cb := kh.WithPolicy(Policy{Mode: PolicyAsk, Ask: func(hostname string, remote net.Addr, key ssh.PublicKey) bool {
+		if KeysEqual(key, slowKey) {
+			close(asking)
+			<-release
+		}
+		return true
+	}})
+	slowResult := make(chan error)
+	go func() {
+		slowResult <- cb("slow.example.test:22", noAddr, slowKey)
+	}()
+	<-asking
+
+	// Other lookups must not wait for the pending prompt
+	if err := cb("other.example.test:22", noAddr, fastKey); err != nil {
+		t.Errorf("Unexpected error from callback for other host: %v", err)
+	}
+	if keys := cb.HostKeys("slow.example.test:22"); len(keys) != 0 {
+		t.Errorf("Expected no keys for host with pending prompt, instead found %v", keys)
+	}
+
+	// A key of the same type accepted for the host meanwhile takes precedence
+	// once the prompt returns
+	if err := cb("slow.example.test:22", noAddr, fastKey); err != nil {
+		t.Errorf("Unexpected error from callback for slow host: %v", err)
+	}
+	close(release)
+	if err := <-slowResult; !IsHostKeyChanged(err) {
+		t.Errorf("Expected key approved after another key of the same type to be rejected, instead found %v", err)
+	}
+	if keys := cb.HostKeys("slow.example.test:22"); len(keys) != 1 || !KeysEqual(keys[0], fastKey) {
+		t.Errorf("Expected only the first accepted key, instead found %v", keys)
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	kh, err := New(getTestKnownHosts(t))
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	base := &ssh.ClientConfig{User: "someone", HostKeyAlgorithms: []string{ssh.KeyAlgoRSA}}
+	config := kh.ClientConfig("only-ecdsa.example.test:22", base)
+	if config == base || config.User != "someone" || config.HostKeyCallback == nil {
+		t.Errorf("Unexpected config: %+v", config)
+	}
+	if len(config.HostKeyAlgorithms) != 1 || config.HostKeyAlgorithms[0] != ssh.KeyAlgoECDSA256 {
+		t.Errorf("Unexpected HostKeyAlgorithms: %v", config.HostKeyAlgorithms)
+	}
+	if len(base.HostKeyAlgorithms) != 1 || base.HostKeyAlgorithms[0] != ssh.KeyAlgoRSA || base.HostKeyCallback != nil {
+		t.Error("ClientConfig unexpectedly modified base")
+	}
+	if config = kh.ClientConfig("unknown.example.test:22", nil); config.HostKeyAlgorithms != nil || config.HostKeyCallback == nil {
+		t.Errorf("Unexpected config for unknown host: %+v", config)
+	}
+
+	config = kh.ClientConfigWithPolicy("unknown.example.test:22", base, Policy{Mode: PolicyAcceptNew})
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	if err := config.HostKeyCallback("unknown.example.test:22", noAddr, generatePubKeyEd25519(t)); err != nil {
+		t.Errorf("Expected policy callback to accept unknown host, instead found %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package putty
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultHostKeysFile returns the path of the file in which PuTTY and plink
+// store host keys on Unix systems, ~/.putty/sshhostkeys.
+func DefaultHostKeysFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".putty", "sshhostkeys"), nil
+}
+
+// LoadHostKeysFile returns an entry for each host key in the file at path,
+// which should be in the format used by PuTTY and plink on Unix systems: one
+// "type@port:host value" line per key, using the same key names and value
+// encodings as PuTTY's Windows registry store. Each entry has a single pattern,
+// formatted in the same manner as Normalize, along with the Filename and Line
+// it was read from.
+//
+// Lines which cannot be converted, including legacy SSH-1 "rsa" keys, key types
+// which are not supported by golang.org/x/crypto/ssh, and malformed lines, are
+// returned in skipped rather than causing an error. If the file does not exist,
+// the returned error satisfies errors.Is(err, fs.ErrNotExist).
+func LoadHostKeysFile(path string) (entries []knownhosts.Entry, skipped []Skipped, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		name, value := line, ""
+		if space := strings.IndexByte(line, ' '); space >= 0 {
+			name, value = line[:space], strings.TrimSpace(line[space+1:])
+		}
+		before := len(entries)
+		entries, skipped = convert(entries, skipped, name, value)
+		if len(entries) > before {
+			entries[before].Filename = path
+			entries[before].Line = lineNum
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return entries, skipped, nil
+}
+
+// StoreHostKeyFile stores key as a trusted host key for host and port in the
+// file at path, in the same format as PuTTY and plink on Unix systems. Any key
+// of the same type previously stored for host and port is replaced, and as in
+// PuTTY, the new line is placed at the start of the file. If the file does not
+// exist yet, it is created with mode 0600, and its parent directory is created
+// with mode 0700 if needed. The file is rewritten using knownhosts.RewriteFile,
+// so the supplied options are handled in the same manner.
+//
+// See StoreRegistryHostKey for the supported key types and host format.
+func StoreHostKeyFile(path string, host string, port int, key ssh.PublicKey, opts ...knownhosts.WriteOption) error {
+	keyType, value, err := formatKey(key)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(host, " \t\r\n") {
+		return &knownhosts.InvalidHostnameError{Address: host, Reason: "contains spaces"}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	name := formatName(keyType, port, host)
+	return knownhosts.RewriteFile(path, func(in io.Reader, out io.Writer) error {
+		contents, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		buf.WriteString(name + " " + value + "\n")
+		for _, line := range strings.SplitAfter(string(contents), "\n") {
+			if line == "" || strings.HasPrefix(line, name+" ") {
+				continue
+			}
+			buf.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				buf.WriteByte('\n')
+			}
+		}
+		_, err = out.Write(buf.Bytes())
+		return err
+	}, opts...)
+}
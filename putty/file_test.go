@@ -0,0 +1,107 @@
+package putty
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skeema/knownhosts"
+	"github.com/skeema/knownhosts/knownhoststest"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLoadHostKeysFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sshhostkeys")
+	edValue := "0x216936d3cd6e53fec0a4e231fdd6dc5c692cc7609525a7b2c9562d608f25d51a,0x6666666666666666666666666666666666666666666666666666666666666658"
+	contents := "ssh-ed25519@22:example.test " + edValue + "\n" +
+		"rsa@22:legacy.example.test 0x23,0xabcdef\n" +
+		"\n" +
+		"ssh-ed448@22:example.test 0x1,0x2\n" +
+		"garbage\n" +
+		"ssh-ed25519@2222:2001:db8::1 " + edValue + "\n"
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", path, err)
+	}
+	entries, skipped, err := LoadHostKeysFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error from LoadHostKeysFile: %v", err)
+	}
+	if len(entries) != 2 || len(skipped) != 3 {
+		t.Fatalf("Expected 2 entries and 3 skipped, instead found %d and %d", len(entries), len(skipped))
+	}
+	if entries[0].Patterns[0] != "example.test" || entries[0].Filename != path || entries[0].Line != 1 {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].Patterns[0] != "[2001:db8::1]:2222" || entries[1].Line != 6 {
+		t.Errorf("Unexpected second entry: %+v", entries[1])
+	}
+	if skipped[0].Name != "rsa@22:legacy.example.test" || skipped[0].Reason != "unsupported SSH-1 key" {
+		t.Errorf("Unexpected skipped entry: %+v", skipped[0])
+	}
+	if skipped[2].Name != "garbage" {
+		t.Errorf("Unexpected skipped entry: %+v", skipped[2])
+	}
+
+	// Entries can be used to build a callback
+	cb, err := knownhosts.NewFromEntries(entries)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromEntries: %v", err)
+	}
+	if keys := cb.HostKeys("example.test:22"); len(keys) != 1 || !bytes.Equal(keys[0].Marshal(), entries[0].Key.Marshal()) {
+		t.Errorf("Unexpected return from HostKeys: %v", keys)
+	}
+
+	if _, _, err := LoadHostKeysFile(path + ".missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected LoadHostKeysFile to return an fs.ErrNotExist error, instead found %v", err)
+	}
+}
+
+func TestStoreHostKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".putty", "sshhostkeys")
+	key1, key2, key3 := knownhoststest.GenerateKey(t, ssh.KeyAlgoED25519), knownhoststest.GenerateKey(t, ssh.KeyAlgoED25519), knownhoststest.GenerateKey(t, ssh.KeyAlgoED25519)
+	if err := StoreHostKeyFile(path, "example.test", 22, key1); err != nil {
+		t.Fatalf("Unexpected error from StoreHostKeyFile: %v", err)
+	}
+	if err := StoreHostKeyFile(path, "other.example.test", 2222, key2); err != nil {
+		t.Fatalf("Unexpected error from StoreHostKeyFile: %v", err)
+	}
+	if err := StoreHostKeyFile(path, "example.test", 22, key3); err != nil {
+		t.Fatalf("Unexpected error from StoreHostKeyFile: %v", err)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatalf("Unexpected error from Stat: %v", err)
+	} else if fi.Mode().Perm() != 0600 && os.PathSeparator == '/' {
+		t.Errorf("Expected file mode 0600, instead found %o", fi.Mode().Perm())
+	}
+
+	entries, skipped, err := LoadHostKeysFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error from LoadHostKeysFile: %v", err)
+	}
+	if len(entries) != 2 || len(skipped) != 0 {
+		t.Fatalf("Expected 2 entries and 0 skipped, instead found %d and %d", len(entries), len(skipped))
+	}
+	if entries[0].Patterns[0] != "example.test" || !bytes.Equal(entries[0].Key.Marshal(), key3.Marshal()) {
+		t.Errorf("Expected most recently stored key first, instead found %+v", entries[0])
+	}
+	if entries[1].Patterns[0] != "[other.example.test]:2222" || !bytes.Equal(entries[1].Key.Marshal(), key2.Marshal()) {
+		t.Errorf("Unexpected second entry: %+v", entries[1])
+	}
+
+	if err := StoreHostKeyFile(path, "bad host", 22, key1); err == nil {
+		t.Error("Expected StoreHostKeyFile to return an error for a hostname with spaces, but it did not")
+	}
+	cert := &ssh.Certificate{Key: key1, CertType: ssh.HostCert}
+	if err := StoreHostKeyFile(path, "example.test", 22, cert); err == nil {
+		t.Error("Expected StoreHostKeyFile to return an error for a certificate, but it did not")
+	}
+	if contents, err := os.ReadFile(path); err != nil {
+		t.Fatalf("Unable to read %s: %v", path, err)
+	} else if lines := strings.Count(string(contents), "\n"); lines != 2 {
+		t.Errorf("Expected file to have 2 lines, instead found %d", lines)
+	}
+}
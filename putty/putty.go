@@ -0,0 +1,312 @@
+// Package putty converts host keys stored by PuTTY into entries compatible with
+// github.com/skeema/knownhosts. On Windows, PuTTY stores host keys in the
+// registry; see LoadRegistryHostKeys and StoreRegistryHostKey. On other
+// systems, PuTTY and plink store host keys in a text file using the same
+// format; see LoadHostKeysFile and StoreHostKeyFile.
+//
+// PuTTY identifies each stored key by a name of the form "type@port:host", for
+// example "ssh-ed25519@22:example.com", and stores the key itself as a
+// comma-separated list of hex-encoded numbers whose meaning depends on the key
+// type.
+package putty
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+)
+
+// Skipped describes a stored PuTTY host key which could not be converted, for
+// example because it uses a key type which is not supported by
+// golang.org/x/crypto/ssh.
+type Skipped struct {
+	Name   string // PuTTY's name for the key, in "type@port:host" form
+	Reason string
+}
+
+// errUnsupportedType is returned by parseKey for key types which cannot be
+// represented as an ssh.PublicKey, such as SSH-1 "rsa" keys or "ssh-ed448".
+var errUnsupportedType = errors.New("unsupported key type")
+
+// parseName splits a PuTTY host key name of the form "type@port:host" into
+// its components.
+func parseName(name string) (keyType string, port int, host string, err error) {
+	at := strings.IndexByte(name, '@')
+	colon := strings.IndexByte(name, ':')
+	if at < 1 || colon < at+2 || colon == len(name)-1 {
+		return "", 0, "", fmt.Errorf("knownhosts: malformed PuTTY host key name %q", name)
+	}
+	port, err = strconv.Atoi(name[at+1 : colon])
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, "", fmt.Errorf("knownhosts: malformed port in PuTTY host key name %q", name)
+	}
+	return name[:at], port, name[colon+1:], nil
+}
+
+// parseKey converts a PuTTY host key value of the supplied type into an
+// ssh.PublicKey.
+func parseKey(keyType, value string) (ssh.PublicKey, error) {
+	fields := strings.Split(value, ",")
+	var curve elliptic.Curve
+	switch keyType {
+	case "ecdsa-sha2-nistp256":
+		curve = elliptic.P256()
+	case "ecdsa-sha2-nistp384":
+		curve = elliptic.P384()
+	case "ecdsa-sha2-nistp521":
+		curve = elliptic.P521()
+	}
+	if curve != nil {
+		// The first field is the curve name, such as "nistp256"
+		if len(fields) != 3 || fields[0] != strings.TrimPrefix(keyType, "ecdsa-sha2-") {
+			return nil, fmt.Errorf("knownhosts: malformed PuTTY %s value", keyType)
+		}
+		fields = fields[1:]
+	}
+	nums := make([]*big.Int, len(fields))
+	for n, field := range fields {
+		hex := strings.TrimPrefix(strings.TrimPrefix(field, "0x"), "0X")
+		num, ok := new(big.Int).SetString(hex, 16)
+		if !ok || hex == "" || hex[0] == '-' || hex[0] == '+' {
+			return nil, fmt.Errorf("knownhosts: malformed number in PuTTY %s value", keyType)
+		}
+		nums[n] = num
+	}
+
+	var wantFields int
+	var pub interface{}
+	switch keyType {
+	case "rsa2":
+		// e, n
+		wantFields = 2
+		if len(nums) == wantFields {
+			if !nums[0].IsInt64() || nums[0].Int64() > int64(^uint32(0)>>1) {
+				return nil, fmt.Errorf("knownhosts: unsupported RSA exponent in PuTTY %s value", keyType)
+			}
+			pub = &rsa.PublicKey{E: int(nums[0].Int64()), N: nums[1]}
+		}
+	case "dss":
+		// p, q, g, y
+		wantFields = 4
+		if len(nums) == wantFields {
+			pub = &dsa.PublicKey{
+				Parameters: dsa.Parameters{P: nums[0], Q: nums[1], G: nums[2]},
+				Y:          nums[3],
+			}
+		}
+	case "ssh-ed25519":
+		// x, y of the curve point
+		wantFields = 2
+		if len(nums) == wantFields {
+			if nums[1].BitLen() > 255 {
+				return nil, fmt.Errorf("knownhosts: malformed PuTTY %s value", keyType)
+			}
+			pub = ed25519.PublicKey(encodeEd25519(nums[0], nums[1]))
+		}
+	case "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521":
+		// x, y of the curve point
+		wantFields = 2
+		if len(nums) == wantFields {
+			if !curve.IsOnCurve(nums[0], nums[1]) {
+				return nil, fmt.Errorf("knownhosts: PuTTY %s value is not a point on the curve", keyType)
+			}
+			pub = &ecdsa.PublicKey{Curve: curve, X: nums[0], Y: nums[1]}
+		}
+	default:
+		return nil, errUnsupportedType
+	}
+	if len(nums) != wantFields {
+		return nil, fmt.Errorf("knownhosts: malformed PuTTY %s value", keyType)
+	}
+	return ssh.NewPublicKey(pub)
+}
+
+// encodeEd25519 returns the standard 32-byte encoding of the Ed25519 point
+// (x, y): y in little-endian form, with the top bit holding the low bit of x.
+func encodeEd25519(x, y *big.Int) []byte {
+	buf := make([]byte, ed25519.PublicKeySize)
+	y.FillBytes(buf)
+	for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+		buf[i], buf[j] = buf[j], buf[i]
+	}
+	if x.Bit(0) == 1 {
+		buf[len(buf)-1] |= 0x80
+	}
+	return buf
+}
+
+// formatKey returns PuTTY's key type name and value encoding for key. An error
+// is returned for key types which PuTTY does not support, such as certificates
+// or security key types.
+func formatKey(key ssh.PublicKey) (keyType, value string, err error) {
+	unsupported := fmt.Errorf("knownhosts: PuTTY does not support %s host keys", key.Type())
+	cpk, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return "", "", unsupported
+	}
+	switch pub := cpk.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return "rsa2", fmt.Sprintf("0x%x,0x%x", pub.E, pub.N), nil
+	case *dsa.PublicKey:
+		return "dss", fmt.Sprintf("0x%x,0x%x,0x%x,0x%x", pub.P, pub.Q, pub.G, pub.Y), nil
+	case *ecdsa.PublicKey:
+		if key.Type() != ssh.KeyAlgoECDSA256 && key.Type() != ssh.KeyAlgoECDSA384 && key.Type() != ssh.KeyAlgoECDSA521 {
+			return "", "", unsupported
+		}
+		curveName := strings.TrimPrefix(key.Type(), "ecdsa-sha2-")
+		return key.Type(), fmt.Sprintf("%s,0x%x,0x%x", curveName, pub.X, pub.Y), nil
+	case ed25519.PublicKey:
+		if key.Type() != ssh.KeyAlgoED25519 {
+			return "", "", unsupported
+		}
+		x, y, err := decodeEd25519(pub)
+		if err != nil {
+			return "", "", err
+		}
+		return key.Type(), fmt.Sprintf("0x%x,0x%x", x, y), nil
+	}
+	return "", "", unsupported
+}
+
+// ed25519P is the field prime 2^255 - 19 used by Ed25519.
+var ed25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// decodeEd25519 returns the coordinates of the Ed25519 point encoded in pub,
+// which PuTTY stores instead of the standard 32-byte encoding. This is the
+// inverse of encodeEd25519, following the decoding procedure in RFC 8032
+// section 5.1.3.
+func decodeEd25519(pub ed25519.PublicKey) (x, y *big.Int, err error) {
+	malformed := errors.New("knownhosts: malformed ssh-ed25519 public key")
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, nil, malformed
+	}
+	buf := make([]byte, len(pub))
+	for n := range pub {
+		buf[len(buf)-1-n] = pub[n]
+	}
+	sign := uint(buf[0] >> 7)
+	buf[0] &= 0x7f
+	y = new(big.Int).SetBytes(buf)
+	if y.Cmp(ed25519P) >= 0 {
+		return nil, nil, malformed
+	}
+
+	// x^2 = (y^2 - 1) / (d*y^2 + 1), where d = -121665/121666
+	p := ed25519P
+	d := new(big.Int).Mul(big.NewInt(-121665), new(big.Int).ModInverse(big.NewInt(121666), p))
+	d.Mod(d, p)
+	y2 := new(big.Int).Mul(y, y)
+	u := new(big.Int).Sub(y2, big.NewInt(1))
+	u.Mod(u, p)
+	v := new(big.Int).Mul(d, y2)
+	v.Add(v, big.NewInt(1))
+	v.Mod(v, p)
+	x2 := new(big.Int).Mul(u, new(big.Int).ModInverse(v, p))
+	x2.Mod(x2, p)
+
+	exp := new(big.Int).Add(p, big.NewInt(3))
+	exp.Rsh(exp, 3)
+	x = new(big.Int).Exp(x2, exp, p)
+	if new(big.Int).Exp(x, big.NewInt(2), p).Cmp(x2) != 0 {
+		// Multiply by sqrt(-1) = 2^((p-1)/4)
+		exp = new(big.Int).Sub(p, big.NewInt(1))
+		exp.Rsh(exp, 2)
+		x.Mul(x, new(big.Int).Exp(big.NewInt(2), exp, p))
+		x.Mod(x, p)
+		if new(big.Int).Exp(x, big.NewInt(2), p).Cmp(x2) != 0 {
+			return nil, nil, malformed
+		}
+	}
+	if x.Sign() == 0 && sign == 1 {
+		return nil, nil, malformed
+	} else if x.Bit(0) != sign {
+		x.Sub(p, x)
+	}
+	return x, y, nil
+}
+
+// formatName returns PuTTY's name for a host key of keyType for host and port.
+func formatName(keyType string, port int, host string) string {
+	return keyType + "@" + strconv.Itoa(port) + ":" + host
+}
+
+// newEntry converts a PuTTY host key name and value into an Entry. The
+// returned error is errUnsupportedType if the key type cannot be represented.
+func newEntry(name, value string) (knownhosts.Entry, error) {
+	keyType, port, host, err := parseName(name)
+	if err != nil {
+		return knownhosts.Entry{}, err
+	}
+	key, err := parseKey(keyType, value)
+	if err != nil {
+		return knownhosts.Entry{}, err
+	}
+	address := knownhosts.Normalize(net.JoinHostPort(host, strconv.Itoa(port)))
+	return knownhosts.Entry{Patterns: []string{address}, Key: key}, nil
+}
+
+// convert processes a PuTTY host key name and value, appending the resulting
+// entry to entries, or a Skipped to skipped if the key cannot be converted.
+func convert(entries []knownhosts.Entry, skipped []Skipped, name, value string) ([]knownhosts.Entry, []Skipped) {
+	entry, err := newEntry(name, value)
+	if errors.Is(err, errUnsupportedType) {
+		keyType, _, _, _ := parseName(name)
+		reason := "unsupported key type " + keyType
+		if keyType == "rsa" {
+			reason = "unsupported SSH-1 key"
+		}
+		return entries, append(skipped, Skipped{Name: name, Reason: reason})
+	} else if err != nil {
+		return entries, append(skipped, Skipped{Name: name, Reason: strings.TrimPrefix(err.Error(), "knownhosts: ")})
+	}
+	return append(entries, entry), skipped
+}
+
+// escapeRegistryName escapes a hostname in the same manner as PuTTY, for use
+// in registry value names: spaces, backslashes, wildcard characters, percent
+// signs, non-printable or non-ASCII bytes, and a leading dot are replaced by
+// "%" followed by two uppercase hex digits.
+func escapeRegistryName(host string) string {
+	var b strings.Builder
+	for n := 0; n < len(host); n++ {
+		c := host[n]
+		if c == ' ' || c == '\\' || c == '*' || c == '?' || c == '%' || c < ' ' || c > '~' || (c == '.' && n == 0) {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// unescapeRegistryName reverses the escaping which PuTTY applies to hostnames
+// when using them in registry value names, in which some characters are
+// replaced by "%" followed by two uppercase hex digits.
+func unescapeRegistryName(name string) string {
+	if !strings.Contains(name, "%") {
+		return name
+	}
+	var b strings.Builder
+	for n := 0; n < len(name); n++ {
+		if name[n] == '%' && n+2 < len(name) {
+			if c, err := strconv.ParseUint(name[n+1:n+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				n += 2
+				continue
+			}
+		}
+		b.WriteByte(name[n])
+	}
+	return b.String()
+}
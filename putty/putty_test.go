@@ -0,0 +1,214 @@
+package putty
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseName(t *testing.T) {
+	cases := []struct {
+		name     string
+		keyType  string
+		port     int
+		host     string
+		expectOK bool
+	}{
+		{"ssh-ed25519@22:example.test", "ssh-ed25519", 22, "example.test", true},
+		{"rsa2@2222:192.168.1.1", "rsa2", 2222, "192.168.1.1", true},
+		{"ecdsa-sha2-nistp256@22:2001:db8::1", "ecdsa-sha2-nistp256", 22, "2001:db8::1", true},
+		{"ssh-ed25519@22:", "", 0, "", false},
+		{"ssh-ed25519@:host", "", 0, "", false},
+		{"@22:host", "", 0, "", false},
+		{"ssh-ed25519@0:host", "", 0, "", false},
+		{"ssh-ed25519@70000:host", "", 0, "", false},
+		{"ssh-ed25519:host", "", 0, "", false},
+	}
+	for _, c := range cases {
+		keyType, port, host, err := parseName(c.name)
+		if c.expectOK && err != nil {
+			t.Errorf("Unexpected error from parseName(%q): %v", c.name, err)
+		} else if !c.expectOK && err == nil {
+			t.Errorf("Expected parseName(%q) to return an error, but it did not", c.name)
+		} else if keyType != c.keyType || port != c.port || host != c.host {
+			t.Errorf("Unexpected return from parseName(%q): %q %d %q", c.name, keyType, port, host)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	// The Ed25519 base point is a convenient known vector: its coordinates are
+	// well-known, and its encoding is 0x58 followed by 31 bytes of 0x66
+	basePoint := bytes.Repeat([]byte{0x66}, ed25519.PublicKeySize)
+	basePoint[0] = 0x58
+	edValue := "0x216936d3cd6e53fec0a4e231fdd6dc5c692cc7609525a7b2c9562d608f25d51a,0x6666666666666666666666666666666666666666666666666666666666666658"
+	key, err := parseKey("ssh-ed25519", edValue)
+	if err != nil {
+		t.Fatalf("Unexpected error from parseKey: %v", err)
+	}
+	expected, _ := ssh.NewPublicKey(ed25519.PublicKey(basePoint))
+	if !bytes.Equal(key.Marshal(), expected.Marshal()) {
+		t.Errorf("Unexpected key from parseKey: %s", hex.EncodeToString(key.Marshal()))
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Unexpected error from rsa.GenerateKey: %v", err)
+	}
+	key, err = parseKey("rsa2", fmt.Sprintf("0x%x,0x%x", rsaKey.E, rsaKey.N))
+	if err != nil {
+		t.Fatalf("Unexpected error from parseKey: %v", err)
+	}
+	expected, _ = ssh.NewPublicKey(&rsaKey.PublicKey)
+	if !bytes.Equal(key.Marshal(), expected.Marshal()) {
+		t.Error("Unexpected key from parseKey for rsa2")
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error from ecdsa.GenerateKey: %v", err)
+	}
+	key, err = parseKey("ecdsa-sha2-nistp384", fmt.Sprintf("nistp384,0x%x,0x%x", ecKey.X, ecKey.Y))
+	if err != nil {
+		t.Fatalf("Unexpected error from parseKey: %v", err)
+	}
+	expected, _ = ssh.NewPublicKey(&ecKey.PublicKey)
+	if !bytes.Equal(key.Marshal(), expected.Marshal()) {
+		t.Error("Unexpected key from parseKey for ecdsa-sha2-nistp384")
+	}
+
+	badCases := []struct {
+		keyType string
+		value   string
+	}{
+		{"ssh-ed25519", "0x1"},
+		{"ssh-ed25519", "0x1,0xzz"},
+		{"ssh-ed25519", "0x1,-0x2"},
+		{"rsa2", "0x10001"},
+		{"ecdsa-sha2-nistp384", fmt.Sprintf("nistp256,0x%x,0x%x", ecKey.X, ecKey.Y)},
+		{"ecdsa-sha2-nistp384", fmt.Sprintf("nistp384,0x%x,0x%x", ecKey.Y, ecKey.X)},
+	}
+	for _, c := range badCases {
+		if _, err := parseKey(c.keyType, c.value); err == nil || err == errUnsupportedType {
+			t.Errorf("Expected parseKey(%q, %q) to return a parse error, instead found %v", c.keyType, c.value, err)
+		}
+	}
+	for _, keyType := range []string{"rsa", "ssh-ed448", "unknown"} {
+		if _, err := parseKey(keyType, "0x1,0x2"); err != errUnsupportedType {
+			t.Errorf("Expected parseKey(%q) to return errUnsupportedType, instead found %v", keyType, err)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	edValue := "0x216936d3cd6e53fec0a4e231fdd6dc5c692cc7609525a7b2c9562d608f25d51a,0x6666666666666666666666666666666666666666666666666666666666666658"
+	entries, skipped := convert(nil, nil, "ssh-ed25519@22:Example.Test", edValue)
+	entries, skipped = convert(entries, skipped, "ssh-ed25519@2222:2001:db8::1", edValue)
+	entries, skipped = convert(entries, skipped, "ssh-ed448@22:example.test", "0x1,0x2")
+	entries, skipped = convert(entries, skipped, "ssh-ed25519@22:example.test", "0x1")
+	if len(entries) != 2 || len(skipped) != 2 {
+		t.Fatalf("Expected 2 entries and 2 skipped, instead found %d and %d", len(entries), len(skipped))
+	}
+	if entries[0].Patterns[0] != "example.test" || entries[1].Patterns[0] != "[2001:db8::1]:2222" {
+		t.Errorf("Unexpected patterns: %v, %v", entries[0].Patterns, entries[1].Patterns)
+	}
+	if skipped[0].Name != "ssh-ed448@22:example.test" || skipped[0].Reason != "unsupported key type ssh-ed448" {
+		t.Errorf("Unexpected skipped entry: %+v", skipped[0])
+	}
+}
+
+func TestUnescapeRegistryName(t *testing.T) {
+	cases := map[string]string{
+		"ssh-ed25519@22:example.test":   "ssh-ed25519@22:example.test",
+		"ssh-ed25519@22:%2Eexample":     "ssh-ed25519@22:.example",
+		"ssh-ed25519@22:my%20host%25":   "ssh-ed25519@22:my host%",
+		"ssh-ed25519@22:trailing%2":     "ssh-ed25519@22:trailing%2",
+		"ssh-ed25519@22:not%zzhex":      "ssh-ed25519@22:not%zzhex",
+		"ssh-ed25519@22:fe80::1%25eth0": "ssh-ed25519@22:fe80::1%eth0",
+	}
+	for input, expected := range cases {
+		if actual := unescapeRegistryName(input); actual != expected {
+			t.Errorf("Expected unescapeRegistryName(%q) to return %q, instead found %q", input, expected, actual)
+		}
+	}
+}
+
+func TestFormatKeyRoundTrip(t *testing.T) {
+	var keys []ssh.PublicKey
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Unexpected error from rsa.GenerateKey: %v", err)
+	}
+	pub, _ := ssh.NewPublicKey(&rsaKey.PublicKey)
+	keys = append(keys, pub)
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		ecKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatalf("Unexpected error from ecdsa.GenerateKey: %v", err)
+		}
+		pub, _ := ssh.NewPublicKey(&ecKey.PublicKey)
+		keys = append(keys, pub)
+	}
+	for n := 0; n < 20; n++ {
+		edKey, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Unexpected error from ed25519.GenerateKey: %v", err)
+		}
+		pub, _ := ssh.NewPublicKey(edKey)
+		keys = append(keys, pub)
+	}
+
+	for _, key := range keys {
+		keyType, value, err := formatKey(key)
+		if err != nil {
+			t.Errorf("Unexpected error from formatKey for %s: %v", key.Type(), err)
+			continue
+		}
+		parsed, err := parseKey(keyType, value)
+		if err != nil {
+			t.Errorf("Unexpected error from parseKey(%q, %q): %v", keyType, value, err)
+		} else if !bytes.Equal(parsed.Marshal(), key.Marshal()) {
+			t.Errorf("Round trip of %s key through %q did not yield the original key", key.Type(), value)
+		}
+	}
+
+	// Known vector: the Ed25519 base point
+	basePoint := bytes.Repeat([]byte{0x66}, ed25519.PublicKeySize)
+	basePoint[0] = 0x58
+	pub, _ = ssh.NewPublicKey(ed25519.PublicKey(basePoint))
+	expected := "0x216936d3cd6e53fec0a4e231fdd6dc5c692cc7609525a7b2c9562d608f25d51a,0x6666666666666666666666666666666666666666666666666666666666666658"
+	if keyType, value, err := formatKey(pub); err != nil || keyType != "ssh-ed25519" || value != expected {
+		t.Errorf("Unexpected return from formatKey: %q, %q, %v", keyType, value, err)
+	}
+
+	// Certificates are not supported by PuTTY
+	cert := &ssh.Certificate{Key: pub, CertType: ssh.HostCert}
+	if _, _, err := formatKey(cert); err == nil {
+		t.Error("Expected formatKey to return an error for a certificate, but it did not")
+	}
+}
+
+func TestEscapeRegistryName(t *testing.T) {
+	cases := map[string]string{
+		"example.test":  "example.test",
+		".example.test": "%2Eexample.test",
+		"my host%":      "my%20host%25",
+		"fe80::1%eth0":  "fe80::1%25eth0",
+		"a*b?c\\d":      "a%2Ab%3Fc%5Cd",
+	}
+	for input, expected := range cases {
+		if actual := escapeRegistryName(input); actual != expected {
+			t.Errorf("Expected escapeRegistryName(%q) to return %q, instead found %q", input, expected, actual)
+		} else if unescaped := unescapeRegistryName(actual); unescaped != input {
+			t.Errorf("Expected unescapeRegistryName(%q) to return %q, instead found %q", actual, input, unescaped)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+//go:build windows
+// +build windows
+
+package putty
+
+import (
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// RegistryPath is the registry key, relative to HKEY_CURRENT_USER, under which
+// PuTTY stores host keys.
+const RegistryPath = `Software\SimonTatham\PuTTY\SshHostKeys`
+
+// registryFilename is used as the Filename of entries loaded from the registry.
+const registryFilename = `HKEY_CURRENT_USER\` + RegistryPath
+
+// LoadRegistryHostKeys returns an entry for each host key which the current
+// user has accepted in PuTTY, as stored in the registry under RegistryPath.
+// Each entry has a single pattern, formatted in the same manner as Normalize,
+// and a Filename referring to the registry key.
+//
+// Stored keys which cannot be converted, such as SSH-1 keys or key types which
+// are not supported by golang.org/x/crypto/ssh, are returned in skipped rather
+// than causing an error. If PuTTY has not stored any host keys yet, no entries
+// and a nil error are returned.
+func LoadRegistryHostKeys() (entries []knownhosts.Entry, skipped []Skipped, err error) {
+	k, err := registry.OpenKey(registry.CURRENT_USER, RegistryPath, registry.QUERY_VALUE)
+	if errors.Is(err, windows.ERROR_FILE_NOT_FOUND) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer k.Close()
+
+	names, err := k.ReadValueNames(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range names {
+		value, _, err := k.GetStringValue(name)
+		if err != nil {
+			skipped = append(skipped, Skipped{Name: name, Reason: err.Error()})
+			continue
+		}
+		entries, skipped = convert(entries, skipped, unescapeRegistryName(name), value)
+	}
+	for n := range entries {
+		entries[n].Filename = registryFilename
+	}
+	return entries, skipped, nil
+}
+
+// NewRegistryCallback returns a host key callback which trusts the host keys
+// stored by PuTTY, as returned by LoadRegistryHostKeys. Skipped keys are
+// ignored. See knownhosts.NewFromEntries for more information on the callback.
+func NewRegistryCallback() (knownhosts.HostKeyCallback, error) {
+	entries, _, err := LoadRegistryHostKeys()
+	if err != nil {
+		return nil, err
+	}
+	return knownhosts.NewFromEntries(entries)
+}
+
+// StoreRegistryHostKey stores key as a trusted host key for host and port in
+// the registry under RegistryPath, in the same format as PuTTY itself, so that
+// PuTTY will not prompt the user to accept the key again. Any key of the same
+// type previously stored for host and port is replaced. The host is used as-is,
+// so it should match the hostname the user supplies to PuTTY; IPv6 addresses
+// should not be bracketed.
+//
+// PuTTY supports rsa2 (ssh-rsa), ecdsa-sha2-nistp256, ecdsa-sha2-nistp384,
+// ecdsa-sha2-nistp521, and ssh-ed25519 host keys, as well as legacy ssh-dss
+// keys. An error is returned for any other key type.
+func StoreRegistryHostKey(host string, port int, key ssh.PublicKey) error {
+	keyType, value, err := formatKey(key)
+	if err != nil {
+		return err
+	}
+	k, _, err := registry.CreateKey(registry.CURRENT_USER, RegistryPath, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+	return k.SetStringValue(formatName(keyType, port, escapeRegistryName(host)), value)
+}
+
+// ExportToRegistry stores the host keys known to cb for each of hosts in the
+// registry, using StoreRegistryHostKey. Each host should be in "host:port" or
+// "host" form, with port 22 used if no port is specified. Keys of types which
+// PuTTY does not support are returned in skipped, rather than causing an error.
+func ExportToRegistry(cb knownhosts.HostKeyCallback, hosts []string) (skipped []Skipped, err error) {
+	for _, hostWithPort := range hosts {
+		host, portStr, splitErr := net.SplitHostPort(hostWithPort)
+		if splitErr != nil {
+			host, portStr = hostWithPort, "22"
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return skipped, &knownhosts.InvalidHostnameError{Address: hostWithPort, Reason: "has an invalid port"}
+		}
+		for _, key := range cb.HostKeys(net.JoinHostPort(host, portStr)) {
+			if _, _, err := formatKey(key); err != nil {
+				skipped = append(skipped, Skipped{Name: formatName(key.Type(), port, host), Reason: "unsupported key type " + key.Type()})
+			} else if err := StoreRegistryHostKey(host, port, key); err != nil {
+				return skipped, err
+			}
+		}
+	}
+	return skipped, nil
+}
@@ -0,0 +1,144 @@
+package knownhosts
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Dimensions of the randomart field, matching OpenSSH.
+const (
+	randomartWidth  = 17
+	randomartHeight = 9
+)
+
+// randomartSymbols are the characters used for each visit count, followed by
+// the start and end markers.
+const randomartSymbols = " .o+=*BOX@%&#/^SE"
+
+// Randomart returns a visualization of key's SHA256 fingerprint, identical to
+// the output of ssh-keygen -lv and of ssh's VisualHostKey option. This uses the
+// "drunken bishop" algorithm, in which a walk determined by the fingerprint's
+// bits leaves a trail across a 17x9 field. Differing keys produce pictures
+// which are much easier for a human to tell apart than fingerprint strings.
+//
+// The result consists of 11 lines separated by newlines, without a trailing
+// newline. The top border shows the key type and size, and the bottom border
+// shows the hash algorithm. As with Fingerprint, a certificate is visualized
+// using its certified key, although its top border shows the certificate type.
+func Randomart(key ssh.PublicKey) string {
+	digest := sha256.Sum256(plainKey(key).Marshal())
+	end := len(randomartSymbols) - 1
+	var field [randomartWidth][randomartHeight]int
+	x, y := randomartWidth/2, randomartHeight/2
+	for _, input := range digest {
+		for b := 0; b < 4; b++ {
+			if input&0x1 != 0 {
+				x++
+			} else {
+				x--
+			}
+			if input&0x2 != 0 {
+				y++
+			} else {
+				y--
+			}
+			x = clampInt(x, 0, randomartWidth-1)
+			y = clampInt(y, 0, randomartHeight-1)
+			if field[x][y] < end-2 {
+				field[x][y]++
+			}
+			input >>= 2
+		}
+	}
+	field[randomartWidth/2][randomartHeight/2] = end - 1
+	field[x][y] = end
+
+	// OpenSSH omits the size if the title would not fit, and truncates anything
+	// still too long
+	typeName, bits := randomartKeyType(key)
+	title := fmt.Sprintf("[%s %d]", typeName, bits)
+	if len(title) > randomartWidth {
+		title = "[" + typeName + "]"
+	}
+	if len(title) > randomartWidth-1 {
+		title = title[:randomartWidth-1]
+	}
+
+	var b strings.Builder
+	writeRandomartBorder(&b, title)
+	b.WriteByte('\n')
+	for y := 0; y < randomartHeight; y++ {
+		b.WriteByte('|')
+		for x := 0; x < randomartWidth; x++ {
+			b.WriteByte(randomartSymbols[field[x][y]])
+		}
+		b.WriteString("|\n")
+	}
+	writeRandomartBorder(&b, "[SHA256]")
+	return b.String()
+}
+
+// writeRandomartBorder writes a horizontal border with label centered the same
+// way as OpenSSH, which rounds toward the left.
+func writeRandomartBorder(b *strings.Builder, label string) {
+	left := (randomartWidth - len(label)) / 2
+	b.WriteByte('+')
+	b.WriteString(strings.Repeat("-", left))
+	b.WriteString(label)
+	b.WriteString(strings.Repeat("-", randomartWidth-left-len(label)))
+	b.WriteByte('+')
+}
+
+// randomartKeyType returns OpenSSH's short name for the type of key, and the
+// size of the key in bits.
+func randomartKeyType(key ssh.PublicKey) (string, int) {
+	var name string
+	var bits int
+	plain := plainKey(key)
+	switch plain.Type() {
+	case ssh.KeyAlgoRSA:
+		name = "RSA"
+	case ssh.KeyAlgoDSA:
+		name = "DSA"
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		name = "ECDSA"
+	case ssh.KeyAlgoED25519:
+		name, bits = "ED25519", 256
+	case ssh.KeyAlgoSKECDSA256:
+		name, bits = "ECDSA-SK", 256
+	case ssh.KeyAlgoSKED25519:
+		name, bits = "ED25519-SK", 256
+	default:
+		name = "unknown"
+	}
+	if cpk, ok := plain.(ssh.CryptoPublicKey); ok {
+		switch k := cpk.CryptoPublicKey().(type) {
+		case *rsa.PublicKey:
+			bits = k.N.BitLen()
+		case *dsa.PublicKey:
+			bits = k.P.BitLen()
+		case *ecdsa.PublicKey:
+			bits = k.Curve.Params().BitSize
+		}
+	}
+	if _, ok := key.(*ssh.Certificate); ok {
+		name += "-CERT"
+	}
+	return name, bits
+}
+
+// clampInt returns n limited to the range [min, max].
+func clampInt(n, min, max int) int {
+	if n < min {
+		return min
+	} else if n > max {
+		return max
+	}
+	return n
+}
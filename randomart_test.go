@@ -0,0 +1,95 @@
+package knownhosts
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRandomart(t *testing.T) {
+	// Expected output obtained from ssh-keygen -lv
+	cases := []struct {
+		authorizedKey string
+		expected      string
+	}{
+		{
+			"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIDJqkTKB7K1fyiOrxI1Uw6VSlzUaID+9YxPu+MpNYaT7",
+			`+--[ED25519 256]--+
+|.o+=             |
+|+ oo.            |
+|E+= . .          |
+|=B.  . .         |
+|+ .o o. S        |
+|o=o =  o         |
+|B+ +    +        |
+|=o+ + .+ o       |
+|B=o+ ++.o.o.     |
++----[SHA256]-----+`,
+		},
+		{
+			"ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBI+fZa4MBsS1heMkrFt4mt+Ec5PWeSruGQmPkI7wfCO5ndYzMLb+bTcGFAkwmyTlPfUyY4/NqrCTHHew1MSgmBo=",
+			`+---[ECDSA 256]---+
+|@@O.             |
+|==o+.            |
+|..oo    .        |
+| =*      +       |
+|o+.*    S .      |
+|. = B  . o       |
+|+= = =  .        |
+|=o  B o.         |
+|. E+ ...         |
++----[SHA256]-----+`,
+		},
+		{
+			"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQDR8PMBXNc7Y4bUQ5ff2AHON25g5yVmuCmVeGtDHsvs1EsyUeoenbP0yIXhRPwXzd2QwtDi2O24pNEiLhvi7lSKXG69Gq2GNzyPgXKm6gZ1ksWaCL16SlKX4h3wFuht/a5dHCCUozOoIy5mR+ltyRLL6Pscl6NK/qL/3nGSbpTw5ee9OrtEVKqcD6FFyc8GpeU8vuM7KXH8XfjItC8tVYRI5qIQifAM4uBv8m5mxxnAHqg2+b3avdVBS9z3D+f7hWlkKx8egJgYifcvifUxyRI2h9ygfO8kH3OsH43K60jsxQri6A3Kp1eapXItXUGtEA+B893rh5vJNyfD1wGSQ8dR",
+			`+---[RSA 2048]----+
+|  + o .    .E .  |
+| o X = +   . o   |
+|  o @ = + .      |
+|   = + * .       |
+|. + = o S        |
+|.* * + o +       |
+|o.o * o   =      |
+|.  . o.  . +     |
+|    .oo.. .      |
++----[SHA256]-----+`,
+		},
+		{
+			"ssh-ed25519-cert-v01@openssh.com AAAAIHNzaC1lZDI1NTE5LWNlcnQtdjAxQG9wZW5zc2guY29tAAAAIPZ7OFWYzOZPqiNElzgeF8krsIrwwgwTDxouh/KvUrl9AAAAIFQVMoEgb/a4G1gNOJ+SXRniBjHO/fhjQHd0oQIWlEazAAAAAAAAAAAAAAACAAAAAmlkAAAAAAAAAAAAAAAA//////////8AAAAAAAAAAAAAAAAAAAAzAAAAC3NzaC1lZDI1NTE5AAAAIJRmR7O36uKl8Y6pMuUPWiP8otVdzcIiMKQ2mEQCZmrGAAAAUwAAAAtzc2gtZWQyNTUxOQAAAECZEV8mnYxPHiQxBTt9WFVSDE4a86HPXiu2DKneUbAj5w6DXuirAEU9A3Un6VbULfZuLK6dUN2b/SxqsqS1oCgC",
+			`+-[ED25519-CERT]--+
+|#+oo+oo          |
+|B=.+ . o o       |
+|.=.o  + o o      |
+|..* o .o . o     |
+|.o = +  S . .    |
+|oo+ o .  .       |
+|.E=..+           |
+| o.oo..          |
+|  .+o ..         |
++----[SHA256]-----+`,
+		},
+	}
+	for _, tc := range cases {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(tc.authorizedKey))
+		if err != nil {
+			t.Fatalf("Unexpected error from ParseAuthorizedKey: %v", err)
+		}
+		if actual := Randomart(key); actual != tc.expected {
+			t.Errorf("Unexpected randomart for %s key: expected\n%s\nfound\n%s", key.Type(), tc.expected, actual)
+		}
+	}
+
+	// Every generated key type produces an 11-line picture of consistent width
+	for _, key := range []ssh.PublicKey{generatePubKeyRSA(t), generatePubKeyECDSA(t), generatePubKeyEd25519(t)} {
+		lines := strings.Split(Randomart(key), "\n")
+		if len(lines) != 11 {
+			t.Errorf("Expected 11 lines of randomart for %s key, instead found %d", key.Type(), len(lines))
+		}
+		for _, line := range lines {
+			if len(line) != 19 {
+				t.Errorf("Expected randomart lines of length 19 for %s key, instead found %q", key.Type(), line)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package knownhosts
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// RecordedKey is a host key presented by a server, as captured by a Recorder.
+type RecordedKey struct {
+	Hostname string
+	Remote   net.Addr
+	Key      ssh.PublicKey
+	Time     time.Time
+}
+
+// Recorder accumulates the host keys passed to a callback returned by
+// NewRecordingCallback or WrapRecording. A Recorder is safe for concurrent
+// use.
+type Recorder struct {
+	mu   sync.Mutex
+	keys []RecordedKey
+}
+
+// NewRecordingCallback returns a host key callback which records every host key
+// it is called with, along with a Recorder for inspecting them. The callback
+// never rejects a key.
+//
+// This callback is insecure: it provides no protection against
+// man-in-the-middle attacks. It is intended only for diagnostic use, and for
+// scanning hosts in order to show the user which keys they would be trusting.
+// Connections using it should not be used to send credentials or other
+// sensitive data. Use WrapRecording to record keys while verifying them.
+func NewRecordingCallback() (ssh.HostKeyCallback, *Recorder) {
+	r := &Recorder{}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		r.record(hostname, remote, key)
+		return nil
+	}, r
+}
+
+// WrapRecording returns a host key callback which records every host key it
+// is called with, and then calls inner, returning its error unchanged. Keys are
+// recorded regardless of whether inner accepts them.
+//
+// Lookups made by HostKeyCallback.HostKeys are passed to inner without being
+// recorded, so the returned callback may be converted to a HostKeyCallback to
+// populate ssh.ClientConfig.HostKeyAlgorithms in the usual manner.
+func WrapRecording(inner ssh.HostKeyCallback) (ssh.HostKeyCallback, *Recorder) {
+	r := &Recorder{}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		r.record(hostname, remote, key)
+		return inner(hostname, remote, key)
+	}, r
+}
+
+// record appends the supplied args to r's recorded keys, unless key is the
+// placeholder key used by HostKeyCallback.HostKeys.
+func (r *Recorder) record(hostname string, remote net.Addr, key ssh.PublicKey) {
+	if _, placeholder := key.(*fakePublicKey); placeholder {
+		return
+	}
+	rk := RecordedKey{Hostname: hostname, Remote: remote, Key: key, Time: time.Now()}
+	r.mu.Lock()
+	r.keys = append(r.keys, rk)
+	r.mu.Unlock()
+}
+
+// Keys returns a copy of all host keys recorded so far, in the order in which
+// they were presented.
+func (r *Recorder) Keys() []RecordedKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedKey(nil), r.keys...)
+}
+
+// Reset discards all host keys recorded so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.keys = nil
+	r.mu.Unlock()
+}
+
+// Entries returns the recorded host keys as known_hosts entries, in the order
+// in which they were first presented. Each entry's patterns are determined in
+// the same manner as WriteKnownHost, so the remote address is only included if
+// it is an IP address and port which differs from the hostname. Keys presented
+// more than once for the same patterns are only included once. Hostnames which
+// cannot be written to a known_hosts file are included as-is.
+func (r *Recorder) Entries() []Entry {
+	keys := r.Keys()
+	entries := make([]Entry, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, rk := range keys {
+		patterns, err := knownHostAddresses(rk.Hostname, rk.Remote, writeOptions{})
+		if err != nil {
+			patterns = []string{rk.Hostname}
+		}
+		id := strings.Join(patterns, ",") + " " + string(rk.Key.Marshal())
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		entries = append(entries, Entry{Patterns: patterns, Key: rk.Key})
+	}
+	return entries
+}
+
+// WriteKnownHosts writes a known_hosts line to w for each of the recorded
+// entries, as returned by Entries, using WriteKnownHostAddresses with the
+// supplied opts. Nothing is written if any entry cannot be written.
+func (r *Recorder) WriteKnownHosts(w io.Writer, opts ...WriteOption) error {
+	var buf bytes.Buffer
+	for _, e := range r.Entries() {
+		if err := WriteKnownHostAddresses(&buf, e.Patterns, e.Key, opts...); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// AppendKnownHosts appends the recorded host keys to the known_hosts file at
+// path, using AppendKnownHost with the supplied opts for each key, so keys
+// which the file already lists for the same host are skipped. If appending a
+// key fails, the error is returned and the remaining keys are not appended.
+func (r *Recorder) AppendKnownHosts(path string, opts ...WriteOption) error {
+	for _, rk := range r.Keys() {
+		if err := AppendKnownHost(path, rk.Hostname, rk.Remote, rk.Key, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package knownhosts
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewRecordingCallback(t *testing.T) {
+	cb, rec := NewRecordingCallback()
+	key, otherKey := generatePubKeyEd25519(t), generatePubKeyECDSA(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+	before := time.Now()
+	for _, k := range []*struct {
+		hostname string
+		remote   net.Addr
+	}{
+		{"a.example.test:22", remote},
+		{"b.example.test:2222", &net.UnixAddr{Name: "/tmp/b.sock", Net: "unix"}},
+		{"a.example.test:22", remote},
+	} {
+		if err := cb(k.hostname, k.remote, key); err != nil {
+			t.Errorf("Unexpected error from recording callback: %v", err)
+		}
+	}
+	if err := cb("a.example.test:22", remote, otherKey); err != nil {
+		t.Errorf("Unexpected error from recording callback: %v", err)
+	}
+
+	// Lookups by HostKeys are not recorded
+	if keys := HostKeyCallback(cb).HostKeys("a.example.test"); len(keys) > 0 {
+		t.Errorf("Expected no keys from HostKeys, instead found %v", keys)
+	}
+
+	recorded := rec.Keys()
+	if len(recorded) != 4 {
+		t.Fatalf("Expected 4 recorded keys, instead found %d", len(recorded))
+	}
+	if rk := recorded[1]; rk.Hostname != "b.example.test:2222" || rk.Remote.Network() != "unix" || !KeysEqual(rk.Key, key) || rk.Time.Before(before) {
+		t.Errorf("Unexpected recorded key: %+v", rk)
+	}
+
+	var buf bytes.Buffer
+	if err := rec.WriteKnownHosts(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteKnownHosts: %v", err)
+	}
+	expected := "a.example.test,192.0.2.1 " + keyString(key) + "\n" +
+		"[b.example.test]:2222 " + keyString(key) + "\n" +
+		"a.example.test,192.0.2.1 " + keyString(otherKey) + "\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected output from WriteKnownHosts: expected %q, found %q", expected, buf.String())
+	}
+	if entries := rec.Entries(); len(entries) != 3 || entries[1].Patterns[0] != "[b.example.test]:2222" {
+		t.Errorf("Unexpected result from Entries: %+v", entries)
+	}
+
+	// The appended file trusts exactly the recorded keys
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	if err := rec.AppendKnownHosts(khPath); err != nil {
+		t.Fatalf("Unexpected error from AppendKnownHosts: %v", err)
+	}
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	if err := kh("b.example.test:2222", remote, key); err != nil {
+		t.Errorf("Unexpected error from callback: %v", err)
+	}
+
+	rec.Reset()
+	if recorded := rec.Keys(); len(recorded) != 0 {
+		t.Errorf("Expected no recorded keys after Reset, instead found %d", len(recorded))
+	}
+}
+
+func TestWrapRecording(t *testing.T) {
+	khPath := getTestKnownHosts(t)
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	cb, rec := WrapRecording(kh.HostKeyCallback())
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	key := generatePubKeyEd25519(t)
+
+	// The inner callback's errors are preserved
+	if err := cb("unknown.example.test:22", noAddr, key); !IsHostUnknown(err) {
+		t.Errorf("Expected unknown host error, instead found %v", err)
+	}
+	known := kh.HostKeys("only-ed25519.example.test:22")
+	if len(known) == 0 {
+		t.Fatal("Test known_hosts is missing expected host")
+	}
+	if err := cb("only-ed25519.example.test:22", noAddr, known[0]); err != nil {
+		t.Errorf("Unexpected error from callback for known key: %v", err)
+	}
+	if err := cb("only-ed25519.example.test:22", noAddr, key); !IsHostKeyChanged(err) {
+		t.Errorf("Expected changed key error, instead found %v", err)
+	}
+
+	// HostKeyAlgorithms still works through the wrapper, without recording
+	if algos := HostKeyCallback(cb).HostKeyAlgorithms("only-ed25519.example.test"); len(algos) != 1 {
+		t.Errorf("Unexpected result from HostKeyAlgorithms: %v", algos)
+	}
+	if recorded := rec.Keys(); len(recorded) != 3 {
+		t.Errorf("Expected 3 recorded keys, instead found %d", len(recorded))
+	}
+
+	// Recording is safe for concurrent use
+	rec.Reset()
+	var wg sync.WaitGroup
+	for n := 0; n < 20; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cb("only-ed25519.example.test:22", noAddr, known[0])
+		}()
+	}
+	wg.Wait()
+	if recorded := rec.Keys(); len(recorded) != 20 {
+		t.Errorf("Expected 20 recorded keys, instead found %d", len(recorded))
+	}
+}
@@ -0,0 +1,207 @@
+package knownhosts
+
+import (
+	"encoding/base64"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// RemoveHost removes all known_hosts entries for host from the file at path,
+// similar to OpenSSH's "ssh-keygen -R". The host is normalized using Normalize,
+// so a non-standard port may be specified either as "host:port" or in
+// known_hosts "[host]:port" form, and entries for the same host on a different
+// port are not affected. Hashed entries are removed if they match the host.
+//
+// If a line lists multiple patterns and only some of them match the host, just
+// the matching patterns are removed, and the rest of the line is retained.
+// Wildcard patterns, negated patterns, and lines with a marker such as
+// @cert-authority or @revoked are never removed. All other content, including
+// comments and blank lines, is preserved exactly.
+//
+// The returned count is the number of lines which were removed or modified. The
+// file is locked and rewritten atomically. Supply the WithBackup option to
+// retain a copy of the original file.
+func RemoveHost(path string, host string, opts ...WriteOption) (removed int, err error) {
+	address := Normalize(host)
+	err = rewriteLines(path, newWriteOptions(opts), func(_ int, line string) (string, error) {
+		content, eol := splitLineEnding(line)
+		leading, patterns, rest := splitFirstField(content)
+		if patterns == "" || patterns[0] == '#' || patterns[0] == '@' {
+			return line, nil
+		}
+		allPatterns := strings.Split(patterns, ",")
+		kept := make([]string, 0, len(allPatterns))
+		for _, pattern := range allPatterns {
+			if isHostPattern(pattern) || !patternMatchesAddress(pattern, address) {
+				kept = append(kept, pattern)
+			}
+		}
+		if len(kept) == len(allPatterns) {
+			return line, nil
+		}
+		removed++
+		if len(kept) == 0 {
+			return "", nil
+		}
+		return leading + strings.Join(kept, ",") + rest + eol, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// RemoveByKey removes all known_hosts entries for key from the file at path,
+// regardless of which host patterns they apply to. Keys are compared by their
+// marshaled form, so this applies equally to plaintext and hashed entries.
+// Lines with a marker, such as @cert-authority or @revoked, are only removed if
+// the WithMarkerLines option is supplied. All other content is preserved
+// exactly.
+//
+// The removed lines are returned, without line endings, in the order that they
+// appeared in the file. The file is locked and rewritten atomically. Supply the
+// WithBackup option to retain a copy of the original file. An error is
+// returned without modifying the file if key is nil or empty.
+func RemoveByKey(path string, key ssh.PublicKey, opts ...WriteOption) (removed []string, err error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	wo := newWriteOptions(opts)
+	marshaled := key.Marshal()
+	err = rewriteLines(path, wo, func(_ int, line string) (string, error) {
+		content, _ := splitLineEnding(line)
+		_, first, rest := splitFirstField(content)
+		if first == "" || first[0] == '#' {
+			return line, nil
+		} else if first[0] == '@' {
+			if !wo.markerLines {
+				return line, nil
+			}
+			_, _, rest = splitFirstField(rest) // skip past patterns following marker
+		}
+		if !keyFieldsMatch(rest, marshaled) {
+			return line, nil
+		}
+		removed = append(removed, content)
+		return "", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// ReplaceHostKey replaces the key of the existing known_hosts entry for host
+// which has the same key type as newKey, in the file at path. Only the key
+// itself is rewritten; the line's position, host patterns (including hashed
+// patterns), and comment are all preserved. If multiple lines for host have a
+// key of the same type, they are all replaced. If there is no existing entry
+// for host with the same key type, a new line for host is appended instead,
+// using the WithHashedHostnames and WithComment options if supplied.
+//
+// Host matching follows the same rules as RemoveHost, and lines with a marker
+// are never modified. The file is locked and rewritten atomically, so the host
+// is never absent from the file during the rotation. Supply the WithBackup
+// option to retain a copy of the original file.
+func ReplaceHostKey(path string, host string, newKey ssh.PublicKey, opts ...WriteOption) error {
+	wo := newWriteOptions(opts)
+	address, err := NormalizeStrict(withDefaultPort(host, wo.defaultPort))
+	if err != nil {
+		return err
+	} else if err := validateKey(newKey); err != nil {
+		return err
+	}
+	newKeyStr := newKey.Type() + " " + base64.StdEncoding.EncodeToString(newKey.Marshal())
+	if err := validateComment(wo.comment); err != nil {
+		return err
+	}
+	return rewriteFile(path, wo, func(in io.Reader, out io.Writer) error {
+		var replaced bool
+		lastEOL := "\n"
+		err := transformLines(in, out, func(_ int, line string) (string, error) {
+			content, eol := splitLineEnding(line)
+			lastEOL = eol
+			leading, patterns, rest := splitFirstField(content)
+			if patterns == "" || patterns[0] == '#' || patterns[0] == '@' {
+				return line, nil
+			}
+			sep, keyType, rest := splitFirstField(rest)
+			if keyType != newKey.Type() || !anyPatternMatchesAddress(patterns, address) {
+				return line, nil
+			}
+			_, _, comment := splitFirstField(rest)
+			replaced = true
+			return leading + patterns + sep + newKeyStr + comment + eol, nil
+		})
+		if err != nil || replaced {
+			return err
+		}
+		newLine, err := knownHostLines([]string{address}, newKey, wo)
+		if err != nil {
+			return err
+		}
+		newLine += "\n"
+		if lastEOL == "" {
+			newLine = "\n" + newLine
+		}
+		_, err = io.WriteString(out, newLine)
+		return err
+	})
+}
+
+// anyPatternMatchesAddress returns true if any pattern in the comma-separated
+// patterns matches address, as per patternMatchesAddress.
+func anyPatternMatchesAddress(patterns, address string) bool {
+	for _, pattern := range strings.Split(patterns, ",") {
+		if patternMatchesAddress(pattern, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// Prune rewrites the known_hosts file at path, keeping only the entries for
+// which keep returns true. Each entry corresponds to one line of the file, so
+// lines listing multiple host patterns are kept or removed as a whole. Comments,
+// blank lines, and any lines which cannot be parsed are always kept. The Entry
+// passed to keep has its Filename and Line fields populated.
+//
+// The removed entries are returned in the order they appeared in the file.
+// Supply the WithDryRun option to obtain these entries without modifying the
+// file. Otherwise, the file is locked and rewritten atomically; supply the
+// WithBackup option to retain a copy of the original file.
+func Prune(path string, keep func(Entry) bool, opts ...WriteOption) (removed []Entry, err error) {
+	err = rewriteLines(path, newWriteOptions(opts), func(lineNum int, line string) (string, error) {
+		e, ok, err := parseEntry(line)
+		if err != nil || !ok {
+			return line, nil
+		}
+		e.Filename, e.Line = path, lineNum
+		if keep(e) {
+			return line, nil
+		}
+		removed = append(removed, e)
+		return "", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// PruneKeyTypes rewrites the known_hosts file at path, removing all entries
+// with a key of any of the disallowed key types, such as ssh.KeyAlgoDSA. This
+// is a convenience wrapper around Prune, and supports the same options, such
+// as WithDryRun and WithBackup.
+func PruneKeyTypes(path string, disallowed []string, opts ...WriteOption) (removed []Entry, err error) {
+	return Prune(path, func(e Entry) bool {
+		for _, keyType := range disallowed {
+			if e.Key.Type() == keyType {
+				return false
+			}
+		}
+		return true
+	}, opts...)
+}
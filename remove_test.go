@@ -0,0 +1,274 @@
+package knownhosts
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRemoveHost(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	keys := []ssh.PublicKey{generatePubKeyEd25519(t), generatePubKeyECDSA(t), generatePubKeyEd25519(t)}
+	hashedTarget, _ := HashHostname("target.example.test")
+	hashedOther, _ := HashHostname("other.example.test")
+	lines := []string{
+		"# comment mentioning target.example.test",
+		"",
+		Line([]string{"target.example.test"}, keys[0]),
+		Line([]string{"other.example.test", "target.example.test", "10.0.0.5"}, keys[1]) + " with comment",
+		hashedTarget + " " + keyString(keys[2]),
+		hashedOther + " " + keyString(keys[2]),
+		Line([]string{"target.example.test:2222"}, keys[0]),
+		"@cert-authority target.example.test " + keyString(keys[2]),
+		"*.example.test,!target.example.test " + keyString(keys[1]),
+	}
+	writeLines := func(lines []string) string {
+		var contents string
+		for _, line := range lines {
+			contents += line + "\r\n"
+		}
+		return contents
+	}
+	if err := os.WriteFile(khPath, []byte(writeLines(lines)), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+
+	removed, err := RemoveHost(khPath, "target.example.test:22", WithBackup())
+	if err != nil {
+		t.Fatalf("Unexpected error from RemoveHost: %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("Expected RemoveHost to report 3 lines removed or modified, instead found %d", removed)
+	}
+	want := writeLines([]string{
+		lines[0],
+		lines[1],
+		Line([]string{"other.example.test", "10.0.0.5"}, keys[1]) + " with comment",
+		lines[5],
+		lines[6],
+		lines[7],
+		lines[8],
+	})
+	if contents, err := os.ReadFile(khPath); err != nil || string(contents) != want {
+		t.Errorf("Unexpected contents after RemoveHost (err=%v):\n%q\nExpected:\n%q", err, contents, want)
+	}
+	if backup, err := os.ReadFile(khPath + ".old"); err != nil || string(backup) != writeLines(lines) {
+		t.Errorf("Backup file does not match original contents; err=%v", err)
+	}
+
+	// Remaining entries should still work; removed host should now be unknown
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	if err := kh("other.example.test:22", noAddr, keys[1]); err != nil {
+		t.Errorf("Unexpected error from callback for other.example.test: %v", err)
+	}
+	if err := kh("target.example.test:2222", noAddr, keys[0]); err != nil {
+		t.Errorf("Unexpected error from callback for target.example.test on port 2222: %v", err)
+	}
+	for _, key := range keys[:2] { // keys[2] remains in the @cert-authority line
+		if kh.Exists("target.example.test:22", key) {
+			t.Errorf("Expected %s key to be removed for target.example.test, but it still exists", key.Type())
+		}
+	}
+
+	// Removing the non-standard port form should work with either syntax, and
+	// removing again should be a no-op
+	for _, host := range []string{"[target.example.test]:2222", "target.example.test:2222"} {
+		expected := 1
+		if host == "target.example.test:2222" {
+			expected = 0
+		}
+		if removed, err := RemoveHost(khPath, host); err != nil || removed != expected {
+			t.Errorf("RemoveHost(%q) returned %d, %v; expected %d, nil", host, removed, err, expected)
+		}
+	}
+
+	// Wildcard and negated patterns are never removed, even if the host is given
+	// in exactly the same form
+	before, err := os.ReadFile(khPath)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %v", khPath, err)
+	}
+	for _, host := range []string{"*.example.test", "!target.example.test", "?arget.example.test"} {
+		if removed, err := RemoveHost(khPath, host); err != nil || removed != 0 {
+			t.Errorf("RemoveHost(%q) returned %d, %v; expected 0, nil", host, removed, err)
+		}
+	}
+	if after, err := os.ReadFile(khPath); err != nil || string(after) != string(before) {
+		t.Errorf("Expected RemoveHost with host patterns to leave file unchanged (err=%v):\n%q\nExpected:\n%q", err, after, before)
+	}
+
+	// Nonexistent file should return an error
+	if _, err := RemoveHost(khPath+"_does_not_exist", "target.example.test"); err == nil {
+		t.Error("Expected error from RemoveHost on nonexistent file, but error was nil")
+	}
+}
+
+func TestRemoveByKey(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	target := generatePubKeyEd25519(t)
+	other := generatePubKeyECDSA(t)
+	hashed, _ := HashHostname("hashed.example.test")
+	lines := []string{
+		"# comment",
+		Line([]string{"a.example.test", "10.0.0.5"}, target) + " comment",
+		Line([]string{"a.example.test"}, other),
+		hashed + " " + keyString(target),
+		"@cert-authority *.example.test " + keyString(target),
+		"@revoked old.example.test " + keyString(target),
+		Line([]string{"b.example.test"}, other),
+	}
+	contents := ""
+	for _, line := range lines {
+		contents += line + "\n"
+	}
+	if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+
+	removed, err := RemoveByKey(khPath, target)
+	if err != nil {
+		t.Fatalf("Unexpected error from RemoveByKey: %v", err)
+	}
+	if len(removed) != 2 || removed[0] != lines[1] || removed[1] != lines[3] {
+		t.Errorf("Unexpected removed lines from RemoveByKey: %q", removed)
+	}
+	want := lines[0] + "\n" + lines[2] + "\n" + lines[4] + "\n" + lines[5] + "\n" + lines[6] + "\n"
+	if after, _ := os.ReadFile(khPath); string(after) != want {
+		t.Errorf("Unexpected contents after RemoveByKey:\n%s\nExpected:\n%s", after, want)
+	}
+
+	// With WithMarkerLines, the marker lines should be removed too
+	removed, err = RemoveByKey(khPath, target, WithMarkerLines())
+	if err != nil {
+		t.Fatalf("Unexpected error from RemoveByKey: %v", err)
+	}
+	if len(removed) != 2 || removed[0] != lines[4] || removed[1] != lines[5] {
+		t.Errorf("Unexpected removed lines from RemoveByKey with WithMarkerLines: %q", removed)
+	}
+	want = lines[0] + "\n" + lines[2] + "\n" + lines[6] + "\n"
+	if after, _ := os.ReadFile(khPath); string(after) != want {
+		t.Errorf("Unexpected contents after RemoveByKey:\n%s\nExpected:\n%s", after, want)
+	}
+
+	// A nil key returns an error without modifying the file
+	if _, err := RemoveByKey(khPath, nil); err == nil {
+		t.Error("Expected error from RemoveByKey with nil key, but err was nil")
+	}
+	if after, _ := os.ReadFile(khPath); string(after) != want {
+		t.Errorf("Unexpected contents after RemoveByKey with nil key:\n%s\nExpected:\n%s", after, want)
+	}
+}
+
+func TestReplaceHostKey(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	oldKey, newKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t)
+	ecKey := generatePubKeyECDSA(t)
+	hashed, _ := HashHostname("hashed.example.test")
+	lines := []string{
+		Line([]string{"rotate.example.test", "10.0.0.5"}, oldKey) + " keep this comment",
+		Line([]string{"rotate.example.test"}, ecKey),
+		hashed + " " + keyString(oldKey),
+		"@revoked rotate.example.test " + keyString(oldKey),
+	}
+	contents := lines[0] + "\n" + lines[1] + "\n" + lines[2] + "\n" + lines[3] // no trailing newline
+	if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+
+	for _, host := range []string{"rotate.example.test", "hashed.example.test:22", "new.example.test"} {
+		if err := ReplaceHostKey(khPath, host, newKey); err != nil {
+			t.Fatalf("Unexpected error from ReplaceHostKey(%q): %v", host, err)
+		}
+	}
+	want := Line([]string{"rotate.example.test", "10.0.0.5"}, newKey) + " keep this comment\n" +
+		lines[1] + "\n" +
+		hashed + " " + keyString(newKey) + "\n" +
+		lines[3] + "\n" +
+		Line([]string{"new.example.test"}, newKey) + "\n"
+	if after, _ := os.ReadFile(khPath); string(after) != want {
+		t.Errorf("Unexpected contents after ReplaceHostKey:\n%s\nExpected:\n%s", after, want)
+	}
+
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	for _, host := range []string{"10.0.0.5:22", "hashed.example.test:22", "new.example.test:22"} {
+		if err := kh(host, noAddr, newKey); err != nil {
+			t.Errorf("Unexpected error from callback for %s with new key: %v", host, err)
+		}
+		if err := kh(host, noAddr, oldKey); err == nil {
+			t.Errorf("Expected old key for %s to be rejected, but error was nil", host)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	khPath := filepath.Join(t.TempDir(), "known_hosts")
+	edKey, ecKey, rsaKey := generatePubKeyEd25519(t), generatePubKeyECDSA(t), generatePubKeyRSA(t)
+	hashed, _ := HashHostname("hashed.example.test")
+	lines := []string{
+		"# comment",
+		"",
+		Line([]string{"a.example.test", "10.0.0.5"}, rsaKey) + " old rsa",
+		Line([]string{"a.example.test", "10.0.0.5"}, edKey),
+		hashed + " " + keyString(rsaKey),
+		Line([]string{"b.example.test"}, ecKey),
+		"malformed line",
+	}
+	contents := ""
+	for _, line := range lines {
+		contents += line + "\n"
+	}
+	if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+
+	// Dry run should report entries without modifying the file
+	keepNonRSA := func(e Entry) bool { return e.Key.Type() != ssh.KeyAlgoRSA }
+	removed, err := Prune(khPath, keepNonRSA, WithDryRun())
+	if err != nil {
+		t.Fatalf("Unexpected error from Prune: %v", err)
+	}
+	if len(removed) != 2 || removed[0].Line != 3 || removed[0].Comment != "old rsa" || removed[1].Line != 5 || !removed[1].Hashed() {
+		t.Errorf("Unexpected removed entries from Prune dry run: %+v", removed)
+	}
+	if after, _ := os.ReadFile(khPath); string(after) != contents {
+		t.Errorf("Expected Prune dry run to leave file unchanged, but contents are now %q", after)
+	}
+
+	disallowed := []string{ssh.KeyAlgoDSA, ssh.KeyAlgoRSA}
+	removed, err = PruneKeyTypes(khPath, disallowed, WithDryRun())
+	if err != nil {
+		t.Fatalf("Unexpected error from PruneKeyTypes: %v", err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("Unexpected removed entries from PruneKeyTypes dry run: %+v", removed)
+	}
+	if after, _ := os.ReadFile(khPath); string(after) != contents {
+		t.Errorf("Expected PruneKeyTypes dry run to leave file unchanged, but contents are now %q", after)
+	}
+
+	removed, err = PruneKeyTypes(khPath, disallowed, WithBackup())
+	if err != nil {
+		t.Fatalf("Unexpected error from PruneKeyTypes: %v", err)
+	}
+	if len(removed) != 2 || removed[0].Filename != khPath {
+		t.Errorf("Unexpected removed entries from PruneKeyTypes: %+v", removed)
+	}
+	want := lines[0] + "\n" + lines[1] + "\n" + lines[3] + "\n" + lines[5] + "\n" + lines[6] + "\n"
+	if after, _ := os.ReadFile(khPath); string(after) != want {
+		t.Errorf("Unexpected contents after PruneKeyTypes:\n%s\nExpected:\n%s", after, want)
+	}
+	if backup, err := os.ReadFile(khPath + ".old"); err != nil || string(backup) != contents {
+		t.Errorf("Backup file does not match original contents; err=%v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package knownhosts
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errUnchanged may be returned by a rewriteFile transform function to indicate
+// that no changes are needed.
+var errUnchanged = errors.New("knownhosts: file unchanged")
+
+// RewriteFile atomically replaces the contents of the existing known_hosts file
+// at path with the output of transform, which is supplied the file's current
+// contents. This is the same mechanism used internally by all functions in this
+// package which modify existing lines of a known_hosts file, such as RemoveHost
+// and HashFile, and it may be used to implement other custom modifications
+// safely.
+//
+// The new contents are written to a temporary file in the same directory,
+// synced to disk, and then renamed over the original, so that a failure or
+// crash at any point leaves either the original or the new contents intact,
+// never a truncated file. If transform returns an error or panics, the
+// temporary file is removed and the original file is left unchanged. The
+// original file's permissions are retained, along with its owner and group
+// where possible. If path is a symlink, the file it points to is rewritten,
+// and the symlink itself is left in place.
+//
+// An exclusive advisory lock is held on the file for the duration of the
+// rewrite, so concurrent modifications via this package cannot be lost. The
+// WithLockTimeout, WithBackup, WithDryRun, and WithPlan options are supported;
+// other options have no effect. If the file cannot be written due to permissions,
+// the returned error will be a *PermissionError.
+func RewriteFile(path string, transform func(in io.Reader, out io.Writer) error, opts ...WriteOption) error {
+	return rewriteFile(path, newWriteOptions(opts), transform)
+}
+
+// rewriteFile implements RewriteFile. If the WithBackup option was used, the
+// original contents are copied to the target path + ".old" before the rename.
+// If transform returns errUnchanged, the original file is left as-is and nil
+// is returned. If the WithDryRun option was used, transform is still called,
+// but its output is discarded and the file is never modified. If the WithPlan
+// option was used, the rewrite is recorded in the ChangeSet instead.
+func rewriteFile(path string, wo writeOptions, transform func(in io.Reader, out io.Writer) error) (err error) {
+	if wo.plan != nil {
+		return wo.plan.rewrite(path, wo, transform)
+	}
+	// Rewrite the target of a symlink, rather than replacing the symlink itself
+	// with a regular file. If resolution fails, openLocked reports the problem.
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	f, err := openLocked(path, os.O_RDWR, wo.lockTimeout)
+	if err != nil {
+		return wrapFileError(path, err)
+	}
+	defer closeLocked(f)
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if wo.dryRun {
+		if err := transform(bufio.NewReader(f), io.Discard); err != errUnchanged {
+			return err
+		}
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return wrapFileError(path, err)
+	}
+	// Clean up the temp file on any failure, including a panic in transform
+	var renamed bool
+	defer func() {
+		if !renamed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	w := bufio.NewWriter(tmp)
+	if err := transform(bufio.NewReader(f), w); err == errUnchanged {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return wrapFileError(path, err)
+	}
+	preserveOwner(tmp, fi)
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return wrapFileError(path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return wrapFileError(path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return wrapFileError(path, err)
+	}
+	if wo.backup {
+		if err := copyToBackup(f, path+".old", fi.Mode().Perm()); err != nil {
+			return wrapFileError(path+".old", err)
+		}
+	}
+	if err := renameFile(tmp.Name(), path); err != nil {
+		return wrapFileError(path, err)
+	}
+	renamed = true
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// copyToBackup copies the full contents of f to backupPath, replacing any
+// existing file there.
+func copyToBackup(f *os.File, backupPath string, perm os.FileMode) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	backup, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(backup, f); err != nil {
+		backup.Close()
+		return err
+	}
+	return backup.Close()
+}
+
+// rewriteLines rewrites the known_hosts file at path using rewriteFile, passing
+// each line (including its line ending, if any) to fn along with its 1-based
+// line number. The line is replaced with the string returned by fn, which
+// should include a line ending if the line is to be retained; returning an
+// empty string removes the line entirely.
+func rewriteLines(path string, wo writeOptions, fn func(lineNum int, line string) (string, error)) error {
+	return rewriteFile(path, wo, func(in io.Reader, out io.Writer) error {
+		return transformLines(in, out, fn)
+	})
+}
+
+// transformLines reads lines from in, passes each line to fn as described in
+// rewriteLines, and writes the result to out.
+func transformLines(in io.Reader, out io.Writer, fn func(lineNum int, line string) (string, error)) error {
+	r := bufio.NewReader(in)
+	for lineNum := 1; ; lineNum++ {
+		line, readErr := r.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if line == "" && readErr == io.EOF {
+			return nil
+		}
+		newLine, err := fn(lineNum, line)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(out, newLine); err != nil {
+			return err
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// splitLineEnding splits line into its content and its line ending, which may
+// be "\n", "\r\n", or an empty string.
+func splitLineEnding(line string) (content, eol string) {
+	content = strings.TrimRight(line, "\r\n")
+	return content, line[len(content):]
+}
+
+// splitFirstField splits s into any leading whitespace, the first
+// whitespace-delimited field, and the remainder of the string (which begins
+// with whitespace, if non-empty).
+func splitFirstField(s string) (leading, field, rest string) {
+	field = strings.TrimLeft(s, " \t")
+	leading = s[:len(s)-len(field)]
+	if end := strings.IndexAny(field, " \t"); end >= 0 {
+		field, rest = field[:end], field[end:]
+	}
+	return leading, field, rest
+}
+
+// keyFieldsMatch returns true if s, which should be the remainder of a
+// known_hosts line after its marker (if any) and host patterns, begins with a
+// key type and base64-encoded key whose decoded form equals marshaledKey.
+func keyFieldsMatch(s string, marshaledKey []byte) bool {
+	_, _, rest := splitFirstField(s)
+	_, encodedKey, _ := splitFirstField(rest)
+	decoded, err := base64.StdEncoding.DecodeString(encodedKey)
+	return err == nil && bytes.Equal(decoded, marshaledKey)
+}
@@ -0,0 +1,140 @@
+package knownhosts
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRewriteFile(t *testing.T) {
+	dir := t.TempDir()
+	khPath := filepath.Join(dir, "known_hosts")
+	original := "# comment\n" + Line([]string{"a.example.test"}, generatePubKeyEd25519(t)) + "\n"
+	if err := os.WriteFile(khPath, []byte(original), 0640); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	upper := func(in io.Reader, out io.Writer) error {
+		contents, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(out, strings.ToUpper(string(contents)))
+		return err
+	}
+
+	if err := RewriteFile(khPath, upper); err != nil {
+		t.Fatalf("Unexpected error from RewriteFile: %v", err)
+	}
+	if after, _ := os.ReadFile(khPath); string(after) != strings.ToUpper(original) {
+		t.Errorf("Unexpected contents after RewriteFile: %q", after)
+	}
+	if runtime.GOOS != "windows" {
+		if fi, err := os.Stat(khPath); err != nil {
+			t.Fatalf("Unexpected error from Stat: %v", err)
+		} else if perm := fi.Mode().Perm(); perm != 0640 {
+			t.Errorf("Expected RewriteFile to retain permissions 0640, instead found %v", perm)
+		}
+	}
+	assertNoTempFiles(t, dir)
+
+	// Rewriting via a symlink should rewrite the target, leaving the link as-is
+	if runtime.GOOS != "windows" {
+		linkPath := filepath.Join(t.TempDir(), "known_hosts_link")
+		if err := os.Symlink(khPath, linkPath); err != nil {
+			t.Fatalf("Unable to create symlink: %v", err)
+		}
+		if err := RewriteFile(linkPath, func(in io.Reader, out io.Writer) error {
+			_, err := io.WriteString(out, original)
+			return err
+		}); err != nil {
+			t.Fatalf("Unexpected error from RewriteFile: %v", err)
+		}
+		if fi, err := os.Lstat(linkPath); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("Expected %s to still be a symlink, instead found %v, err=%v", linkPath, fi, err)
+		}
+		if after, _ := os.ReadFile(khPath); string(after) != original {
+			t.Errorf("Unexpected contents of symlink target after RewriteFile: %q", after)
+		}
+	}
+
+	// Nonexistent file should return an error without creating anything
+	missingPath := filepath.Join(dir, "missing")
+	if err := RewriteFile(missingPath, upper); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected RewriteFile of nonexistent file to return ErrNotExist, instead found %v", err)
+	}
+	if _, err := os.Stat(missingPath); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to not exist, but Stat returned %v", missingPath, err)
+	}
+}
+
+// TestRewriteFileFailure confirms that a transform which fails part-way
+// through, by either returning an error or panicking, never damages the
+// original file or leaves temporary files behind.
+func TestRewriteFileFailure(t *testing.T) {
+	dir := t.TempDir()
+	khPath := filepath.Join(dir, "known_hosts")
+	original := Line([]string{"a.example.test"}, generatePubKeyEd25519(t)) + "\n" + Line([]string{"b.example.test"}, generatePubKeyECDSA(t)) + "\n"
+	if err := os.WriteFile(khPath, []byte(original), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	partial := func(out io.Writer) {
+		// Write enough to exceed the buffer size, so that some data reaches the
+		// temp file before the failure
+		io.WriteString(out, strings.Repeat("garbage\n", 2000))
+	}
+
+	failErr := errors.New("transform failed")
+	err := RewriteFile(khPath, func(in io.Reader, out io.Writer) error {
+		partial(out)
+		return failErr
+	}, WithBackup())
+	if err != failErr {
+		t.Errorf("Expected RewriteFile to return transform's error, instead found %v", err)
+	}
+	if after, _ := os.ReadFile(khPath); string(after) != original {
+		t.Errorf("Expected original file to be intact after failed transform, instead found %d bytes", len(after))
+	}
+	if _, err := os.Stat(khPath + ".old"); !os.IsNotExist(err) {
+		t.Errorf("Expected no backup file after failed transform, but Stat returned %v", err)
+	}
+	assertNoTempFiles(t, dir)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected panic to propagate out of RewriteFile")
+			}
+		}()
+		RewriteFile(khPath, func(in io.Reader, out io.Writer) error {
+			partial(out)
+			panic("transform killed")
+		})
+	}()
+	if after, _ := os.ReadFile(khPath); string(after) != original {
+		t.Errorf("Expected original file to be intact after panicking transform, instead found %d bytes", len(after))
+	}
+	assertNoTempFiles(t, dir)
+
+	// The lock must have been released despite the panic
+	if err := RewriteFile(khPath, func(in io.Reader, out io.Writer) error {
+		_, err := io.Copy(out, in)
+		return err
+	}, WithLockTimeout(0)); err != nil {
+		t.Errorf("Unexpected error from RewriteFile after panic: %v", err)
+	}
+}
+
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.tmp*"))
+	if err != nil {
+		t.Fatalf("Unexpected error from Glob: %v", err)
+	}
+	if len(matches) > 0 {
+		t.Errorf("Expected no temporary files in %s, instead found %v", dir, matches)
+	}
+}
@@ -0,0 +1,210 @@
+package knownhosts
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ScanConfig configures the behavior of Scan.
+type ScanConfig struct {
+	// HostKeyAlgorithms lists the host key algorithms to offer to the server,
+	// in order of preference. If empty, the defaults of golang.org/x/crypto/ssh
+	// are used. Specifying a single algorithm requests a key of that type.
+	//
+	// In ScanAll, this instead lists the algorithms to attempt individually. If
+	// empty, ScanAll attempts ssh-ed25519, ecdsa-sha2-nistp256,
+	// ecdsa-sha2-nistp384, ecdsa-sha2-nistp521, rsa-sha2-512, rsa-sha2-256,
+	// and ssh-rsa.
+	HostKeyAlgorithms []string
+
+	// Concurrency limits the number of simultaneous connections made by
+	// ScanAll. If zero or negative, a default of 4 is used. Scan ignores this
+	// field.
+	Concurrency int
+
+	// ClientVersion is the SSH identification string sent to the server. If
+	// empty, the default of golang.org/x/crypto/ssh is used.
+	ClientVersion string
+
+	// Dialer is used to connect to the server. If nil, a zero net.Dialer is
+	// used.
+	Dialer *net.Dialer
+}
+
+// ScanError is returned by Scan when a host key could not be obtained. Network
+// is true if the failure was caused by the network, such as a refused
+// connection or a timeout, and false if the server's response could not be
+// handled, for example because it is not an SSH server or does not support
+// any of the requested host key algorithms.
+type ScanError struct {
+	Addr    string
+	Network bool
+	Err     error
+}
+
+// Error satisfies the error interface.
+func (se *ScanError) Error() string {
+	return "knownhosts: unable to scan " + se.Addr + ": " + se.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (se *ScanError) Unwrap() error {
+	return se.Err
+}
+
+// errScanComplete is returned by Scan's host key callback to abort the
+// handshake once the server's host key has been recorded.
+var errScanComplete = errors.New("knownhosts: scan complete")
+
+// Scan connects to the SSH server at addr and returns its host key, similar to
+// ssh-keyscan. The SSH handshake is aborted as soon as the server presents its
+// host key, so no authentication is ever attempted. The key is not verified in
+// any way; callers must determine whether it is trustworthy before writing it
+// with functions such as WriteKnownHost.
+//
+// Any deadline or cancellation of ctx applies to the entire scan, including
+// both the connection and the handshake. The config may be nil to use the
+// defaults. If the scan fails, the returned error will be a *ScanError.
+func Scan(ctx context.Context, network, addr string, config *ScanConfig) (ssh.PublicKey, error) {
+	if config == nil {
+		config = &ScanConfig{}
+	}
+	dialer := config.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, &ScanError{Addr: addr, Network: true, Err: err}
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Close the connection if ctx is cancelled mid-handshake, to unblock it
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	var key ssh.PublicKey
+	clientConfig := &ssh.ClientConfig{
+		User:              "knownhosts-scan",
+		HostKeyAlgorithms: config.HostKeyAlgorithms,
+		ClientVersion:     config.ClientVersion,
+		HostKeyCallback: func(hostname string, remote net.Addr, k ssh.PublicKey) error {
+			key = k
+			return errScanComplete
+		},
+	}
+	_, _, _, err = ssh.NewClientConn(conn, addr, clientConfig)
+	if key != nil {
+		return key, nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, &ScanError{Addr: addr, Network: true, Err: ctxErr}
+	} else if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		// The connection deadline may fire slightly before the context records
+		// its expiry
+		return nil, &ScanError{Addr: addr, Network: true, Err: context.DeadlineExceeded}
+	}
+	var netErr net.Error
+	return nil, &ScanError{Addr: addr, Network: errors.As(err, &netErr), Err: err}
+}
+
+// defaultScanAlgorithms is used by ScanAll if ScanConfig.HostKeyAlgorithms is
+// empty.
+var defaultScanAlgorithms = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512,
+	ssh.KeyAlgoRSASHA256,
+	ssh.KeyAlgoRSA,
+}
+
+// ScanErrors is returned by ScanAll when one or more scans failed. Each
+// element corresponds to one failed host key algorithm.
+type ScanErrors []*ScanError
+
+// Error satisfies the error interface.
+func (se ScanErrors) Error() string {
+	msgs := make([]string, len(se))
+	for n, err := range se {
+		msgs[n] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ScanAll obtains every host key offered by the SSH server at addr, similar to
+// ssh-keyscan, by calling Scan once per host key algorithm, with at most
+// config.Concurrency scans in progress at a time. Keys offered under multiple
+// algorithms, such as an RSA key offered as both rsa-sha2-256 and ssh-rsa, are
+// only returned once. Keys are returned in the order of the algorithm list.
+//
+// Scans which fail only because the server has no key for that algorithm are
+// ignored, as long as at least one key is found. If any other scan fails, the
+// keys which were found are returned along with a ScanErrors describing the
+// failures.
+func ScanAll(ctx context.Context, network, addr string, config *ScanConfig) ([]ssh.PublicKey, error) {
+	var cfg ScanConfig
+	if config != nil {
+		cfg = *config
+	}
+	algorithms := cfg.HostKeyAlgorithms
+	if len(algorithms) == 0 {
+		algorithms = defaultScanAlgorithms
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	keys := make([]ssh.PublicKey, len(algorithms))
+	errs := make([]error, len(algorithms))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for n, algorithm := range algorithms {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(n int, algorithm string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			attemptCfg := cfg
+			attemptCfg.HostKeyAlgorithms = []string{algorithm}
+			keys[n], errs[n] = Scan(ctx, network, addr, &attemptCfg)
+		}(n, algorithm)
+	}
+	wg.Wait()
+
+	result := dedupeKeys(keys)
+	var failures ScanErrors
+	for _, err := range errs {
+		var scanErr *ScanError
+		if !errors.As(err, &scanErr) {
+			continue
+		} else if len(result) > 0 && !scanErr.Network && strings.Contains(scanErr.Err.Error(), "no common algorithm for host key") {
+			continue
+		}
+		failures = append(failures, scanErr)
+	}
+	if len(failures) > 0 {
+		return result, failures
+	}
+	return result, nil
+}
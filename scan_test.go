@@ -0,0 +1,165 @@
+package knownhosts_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/skeema/knownhosts"
+	"github.com/skeema/knownhosts/knownhoststest"
+	"golang.org/x/crypto/ssh"
+)
+
+// startTestServer starts a TCP server on a loopback port, which calls handle
+// in a new goroutine for each connection and then closes the connection. It
+// returns the server's address. The server is stopped upon test completion.
+func startTestServer(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestScan(t *testing.T) {
+	edSigner, ecSigner := knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519), knownhoststest.GenerateSigner(t, ssh.KeyAlgoECDSA256)
+	addr := knownhoststest.StartServer(t, edSigner, ecSigner).Addr
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key, err := knownhosts.Scan(ctx, "tcp", addr, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error from Scan: %v", err)
+	}
+	if !bytes.Equal(key.Marshal(), edSigner.PublicKey().Marshal()) && !bytes.Equal(key.Marshal(), ecSigner.PublicKey().Marshal()) {
+		t.Errorf("Scan returned unexpected %s key", key.Type())
+	}
+
+	key, err = knownhosts.Scan(ctx, "tcp", addr, &knownhosts.ScanConfig{HostKeyAlgorithms: []string{ssh.KeyAlgoED25519}})
+	if err != nil {
+		t.Fatalf("Unexpected error from Scan: %v", err)
+	}
+	if !bytes.Equal(key.Marshal(), edSigner.PublicKey().Marshal()) {
+		t.Errorf("Expected Scan to return ed25519 key, instead found %s", key.Type())
+	}
+
+	key, err = knownhosts.Scan(ctx, "tcp", addr, &knownhosts.ScanConfig{HostKeyAlgorithms: []string{ssh.KeyAlgoECDSA256}})
+	if err != nil {
+		t.Fatalf("Unexpected error from Scan: %v", err)
+	}
+	if !bytes.Equal(key.Marshal(), ecSigner.PublicKey().Marshal()) {
+		t.Errorf("Expected Scan to return ecdsa key, instead found %s", key.Type())
+	}
+
+	// The scanned key can be written directly
+	if line := knownhosts.Line([]string{addr}, key); line == "" {
+		t.Error("Unexpected empty line")
+	}
+
+	// Requesting an algorithm the server lacks is a protocol failure
+	var scanErr *knownhosts.ScanError
+	_, err = knownhosts.Scan(ctx, "tcp", addr, &knownhosts.ScanConfig{HostKeyAlgorithms: []string{ssh.KeyAlgoRSA}})
+	if !errors.As(err, &scanErr) || scanErr.Network {
+		t.Errorf("Expected protocol *knownhosts.ScanError, instead found %v", err)
+	}
+}
+
+func TestScanFailures(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var scanErr *knownhosts.ScanError
+
+	// Connection refused: network failure
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+	if _, err := knownhosts.Scan(ctx, "tcp", closedAddr, nil); !errors.As(err, &scanErr) || !scanErr.Network {
+		t.Errorf("Expected network *knownhosts.ScanError, instead found %v", err)
+	}
+
+	// Non-SSH server: protocol failure
+	addr := startTestServer(t, func(conn net.Conn) {
+		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+	})
+	if _, err := knownhosts.Scan(ctx, "tcp", addr, nil); !errors.As(err, &scanErr) || scanErr.Network {
+		t.Errorf("Expected protocol *knownhosts.ScanError, instead found %v", err)
+	}
+
+	// Server which never responds: timeout from context
+	stall := make(chan struct{})
+	defer close(stall)
+	addr = startTestServer(t, func(conn net.Conn) {
+		<-stall
+	})
+	shortCtx, shortCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shortCancel()
+	start := time.Now()
+	if _, err := knownhosts.Scan(shortCtx, "tcp", addr, nil); !errors.As(err, &scanErr) || !scanErr.Network || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected network *knownhosts.ScanError wrapping context.DeadlineExceeded, instead found %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Scan took too long to time out: %v", elapsed)
+	}
+}
+
+func TestScanAll(t *testing.T) {
+	rsaSigner := knownhoststest.GenerateSigner(t, ssh.KeyAlgoRSA)
+	edSigner, ecSigner := knownhoststest.GenerateSigner(t, ssh.KeyAlgoED25519), knownhoststest.GenerateSigner(t, ssh.KeyAlgoECDSA256)
+	addr := knownhoststest.StartServer(t, rsaSigner, edSigner, ecSigner).Addr
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	keys, err := knownhosts.ScanAll(ctx, "tcp", addr, &knownhosts.ScanConfig{Concurrency: 2})
+	if err != nil {
+		t.Fatalf("Unexpected error from ScanAll: %v", err)
+	}
+	expected := []ssh.PublicKey{edSigner.PublicKey(), ecSigner.PublicKey(), rsaSigner.PublicKey()}
+	if len(keys) != len(expected) {
+		t.Fatalf("Expected ScanAll to return %d keys, instead found %d", len(expected), len(keys))
+	}
+	for n := range keys {
+		if !bytes.Equal(keys[n].Marshal(), expected[n].Marshal()) {
+			t.Errorf("Unexpected key at position %d: %s", n, keys[n].Type())
+		}
+	}
+
+	// Only algorithms the server lacks: error, since no keys were found
+	edOnly := knownhoststest.StartServer(t, edSigner).Addr
+	keys, err = knownhosts.ScanAll(ctx, "tcp", edOnly, &knownhosts.ScanConfig{HostKeyAlgorithms: []string{ssh.KeyAlgoRSA, ssh.KeyAlgoECDSA256}})
+	var scanErrs knownhosts.ScanErrors
+	if len(keys) != 0 || !errors.As(err, &scanErrs) || len(scanErrs) != 2 {
+		t.Errorf("Unexpected return from ScanAll: %v, %v", keys, err)
+	}
+
+	// Network failures are reported for every attempt
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+	keys, err = knownhosts.ScanAll(ctx, "tcp", closedAddr, nil)
+	if len(keys) != 0 || !errors.As(err, &scanErrs) || len(scanErrs) != len(knownhosts.DefaultScanAlgorithms) || !scanErrs[0].Network {
+		t.Errorf("Unexpected return from ScanAll: %v, %v", keys, err)
+	}
+}
@@ -0,0 +1,162 @@
+package knownhosts
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LocalKeyStatus classifies how the known_hosts entries for a host relate to
+// one of the server's own host keys.
+type LocalKeyStatus int
+
+// Constants representing possible values of LocalKeyResult.Status.
+const (
+	LocalKeyAbsent     LocalKeyStatus = iota // no entry for the host has a key of the same type
+	LocalKeyPinned                           // an entry for the host has the key
+	LocalKeyMismatched                       // entries for the host only have different keys of the same type
+	LocalKeyRevoked                          // a @revoked entry matching the host has the key
+)
+
+// String returns an uppercase name for the status, such as "PINNED".
+func (s LocalKeyStatus) String() string {
+	switch s {
+	case LocalKeyAbsent:
+		return "ABSENT"
+	case LocalKeyPinned:
+		return "PINNED"
+	case LocalKeyMismatched:
+		return "MISMATCHED"
+	case LocalKeyRevoked:
+		return "REVOKED"
+	}
+	return "INVALID"
+}
+
+// LocalKeyResult describes the known_hosts entries for one host and one of the
+// server's host keys, as determined by VerifyLocalHostKey.
+type LocalKeyResult struct {
+	Host   string        // normalized using Normalize
+	Key    ssh.PublicKey // the signer's public key, or the key of its certificate
+	Status LocalKeyStatus
+
+	// Recorded lists the entries responsible for Status: the entries with the
+	// key for LocalKeyPinned or LocalKeyRevoked, or the entries with different
+	// keys of the same type for LocalKeyMismatched.
+	Recorded []Entry
+}
+
+// String returns a one-line description of the result, including the
+// fingerprints and locations of any mismatched keys.
+func (r LocalKeyResult) String() string {
+	s := fmt.Sprintf("%s %s %s: %s", r.Host, r.Key.Type(), Fingerprint(r.Key), r.Status)
+	if r.Status == LocalKeyMismatched {
+		recorded := make([]string, len(r.Recorded))
+		for n, e := range r.Recorded {
+			recorded[n] = fmt.Sprintf("%s at %s:%d", Fingerprint(e.Key), e.Filename, e.Line)
+		}
+		s += " (recorded " + strings.Join(recorded, ", ") + ")"
+	}
+	return s
+}
+
+// VerifyReport summarizes the result of VerifyLocalHostKey.
+type VerifyReport struct {
+	// Results has one element per combination of hostname and signer, ordered
+	// by hostname and then by signer, in the order they were supplied.
+	Results []LocalKeyResult
+}
+
+// OK returns true if every result has status LocalKeyPinned.
+func (vr VerifyReport) OK() bool {
+	for _, r := range vr.Results {
+		if r.Status != LocalKeyPinned {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the results, one per line, in the form returned by
+// LocalKeyResult.String.
+func (vr VerifyReport) String() string {
+	var b strings.Builder
+	for _, r := range vr.Results {
+		b.WriteString(r.String() + "\n")
+	}
+	return b.String()
+}
+
+// VerifyLocalHostKey checks whether known_hosts entries, such as those of a
+// fleet-wide file distributed to clients, pin a server's own host keys under
+// each of the hostnames that clients use to reach it. This is intended for use
+// in a startup or health check of the server, so that a botched key rotation
+// is detected before clients begin to fail. Hostnames lacking a port are
+// treated as being on port 22.
+//
+// Each signer corresponds to one of the server's host keys; if it is a
+// certificate signer, the certificate's underlying key is checked. Entries are
+// matched to hostnames using MatchesLine. Keys of @cert-authority entries never
+// count as a match, since clients compare them against the certificate's
+// signature rather than the host key. A key which is recorded for a host as
+// well as revoked is reported as LocalKeyRevoked.
+//
+// An error is returned if no signers are supplied, or if any signer is nil.
+func VerifyLocalHostKey(entries []Entry, hostnames []string, signers ...ssh.Signer) (VerifyReport, error) {
+	if len(signers) == 0 {
+		return VerifyReport{}, errors.New("knownhosts: no host key signers supplied")
+	}
+	keys := make([]ssh.PublicKey, len(signers))
+	for n, signer := range signers {
+		if signer == nil {
+			return VerifyReport{}, fmt.Errorf("knownhosts: host key signer %d is nil", n+1)
+		}
+		keys[n] = signer.PublicKey()
+		if cert, ok := keys[n].(*ssh.Certificate); ok {
+			keys[n] = cert.Key
+		}
+	}
+
+	report := VerifyReport{Results: make([]LocalKeyResult, 0, len(hostnames)*len(keys))}
+	for _, hostname := range hostnames {
+		host := Normalize(hostname)
+		var matching []Entry
+		for _, e := range entries {
+			if e.Key != nil && e.Marker != MarkerCertAuthority && MatchesLine(e.Patterns, host) {
+				matching = append(matching, e)
+			}
+		}
+		for _, key := range keys {
+			report.Results = append(report.Results, verifyLocalKey(host, key, matching))
+		}
+	}
+	return report, nil
+}
+
+// verifyLocalKey returns the result for key, given the entries matching host.
+func verifyLocalKey(host string, key ssh.PublicKey, matching []Entry) LocalKeyResult {
+	result := LocalKeyResult{Host: host, Key: key}
+	var pinned, revoked, mismatched []Entry
+	for _, e := range matching {
+		switch {
+		case e.Marker == MarkerRevoked && KeysEqual(e.Key, key):
+			revoked = append(revoked, e)
+		case e.Marker != MarkerNone:
+		case KeysEqual(e.Key, key):
+			pinned = append(pinned, e)
+		case e.Key.Type() == key.Type():
+			mismatched = append(mismatched, e)
+		}
+	}
+	switch {
+	case len(revoked) > 0:
+		result.Status, result.Recorded = LocalKeyRevoked, revoked
+	case len(pinned) > 0:
+		result.Status, result.Recorded = LocalKeyPinned, pinned
+	case len(mismatched) > 0:
+		result.Status, result.Recorded = LocalKeyMismatched, mismatched
+	}
+	return result
+}
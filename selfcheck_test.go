@@ -0,0 +1,97 @@
+package knownhosts
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestVerifyLocalHostKey(t *testing.T) {
+	edSigner, ecSigner, caSigner := generateSignerEd25519(t), generateSignerECDSA(t), generateSignerEd25519(t)
+	otherEdKey := generatePubKeyEd25519(t)
+	certSigner, err := ssh.NewCertSigner(generateHostCert(t, caSigner, edSigner.PublicKey(), "pinned.example.test"), edSigner)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewCertSigner: %v", err)
+	}
+	hashed, err := HashHostname("hashed.example.test")
+	if err != nil {
+		t.Fatalf("Unexpected error from HashHostname: %v", err)
+	}
+	entries := []Entry{
+		{Patterns: []string{"pinned.example.test", "10.0.0.1"}, Key: edSigner.PublicKey(), Filename: "fleet", Line: 1},
+		{Patterns: []string{"pinned.example.test"}, Key: ecSigner.PublicKey(), Filename: "fleet", Line: 2},
+		{Patterns: []string{"rotated.example.test"}, Key: otherEdKey, Filename: "fleet", Line: 3},
+		{Patterns: []string{hashed}, Key: edSigner.PublicKey(), Filename: "fleet", Line: 4},
+		{Marker: MarkerCertAuthority, Patterns: []string{"*.ca.example.test"}, Key: edSigner.PublicKey(), Filename: "fleet", Line: 5},
+		{Patterns: []string{"revoked.example.test"}, Key: edSigner.PublicKey(), Filename: "fleet", Line: 6},
+		{Marker: MarkerRevoked, Patterns: []string{"revoked.example.test"}, Key: edSigner.PublicKey(), Filename: "fleet", Line: 7},
+	}
+	hostnames := []string{
+		"PINNED.example.test:22",
+		"[10.0.0.1]:2222",
+		"rotated.example.test",
+		"hashed.example.test",
+		"web.ca.example.test",
+		"revoked.example.test",
+	}
+	expected := []struct {
+		host   string
+		status LocalKeyStatus
+		line   int
+	}{
+		{"pinned.example.test", LocalKeyPinned, 1},
+		{"pinned.example.test", LocalKeyPinned, 2},
+		{"[10.0.0.1]:2222", LocalKeyAbsent, 0},
+		{"[10.0.0.1]:2222", LocalKeyAbsent, 0},
+		{"rotated.example.test", LocalKeyMismatched, 3},
+		{"rotated.example.test", LocalKeyAbsent, 0},
+		{"hashed.example.test", LocalKeyPinned, 4},
+		{"hashed.example.test", LocalKeyAbsent, 0},
+		{"web.ca.example.test", LocalKeyAbsent, 0},
+		{"web.ca.example.test", LocalKeyAbsent, 0},
+		{"revoked.example.test", LocalKeyRevoked, 7},
+		{"revoked.example.test", LocalKeyAbsent, 0},
+	}
+
+	report, err := VerifyLocalHostKey(entries, hostnames, certSigner, ecSigner)
+	if err != nil {
+		t.Fatalf("Unexpected error from VerifyLocalHostKey: %v", err)
+	}
+	if len(report.Results) != len(expected) {
+		t.Fatalf("Expected %d results, instead found %d", len(expected), len(report.Results))
+	}
+	for n, r := range report.Results {
+		var line int
+		if len(r.Recorded) > 0 {
+			line = r.Recorded[0].Line
+		}
+		if r.Host != expected[n].host || r.Status != expected[n].status || line != expected[n].line {
+			t.Errorf("Unexpected result %d: expected %+v, found %s", n, expected[n], r)
+		}
+	}
+	if !KeysEqual(report.Results[0].Key, edSigner.PublicKey()) {
+		t.Error("Expected certificate signer to be checked using its underlying key")
+	}
+	if report.OK() {
+		t.Error("Expected OK to return false for report with non-pinned results")
+	}
+	if s := report.Results[4].String(); !strings.Contains(s, "MISMATCHED (recorded "+Fingerprint(otherEdKey)+" at fleet:3)") {
+		t.Errorf("Unexpected result string: %q", s)
+	}
+	if lines := strings.Split(strings.TrimSuffix(report.String(), "\n"), "\n"); len(lines) != len(expected) {
+		t.Errorf("Expected report string to have %d lines, instead found %d", len(expected), len(lines))
+	}
+
+	if report, err = VerifyLocalHostKey(entries, hostnames[:1], edSigner, ecSigner); err != nil {
+		t.Errorf("Unexpected error from VerifyLocalHostKey: %v", err)
+	} else if !report.OK() {
+		t.Errorf("Expected OK to return true, instead report was:\n%s", report)
+	}
+	if _, err := VerifyLocalHostKey(entries, hostnames); err == nil {
+		t.Error("Expected error from VerifyLocalHostKey without signers, but err was nil")
+	}
+	if _, err := VerifyLocalHostKey(entries, hostnames, edSigner, nil); err == nil {
+		t.Error("Expected error from VerifyLocalHostKey with nil signer, but err was nil")
+	}
+}
@@ -0,0 +1,152 @@
+package knownhosts
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Precedence determines how NewFromSources combines sources which disagree
+// about a host.
+type Precedence int
+
+// Constants representing possible values of Precedence.
+const (
+	PrecedenceUnion           Precedence = iota // a key known by any source is accepted
+	PrecedenceFirstMatch                        // the first source which knows the host is used exclusively
+	PrecedenceStrictAgreement                   // sources listing different keys for a host cause a *SourceConflictError
+)
+
+// SourceConflictError is returned by callbacks from NewFromSources using
+// PrecedenceStrictAgreement if two sources list different keys of the same
+// type for a host. Sources and Keys identify the conflicting sources, by their
+// position in the arguments to NewFromSources, and the key from each.
+type SourceConflictError struct {
+	Hostname string
+	Sources  [2]int
+	Keys     [2]xknownhosts.KnownKey
+}
+
+// Error satisfies the error interface, describing both conflicting keys.
+func (sce *SourceConflictError) Error() string {
+	describe := func(n int) string {
+		kk := sce.Keys[n]
+		return fmt.Sprintf("source %d has %s at %s:%d", sce.Sources[n]+1, Fingerprint(kk.Key), kk.Filename, kk.Line)
+	}
+	return fmt.Sprintf("knownhosts: sources disagree about %s key of %s: %s, but %s", sce.Keys[0].Key.Type(), sce.Hostname, describe(0), describe(1))
+}
+
+// NewFromSources creates a host key callback which combines several sources
+// of host keys, such as callbacks for a user's known_hosts file, a system-wide
+// file, static entries, and a fetched fleet file, in that order of priority.
+// The precedence p determines the result when the sources disagree about a
+// host:
+//
+//   - PrecedenceUnion accepts a key if any source accepts it, and otherwise
+//     reports the keys of every source. Keys are accepted in the same cases as
+//     when passing several files to New.
+//   - PrecedenceFirstMatch uses only the first source which knows the host,
+//     either accepting the key or rejecting it with the keys of that source,
+//     even if a later source would accept it. Hosts unknown to every source are
+//     unknown.
+//   - PrecedenceStrictAgreement rejects every key with a *SourceConflictError
+//     if two sources list different keys of the same type for the host,
+//     including the keys of @cert-authority lines. Otherwise it behaves like
+//     PrecedenceUnion. Sources which list keys of different types, or which
+//     do not know the host, do not conflict.
+//
+// The precedence applies equally to the HostKeys and HostKeyAlgorithms methods
+// of the returned callback, which report the keys used for verification. For
+// a host with conflicting sources under PrecedenceStrictAgreement, they return
+// an empty slice.
+//
+// Regardless of p, every source is consulted on each call. A key revoked by any
+// source is always rejected, since a revocation should never be shadowed, and
+// any other error from a source, such as one wrapping ErrStale, is returned
+// as-is, in the order of the sources.
+func NewFromSources(p Precedence, sources ...KeyDB) HostKeyCallback {
+	cbs := make([]ssh.HostKeyCallback, len(sources))
+	for n, src := range sources {
+		cbs[n] = src.HostKeyCallback()
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		results := make([]error, len(cbs))
+		wants := make([][]xknownhosts.KnownKey, len(cbs))
+		accepted := false
+		for n, cb := range cbs {
+			results[n] = cb(hostname, remote, key)
+			var keyErr *xknownhosts.KeyError
+			if results[n] == nil {
+				accepted = true
+			} else if errors.As(results[n], &keyErr) {
+				wants[n] = keyErr.Want
+			} else {
+				return results[n]
+			}
+		}
+
+		switch p {
+		case PrecedenceFirstMatch:
+			for n, err := range results {
+				if err == nil || len(wants[n]) > 0 {
+					return err
+				}
+			}
+		case PrecedenceStrictAgreement:
+			if _, placeholder := key.(*fakePublicKey); !placeholder {
+				// Sources which accepted the key did not report their other keys
+				for n, cb := range cbs {
+					if results[n] == nil {
+						var keyErr *xknownhosts.KeyError
+						if err := cb(hostname, remote, placeholderPubKey); errors.As(err, &keyErr) {
+							wants[n] = keyErr.Want
+						} else if err != nil {
+							return err
+						}
+					}
+				}
+			}
+			if err := sourceConflict(hostname, wants); err != nil {
+				return err
+			}
+		}
+
+		if accepted {
+			return nil
+		}
+		var want []xknownhosts.KnownKey
+		for _, w := range wants {
+			want = append(want, w...)
+		}
+		if len(want) > 0 {
+			return &xknownhosts.KeyError{Want: want}
+		} else if len(results) > 0 {
+			return results[0]
+		}
+		return newUnknownHostError(hostname, remote, key, &xknownhosts.KeyError{}, writeOptions{})
+	}
+}
+
+// sourceConflict returns a *SourceConflictError for the first pair of sources
+// whose keys in wants have the same type but differ, or nil if there is none.
+func sourceConflict(hostname string, wants [][]xknownhosts.KnownKey) error {
+	for i := range wants {
+		for j := i + 1; j < len(wants); j++ {
+			for _, ki := range wants[i] {
+				for _, kj := range wants[j] {
+					if ki.Key.Type() == kj.Key.Type() && !KeysEqual(ki.Key, kj.Key) {
+						return &SourceConflictError{
+							Hostname: hostname,
+							Sources:  [2]int{i, j},
+							Keys:     [2]xknownhosts.KnownKey{ki, kj},
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package knownhosts
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestNewFromSources(t *testing.T) {
+	userKey, fleetKey, rsaKey, sharedKey, revokedKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t), generatePubKeyRSA(t), generatePubKeyEd25519(t), generatePubKeyEd25519(t)
+	user, err := NewFromEntries([]Entry{
+		{Patterns: []string{"conflict.example.test"}, Key: userKey, Filename: "user"},
+		{Patterns: []string{"shared.example.test"}, Key: sharedKey, Filename: "user"},
+		{Patterns: []string{"revoked.example.test"}, Key: revokedKey, Filename: "user"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromEntries: %v", err)
+	}
+	fleet, err := NewFromEntries([]Entry{
+		{Patterns: []string{"conflict.example.test"}, Key: fleetKey, Filename: "fleet"},
+		{Patterns: []string{"conflict.example.test"}, Key: rsaKey, Filename: "fleet"},
+		{Patterns: []string{"shared.example.test"}, Key: sharedKey, Filename: "fleet"},
+		{Patterns: []string{"shared.example.test"}, Key: rsaKey, Filename: "fleet"},
+		{Patterns: []string{"fleetonly.example.test"}, Key: fleetKey, Filename: "fleet"},
+		{Marker: MarkerRevoked, Patterns: []string{"*"}, Key: revokedKey, Filename: "fleet"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewFromEntries: %v", err)
+	}
+	remote, _ := net.ResolveTCPAddr("tcp", "10.0.0.5:22")
+
+	const (
+		accept  = "accept"
+		changed = "changed"
+		unknown = "unknown"
+		revoked = "revoked"
+		clash   = "conflict"
+	)
+	describe := func(err error) string {
+		var revokedErr *xknownhosts.RevokedError
+		var conflictErr *SourceConflictError
+		switch {
+		case err == nil:
+			return accept
+		case IsHostKeyChanged(err):
+			return changed
+		case IsHostUnknown(err):
+			return unknown
+		case errors.As(err, &revokedErr):
+			return revoked
+		case errors.As(err, &conflictErr):
+			return clash
+		}
+		return "error " + err.Error()
+	}
+	cases := []struct {
+		host        string
+		key         ssh.PublicKey
+		expect      [3]string // Union, FirstMatch, StrictAgreement
+		expectAlgos [3]int
+	}{
+		{"conflict.example.test:22", userKey, [3]string{accept, accept, clash}, [3]int{4, 1, 0}},
+		{"conflict.example.test:22", fleetKey, [3]string{accept, changed, clash}, [3]int{4, 1, 0}},
+		{"conflict.example.test:22", rsaKey, [3]string{accept, changed, clash}, [3]int{4, 1, 0}},
+		{"conflict.example.test:22", generatePubKeyEd25519(t), [3]string{changed, changed, clash}, [3]int{4, 1, 0}},
+		{"shared.example.test:22", sharedKey, [3]string{accept, accept, accept}, [3]int{4, 1, 4}},
+		{"shared.example.test:22", rsaKey, [3]string{accept, changed, accept}, [3]int{4, 1, 4}},
+		{"fleetonly.example.test:22", fleetKey, [3]string{accept, accept, accept}, [3]int{1, 1, 1}},
+		{"revoked.example.test:22", revokedKey, [3]string{revoked, revoked, revoked}, [3]int{1, 1, 1}},
+		{"unknown.example.test:22", userKey, [3]string{unknown, unknown, unknown}, [3]int{0, 0, 0}},
+	}
+	for n, p := range []Precedence{PrecedenceUnion, PrecedenceFirstMatch, PrecedenceStrictAgreement} {
+		cb := NewFromSources(p, user, fleet)
+		for _, tc := range cases {
+			if actual := describe(cb(tc.host, remote, tc.key)); actual != tc.expect[n] {
+				t.Errorf("Precedence %d, host %s, key %s: expected %s, found %s", p, tc.host, Fingerprint(tc.key), tc.expect[n], actual)
+			}
+			if algos := cb.HostKeyAlgorithms(tc.host); len(algos) != tc.expectAlgos[n] {
+				t.Errorf("Precedence %d, host %s: expected %d algorithms, found %v", p, tc.host, tc.expectAlgos[n], algos)
+			}
+		}
+	}
+
+	// FirstMatch uses the keys of the first source which knows the host
+	if keys := NewFromSources(PrecedenceFirstMatch, user, fleet).HostKeys("conflict.example.test"); len(keys) != 1 || !KeysEqual(keys[0], userKey) {
+		t.Errorf("Unexpected result from HostKeys with PrecedenceFirstMatch: %v", keys)
+	}
+	if keys := NewFromSources(PrecedenceFirstMatch, fleet, user).HostKeys("conflict.example.test"); len(keys) != 2 || containsKey(keys, userKey) {
+		t.Errorf("Unexpected result from HostKeys with PrecedenceFirstMatch and reversed sources: %v", keys)
+	}
+
+	// The conflict error identifies both sources and keys
+	err = NewFromSources(PrecedenceStrictAgreement, user, fleet)("conflict.example.test:22", remote, userKey)
+	var conflictErr *SourceConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("Expected *SourceConflictError, instead found %v", err)
+	}
+	if conflictErr.Sources != [2]int{0, 1} || conflictErr.Keys[0].Filename != "user" || !KeysEqual(conflictErr.Keys[0].Key, userKey) || conflictErr.Keys[1].Filename != "fleet" || !KeysEqual(conflictErr.Keys[1].Key, fleetKey) {
+		t.Errorf("Unexpected contents of SourceConflictError: %+v", conflictErr)
+	}
+	if IsHostKeyChanged(err) || IsHostUnknown(err) {
+		t.Errorf("SourceConflictError should not be classified as changed or unknown: %v", err)
+	}
+
+	// Other errors from any source are returned in all modes
+	failure := errors.New("source failure")
+	broken := HostKeyCallback(func(string, net.Addr, ssh.PublicKey) error { return failure })
+	for _, p := range []Precedence{PrecedenceUnion, PrecedenceFirstMatch, PrecedenceStrictAgreement} {
+		if err := NewFromSources(p, user, broken)("shared.example.test:22", remote, sharedKey); err != failure {
+			t.Errorf("Precedence %d: expected source failure, instead found %v", p, err)
+		}
+	}
+
+	// No sources means every host is unknown
+	if err := NewFromSources(PrecedenceUnion)("shared.example.test:22", remote, sharedKey); !IsHostUnknown(err) {
+		t.Errorf("Expected unknown host error with no sources, instead found %v", err)
+	}
+}
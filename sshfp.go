@@ -0,0 +1,173 @@
+package knownhosts
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHFPRecord represents a DNS SSHFP resource record, as defined by RFC 4255.
+type SSHFPRecord struct {
+	Algorithm       uint8 // 1 = RSA, 2 = DSA, 3 = ECDSA, 4 = Ed25519, 6 = Ed448
+	FingerprintType uint8 // 1 = SHA-1, 2 = SHA-256
+	Fingerprint     []byte
+}
+
+// Resolver looks up SSHFP records. The Go standard library cannot query
+// SSHFP records, so callers must supply an implementation, typically backed
+// by a DNS client library. LookupSSHFP should return authenticated = true only
+// if the response was validated using DNSSEC, for example if a validating
+// resolver set the AD flag in its response.
+type Resolver interface {
+	LookupSSHFP(ctx context.Context, hostname string) (records []SSHFPRecord, authenticated bool, err error)
+}
+
+// Match describes the result of verifying a host key against SSHFP records.
+type Match int
+
+// Constants representing possible results of VerifySSHFP.
+const (
+	MatchNone     Match = iota // no SSHFP record matches the key
+	MatchInsecure              // a record matches, but the DNS response was not authenticated
+	MatchSecure                // a record matches, and the DNS response was authenticated
+)
+
+// String returns a description of the match.
+func (m Match) String() string {
+	switch m {
+	case MatchInsecure:
+		return "insecure"
+	case MatchSecure:
+		return "secure"
+	default:
+		return "none"
+	}
+}
+
+// sshfpAlgorithm returns the SSHFP algorithm number for keyType, as assigned
+// by RFC 4255, RFC 6594, and RFC 7479, or 0 if keyType has no assigned number.
+func sshfpAlgorithm(keyType string) uint8 {
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return 1
+	case ssh.KeyAlgoDSA:
+		return 2
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		return 3
+	case ssh.KeyAlgoED25519:
+		return 4
+	}
+	return 0
+}
+
+// VerifySSHFP checks whether key matches an SSHFP record for hostname, like
+// OpenSSH's VerifyHostKeyDNS option. The hostname may include a port, which is
+// ignored since SSHFP records apply to all ports. SHA-1 and SHA-256
+// fingerprints are both supported. MatchSecure is only returned if resolver
+// reports that its response was authenticated using DNSSEC; otherwise, a
+// matching record yields MatchInsecure, which should be treated as advisory.
+//
+// MatchNone is returned without performing a lookup if hostname is an IP
+// address, or if key is of a type with no SSHFP algorithm number, such as a
+// certificate.
+func VerifySSHFP(ctx context.Context, hostname string, key ssh.PublicKey, resolver Resolver) (Match, error) {
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
+	}
+	hostname = trimTrailingDot(lowerASCII(strings.Trim(hostname, "[]")))
+	algorithm := sshfpAlgorithm(key.Type())
+	if algorithm == 0 || net.ParseIP(stripZone(hostname)) != nil {
+		return MatchNone, nil
+	}
+	records, authenticated, err := resolver.LookupSSHFP(ctx, hostname)
+	if err != nil {
+		return MatchNone, err
+	}
+	marshaled := key.Marshal()
+	sha1Sum := sha1.Sum(marshaled)
+	sha256Sum := sha256.Sum256(marshaled)
+	for _, rec := range records {
+		if rec.Algorithm != algorithm {
+			continue
+		}
+		var matched bool
+		switch rec.FingerprintType {
+		case 1:
+			matched = bytes.Equal(rec.Fingerprint, sha1Sum[:])
+		case 2:
+			matched = bytes.Equal(rec.Fingerprint, sha256Sum[:])
+		}
+		if matched && authenticated {
+			return MatchSecure, nil
+		} else if matched {
+			return MatchInsecure, nil
+		}
+	}
+	return MatchNone, nil
+}
+
+// SSHFPConfig configures the behavior of WithSSHFPFallback.
+type SSHFPConfig struct {
+	// Resolver is used to look up SSHFP records. It is required.
+	Resolver Resolver
+
+	// Timeout limits the duration of each lookup. If zero, lookups are limited
+	// only by the resolver itself.
+	Timeout time.Duration
+
+	// TrustInsecure causes keys matching SSHFP records to be accepted even if
+	// the DNS response was not authenticated using DNSSEC. This should only be
+	// enabled if the path to the resolver is otherwise trusted.
+	TrustInsecure bool
+
+	// AppendPath, if non-empty, is a known_hosts file path to which accepted
+	// keys are appended using AppendKnownHost.
+	AppendPath string
+
+	// OnMatch, if non-nil, is called whenever a key unknown to the underlying
+	// callback is accepted due to an SSHFP match, so that callers may
+	// distinguish keys accepted via DNS from those already in known_hosts.
+	// The append error is non-nil if AppendPath is set and appending failed;
+	// the key is accepted regardless.
+	OnMatch func(hostname string, remote net.Addr, key ssh.PublicKey, match Match, appendErr error)
+}
+
+// WithSSHFPFallback returns a host key callback which behaves like cb, except
+// that if cb reports the host as unknown (see IsHostUnknown), the key is
+// verified against SSHFP records using VerifySSHFP. The key is accepted if the
+// result is MatchSecure, or MatchInsecure when cfg.TrustInsecure is set. In all
+// other cases, including lookup failures, the original error from cb is
+// returned, so the callback fails closed. Hosts whose key has changed are never
+// checked against DNS.
+func WithSSHFPFallback(cb HostKeyCallback, cfg SSHFPConfig) HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := cb(hostname, remote, key)
+		if !IsHostUnknown(err) || sshfpAlgorithm(key.Type()) == 0 {
+			return err
+		}
+		ctx := context.Background()
+		if cfg.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+			defer cancel()
+		}
+		match, lookupErr := VerifySSHFP(ctx, hostname, key, cfg.Resolver)
+		if lookupErr != nil || match == MatchNone || (match == MatchInsecure && !cfg.TrustInsecure) {
+			return err
+		}
+		var appendErr error
+		if cfg.AppendPath != "" {
+			appendErr = AppendKnownHost(cfg.AppendPath, hostname, remote, key)
+		}
+		if cfg.OnMatch != nil {
+			cfg.OnMatch(hostname, remote, key, match, appendErr)
+		}
+		return nil
+	}
+}
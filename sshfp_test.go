@@ -0,0 +1,176 @@
+package knownhosts
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeResolver is a Resolver which returns canned SSHFP records, keyed by
+// hostname, and counts lookups.
+type fakeResolver struct {
+	records       map[string][]SSHFPRecord
+	authenticated bool
+	err           error
+	lookups       int
+}
+
+func (fr *fakeResolver) LookupSSHFP(ctx context.Context, hostname string) ([]SSHFPRecord, bool, error) {
+	fr.lookups++
+	if fr.err != nil {
+		return nil, false, fr.err
+	}
+	return fr.records[hostname], fr.authenticated, nil
+}
+
+func sshfpRecords(algorithm uint8, key ssh.PublicKey) []SSHFPRecord {
+	sha1Sum := sha1.Sum(key.Marshal())
+	sha256Sum := sha256.Sum256(key.Marshal())
+	return []SSHFPRecord{
+		{Algorithm: algorithm, FingerprintType: 1, Fingerprint: sha1Sum[:]},
+		{Algorithm: algorithm, FingerprintType: 2, Fingerprint: sha256Sum[:]},
+	}
+}
+
+func TestVerifySSHFP(t *testing.T) {
+	edKey, rsaKey, ecKey := generatePubKeyEd25519(t), generatePubKeyRSA(t), generatePubKeyECDSA(t)
+	resolver := &fakeResolver{
+		records: map[string][]SSHFPRecord{
+			"ed.example.test":  sshfpRecords(4, edKey),
+			"rsa.example.test": sshfpRecords(1, rsaKey)[:1], // SHA-1 only
+			"ec.example.test":  sshfpRecords(3, ecKey)[1:],  // SHA-256 only
+			"bad.example.test": sshfpRecords(1, edKey),      // wrong algorithm number
+		},
+		authenticated: true,
+	}
+	ctx := context.Background()
+	cases := []struct {
+		hostname string
+		key      ssh.PublicKey
+		expected Match
+	}{
+		{"ed.example.test", edKey, MatchSecure},
+		{"ED.example.test.:22", edKey, MatchSecure},
+		{"ed.example.test:2222", edKey, MatchSecure},
+		{"rsa.example.test", rsaKey, MatchSecure},
+		{"ec.example.test", ecKey, MatchSecure},
+		{"ed.example.test", rsaKey, MatchNone},
+		{"bad.example.test", edKey, MatchNone},
+		{"unknown.example.test", edKey, MatchNone},
+	}
+	for _, c := range cases {
+		if match, err := VerifySSHFP(ctx, c.hostname, c.key, resolver); err != nil {
+			t.Errorf("Unexpected error from VerifySSHFP(%q): %v", c.hostname, err)
+		} else if match != c.expected {
+			t.Errorf("Expected VerifySSHFP(%q) to return %s, instead found %s", c.hostname, c.expected, match)
+		}
+	}
+
+	// Unauthenticated responses should only yield an insecure match
+	resolver.authenticated = false
+	if match, err := VerifySSHFP(ctx, "ed.example.test", edKey, resolver); err != nil || match != MatchInsecure {
+		t.Errorf("Unexpected return from VerifySSHFP: %s, %v", match, err)
+	}
+
+	// IP addresses and certificates should not trigger lookups
+	resolver.lookups = 0
+	cert := &ssh.Certificate{Key: edKey, CertType: ssh.HostCert}
+	for _, hostname := range []string{"192.168.1.1:22", "[2001:db8::1]:22", "fe80::1%eth0"} {
+		if match, err := VerifySSHFP(ctx, hostname, edKey, resolver); err != nil || match != MatchNone {
+			t.Errorf("Unexpected return from VerifySSHFP(%q): %s, %v", hostname, match, err)
+		}
+	}
+	if match, err := VerifySSHFP(ctx, "ed.example.test", cert, resolver); err != nil || match != MatchNone {
+		t.Errorf("Unexpected return from VerifySSHFP for certificate: %s, %v", match, err)
+	}
+	if resolver.lookups != 0 {
+		t.Errorf("Expected no lookups, instead found %d", resolver.lookups)
+	}
+
+	resolver.err = errors.New("lookup failed")
+	if _, err := VerifySSHFP(ctx, "ed.example.test", edKey, resolver); err == nil {
+		t.Error("Expected VerifySSHFP to return an error, but it did not")
+	}
+}
+
+func TestWithSSHFPFallback(t *testing.T) {
+	khPath := getTestKnownHosts(t)
+	kh, err := New(khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	edKey := generatePubKeyEd25519(t)
+	resolver := &fakeResolver{
+		records: map[string][]SSHFPRecord{
+			"new.example.test":   sshfpRecords(4, edKey),
+			"multi.example.test": sshfpRecords(4, edKey),
+		},
+	}
+	var matches []Match
+	cfg := SSHFPConfig{
+		Resolver:   resolver,
+		AppendPath: khPath,
+		OnMatch: func(hostname string, remote net.Addr, key ssh.PublicKey, match Match, appendErr error) {
+			if appendErr != nil {
+				t.Errorf("Unexpected append error: %v", appendErr)
+			}
+			matches = append(matches, match)
+		},
+	}
+
+	// Insecure match should not be accepted by default
+	cb := WithSSHFPFallback(kh, cfg)
+	if err := cb("new.example.test:22", noAddr, edKey); !IsHostUnknown(err) {
+		t.Errorf("Expected insecure match to be rejected, instead found %v", err)
+	}
+
+	// Changed keys must never be accepted via DNS
+	resolver.authenticated = true
+	if err := cb("multi.example.test:2233", noAddr, edKey); !IsHostKeyChanged(err) {
+		t.Errorf("Expected changed host key error, instead found %v", err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("Expected no matches so far, instead found %v", matches)
+	}
+
+	// Secure match should be accepted and appended
+	if err := cb("new.example.test:22", noAddr, edKey); err != nil {
+		t.Errorf("Unexpected error from callback: %v", err)
+	}
+	if len(matches) != 1 || matches[0] != MatchSecure {
+		t.Errorf("Unexpected matches: %v", matches)
+	}
+	if kh, err = New(khPath); err != nil {
+		t.Fatalf("Unexpected error from New: %v", err)
+	} else if err := kh("new.example.test:22", noAddr, edKey); err != nil {
+		t.Errorf("Expected appended key to be known, instead found %v", err)
+	}
+
+	// Lookup failures fail closed
+	resolver.err = errors.New("lookup failed")
+	if err := cb("other.example.test:22", noAddr, edKey); !IsHostUnknown(err) {
+		t.Errorf("Expected unknown host error after lookup failure, instead found %v", err)
+	}
+
+	// Insecure match is accepted if configured
+	resolver.err, resolver.authenticated = nil, false
+	cfg.TrustInsecure, cfg.AppendPath = true, ""
+	cb = WithSSHFPFallback(kh, cfg)
+	if err := cb("new.example.test:2222", noAddr, edKey); err != nil {
+		t.Errorf("Unexpected error from callback: %v", err)
+	}
+	if len(matches) != 2 || matches[1] != MatchInsecure {
+		t.Errorf("Unexpected matches: %v", matches)
+	}
+
+	// Lookups via the bogus-key trick should be unaffected
+	if keys := cb.HostKeys("new.example.test:2222"); len(keys) != 0 {
+		t.Errorf("Expected no keys from HostKeys, instead found %v", keys)
+	}
+}
@@ -0,0 +1,107 @@
+package knownhosts
+
+import "strings"
+
+// StaticFilename is the Filename given to static entries passed to
+// NewWithStaticEntries which have no Filename of their own. It appears in the
+// KnownKey values of errors from the resulting callback, so that keys which
+// were compiled into a program can be distinguished from user-accepted keys.
+const StaticFilename = "<static>"
+
+// StaticOptions configures the behavior of NewWithStaticEntries.
+type StaticOptions struct {
+	// PreferStatic causes static entries to take precedence over file entries
+	// when they conflict. By default, file entries take precedence.
+	PreferStatic bool
+
+	// OnConflict, if non-nil, is called for each host pattern which has
+	// conflicting keys of the same type in a static entry and a file entry.
+	// The pattern is removed from whichever entry does not take precedence.
+	OnConflict func(pattern string, static, file Entry)
+}
+
+// NewWithStaticEntries creates a host key callback from the supplied static
+// entries combined with the entries of the known_hosts files, for example to
+// ship known-good host keys for a fixed set of services inside a binary. Static
+// entries participate fully in lookups, including HostKeys,
+// HostKeyAlgorithms, @cert-authority, and @revoked. Static entries without a
+// Filename are given StaticFilename. The files are read using ReadEntries.
+//
+// A static entry and a file entry conflict if neither has a marker, they share
+// a plaintext host pattern (or the file entry has a hashed pattern matching the
+// static pattern), and they have different keys of the same type. For each
+// conflict, opts.OnConflict is called, and the pattern is removed from the
+// entry which does not take precedence; entries left without patterns are
+// omitted.
+func NewWithStaticEntries(static []Entry, opts StaticOptions, files ...string) (HostKeyCallback, error) {
+	fileEntries, err := ReadEntries(files...)
+	if err != nil {
+		return nil, err
+	}
+	static = append([]Entry(nil), static...)
+	for n := range static {
+		if static[n].Filename == "" {
+			static[n].Filename = StaticFilename
+		}
+		static[n].Patterns = append([]string(nil), static[n].Patterns...)
+	}
+
+	for s := range static {
+		if static[s].Marker != MarkerNone || static[s].Key == nil {
+			continue
+		}
+		for f := range fileEntries {
+			if fileEntries[f].Marker != MarkerNone || fileEntries[f].Key.Type() != static[s].Key.Type() || KeysEqual(fileEntries[f].Key, static[s].Key) {
+				continue
+			}
+			for _, pattern := range static[s].Patterns {
+				filePattern, ok := conflictingPattern(pattern, fileEntries[f].Patterns)
+				if !ok {
+					continue
+				}
+				if opts.OnConflict != nil {
+					opts.OnConflict(pattern, static[s], fileEntries[f])
+				}
+				if opts.PreferStatic {
+					fileEntries[f].Patterns = removePattern(fileEntries[f].Patterns, filePattern)
+				} else {
+					static[s].Patterns = removePattern(static[s].Patterns, pattern)
+				}
+			}
+		}
+	}
+
+	var entries []Entry
+	for _, e := range append(static, fileEntries...) {
+		if len(e.Patterns) > 0 {
+			entries = append(entries, e)
+		}
+	}
+	return NewFromEntries(entries)
+}
+
+// conflictingPattern returns the element of patterns which refers to the same
+// host as the plaintext pattern, if any. Wildcard and negated patterns are not
+// considered.
+func conflictingPattern(pattern string, patterns []string) (string, bool) {
+	if strings.ContainsAny(pattern, "*?!") || strings.HasPrefix(pattern, hashMagic) {
+		return "", false
+	}
+	for _, p := range patterns {
+		if !strings.ContainsAny(p, "*?!") && patternMatchesAddress(p, Normalize(pattern)) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// removePattern returns patterns without any occurrences of pattern.
+func removePattern(patterns []string, pattern string) []string {
+	var result []string
+	for _, p := range patterns {
+		if p != pattern {
+			result = append(result, p)
+		}
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+package knownhosts
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestNewWithStaticEntries(t *testing.T) {
+	khPath := getTestKnownHosts(t)
+	fileKey, staticKey, otherKey := generatePubKeyEd25519(t), generatePubKeyEd25519(t), generatePubKeyEd25519(t)
+	f, err := os.OpenFile(khPath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("Unable to open %s for writing: %v", khPath, err)
+	}
+	f.WriteString(Line([]string{"git.example.test"}, fileKey) + "\n")
+	f.WriteString(Line([]string{"hashed.example.test"}, fileKey, WithHashedHostnames()) + "\n")
+	f.Close()
+	static := []Entry{
+		{Patterns: []string{"git.example.test", "artifacts.example.test"}, Key: staticKey},
+		{Patterns: []string{"hashed.example.test"}, Key: staticKey},
+		{Patterns: []string{"[ssh.example.test]:2222"}, Key: generatePubKeyECDSA(t)},
+		{Marker: "@revoked", Patterns: []string{"*"}, Key: otherKey},
+	}
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+
+	var conflicts []string
+	opts := StaticOptions{
+		OnConflict: func(pattern string, static, file Entry) {
+			conflicts = append(conflicts, pattern)
+			if file.Filename != khPath || static.Filename != StaticFilename {
+				t.Errorf("Unexpected conflicting entries: %+v, %+v", static, file)
+			}
+		},
+	}
+	cb, err := NewWithStaticEntries(static, opts, khPath)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewWithStaticEntries: %v", err)
+	}
+	if len(conflicts) != 2 || conflicts[0] != "git.example.test" || conflicts[1] != "hashed.example.test" {
+		t.Errorf("Unexpected conflicts: %v", conflicts)
+	}
+
+	// File entries take precedence by default
+	if err := cb("git.example.test:22", noAddr, fileKey); err != nil {
+		t.Errorf("Expected file key to be accepted, instead found %v", err)
+	}
+	var keyErr *xknownhosts.KeyError
+	if err := cb("git.example.test:22", noAddr, staticKey); !errors.As(err, &keyErr) || len(keyErr.Want) != 1 || keyErr.Want[0].Filename != khPath {
+		t.Errorf("Expected static key to be rejected in favor of file key, instead found %v", err)
+	}
+	if err := cb("hashed.example.test:22", noAddr, staticKey); !IsHostKeyChanged(err) {
+		t.Errorf("Expected static key to be rejected in favor of hashed file key, instead found %v", err)
+	}
+
+	// Non-conflicting static entries are used, and marked as static
+	if err := cb("artifacts.example.test:22", noAddr, staticKey); err != nil {
+		t.Errorf("Expected static key to be accepted, instead found %v", err)
+	}
+	if err := cb("artifacts.example.test:22", noAddr, fileKey); !errors.As(err, &keyErr) || len(keyErr.Want) != 1 || keyErr.Want[0].Filename != StaticFilename {
+		t.Errorf("Expected KeyError referring to static entry, instead found %v", err)
+	}
+	if algos := cb.HostKeyAlgorithms("ssh.example.test:2222"); len(algos) != 1 || algos[0] != ssh.KeyAlgoECDSA256 {
+		t.Errorf("Unexpected return from HostKeyAlgorithms: %v", algos)
+	}
+	if keys := cb.HostKeys("multi.example.test:2233"); len(keys) == 0 {
+		t.Error("Expected file entries to be present")
+	}
+	var revokedErr *xknownhosts.RevokedError
+	if err := cb("artifacts.example.test:22", noAddr, otherKey); !errors.As(err, &revokedErr) {
+		t.Errorf("Expected static @revoked entry to apply, instead found %v", err)
+	}
+
+	// With PreferStatic, static entries win
+	opts.PreferStatic = true
+	conflicts = nil
+	if cb, err = NewWithStaticEntries(static, opts, khPath); err != nil {
+		t.Fatalf("Unexpected error from NewWithStaticEntries: %v", err)
+	}
+	if err := cb("git.example.test:22", noAddr, staticKey); err != nil {
+		t.Errorf("Expected static key to be accepted, instead found %v", err)
+	}
+	if err := cb("hashed.example.test:22", noAddr, staticKey); err != nil {
+		t.Errorf("Expected static key to be accepted, instead found %v", err)
+	}
+	if err := cb("git.example.test:22", noAddr, fileKey); !IsHostKeyChanged(err) {
+		t.Errorf("Expected file key to be rejected in favor of static key, instead found %v", err)
+	}
+	if static[0].Filename != "" || len(static[0].Patterns) != 2 {
+		t.Error("NewWithStaticEntries unexpectedly modified its input")
+	}
+}
@@ -0,0 +1,118 @@
+package knownhosts
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// Style describes whether the host patterns in a known_hosts file are hashed.
+type Style int
+
+// Constants representing the possible results of DetectStyle.
+const (
+	StyleEmpty  Style = iota // no host key lines at all
+	StylePlain               // only plaintext host patterns
+	StyleHashed              // only hashed host patterns
+	StyleMixed               // both plaintext and hashed host patterns
+)
+
+// String returns a human-readable name for the style.
+func (s Style) String() string {
+	switch s {
+	case StyleEmpty:
+		return "empty"
+	case StylePlain:
+		return "plain"
+	case StyleHashed:
+		return "hashed"
+	case StyleMixed:
+		return "mixed"
+	}
+	return "unknown"
+}
+
+// StyleReport describes the composition of a known_hosts file, as returned by
+// DetectStyle.
+type StyleReport struct {
+	Style     Style // summary of whether host key lines are hashed
+	Plain     int   // host key lines with only plaintext host patterns
+	Hashed    int   // host key lines with at least one hashed host pattern
+	Markers   int   // lines with a marker, such as @cert-authority or @revoked
+	Comments  int   // comment lines; blank lines are not counted
+	Malformed int   // lines which could not be parsed
+}
+
+// DetectStyle examines the known_hosts file at path and reports whether its
+// host key lines use plaintext or hashed host patterns, along with counts of
+// each kind of line. Lines are parsed using the same grammar as ParseLine, but
+// the file is streamed rather than held in memory. Comments, blank lines,
+// malformed lines, and lines with a marker such as @cert-authority do not
+// affect the Style, since marker lines are never hashed by OpenSSH. A
+// nonexistent file is reported as StyleEmpty, without an error.
+func DetectStyle(path string) (StyleReport, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return StyleReport{}, nil
+	} else if err != nil {
+		return StyleReport{}, err
+	}
+	defer f.Close()
+	report, err := detectStyle(f)
+	if err != nil {
+		return report, fmt.Errorf("knownhosts: unable to read %s: %w", path, err)
+	}
+	return report, nil
+}
+
+// detectStyle implements DetectStyle for known_hosts data read from r. If
+// reading fails, the report reflects the lines read before the failure.
+func detectStyle(r io.Reader) (StyleReport, error) {
+	var report StyleReport
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		e, ok, err := parseEntry(line)
+		switch {
+		case err != nil:
+			report.Malformed++
+		case !ok:
+			if content, _ := splitLineEnding(line); strings.TrimSpace(content) != "" {
+				report.Comments++
+			}
+		case e.Marker != MarkerNone:
+			report.Markers++
+		case e.Hashed():
+			report.Hashed++
+		default:
+			report.Plain++
+		}
+	}
+	switch {
+	case report.Hashed > 0 && report.Plain > 0:
+		report.Style = StyleMixed
+	case report.Hashed > 0:
+		report.Style = StyleHashed
+	case report.Plain > 0:
+		report.Style = StylePlain
+	}
+	return report, scanner.Err()
+}
+
+// autoHashed returns true if new entries appended to a file with the supplied
+// contents should be hashed: that is, if the file's host key lines are
+// predominantly hashed, or if there are no host key lines and emptyDefault is
+// StyleHashed.
+func autoHashed(contents []byte, emptyDefault Style) bool {
+	report, _ := detectStyle(bytes.NewReader(contents))
+	if report.Style == StyleEmpty {
+		return emptyDefault == StyleHashed
+	}
+	return report.Hashed > report.Plain
+}
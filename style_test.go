@@ -0,0 +1,100 @@
+package knownhosts
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectStyle(t *testing.T) {
+	dir := t.TempDir()
+	key := generatePubKeyEd25519(t)
+	plain := Line([]string{"plain.example.test"}, key)
+	hashed := Line([]string{"hashed.example.test"}, key, WithHashedHostnames())
+	ca := "@cert-authority *.ca.example.test " + keyString(key)
+	cases := map[string]Style{
+		"":                       StyleEmpty,
+		"# only a comment\n\n":   StyleEmpty,
+		ca + "\n":                StyleEmpty,
+		plain + "\n" + ca + "\n": StylePlain,
+		"# comment\n" + hashed + "\n" + ca + "\n":    StyleHashed,
+		hashed + "\n" + plain + "\n" + hashed + "\n": StyleMixed,
+	}
+	for contents, want := range cases {
+		khPath := filepath.Join(dir, "known_hosts")
+		if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %v", khPath, err)
+		}
+		if report, err := DetectStyle(khPath); err != nil {
+			t.Errorf("Unexpected error from DetectStyle: %v", err)
+		} else if report.Style != want {
+			t.Errorf("Expected DetectStyle to return %s for %q, instead found %s", want, contents, report.Style)
+		}
+	}
+	if report, err := DetectStyle(filepath.Join(dir, "missing")); report != (StyleReport{}) || err != nil {
+		t.Errorf("Expected DetectStyle of nonexistent file to return an empty report with nil error, instead found %+v, %v", report, err)
+	}
+
+	// Malformed lines are counted, but do not affect the style even if they
+	// look hashed
+	khPath := filepath.Join(dir, "known_hosts")
+	contents := strings.Join([]string{
+		"# comment", "", hashed, plain, plain, ca,
+		"@revoked * " + keyString(key),
+		"|1|bogus ssh-ed25519",
+		"  # indented comment",
+	}, "\n")
+	if err := os.WriteFile(khPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", khPath, err)
+	}
+	expected := StyleReport{Style: StyleMixed, Plain: 2, Hashed: 1, Markers: 2, Comments: 2, Malformed: 1}
+	if report, err := DetectStyle(khPath); err != nil {
+		t.Errorf("Unexpected error from DetectStyle: %v", err)
+	} else if report != expected {
+		t.Errorf("Unexpected report from DetectStyle: expected %+v, found %+v", expected, report)
+	}
+}
+
+func TestAppendKnownHostAutoStyle(t *testing.T) {
+	dir := t.TempDir()
+	noAddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	key := generatePubKeyEd25519(t)
+	plain := Line([]string{"plain.example.test"}, key)
+	hashed := Line([]string{"hashed.example.test"}, key, WithHashedHostnames())
+
+	cases := []struct {
+		contents     string
+		emptyDefault Style
+		wantHashed   bool
+	}{
+		{"", StylePlain, false},
+		{"", StyleHashed, true},
+		{"# comment only\n", StyleHashed, true},
+		{plain + "\n", StyleHashed, false},
+		{hashed + "\n", StylePlain, true},
+		{hashed + "\n" + hashed + "\n" + plain + "\n", StylePlain, true},
+		{hashed + "\n" + plain + "\n" + plain + "\n", StyleHashed, false},
+	}
+	for n, c := range cases {
+		khPath := filepath.Join(dir, "known_hosts")
+		if c.contents == "" {
+			os.Remove(khPath) // bootstrap case: file does not exist yet
+		} else if err := os.WriteFile(khPath, []byte(c.contents), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %v", khPath, err)
+		}
+		if err := AppendKnownHost(khPath, "new.example.test", noAddr, generatePubKeyECDSA(t), WithAutoStyle(c.emptyDefault)); err != nil {
+			t.Fatalf("Unexpected error from AppendKnownHost: %v", err)
+		}
+		after, err := os.ReadFile(khPath)
+		if err != nil {
+			t.Fatalf("Unable to read %s: %v", khPath, err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(after), "\n"), "\n")
+		appended := lines[len(lines)-1]
+		if isHashed := strings.HasPrefix(appended, hashMagic); isHashed != c.wantHashed {
+			t.Errorf("Case %d: expected appended line hashed=%t, instead found %q", n, c.wantHashed, appended)
+		}
+	}
+}
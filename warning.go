@@ -0,0 +1,147 @@
+package knownhosts
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// WarningCode identifies the kind of problem described by a Warning.
+type WarningCode string
+
+// Codes of warnings reported by CheckEntries and ReadEntriesWithWarnings.
+const (
+	// WarningMalformed indicates a line which could not be parsed, and was
+	// skipped by ReadEntriesWithWarnings.
+	WarningMalformed WarningCode = "malformed"
+
+	// WarningDuplicate indicates an entry with the same marker, host patterns,
+	// and key as an earlier entry.
+	WarningDuplicate WarningCode = "duplicate"
+
+	// WarningDeprecatedKey indicates a DSA key, or an RSA key smaller than
+	// 2048 bits.
+	WarningDeprecatedKey WarningCode = "deprecated-key"
+
+	// WarningUnknownMarker indicates an entry with a marker other than
+	// @cert-authority or @revoked, which is ignored by NewFromEntries.
+	WarningUnknownMarker WarningCode = "unknown-marker"
+
+	// WarningBroadPattern indicates a known key or trusted CA whose host
+	// patterns include one which matches every host, such as "*".
+	WarningBroadPattern WarningCode = "broad-pattern"
+
+	// WarningPlaintextPattern indicates an entry with a plaintext host
+	// pattern, as reported by RequireHashed.
+	WarningPlaintextPattern WarningCode = "plaintext-pattern"
+
+	// WarningUnhashablePattern indicates an entry with a wildcard or negated
+	// host pattern, which cannot be hashed, as reported by RequireHashed.
+	WarningUnhashablePattern WarningCode = "unhashable-pattern"
+)
+
+// Warning describes a problem with a known_hosts line which does not prevent
+// the rest of the file from being used, but is worth bringing to the user's
+// attention.
+type Warning struct {
+	Filename string
+	Line     int
+	Code     WarningCode
+	Message  string
+}
+
+// String returns the warning in the form "filename:line: code: message", or
+// "code: message" if the warning has no Filename.
+func (w Warning) String() string {
+	if w.Filename == "" {
+		return fmt.Sprintf("%s: %s", w.Code, w.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", w.Filename, w.Line, w.Code, w.Message)
+}
+
+// CheckEntries returns warnings about entries, such as those returned by
+// ReadEntries, in the same order as the entries. Duplicates are detected
+// across all of the supplied entries, even if they came from different files.
+func CheckEntries(entries []Entry) []Warning {
+	var warnings []Warning
+	seen := make(map[string]Entry) // marker, patterns, and key -> first entry
+	for _, e := range entries {
+		warn := func(code WarningCode, format string, a ...interface{}) {
+			warnings = append(warnings, Warning{Filename: e.Filename, Line: e.Line, Code: code, Message: fmt.Sprintf(format, a...)})
+		}
+		lineKey := string(e.Marker) + " " + strings.Join(e.Patterns, ",") + " " + string(e.Key.Marshal())
+		if first, ok := seen[lineKey]; ok {
+			warn(WarningDuplicate, "identical to %s:%d", first.Filename, first.Line)
+			continue
+		}
+		seen[lineKey] = e
+		if !e.Marker.known() {
+			warn(WarningUnknownMarker, "unknown marker %s, so this line is ignored", e.Marker)
+			continue
+		}
+		if name, bits := randomartKeyType(e.Key); name == "DSA" || name == "DSA-CERT" {
+			warn(WarningDeprecatedKey, "DSA keys are unsupported by OpenSSH 7.0 and later")
+		} else if (name == "RSA" || name == "RSA-CERT") && bits < 2048 {
+			warn(WarningDeprecatedKey, "%d-bit RSA key is weak; at least 2048 bits is recommended", bits)
+		}
+		if e.Marker == MarkerRevoked {
+			continue
+		}
+		for _, pattern := range e.Patterns {
+			if pattern != "" && strings.Trim(pattern, "*?.") == "" {
+				warn(WarningBroadPattern, "pattern %q matches every host", pattern)
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+// ReadEntriesWithWarnings behaves like ReadEntries, except that malformed lines
+// are skipped rather than causing an error, and warn is called for each
+// skipped line as well as for each warning returned by CheckEntries. Warnings
+// are reported after all files have been parsed, ordered by file and line.
+// The returned error is only non-nil if a file cannot be read, or exceeds the
+// default limits described by ReadEntries.
+func ReadEntriesWithWarnings(warn func(Warning), files ...string) ([]Entry, error) {
+	var entries []Entry
+	var warnings []Warning
+	skip := func(pe *ParseError) {
+		warnings = append(warnings, Warning{
+			Filename: pe.Filename,
+			Line:     pe.Line,
+			Code:     WarningMalformed,
+			Message:  fmt.Sprintf("column %d: invalid %s: %v", pe.Column, pe.Field, pe.Err),
+		})
+	}
+	fileOrder := make(map[string]int, len(files))
+	for n, path := range files {
+		if _, ok := fileOrder[path]; !ok {
+			fileOrder[path] = n
+		}
+		ro := newReadOptions(nil)
+		f, r, err := openLimited(path, ro)
+		if err != nil {
+			return nil, err
+		}
+		fileEntries, err := parseEntries(r, path, skip, ro)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fileEntries...)
+	}
+	if warn != nil {
+		warnings = append(warnings, CheckEntries(entries)...)
+		sort.SliceStable(warnings, func(i, j int) bool {
+			if fi, fj := fileOrder[warnings[i].Filename], fileOrder[warnings[j].Filename]; fi != fj {
+				return fi < fj
+			}
+			return warnings[i].Line < warnings[j].Line
+		})
+		for _, w := range warnings {
+			warn(w)
+		}
+	}
+	return entries, nil
+}
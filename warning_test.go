@@ -0,0 +1,89 @@
+package knownhosts
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestReadEntriesWithWarnings(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Unable to generate RSA key: %v", err)
+	}
+	weakKey, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Unable to convert public key: %v", err)
+	}
+	key, caKey := keyString(generatePubKeyEd25519(t)), keyString(generatePubKeyEd25519(t))
+
+	dir := t.TempDir()
+	path1, path2 := filepath.Join(dir, "known_hosts"), filepath.Join(dir, "known_hosts2")
+	contents1 := strings.Join([]string{
+		"a.example.test " + key, // 1
+		"# comment",             // 2
+		"b.example.test ssh-ed25519 !!!notbase64", // 3: malformed
+		"b.example.test " + keyString(weakKey),    // 4: deprecated-key
+		"a.example.test " + key,                   // 5: duplicate of 1
+		"@cert-authority * " + caKey,              // 6: broad-pattern
+		"@revoked * " + caKey,                     // 7: revoking everywhere is fine
+		"@bogus c.example.test " + key,            // 8: unknown-marker
+	}, "\n") + "\n"
+	contents2 := strings.Join([]string{
+		"*.*,c.example.test " + key, // 1: broad-pattern
+		"a.example.test " + key,     // 2: duplicate of file 1 line 1
+	}, "\n") + "\n"
+	for path, contents := range map[string]string{path1: contents1, path2: contents2} {
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %v", path, err)
+		}
+	}
+
+	// Warnings must be ordered by file and line, even though duplicates across
+	// files are only found after parsing all files
+	var actual []string
+	entries, err := ReadEntriesWithWarnings(func(w Warning) {
+		actual = append(actual, strings.TrimPrefix(w.Filename, dir)+":"+strconv.Itoa(w.Line)+" "+string(w.Code))
+	}, path1, path2)
+	if err != nil {
+		t.Fatalf("Unexpected error from ReadEntriesWithWarnings: %v", err)
+	}
+	sep := string(filepath.Separator)
+	expected := []string{
+		sep + "known_hosts:3 malformed",
+		sep + "known_hosts:4 deprecated-key",
+		sep + "known_hosts:5 duplicate",
+		sep + "known_hosts:6 broad-pattern",
+		sep + "known_hosts:8 unknown-marker",
+		sep + "known_hosts2:1 broad-pattern",
+		sep + "known_hosts2:2 duplicate",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected warnings: expected %v, found %v", expected, actual)
+	}
+	if len(entries) != 8 {
+		t.Errorf("Expected 8 entries, instead found %d", len(entries))
+	}
+
+	// CheckEntries reports the same warnings, other than malformed lines
+	if warnings := CheckEntries(entries); len(warnings) != len(expected)-1 {
+		t.Errorf("Expected %d warnings from CheckEntries, instead found %v", len(expected)-1, warnings)
+	} else if w := warnings[1]; w.String() != path1+":5: duplicate: identical to "+path1+":1" {
+		t.Errorf("Unexpected warning string: %s", w)
+	}
+
+	// A nil handler is permitted, and missing files are still an error
+	if entries, err := ReadEntriesWithWarnings(nil, path1); err != nil || len(entries) != 6 {
+		t.Errorf("Unexpected result from ReadEntriesWithWarnings with nil handler: %d entries, err=%v", len(entries), err)
+	}
+	if _, err := ReadEntriesWithWarnings(nil, filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected error from ReadEntriesWithWarnings for missing file, but received nil")
+	}
+}